@@ -0,0 +1,234 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package actor
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/sqreen/go-agent/internal/plog"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqsafe"
+)
+
+const (
+	// Default refresh interval of IP list sources.
+	DefaultIPListSourceRefreshInterval = time.Hour
+	// Minimum refresh interval of IP list sources to avoid hammering the remote
+	// list providers.
+	MinIPListSourceRefreshInterval = time.Minute
+	// Maximum size in bytes of an IP list source.
+	maxIPListSourceSize = 16 * 1024 * 1024
+	// Timeout of HTTP requests to IP list source URLs.
+	ipListSourceHTTPTimeout = 30 * time.Second
+)
+
+// IPListSourceLogger is the logger interface used by IP list sources to report
+// their loading errors happening in the background.
+type IPListSourceLogger interface {
+	plog.DebugLogger
+	plog.ErrorLogger
+}
+
+// IPListSource is a named CIDRIPListStore periodically loaded from a local file
+// or a URL, such as the lists of Tor exit nodes or of public open proxies. The
+// list is loaded and parsed into a new CIDRIPListStore while lookups keep using
+// the current one, and only the store pointer swapping is synchronized.
+type IPListSource struct {
+	name            string
+	location        string
+	refreshInterval time.Duration
+	logger          IPListSourceLogger
+	client          *http.Client
+
+	// The store of the IP list loaded from the source location.
+	store *CIDRIPListStore
+
+	done      chan struct{}
+	startOnce sync.Once
+	closeOnce sync.Once
+}
+
+// NewIPListSource returns a new IP list source named `name` loading its list
+// from `location`, which is either a http(s) URL or a local file path, every
+// `refreshInterval`. The default refresh interval is used when zero. The list
+// is empty until `Load()` or `Start()` is called.
+func NewIPListSource(name, location string, refreshInterval time.Duration, logger IPListSourceLogger) (*IPListSource, error) {
+	if name == "" {
+		return nil, sqerrors.New("unexpected empty ip list source name")
+	}
+	if location == "" {
+		return nil, sqerrors.Errorf("ip list source `%s`: unexpected empty location", name)
+	}
+	if refreshInterval == 0 {
+		refreshInterval = DefaultIPListSourceRefreshInterval
+	} else if refreshInterval < MinIPListSourceRefreshInterval {
+		return nil, sqerrors.Errorf("ip list source `%s`: refresh interval `%s` is lower than the minimum `%s`", name, refreshInterval, MinIPListSourceRefreshInterval)
+	}
+	return &IPListSource{
+		name:            name,
+		location:        location,
+		refreshInterval: refreshInterval,
+		logger:          logger,
+		client:          &http.Client{Timeout: ipListSourceHTTPTimeout},
+		done:            make(chan struct{}),
+	}, nil
+}
+
+// Name returns the name of the IP list.
+func (s *IPListSource) Name() string {
+	return s.name
+}
+
+// getStore is a thread-safe store getter.
+func (s *IPListSource) getStore() *CIDRIPListStore {
+	return (*CIDRIPListStore)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&s.store))))
+}
+
+// setStore is a thread-safe store setter.
+func (s *IPListSource) setStore(store *CIDRIPListStore) {
+	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&s.store)), unsafe.Pointer(store))
+}
+
+// Find returns true when the given IP address matched an entry of the IP list.
+// This matched entry is also returned. The error is non-nil when an internal
+// error occurred.
+func (s *IPListSource) Find(ip net.IP) (exists bool, matched string, err error) {
+	store := s.getStore()
+	if store == nil {
+		return false, "", nil
+	}
+	return store.Find(ip)
+}
+
+// Load reads and parses the IP list from the source location, and atomically
+// replaces the current store with the new one. The current store is kept
+// when an error occurs.
+func (s *IPListSource) Load() error {
+	cidrs, err := s.read()
+	if err != nil {
+		return sqerrors.Wrapf(err, "ip list source `%s`: could not read `%s`", s.name, s.location)
+	}
+	store, err := NewCIDRIPListStore(cidrs)
+	if err != nil {
+		return sqerrors.Wrapf(err, "ip list source `%s`: could not create the ip list store", s.name)
+	}
+	s.setStore(store)
+	if s.logger != nil {
+		s.logger.Debugf("ip list source `%s`: loaded %d entries from `%s`", s.name, len(cidrs), s.location)
+	}
+	return nil
+}
+
+func (s *IPListSource) read() ([]string, error) {
+	var r io.ReadCloser
+	if strings.HasPrefix(s.location, "http://") || strings.HasPrefix(s.location, "https://") {
+		res, err := s.client.Get(s.location)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return nil, sqerrors.Errorf("unexpected http response status `%s`", res.Status)
+		}
+		r = res.Body
+	} else {
+		f, err := os.Open(strings.TrimPrefix(s.location, "file://"))
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	}
+	defer r.Close()
+	return ParseIPList(io.LimitReader(r, maxIPListSourceSize))
+}
+
+// Start loads the IP list and then periodically refreshes it in a goroutine
+// until `Close()` is called. Loading errors are logged and the previous list
+// is kept.
+func (s *IPListSource) Start() {
+	s.startOnce.Do(func() {
+		sqsafe.Go(func() error {
+			s.loop()
+			return nil
+		}, nil)
+	})
+}
+
+func (s *IPListSource) loop() {
+	ticker := time.NewTicker(s.refreshInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.Load(); err != nil && s.logger != nil {
+			s.logger.Error(err)
+		}
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops the periodic refresh of the IP list.
+func (s *IPListSource) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	return nil
+}
+
+// ParseIPList parses an IP list having one IP address or CIDR per line. Empty
+// lines and comments starting with `#` are ignored. The first field of every
+// line is used so that lists having extra columns, such as proxy lists with
+// `ip:port` entries or the Tor exit address lists having `ExitAddress <ip>
+// <date>` entries, are also supported. Invalid entries are skipped.
+func ParseIPList(r io.Reader) (cidrs []string, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		entry := fields[0]
+		if entry == "ExitAddress" {
+			if len(fields) < 2 {
+				continue
+			}
+			entry = fields[1]
+		}
+		if host, _, err := net.SplitHostPort(entry); err == nil {
+			entry = host
+		}
+		if !isIPOrCIDR(entry) {
+			continue
+		}
+		cidrs = append(cidrs, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cidrs, nil
+}
+
+func isIPOrCIDR(v string) bool {
+	if strings.IndexByte(v, '/') >= 0 {
+		_, _, err := net.ParseCIDR(v)
+		return err == nil
+	}
+	return net.ParseIP(v) != nil
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package actor_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sqreen/go-agent/internal/actor"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIPList(t *testing.T) {
+	list := `
+# Tor bulk exit list
+1.2.3.4
+5.6.7.0/24 # some network
+
+ExitAddress 9.8.7.6 2020-11-20 10:00:00
+10.0.0.1:8080
+1:2:3:4:5:6:7:8
+not-an-ip
+ExitAddress
+`
+	cidrs, err := actor.ParseIPList(strings.NewReader(list))
+	require.NoError(t, err)
+	require.Equal(t, []string{"1.2.3.4", "5.6.7.0/24", "9.8.7.6", "10.0.0.1", "1:2:3:4:5:6:7:8"}, cidrs)
+}
+
+func TestIPListSource(t *testing.T) {
+	t.Run("Constructor", func(t *testing.T) {
+		for _, tc := range []struct {
+			name, location string
+			refresh        time.Duration
+		}{
+			{name: "", location: "list.txt"},
+			{name: "tor", location: ""},
+			{name: "tor", location: "list.txt", refresh: time.Second},
+		} {
+			tc := tc
+			t.Run("", func(t *testing.T) {
+				_, err := actor.NewIPListSource(tc.name, tc.location, tc.refresh, nil)
+				require.Error(t, err)
+			})
+		}
+	})
+
+	t.Run("File", func(t *testing.T) {
+		f, err := ioutil.TempFile("", "iplist")
+		require.NoError(t, err)
+		defer os.Remove(f.Name())
+		_, err = f.WriteString("1.2.3.4\n10.0.0.0/8\n")
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		source, err := actor.NewIPListSource("my-list", f.Name(), 0, nil)
+		require.NoError(t, err)
+		require.Equal(t, "my-list", source.Name())
+
+		// Empty until loaded
+		exists, _, err := source.Find(net.IP{1, 2, 3, 4})
+		require.NoError(t, err)
+		require.False(t, exists)
+
+		require.NoError(t, source.Load())
+
+		exists, matched, err := source.Find(net.IP{10, 1, 2, 3})
+		require.NoError(t, err)
+		require.True(t, exists)
+		require.Equal(t, "10.0.0.0/8", matched)
+
+		exists, _, err = source.Find(net.IP{11, 1, 2, 3})
+		require.NoError(t, err)
+		require.False(t, exists)
+
+		// Loading errors keep the current list
+		require.NoError(t, os.Remove(f.Name()))
+		require.Error(t, source.Load())
+		exists, _, err = source.Find(net.IP{1, 2, 3, 4})
+		require.NoError(t, err)
+		require.True(t, exists)
+	})
+
+	t.Run("URL", func(t *testing.T) {
+		list := "1.2.3.4\n"
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, list)
+		}))
+		defer srv.Close()
+
+		source, err := actor.NewIPListSource("my-list", srv.URL, 0, nil)
+		require.NoError(t, err)
+		require.NoError(t, source.Load())
+
+		exists, matched, err := source.Find(net.IP{1, 2, 3, 4})
+		require.NoError(t, err)
+		require.True(t, exists)
+		require.Equal(t, "1.2.3.4", matched)
+
+		// Reloading atomically replaces the list
+		list = "5.6.7.8\n"
+		require.NoError(t, source.Load())
+		exists, _, err = source.Find(net.IP{1, 2, 3, 4})
+		require.NoError(t, err)
+		require.False(t, exists)
+		exists, _, err = source.Find(net.IP{5, 6, 7, 8})
+		require.NoError(t, err)
+		require.True(t, exists)
+	})
+
+	t.Run("URL error", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer srv.Close()
+
+		source, err := actor.NewIPListSource("my-list", srv.URL, 0, nil)
+		require.NoError(t, err)
+		require.Error(t, source.Load())
+	})
+}
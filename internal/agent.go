@@ -13,6 +13,7 @@ import (
 	"io/ioutil"
 	"os"
 	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -25,6 +26,7 @@ import (
 	"github.com/sqreen/go-agent/internal/config"
 	"github.com/sqreen/go-agent/internal/metrics"
 	"github.com/sqreen/go-agent/internal/plog"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
 	http_protection_types "github.com/sqreen/go-agent/internal/protection/http/types"
 	"github.com/sqreen/go-agent/internal/rule"
 	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
@@ -229,6 +231,12 @@ func New(cfg *config.Config) *AgentType {
 		return nil
 	}
 
+	// Keep the request headers net/http removes while reading the requests so
+	// that the protections can see them.
+	if err := http_protection.HookRequestTransfer(); err != nil {
+		logger.Error(sqerrors.Wrap(err, "agent: could not hook the net/http request transfer parsing"))
+	}
+
 	if waf.Version() == nil {
 		message := "in-app waf disabled: cgo was disabled during the program compilation while required by the in-app waf"
 		backend.SendAgentMessage(logger, cfg, message)
@@ -363,7 +371,7 @@ func (a *AgentType) Serve() error {
 	}
 
 	// Load the rulepack side car
-	a.rules.SetRules(appLoginRes.PackID, appLoginRes.Rules)
+	a.setRules(appLoginRes.PackID, appLoginRes.Rules)
 	// Load the actionpack side car
 	if err := a.actors.SetActions(appLoginRes.Actions); err != nil {
 		a.logger.Error(sqerrors.Wrap(err, "could not load the list of actions taken from the login response"))
@@ -540,10 +548,22 @@ func (a *AgentType) ReloadRules() (string, error) {
 		}
 	}
 
-	a.rules.SetRules(rulespack.PackID, rulespack.Rules)
+	a.setRules(rulespack.PackID, rulespack.Rules)
 	return rulespack.PackID, nil
 }
 
+// setRules sets the rules and reports the hookpoints of the rules missing from
+// the program instrumentation.
+func (a *AgentType) setRules(packID string, rules []api.Rule) {
+	a.rules.SetRules(packID, rules)
+
+	coverage := a.rules.InstrumentationCoverage()
+	if len(coverage.Missing) == 0 {
+		return
+	}
+	a.logger.Error(withNotificationError{sqerrors.Errorf("instrumentation self-check: %d of the %d hookpoints of the security rules are missing from the program instrumentation (%.0f%% coverage) - the corresponding protections are disabled - please check the program was compiled with the instrumentation tool and that their packages are instrumented: %s", len(coverage.Missing), coverage.Expected, coverage.Ratio()*100, strings.Join(coverage.Missing, ", "))})
+}
+
 func (a *AgentType) SetPerformanceBudget(budget float64) error {
 	a.performanceBudget = time.Duration(budget * float64(time.Millisecond))
 	return nil
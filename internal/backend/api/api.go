@@ -232,6 +232,15 @@ type (
 	RuleJSCallbacks struct {
 		Pre  []string `json:"pre"`
 		Post []string `json:"post"`
+		// Execution limits of the callbacks. Default values are used when
+		// zero.
+		MaxBudget uint64 `json:"max_budget_ms"`
+		// Maximum number of steps of a callback call, which are its function
+		// calls and loop iterations.
+		MaxSteps uint64 `json:"max_steps"`
+		// Number of over-budget calls after which the callbacks are disabled.
+		// They are never disabled when zero.
+		MaxOverBudget uint64 `json:"max_over_budget"`
 	}
 
 	RuleFunctionWAFCallbacks struct {
@@ -278,10 +287,17 @@ type RuleDataValues []RuleDataEntry
 type RuleDataEntry Struct
 
 const (
-	CustomErrorPageType = "custom_error_page"
-	RedirectionType     = "redirection"
-	WAFType             = "waf"
-	CustomType          = "custom"
+	CustomErrorPageType  = "custom_error_page"
+	RedirectionType      = "redirection"
+	WAFType              = "waf"
+	IPListSourceType     = "ip_list_source"
+	CookieProtectionType = "cookie_protection"
+	CSRFProtectionType   = "csrf_protection"
+	CSPType              = "content_security_policy"
+	OpenRedirectType     = "open_redirect_protection"
+	ProtocolAnomalyType  = "protocol_anomaly"
+	HeaderInjectionType  = "response_header_injection"
+	CustomType           = "custom"
 )
 
 type CustomRuleDataEntry map[string]interface{}
@@ -300,6 +316,47 @@ type WAFRuleDataEntry struct {
 	Timeout          uint64   `json:"max_budget_ms"`
 }
 
+type IPListSourceRuleDataEntry struct {
+	Name            string `json:"name"`
+	Location        string `json:"location"`
+	RefreshInterval uint64 `json:"refresh_interval_s"`
+}
+
+type CookieProtectionRuleDataEntry struct {
+	Cookies []string `json:"cookies"`
+	// MonitorOnlyUntil is the end of the rollout period during which the
+	// cookie verification never blocks, so that the cookies set before the
+	// signing was deployed can get their signature.
+	MonitorOnlyUntil time.Time `json:"monitor_only_until"`
+}
+
+type CSPRuleDataEntry struct {
+	Policy     string `json:"policy"`
+	ReportOnly bool   `json:"report_only"`
+	ReportURI  string `json:"report_uri"`
+}
+
+type CSRFProtectionRuleDataEntry struct {
+	AllowedHosts       []string `json:"allowed_hosts"`
+	DoubleSubmitCookie string   `json:"double_submit_cookie"`
+	DoubleSubmitHeader string   `json:"double_submit_header"`
+	BearerAuthPaths    []string `json:"bearer_auth_paths"`
+}
+
+type OpenRedirectProtectionRuleDataEntry struct {
+	AllowedHosts []string `json:"allowed_hosts"`
+	DefaultURL   string   `json:"default_url"`
+}
+
+type ProtocolAnomalyRuleDataEntry struct {
+	MaxHeaders     int      `json:"max_headers"`
+	AllowedMethods []string `json:"allowed_methods"`
+}
+
+type ResponseHeaderInjectionRuleDataEntry struct {
+	ProtectedHeaders []string `json:"protected_headers"`
+}
+
 type ReflectedCallbackBindingAccessorConfig struct {
 	Capabilities []string `json:"capabilities"`
 }
@@ -322,6 +379,17 @@ type ReflectedCallbackHTTPProtectionContextConfig struct {
 	ReflectedCallbackHTTPProtectionContextFromFuncArgConfig
 }
 
+// Types of ReflectedCallbackHTTPProtectionContextConfig. By default, the
+// protection context is looked up in the first function argument of type
+// `context.Context` before falling back to the goroutine-local storage.
+const (
+	// The protection context is looked up in the `context.Context` function
+	// argument at index `arg_index`.
+	ProtectionContextFromFuncArgType = "func_arg"
+	// The protection context is only looked up in the goroutine-local storage.
+	ProtectionContextFromGLSType = "gls"
+)
+
 type ReflectedCallbackHTTPProtectionConfig struct {
 	BlockStrategy ReflectedCallbackBlockStrategyConfig `json:"block_strategy"`
 }
@@ -332,9 +400,10 @@ type ReflectedCallbackProtectionConfig struct {
 }
 
 type ReflectedCallbackConfig struct {
-	Type            string                                 `json:"type"`
-	Protection      *ReflectedCallbackProtectionConfig     `json:"protection"`
-	BindingAccessor ReflectedCallbackBindingAccessorConfig `json:"binding_accessor"`
+	Type              string                                        `json:"type"`
+	Protection        *ReflectedCallbackProtectionConfig            `json:"protection"`
+	BindingAccessor   ReflectedCallbackBindingAccessorConfig        `json:"binding_accessor"`
+	ProtectionContext *ReflectedCallbackHTTPProtectionContextConfig `json:"protection_context"`
 }
 
 type Dependency struct {
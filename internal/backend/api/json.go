@@ -154,6 +154,20 @@ func (v *RuleDataEntry) UnmarshalJSON(data []byte) error {
 		value = &RedirectionRuleDataEntry{}
 	case WAFType:
 		value = &WAFRuleDataEntry{}
+	case IPListSourceType:
+		value = &IPListSourceRuleDataEntry{}
+	case CookieProtectionType:
+		value = &CookieProtectionRuleDataEntry{}
+	case CSRFProtectionType:
+		value = &CSRFProtectionRuleDataEntry{}
+	case CSPType:
+		value = &CSPRuleDataEntry{}
+	case OpenRedirectType:
+		value = &OpenRedirectProtectionRuleDataEntry{}
+	case ProtocolAnomalyType:
+		value = &ProtocolAnomalyRuleDataEntry{}
+	case HeaderInjectionType:
+		value = &ResponseHeaderInjectionRuleDataEntry{}
 	case CustomType:
 		value = &CustomRuleDataEntry{}
 	default:
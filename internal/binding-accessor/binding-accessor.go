@@ -11,12 +11,28 @@
 //
 // The compiled expression is evaluated upon a Go value and returns the
 // resulting Go value.
+//
+// Expression syntax, by increasing precedence:
+//   - `expr | transformation`: transformations such as `flat_keys`,
+//     `flat_values`, and `filter(predicate)` keeping the list elements `@` for
+//     which the predicate expression is true.
+//   - `a || b`, `a && b`, `!a`: boolean operators.
+//   - `a == b`, `a != b`, `a < b`, `a <= b`, `a > b`, `a >= b`: comparisons of
+//     numbers, strings, booleans and nil values.
+//   - `len(v)`, `lower(s)`, `contains(v, e)`, `matches(s, 're')`, `type(v)`:
+//     builtin functions.
+//   - `v.Field`, `v[index]`, `v(args)`: field, index and call operations.
+//   - `#`, `@`, `'string'`, `42`, `4.2`, `true`, `false`, `nil`, `(expr)`:
+//     the context, the current filter element, literals and grouping.
 package bindingaccessor
 
 import (
 	"errors"
+	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
 	"github.com/sqreen/go-agent/internal/sqlib/sqsafe"
@@ -66,15 +82,22 @@ const MaxExecutionDepth = 10
 // binding accessor execution reached the maximum depth `MaxExecutionDepth`.
 var ErrMaxExecutionDepth = errors.New("maximum binding accessor execution depth reached")
 
-// Compile returns the compiled binding accessor expression function.
-func Compile(expr string) (program BindingAccessorFunc, err error) {
+// Compile returns the compiled binding accessor expression function. The
+// expression is also type-checked when a type environment is given with
+// option WithTypeEnv.
+func Compile(expr string, opts ...CompileOption) (program BindingAccessorFunc, err error) {
 	defer func() {
 		if err != nil {
 			err = sqerrors.Wrap(err, "binding accessor compilation error")
 		}
 	}()
 
-	exprFn, err := compileExpr(expr)
+	var cfg compileConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	exprFn, err := compileExpr(expr, cfg.env)
 	if err != nil {
 		return nil, err
 	}
@@ -121,38 +144,16 @@ func Compile(expr string) (program BindingAccessorFunc, err error) {
 	}, nil
 }
 
-func compileExpr(expr string) (valueFunc, error) {
-	buf := strings.TrimSpace(expr)
-	// Get the first operand
-	valueFn, buf, err := compileOperand(buf)
+func compileExpr(expr string, env *TypeEnv) (valueFunc, error) {
+	p := &parser{expr: expr, buf: expr, env: env}
+	n, err := p.parseExpr()
 	if err != nil {
 		return nil, err
 	}
-
-	for len(buf) > 0 {
-		switch buf[0] {
-		case '(':
-			valueFn, buf, err = compileCall(valueFn, buf[1:])
-			if err != nil {
-				return nil, err
-			}
-		case '.':
-			valueFn, buf, err = compileField(valueFn, buf[1:])
-			if err != nil {
-				return nil, err
-			}
-		case '[':
-			valueFn, buf, err = compileIndex(valueFn, buf[1:])
-			if err != nil {
-				return nil, err
-			}
-		case '|':
-			return compileTransformations(valueFn, buf[1:])
-		default:
-			return nil, sqerrors.Errorf("undefined operation `%c` in `%s`", buf[0], expr)
-		}
+	if p.skipSpaces(); len(p.buf) > 0 {
+		return nil, sqerrors.Errorf("undefined operation `%c` in `%s`", p.buf[0], expr)
 	}
-	return valueFn, nil
+	return n.fn, nil
 }
 
 const (
@@ -160,89 +161,304 @@ const (
 	NewValueMaxElements = 150
 )
 
-func compileTransformations(valueFn valueFunc, buf string) (valueFunc, error) {
-	pipeline := strings.Split(buf, "|")
-	for _, tr := range pipeline {
-		trFn, err := compileTransformation(tr)
+// parser is a recursive descent parser of binding accessor expressions. It
+// compiles the expression while parsing it, and type-checks it when a type
+// environment is given.
+type parser struct {
+	expr string
+	// Remaining expression to parse
+	buf string
+	// Optional type environment
+	env *TypeEnv
+	// Static type of the filter element `@` of the ongoing filter predicate
+	elementType reflect.Type
+}
+
+// node is a compiled sub-expression.
+type node struct {
+	fn valueFunc
+	// Static type of the value, nil when unknown.
+	typ reflect.Type
+	// Path of the value when it is a field or index access of the context,
+	// such as `#.Func.Args[0]`, or an empty string otherwise.
+	path string
+}
+
+func (p *parser) skipSpaces() {
+	p.buf = strings.TrimLeft(p.buf, " \t\n")
+}
+
+// peek returns true when the remaining expression starts with the given token
+// after spaces.
+func (p *parser) peek(token string) bool {
+	p.skipSpaces()
+	return strings.HasPrefix(p.buf, token)
+}
+
+// consume consumes the given token when the remaining expression starts with
+// it and returns true, or returns false otherwise.
+func (p *parser) consume(token string) bool {
+	if !p.peek(token) {
+		return false
+	}
+	p.buf = p.buf[len(token):]
+	return true
+}
+
+func (p *parser) expect(token string) error {
+	if !p.consume(token) {
+		return sqerrors.Errorf("missing `%s` in `%s`", token, p.expr)
+	}
+	return nil
+}
+
+// parseExpr parses a pipeline of transformations.
+func (p *parser) parseExpr() (*node, error) {
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("|") && !p.peek("||") {
+		p.buf = p.buf[1:]
+		n, err = p.parseTransformation(n)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+func (p *parser) parseOr() (*node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("||") {
+		right, err := p.parseAnd()
 		if err != nil {
 			return nil, err
 		}
-		lastValueFn := valueFn
-		valueFn = func(ctx Context, depth int) (value interface{}, err error) {
-			v, err := lastValueFn(ctx, depth)
-			if err != nil {
-				return nil, err
-			}
-			return trFn(ctx, v, newValueMaxDepth, NewValueMaxElements), nil
+		if left, err = p.compileBooleanOperator(left, right, true); err != nil {
+			return nil, err
 		}
 	}
-	return valueFn, nil
+	return left, nil
 }
 
-func compileCall(valueFn valueFunc, buf string) (valueFunc, string, error) {
-	close := strings.IndexByte(buf, ')')
-	if close == -1 {
-		return nil, buf, sqerrors.Errorf("missing closing index bracket `)` in `%s`", buf)
+func (p *parser) parseAnd() (*node, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
 	}
+	for p.consume("&&") {
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = p.compileBooleanOperator(left, right, false); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
 
-	// Compile the list of arguments
-	var args []valueFunc
-	if argList := buf[:close]; len(argList) > 0 {
-		argv := strings.Split(argList, ",")
-		args = make([]valueFunc, len(argv))
-		for i, a := range argv {
-			arg, err := compileExpr(a)
+// compileBooleanOperator returns the short-circuit evaluation of `left || right`
+// when `or` is true, or of `left && right` otherwise.
+func (p *parser) compileBooleanOperator(left, right *node, or bool) (*node, error) {
+	if err := p.checkBool(left); err != nil {
+		return nil, err
+	}
+	if err := p.checkBool(right); err != nil {
+		return nil, err
+	}
+	return &node{
+		typ: boolType,
+		fn: func(ctx Context, depth int) (interface{}, error) {
+			l, err := evalBool(left.fn, ctx, depth)
 			if err != nil {
-				return nil, buf, err
+				return nil, err
 			}
-			args[i] = arg
+			if l == or {
+				return l, nil
+			}
+			return evalBool(right.fn, ctx, depth)
+		},
+	}, nil
+}
+
+func evalBool(valueFn valueFunc, ctx Context, depth int) (bool, error) {
+	v, err := valueFn(ctx, depth)
+	if err != nil {
+		return false, err
+	}
+	return execBool(v)
+}
+
+// Comparison operators, ordered so that the two-character operators are tried
+// first.
+var comparisonOperators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+func (p *parser) parseComparison() (*node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range comparisonOperators {
+		if !p.consume(op) {
+			continue
 		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkComparison(op, left, right); err != nil {
+			return nil, err
+		}
+		op := op
+		return &node{
+			typ: boolType,
+			fn: func(ctx Context, depth int) (interface{}, error) {
+				l, err := left.fn(ctx, depth)
+				if err != nil {
+					return nil, err
+				}
+				r, err := right.fn(ctx, depth)
+				if err != nil {
+					return nil, err
+				}
+				return execComparison(op, l, r)
+			},
+		}, nil
 	}
+	return left, nil
+}
 
-	buf = buf[close:]
-	if len(buf) == 1 {
-		buf = buf[:0]
-	} else {
-		buf = buf[1:]
+func (p *parser) parseUnary() (*node, error) {
+	if !p.consume("!") {
+		return p.parsePostfix()
 	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkBool(operand); err != nil {
+		return nil, err
+	}
+	return &node{
+		typ: boolType,
+		fn: func(ctx Context, depth int) (interface{}, error) {
+			v, err := evalBool(operand.fn, ctx, depth)
+			if err != nil {
+				return nil, err
+			}
+			return !v, nil
+		},
+	}, nil
+}
 
-	return func(ctx Context, depth int) (interface{}, error) {
-		if depth == 0 {
-			return nil, ErrMaxExecutionDepth
+// parsePostfix parses an operand followed by field, index and call operations.
+func (p *parser) parsePostfix() (*node, error) {
+	n, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	for len(p.buf) > 0 {
+		switch p.buf[0] {
+		case '(':
+			p.buf = p.buf[1:]
+			n, err = p.parseCall(n)
+		case '.':
+			p.buf = p.buf[1:]
+			n, err = p.parseField(n)
+		case '[':
+			p.buf = p.buf[1:]
+			n, err = p.parseIndex(n)
+		default:
+			return n, nil
 		}
-		v, err := valueFn(ctx, depth-1)
 		if err != nil {
 			return nil, err
 		}
-		argValues := make([]interface{}, len(args))
-		for i, arg := range args {
-			a, err := arg(ctx, depth-1)
+	}
+	return n, nil
+}
+
+// parseArgs parses a list of comma-separated expressions until the closing
+// parenthesis.
+func (p *parser) parseArgs() ([]*node, error) {
+	var args []*node
+	if p.consume(")") {
+		return nil, nil
+	}
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.consume(")") {
+			return args, nil
+		}
+		if !p.consume(",") {
+			return nil, sqerrors.Errorf("missing closing parenthesis `)` in `%s`", p.expr)
+		}
+	}
+}
+
+func (p *parser) parseCall(fn *node) (*node, error) {
+	args, err := p.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+	typ, err := p.checkCall(fn.typ, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &node{
+		typ: typ,
+		fn: func(ctx Context, depth int) (interface{}, error) {
+			if depth == 0 {
+				return nil, ErrMaxExecutionDepth
+			}
+			v, err := fn.fn(ctx, depth-1)
 			if err != nil {
 				return nil, err
 			}
-			argValues[i] = a
-		}
-		return execCall(v, argValues...)
-	}, buf, nil
+			argValues := make([]interface{}, len(args))
+			for i, arg := range args {
+				a, err := arg.fn(ctx, depth-1)
+				if err != nil {
+					return nil, err
+				}
+				argValues[i] = a
+			}
+			return execCall(v, argValues...)
+		},
+	}, nil
 }
 
-func compileField(valueFn valueFunc, buf string) (valueFunc, string, error) {
-	field, buf := parseIdentifier(buf)
-	field = strings.TrimRight(field, " ")
+func (p *parser) parseField(n *node) (*node, error) {
+	field := p.parseIdentifier()
 	if len(field) == 0 {
-		return nil, buf, sqerrors.New("unexpected empty field name")
+		return nil, sqerrors.New("unexpected empty field name")
+	}
+	typ, err := p.checkFieldAccess(n.typ, field)
+	if err != nil {
+		return nil, err
 	}
 
-	return func(ctx Context, depth int) (value interface{}, err error) {
+	return p.newPathNode(n, "."+field, typ, func(ctx Context, depth int) (value interface{}, err error) {
 		if depth == 0 {
 			return nil, ErrMaxExecutionDepth
 		}
-		v, err := valueFn(ctx, depth-1)
+		v, err := n.fn(ctx, depth-1)
 		if err != nil {
 			return nil, err
 		}
 		return execFieldAccess(v, field)
-	}, buf, nil
+	}), nil
 }
 
 func parseIndex(s string) (interface{}, error) {
@@ -262,86 +478,381 @@ func parseIndex(s string) (interface{}, error) {
 	return nil, sqerrors.Errorf("unexpected index value `%s`", s)
 }
 
-func compileOperand(buf string) (valueFunc, string, error) {
-	return compileIdentifier(buf)
+func (p *parser) parseIndex(n *node) (*node, error) {
+	close := strings.IndexByte(p.buf, ']')
+	if close == -1 {
+		return nil, sqerrors.Errorf("missing closing index bracket `]` in `%s`", p.expr)
+	}
+
+	index, err := parseIndex(p.buf[:close])
+	if err != nil {
+		return nil, err
+	}
+	p.buf = p.buf[close+1:]
+
+	typ, err := p.checkIndexAccess(n.typ, index)
+	if err != nil {
+		return nil, err
+	}
+
+	var pathIndex string
+	if str, ok := index.(string); ok {
+		pathIndex = "['" + str + "']"
+	} else {
+		pathIndex = "[" + strconv.Itoa(index.(int)) + "]"
+	}
+	return p.newPathNode(n, pathIndex, typ, func(ctx Context, depth int) (interface{}, error) {
+		if depth == 0 {
+			return nil, ErrMaxExecutionDepth
+		}
+		v, err := n.fn(ctx, depth-1)
+		if err != nil {
+			return nil, err
+		}
+		return execIndexAccess(v, index)
+	}), nil
+}
+
+// newPathNode returns the node of a field or index access of node `n`. Its
+// static type is looked up in the type environment when `n` is a path of the
+// context.
+func (p *parser) newPathNode(n *node, access string, typ reflect.Type, fn valueFunc) *node {
+	var path string
+	if n.path != "" {
+		path = n.path + access
+		if p.env != nil {
+			if envType, exists := p.env.Values[path]; exists {
+				typ = envType
+			}
+		}
+	}
+	return &node{fn: fn, typ: typ, path: path}
 }
 
-func parseIdentifier(buf string) (identifier string, newBuf string) {
-	switch separator := strings.IndexAny(buf, "([.|"); separator {
-	case -1:
-		return buf, buf[:0]
-	default:
-		return buf[:separator], buf[separator:]
+// parseIdentifier parses a Go identifier.
+func (p *parser) parseIdentifier() string {
+	i := strings.IndexFunc(p.buf, func(r rune) bool {
+		return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if i == -1 {
+		i = len(p.buf)
 	}
+	identifier := p.buf[:i]
+	p.buf = p.buf[i:]
+	return identifier
 }
 
-func compileIdentifier(buf string) (valueFunc, string, error) {
-	identifier, buf := parseIdentifier(buf)
-	identifier = strings.TrimSpace(identifier)
+func (p *parser) parseOperand() (*node, error) {
+	p.skipSpaces()
+	if len(p.buf) == 0 {
+		return nil, sqerrors.Errorf("unexpected end of expression `%s`", p.expr)
+	}
 
-	var valueFn valueFunc
-	switch identifier {
-	default:
-		// Try parse a string value
-		if l := len(identifier); l >= 2 && identifier[0] == '\'' && identifier[l-1] == '\'' {
-			str := identifier[1 : l-1]
-			valueFn = func(ctx Context, depth int) (interface{}, error) {
-				return str, nil
-			}
-			break
+	switch c := p.buf[0]; {
+	case c == '#':
+		p.buf = p.buf[1:]
+		var typ reflect.Type
+		if p.env != nil {
+			typ = p.env.Context
+		}
+		return &node{
+			typ:  typ,
+			path: "#",
+			fn: func(ctx Context, depth int) (interface{}, error) {
+				if f, ok := ctx.(*filterContext); ok {
+					return f.ctx, nil
+				}
+				return ctx, nil
+			},
+		}, nil
+
+	case c == '@':
+		p.buf = p.buf[1:]
+		return &node{
+			typ: p.elementType,
+			fn: func(ctx Context, depth int) (interface{}, error) {
+				f, ok := ctx.(*filterContext)
+				if !ok {
+					return nil, sqerrors.New("unexpected filter element `@` outside of a filter")
+				}
+				return f.element, nil
+			},
+		}, nil
+
+	case c == '(':
+		p.buf = p.buf[1:]
+		n, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
 		}
-		return nil, buf, sqerrors.Errorf("unknown identifier `%s`", identifier)
-	case "#":
-		valueFn = func(ctx Context, depth int) (interface{}, error) {
-			return ctx, nil
+		// The parenthesized expression is no longer a path
+		return &node{fn: n.fn, typ: n.typ}, nil
+
+	case c == '\'':
+		str, err := p.parseString()
+		if err != nil {
+			return nil, err
 		}
+		return constantValue(str), nil
+
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.parseNumber()
+	}
+
+	identifier := p.parseIdentifier()
+	switch identifier {
 	case "nil", "null":
-		valueFn = func(ctx Context, depth int) (interface{}, error) {
-			return nil, nil
+		return constantValue(nil), nil
+	case "true":
+		return constantValue(true), nil
+	case "false":
+		return constantValue(false), nil
+	case "":
+		return nil, sqerrors.Errorf("unexpected character `%c` in `%s`", p.buf[0], p.expr)
+	}
+
+	if !p.consume("(") {
+		return nil, sqerrors.Errorf("unknown identifier `%s`", identifier)
+	}
+	return p.parseBuiltinCall(identifier)
+}
+
+func constantValue(v interface{}) *node {
+	return &node{
+		typ: reflect.TypeOf(v),
+		fn: func(Context, int) (interface{}, error) {
+			return v, nil
+		},
+	}
+}
+
+// parseString parses a single-quoted string literal. Single quotes and
+// backslashes can be escaped with a backslash.
+func (p *parser) parseString() (string, error) {
+	var str strings.Builder
+	for i := 1; i < len(p.buf); i++ {
+		switch c := p.buf[i]; c {
+		case '\'':
+			p.buf = p.buf[i+1:]
+			return str.String(), nil
+		case '\\':
+			if i+1 < len(p.buf) {
+				i++
+				c = p.buf[i]
+			}
+			str.WriteByte(c)
+		default:
+			str.WriteByte(c)
 		}
 	}
+	return "", sqerrors.Errorf("missing closing quote `'` in `%s`", p.expr)
+}
 
-	return valueFn, buf, nil
+// parseNumber parses an integer literal into an int value, or a decimal
+// literal into a float64 value.
+func (p *parser) parseNumber() (*node, error) {
+	sign := 0
+	if p.buf[0] == '-' {
+		sign = 1
+	}
+	i := strings.IndexFunc(p.buf[sign:], func(r rune) bool {
+		return r != '.' && (r < '0' || r > '9')
+	})
+	if i == -1 {
+		i = len(p.buf)
+	} else {
+		i += sign
+	}
+	literal := p.buf[:i]
+	if n, err := strconv.Atoi(literal); err == nil {
+		p.buf = p.buf[i:]
+		return constantValue(n), nil
+	}
+	f, err := strconv.ParseFloat(literal, 64)
+	if err != nil {
+		return nil, sqerrors.Errorf("unexpected number `%s` in `%s`", literal, p.expr)
+	}
+	p.buf = p.buf[i:]
+	return constantValue(f), nil
 }
 
-func compileIndex(valueFn valueFunc, buf string) (valueFunc, string, error) {
-	close := strings.IndexByte(buf, ']')
-	if close == -1 {
-		return nil, buf, sqerrors.Errorf("missing closing index bracket `]` in `%s`", buf)
+func (p *parser) parseBuiltinCall(name string) (*node, error) {
+	if name == "matches" {
+		return p.parseMatches()
+	}
+	args, err := p.parseArgs()
+	if err != nil {
+		return nil, err
 	}
 
-	index, err := parseIndex(buf[:close])
+	var (
+		fn  func(args []interface{}) (interface{}, error)
+		typ reflect.Type
+	)
+	switch name {
+	case "len":
+		fn = func(args []interface{}) (interface{}, error) { return execLen(args[0]) }
+		typ = intType
+	case "lower":
+		fn = func(args []interface{}) (interface{}, error) { return execLower(args[0]) }
+		typ = stringType
+	case "contains":
+		fn = func(args []interface{}) (interface{}, error) { return execContains(args[0], args[1]) }
+		typ = boolType
+	case "type":
+		fn = func(args []interface{}) (interface{}, error) { return execType(args[0]), nil }
+		typ = stringType
+	default:
+		return nil, sqerrors.Errorf("unknown function `%s`", name)
+	}
+	if expected := builtinArity[name]; len(args) != expected {
+		return nil, sqerrors.Errorf("function `%s` expects %d arguments but got %d", name, expected, len(args))
+	}
+	if err := p.checkBuiltinCall(name, args); err != nil {
+		return nil, err
+	}
+
+	return &node{
+		typ: typ,
+		fn: func(ctx Context, depth int) (interface{}, error) {
+			if depth == 0 {
+				return nil, ErrMaxExecutionDepth
+			}
+			values := make([]interface{}, len(args))
+			for i, arg := range args {
+				v, err := arg.fn(ctx, depth-1)
+				if err != nil {
+					return nil, err
+				}
+				values[i] = v
+			}
+			return fn(values)
+		},
+	}, nil
+}
+
+var builtinArity = map[string]int{
+	"len":      1,
+	"lower":    1,
+	"contains": 2,
+	"type":     1,
+}
+
+// parseMatches parses the arguments of builtin function `matches()` whose
+// regular expression must be a string literal in order to be compiled once.
+func (p *parser) parseMatches() (*node, error) {
+	str, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	if !p.peek("'") {
+		return nil, sqerrors.Errorf("function `matches` expects a string literal regular expression in `%s`", p.expr)
+	}
+	pattern, err := p.parseString()
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(pattern)
 	if err != nil {
-		return nil, buf, err
+		return nil, sqerrors.Wrapf(err, "function `matches`: regular expression `%s`", pattern)
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if err := p.checkBuiltinCall("matches", []*node{str}); err != nil {
+		return nil, err
 	}
 
-	buf = buf[close:]
-	if len(buf) == 1 {
-		buf = buf[:0]
-	} else {
-		buf = buf[1:]
+	return &node{
+		typ: boolType,
+		fn: func(ctx Context, depth int) (interface{}, error) {
+			if depth == 0 {
+				return nil, ErrMaxExecutionDepth
+			}
+			v, err := str.fn(ctx, depth-1)
+			if err != nil {
+				return nil, err
+			}
+			s, err := execString(v)
+			if err != nil {
+				return nil, err
+			}
+			return re.MatchString(s), nil
+		},
+	}, nil
+}
+
+func (p *parser) parseTransformation(n *node) (*node, error) {
+	p.skipSpaces()
+	name := p.parseIdentifier()
+	if name == "filter" {
+		return p.parseFilter(n)
 	}
 
-	return func(ctx Context, depth int) (interface{}, error) {
-		if depth == 0 {
-			return nil, ErrMaxExecutionDepth
-		}
-		v, err := valueFn(ctx, depth-1)
-		if err != nil {
-			return nil, err
-		}
-		return execIndexAccess(v, index)
-	}, buf, nil
+	trFn, err := compileTransformation(name)
+	if err != nil {
+		return nil, err
+	}
+	return &node{
+		typ: interfaceSliceType,
+		fn: func(ctx Context, depth int) (value interface{}, err error) {
+			v, err := n.fn(ctx, depth)
+			if err != nil {
+				return nil, err
+			}
+			return trFn(ctx, v, newValueMaxDepth, NewValueMaxElements), nil
+		},
+	}, nil
+}
+
+func (p *parser) parseFilter(n *node) (*node, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	elementType, err := p.checkFilter(n.typ)
+	if err != nil {
+		return nil, err
+	}
+	// Type-check the predicate with the element type of the list
+	outerElementType := p.elementType
+	p.elementType = elementType
+	predicate, err := p.parseExpr()
+	p.elementType = outerElementType
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if err := p.checkBool(predicate); err != nil {
+		return nil, err
+	}
+
+	return &node{
+		typ: interfaceSliceType,
+		fn: func(ctx Context, depth int) (interface{}, error) {
+			v, err := n.fn(ctx, depth)
+			if err != nil {
+				return nil, err
+			}
+			return execFilter(ctx, v, predicate.fn, depth, NewValueMaxElements)
+		},
+	}, nil
 }
 
-func compileTransformation(buf string) (transformationFunc, error) {
-	buf = strings.TrimSpace(buf)
-	switch buf {
+func compileTransformation(name string) (transformationFunc, error) {
+	switch name {
 	case "flat_values":
 		return execFlatValues, nil
 	case "flat_keys":
 		return execFlatKeys, nil
 	default:
-		return nil, sqerrors.Errorf("unexpected transformation function `%s`", buf)
+		return nil, sqerrors.Errorf("unexpected transformation function `%s`", name)
 	}
 }
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"testing"
 
 	bindingaccessor "github.com/sqreen/go-agent/internal/binding-accessor"
@@ -477,6 +478,176 @@ func TestBindingAccessor(t *testing.T) {
 			Context:                [][][][][][][][][][][]int{{{{{{{{{{{33}}}}}}}}}}},
 			ExpectedExecutionError: bindingaccessor.ErrMaxExecutionDepth,
 		},
+		{
+			Title:         "integer value",
+			Expression:    `-42`,
+			ExpectedValue: -42,
+		},
+		{
+			Title:         "float value",
+			Expression:    `4.2`,
+			ExpectedValue: 4.2,
+		},
+		{
+			Title:         "boolean value",
+			Expression:    `true`,
+			ExpectedValue: true,
+		},
+		{
+			Title:         "escaped string value",
+			Expression:    `'it\'s'`,
+			ExpectedValue: "it's",
+		},
+		{
+			Title:                    "bad string value",
+			Expression:               `'test`,
+			ExpectedCompilationError: true,
+		},
+		{
+			Title:         "number comparison",
+			Expression:    `#.A > 32 && #.A <= 33.0 && #.B == 33`,
+			Context:       struct{ A, B uint8 }{A: 33, B: 33},
+			ExpectedValue: true,
+		},
+		{
+			Title:         "string comparison",
+			Expression:    `#.A == 'Sqreen' && #.A != 'sqreen' && #.A < 'Z'`,
+			Context:       struct{ A *string }{A: &[]string{"Sqreen"}[0]},
+			ExpectedValue: true,
+		},
+		{
+			Title:         "nil comparison",
+			Expression:    `#.A == nil && #.B != nil`,
+			Context:       struct{ A, B *int }{B: new(int)},
+			ExpectedValue: true,
+		},
+		{
+			Title:                  "bad comparison",
+			Expression:             `#.A < 'Sqreen'`,
+			Context:                struct{ A int }{A: 33},
+			ExpectedExecutionError: true,
+		},
+		{
+			Title:                  "bad ordering",
+			Expression:             `# < true`,
+			Context:                false,
+			ExpectedExecutionError: true,
+		},
+		{
+			Title:         "boolean operators",
+			Expression:    `!(#.A || #.B) || (#.A && !#.B)`,
+			Context:       struct{ A, B bool }{A: true, B: false},
+			ExpectedValue: true,
+		},
+		{
+			Title:         "boolean operator short-circuit",
+			Expression:    `false && #.Undefined`,
+			Context:       struct{}{},
+			ExpectedValue: false,
+		},
+		{
+			Title:                  "non-boolean operand",
+			Expression:             `!#`,
+			Context:                33,
+			ExpectedExecutionError: true,
+		},
+		{
+			Title:                    "unknown operator",
+			Expression:               `len(#) + 1`,
+			ExpectedCompilationError: true,
+		},
+		{
+			Title:      "len function",
+			Expression: `len(#.A) == 6 && len(#.B) == 2 && len(#.C) == 0`,
+			Context: struct {
+				A string
+				B map[int]int
+				C []int
+			}{A: "Sqreen", B: map[int]int{1: 1, 2: 2}},
+			ExpectedValue: true,
+		},
+		{
+			Title:         "lower function",
+			Expression:    `lower(#)`,
+			Context:       "SqReen",
+			ExpectedValue: "sqreen",
+		},
+		{
+			Title:      "contains function",
+			Expression: `contains(#.A, 'ree') && contains(#.B, 2) && contains(#.C, 'k') && !contains(#.B, 3)`,
+			Context: struct {
+				A string
+				B []int
+				C map[string]int
+			}{A: "Sqreen", B: []int{1, 2}, C: map[string]int{"k": 1}},
+			ExpectedValue: true,
+		},
+		{
+			Title:         "matches function",
+			Expression:    `matches(#, '^[a-z]+$')`,
+			Context:       "sqreen",
+			ExpectedValue: true,
+		},
+		{
+			Title:                    "matches function with a non-literal regular expression",
+			Expression:               `matches(#, #)`,
+			ExpectedCompilationError: true,
+		},
+		{
+			Title:                    "matches function with a bad regular expression",
+			Expression:               `matches(#, '(')`,
+			ExpectedCompilationError: true,
+		},
+		{
+			Title:         "type function",
+			Expression:    `#[0] | filter(type(@) != 'nil') | filter(type(@) == 'number' || type(@) == 'list')`,
+			Context:       [][]interface{}{{nil, 1, 1.5, "a", true, []int{}, map[int]int{}, struct{}{}}},
+			ExpectedValue: []interface{}{1, 1.5, []int{}},
+		},
+		{
+			Title:                    "unknown function",
+			Expression:               `upper(#)`,
+			ExpectedCompilationError: true,
+		},
+		{
+			Title:                    "bad number of function arguments",
+			Expression:               `len(#, #)`,
+			ExpectedCompilationError: true,
+		},
+		{
+			Title:      "filter transformation",
+			Expression: `# | flat_values | filter(type(@) == 'string' && len(@) > 5 && @ != #.Skip)`,
+			Context: struct {
+				Skip  string
+				Value []interface{}
+			}{
+				Skip:  "skipped",
+				Value: []interface{}{"short", 42, map[string]string{"k": "long enough"}, "longer"},
+			},
+			ExpectedValue: []interface{}{"long enough", "longer"},
+		},
+		{
+			Title:         "filter transformation of nil",
+			Expression:    `#.A | filter(@ > 1)`,
+			Context:       struct{ A interface{} }{},
+			ExpectedValue: nil,
+		},
+		{
+			Title:                  "filter transformation of a non-list",
+			Expression:             `# | filter(true)`,
+			Context:                33,
+			ExpectedExecutionError: true,
+		},
+		{
+			Title:                  "filter element outside of a filter",
+			Expression:             `@`,
+			ExpectedExecutionError: true,
+		},
+		{
+			Title:                    "missing filter parenthesis",
+			Expression:               `# | filter(true`,
+			ExpectedCompilationError: true,
+		},
 		{
 			Title:                  "less than max execution depth",
 			Expression:             `#[0][0][0][0][0][0][0][0][0][0]`,
@@ -657,6 +828,72 @@ func TestBindingAccessorUsage(t *testing.T) {
 	})
 }
 
+func TestTypeCheck(t *testing.T) {
+	type context struct {
+		A    int
+		B    map[string][]string
+		Func struct {
+			Args []interface{}
+		}
+		Methods *contextWithMethods
+		Any     interface{}
+		Error   error
+		private int
+	}
+
+	env := &bindingaccessor.TypeEnv{
+		Context: reflect.TypeOf(&context{}),
+		Values: map[string]reflect.Type{
+			"#.Func.Args[0]": reflect.TypeOf(&url.URL{}),
+		},
+	}
+
+	for _, tc := range []struct {
+		Expression string
+		Valid      bool
+	}{
+		{Expression: `#.A > 0 && len(#.B['k']) == 1`, Valid: true},
+		{Expression: `#.B['k'] | filter(len(@) > 1)`, Valid: true},
+		{Expression: `#.Methods.MyMethodField1 == 33 && #.Methods.MyMethodField2 == 'Sqreen'`, Valid: true},
+		{Expression: `#.Methods.MyMethodField3[0] && !#.Any.Whatever`, Valid: true},
+		{Expression: `#.Func.Args[0].Query['q'][0]`, Valid: true},
+		{Expression: `#.Func.Args[1].Query['q'][0]`, Valid: true},
+		{Expression: `#.Func.Args[0].Hostname == 'sqreen.com'`, Valid: true},
+		{Expression: `#.Error.Error == ''`, Valid: true},
+		{Expression: `#.C`},
+		{Expression: `#.private`},
+		{Expression: `#.A.B`},
+		{Expression: `#.A[0]`},
+		{Expression: `#.B[0]`},
+		{Expression: `#.B['k']['k']`},
+		{Expression: `#.A()`},
+		{Expression: `#.A == 'Sqreen'`},
+		{Expression: `#.B < #.B`},
+		{Expression: `#.A && true`},
+		{Expression: `lower(#.A)`},
+		{Expression: `len(#.A)`},
+		{Expression: `#.A | filter(true)`},
+		{Expression: `#.B['k'] | filter(@ > 1)`},
+		{Expression: `#.Func.Args[0].Oops`},
+		{Expression: `#.Func.Args[0].Query['q'][0] > 1`},
+		{Expression: `#.Error.Error(33)`},
+	} {
+		tc := tc
+		t.Run(tc.Expression, func(t *testing.T) {
+			// The expression compiles without type environment
+			_, err := bindingaccessor.Compile(tc.Expression)
+			require.NoError(t, err)
+
+			_, err = bindingaccessor.Compile(tc.Expression, bindingaccessor.WithTypeEnv(env))
+			if tc.Valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
 type FlattenedResult []interface{}
 
 func requireEqualFlatResult(t *testing.T, expected FlattenedResult, value interface{}) {
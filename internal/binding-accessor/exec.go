@@ -8,6 +8,7 @@ package bindingaccessor
 
 import (
 	"reflect"
+	"strings"
 	"unicode"
 
 	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
@@ -289,3 +290,220 @@ func flatKeys(v reflect.Value, depth int, elements *int) []interface{} {
 	}
 	return values
 }
+
+// indirect dereferences pointer and interface values.
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func execBool(v interface{}) (bool, error) {
+	b := indirect(reflect.ValueOf(v))
+	if b.Kind() != reflect.Bool {
+		return false, sqerrors.Errorf("unexpected non-boolean value `%[1]v` of type `%[1]T`", v)
+	}
+	return b.Bool(), nil
+}
+
+func execString(v interface{}) (string, error) {
+	s := indirect(reflect.ValueOf(v))
+	if s.Kind() != reflect.String {
+		return "", sqerrors.Errorf("unexpected non-string value `%[1]v` of type `%[1]T`", v)
+	}
+	return s.String(), nil
+}
+
+// toFloat returns the float64 value of numeric values.
+func toFloat(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+// execComparison compares numbers, strings, booleans and nil values with the
+// given comparison operator. Other values can only be compared for equality.
+func execComparison(op string, left, right interface{}) (bool, error) {
+	l, r := indirect(reflect.ValueOf(left)), indirect(reflect.ValueOf(right))
+
+	var cmp int
+	switch lf, lok := toFloat(l); {
+	case !l.IsValid() || !r.IsValid():
+		// At least one nil value
+		if op != "==" && op != "!=" {
+			return false, sqerrors.Errorf("cannot compare nil values with operator `%s`", op)
+		}
+		if l.IsValid() != r.IsValid() {
+			cmp = 1
+		}
+
+	case lok:
+		rf, ok := toFloat(r)
+		if !ok {
+			return false, sqerrors.Errorf("cannot compare number `%v` with value `%[2]v` of type `%[2]T`", left, right)
+		}
+		switch {
+		case lf < rf:
+			cmp = -1
+		case lf > rf:
+			cmp = 1
+		}
+
+	case l.Kind() == reflect.String:
+		if r.Kind() != reflect.String {
+			return false, sqerrors.Errorf("cannot compare string `%v` with value `%[2]v` of type `%[2]T`", left, right)
+		}
+		cmp = strings.Compare(l.String(), r.String())
+
+	default:
+		if op != "==" && op != "!=" {
+			return false, sqerrors.Errorf("cannot order values of type `%T` and `%T`", left, right)
+		}
+		if !reflect.DeepEqual(l.Interface(), r.Interface()) {
+			cmp = 1
+		}
+	}
+
+	switch op {
+	case "==":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	default:
+		return false, sqerrors.Errorf("unexpected comparison operator `%s`", op)
+	}
+}
+
+func execLen(v interface{}) (int, error) {
+	value := indirect(reflect.ValueOf(v))
+	switch value.Kind() {
+	case reflect.Invalid:
+		return 0, nil
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return value.Len(), nil
+	default:
+		return 0, sqerrors.Errorf("cannot get the length of value `%[1]v` of type `%[1]T`", v)
+	}
+}
+
+func execLower(v interface{}) (string, error) {
+	s, err := execString(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(s), nil
+}
+
+// execContains returns true when string `v` contains substring `e`, when list
+// `v` contains element `e`, or when map `v` has key `e`.
+func execContains(v, e interface{}) (bool, error) {
+	value := indirect(reflect.ValueOf(v))
+	switch value.Kind() {
+	case reflect.Invalid:
+		return false, nil
+
+	case reflect.String:
+		s, err := execString(e)
+		if err != nil {
+			return false, err
+		}
+		return strings.Contains(value.String(), s), nil
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if eq, err := execComparison("==", value.Index(i).Interface(), e); err == nil && eq {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case reflect.Map:
+		key := reflect.ValueOf(e)
+		if !key.IsValid() || !key.Type().AssignableTo(value.Type().Key()) {
+			return false, nil
+		}
+		return value.MapIndex(key).IsValid(), nil
+
+	default:
+		return false, sqerrors.Errorf("cannot search into value `%[1]v` of type `%[1]T`", v)
+	}
+}
+
+// execType returns the name of the type of the value, among `nil`, `bool`,
+// `number`, `string`, `list`, `map` and `object`.
+func execType(v interface{}) string {
+	value := indirect(reflect.ValueOf(v))
+	if _, ok := toFloat(value); ok {
+		return "number"
+	}
+	switch value.Kind() {
+	case reflect.Invalid:
+		return "nil"
+	case reflect.Bool:
+		return "bool"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "list"
+	case reflect.Map:
+		return "map"
+	default:
+		return "object"
+	}
+}
+
+// filterContext is the context of filter predicates, providing the filtered
+// element `@` along with the context `#` of the filtered expression.
+type filterContext struct {
+	ctx     Context
+	element interface{}
+}
+
+// execFilter returns the list of elements of list `v` for which the predicate
+// is true. The output list cannot exceed maxElements.
+func execFilter(ctx Context, v interface{}, predicate valueFunc, depth, maxElements int) (interface{}, error) {
+	list := indirect(reflect.ValueOf(v))
+	switch list.Kind() {
+	case reflect.Invalid:
+		return nil, nil
+	case reflect.Slice, reflect.Array:
+	default:
+		return nil, sqerrors.Errorf("cannot filter value `%[1]v` of type `%[1]T`", v)
+	}
+
+	if f, ok := ctx.(*filterContext); ok {
+		// Nested filters keep the root context
+		ctx = f.ctx
+	}
+	var values []interface{}
+	for i := 0; i < list.Len() && len(values) < maxElements; i++ {
+		element := list.Index(i).Interface()
+		keep, err := evalBool(predicate, &filterContext{ctx: ctx, element: element}, depth)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			values = append(values, element)
+		}
+	}
+	return values, nil
+}
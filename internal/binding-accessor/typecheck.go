@@ -0,0 +1,289 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package bindingaccessor
+
+import (
+	"reflect"
+
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+)
+
+// TypeEnv is the static type environment of binding accessor expressions.
+// Expressions compiled with a type environment are type-checked so that
+// ill-typed expressions, such as accesses to fields that do not exist or
+// indexes of the wrong type, are rejected by Compile instead of failing every
+// time they are executed. Values whose static type is unknown, such as
+// `interface{}` values, are only checked when executed.
+type TypeEnv struct {
+	// Context is the static type of the context `#`.
+	Context reflect.Type
+	// Values are the static types of context values that are more precise than
+	// their Go type, such as the arguments of a hooked function call whose Go
+	// type is `interface{}`. They are indexed by their expression path, such as
+	// `#.Func.Args[0]` or `#.Rule.Data.Values['key']`.
+	Values map[string]reflect.Type
+}
+
+// CompileOption is an option of Compile.
+type CompileOption func(*compileConfig)
+
+type compileConfig struct {
+	env *TypeEnv
+}
+
+// WithTypeEnv type-checks the expression against the given type environment.
+func WithTypeEnv(env *TypeEnv) CompileOption {
+	return func(cfg *compileConfig) {
+		cfg.env = env
+	}
+}
+
+var (
+	boolType           = reflect.TypeOf(false)
+	intType            = reflect.TypeOf(0)
+	stringType         = reflect.TypeOf("")
+	interfaceSliceType = reflect.TypeOf([]interface{}(nil))
+	errorType          = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// staticType returns the given type, or nil when the type doesn't give any
+// static information on the actual value type, ie. empty interface types.
+func staticType(t reflect.Type) reflect.Type {
+	if t == nil || (t.Kind() == reflect.Interface && t.NumMethod() == 0) {
+		return nil
+	}
+	return t
+}
+
+// concreteType returns the type of the value pointed to by the given type, or
+// nil when it is unknown, like the execution dereferences pointer and
+// interface values.
+func concreteType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() == reflect.Interface {
+		return nil
+	}
+	return t
+}
+
+func isNumber(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *parser) checkBool(n *node) error {
+	if t := concreteType(n.typ); t != nil && t.Kind() != reflect.Bool {
+		return sqerrors.Errorf("unexpected non-boolean value of type `%s` in `%s`", t, p.expr)
+	}
+	return nil
+}
+
+func (p *parser) checkComparison(op string, left, right *node) error {
+	l, r := concreteType(left.typ), concreteType(right.typ)
+	if l == nil || r == nil {
+		return nil
+	}
+	switch {
+	case isNumber(l):
+		if !isNumber(r) {
+			return sqerrors.Errorf("cannot compare number of type `%s` with value of type `%s` in `%s`", l, r, p.expr)
+		}
+	case l.Kind() == reflect.String:
+		if r.Kind() != reflect.String {
+			return sqerrors.Errorf("cannot compare string of type `%s` with value of type `%s` in `%s`", l, r, p.expr)
+		}
+	case op != "==" && op != "!=":
+		return sqerrors.Errorf("cannot order values of type `%s` and `%s` in `%s`", l, r, p.expr)
+	}
+	return nil
+}
+
+// checkFieldAccess returns the static type of the field or method `field` of
+// a value of type `t`, following the same lookup order as execFieldAccess.
+func (p *parser) checkFieldAccess(t reflect.Type, field string) (reflect.Type, error) {
+	if t = staticType(t); t == nil {
+		return nil, nil
+	}
+	for {
+		switch t.Kind() {
+		case reflect.Ptr:
+			if m, ok := t.MethodByName(field); ok {
+				return p.checkMethodAccess(m, true)
+			}
+			t = t.Elem()
+			continue
+
+		case reflect.Interface:
+			if m, ok := t.MethodByName(field); ok {
+				return p.checkMethodAccess(m, false)
+			}
+			// The method may be provided by the actual value type
+			return nil, nil
+
+		case reflect.Struct:
+			if f, ok := t.FieldByName(field); ok {
+				if f.PkgPath != "" {
+					return nil, sqerrors.Errorf("unexpected access to unexported field `%s` of type `%s` in `%s`", field, t, p.expr)
+				}
+				return staticType(f.Type), nil
+			}
+			fallthrough
+
+		default:
+			if m, ok := t.MethodByName(field); ok {
+				return p.checkMethodAccess(m, true)
+			}
+			return nil, sqerrors.Errorf("no field nor method `%s` found in type `%s` in `%s`", field, t, p.expr)
+		}
+	}
+}
+
+// checkMethodAccess returns the static type of the method access, ie. the
+// type of the method call result when the method has no arguments, or the
+// type of the method value otherwise.
+func (p *parser) checkMethodAccess(m reflect.Method, hasReceiver bool) (reflect.Type, error) {
+	fnType := m.Type
+	first := 0
+	if hasReceiver {
+		first = 1
+	}
+	if fnType.NumIn() == first {
+		return p.checkCallResults(fnType)
+	}
+
+	in := make([]reflect.Type, 0, fnType.NumIn()-first)
+	for i := first; i < fnType.NumIn(); i++ {
+		in = append(in, fnType.In(i))
+	}
+	out := make([]reflect.Type, fnType.NumOut())
+	for i := range out {
+		out[i] = fnType.Out(i)
+	}
+	return reflect.FuncOf(in, out, fnType.IsVariadic()), nil
+}
+
+// checkCallResults returns the static type of the result of a call to a
+// function of type `fnType`, which must return a value and an optional error.
+func (p *parser) checkCallResults(fnType reflect.Type) (reflect.Type, error) {
+	switch n := fnType.NumOut(); {
+	case n != 1 && n != 2:
+		return nil, sqerrors.Errorf("unexpected number of function results of function `%s` in `%s`", fnType, p.expr)
+	case n == 2 && !fnType.Out(1).Implements(errorType):
+		return nil, sqerrors.Errorf("unexpected second function results type of function `%s` in `%s`: expected `error`", fnType, p.expr)
+	}
+	return staticType(fnType.Out(0)), nil
+}
+
+// checkCall returns the static type of the result of the call to a function
+// of type `t` with the given arguments.
+func (p *parser) checkCall(t reflect.Type, args ...*node) (reflect.Type, error) {
+	if t = staticType(t); t == nil || t.Kind() == reflect.Interface {
+		return nil, nil
+	}
+	if t.Kind() != reflect.Func {
+		return nil, sqerrors.Errorf("cannot call value of type `%s` in `%s`", t, p.expr)
+	}
+
+	nbParams := t.NumIn()
+	if t.IsVariadic() {
+		if len(args) < nbParams-1 {
+			return nil, sqerrors.Errorf("function `%s` expects at least %d arguments but got %d in `%s`", t, nbParams-1, len(args), p.expr)
+		}
+	} else if len(args) != nbParams {
+		return nil, sqerrors.Errorf("function `%s` expects %d arguments but got %d in `%s`", t, nbParams, len(args), p.expr)
+	}
+
+	for i, arg := range args {
+		argType := staticType(arg.typ)
+		if argType == nil {
+			continue
+		}
+		var paramType reflect.Type
+		if t.IsVariadic() && i >= nbParams-1 {
+			paramType = t.In(nbParams - 1).Elem()
+		} else {
+			paramType = t.In(i)
+		}
+		if !argType.AssignableTo(paramType) {
+			return nil, sqerrors.Errorf("cannot use value of type `%s` as argument %d of type `%s` of function `%s` in `%s`", argType, i, paramType, t, p.expr)
+		}
+	}
+
+	return p.checkCallResults(t)
+}
+
+// checkIndexAccess returns the static type of the value at the given index of
+// a value of type `t`, following the same rules as execIndexAccess.
+func (p *parser) checkIndexAccess(t reflect.Type, index interface{}) (reflect.Type, error) {
+	if t = concreteType(t); t == nil {
+		return nil, nil
+	}
+	switch t.Kind() {
+	case reflect.Func:
+		return p.checkCall(t, constantValue(index))
+	case reflect.Map:
+		if indexType := reflect.TypeOf(index); !indexType.AssignableTo(t.Key()) {
+			return nil, sqerrors.Errorf("cannot index map of type `%s` with index `%v` of type `%s` in `%s`", t, index, indexType, p.expr)
+		}
+		return staticType(t.Elem()), nil
+	case reflect.Slice:
+		if _, ok := index.(int); !ok {
+			return nil, sqerrors.Errorf("cannot index slice of type `%s` with non-integer index `%v` in `%s`", t, index, p.expr)
+		}
+		return staticType(t.Elem()), nil
+	default:
+		return nil, sqerrors.Errorf("cannot index value of type `%s` in `%s`", t, p.expr)
+	}
+}
+
+func (p *parser) checkBuiltinCall(name string, args []*node) error {
+	t := concreteType(args[0].typ)
+	if t == nil {
+		return nil
+	}
+	switch name {
+	case "len", "contains":
+		switch t.Kind() {
+		case reflect.String:
+			if name == "contains" {
+				if e := concreteType(args[1].typ); e != nil && e.Kind() != reflect.String {
+					return sqerrors.Errorf("function `contains` expects a substring instead of a value of type `%s` in `%s`", e, p.expr)
+				}
+			}
+		case reflect.Slice, reflect.Array, reflect.Map:
+		default:
+			return sqerrors.Errorf("function `%s` cannot be used with values of type `%s` in `%s`", name, t, p.expr)
+		}
+	case "lower", "matches":
+		if t.Kind() != reflect.String {
+			return sqerrors.Errorf("function `%s` expects a string instead of a value of type `%s` in `%s`", name, t, p.expr)
+		}
+	}
+	return nil
+}
+
+// checkFilter returns the static type of the elements of the filtered list of
+// type `t`.
+func (p *parser) checkFilter(t reflect.Type) (elementType reflect.Type, err error) {
+	if t = concreteType(t); t == nil {
+		return nil, nil
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return staticType(t.Elem()), nil
+	default:
+		return nil, sqerrors.Errorf("cannot filter value of type `%s` in `%s`", t, p.expr)
+	}
+}
@@ -22,6 +22,7 @@ import (
 
 	"github.com/sqreen/go-agent/internal/plog"
 	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqsanitize"
 
 	"github.com/spf13/viper"
 )
@@ -230,6 +231,7 @@ const (
 	configKeyDisableSignalBackend      = `disable_signal_backend`
 	configKeyStripSensitiveKeyRegexp   = `strip_sensitive_key_regexp`
 	configKeyStripSensitiveValueRegexp = `strip_sensitive_value_regexp`
+	configKeyCookieSigningKey          = `cookie_signing_key`
 )
 
 // User configuration's default values.
@@ -240,14 +242,11 @@ const (
 	configDefaultMaxMetricsStoreLength = 100 * 1024 * 1024
 
 	// configDefaultStripSensitiveKeyRegexp is the scrubber key regular expression (cf. scrubber doc
-	// for usage). It is a case-insensitive regexp matching passwd, password,
-	// passphrase, secret, authorization, api_key, apikey, accesstoken,
-	// access_token and token.
-	configDefaultStripSensitiveKeyRegexp = `(?i)(passw(((or)?d))|(phrase))|(secret)|(authorization)|(api_?key)|((access_?)?token)`
+	// for usage).
+	configDefaultStripSensitiveKeyRegexp = sqsanitize.DefaultKeyRegexp
 	// configDefaultStripSensitiveValueRegexp is the scrubber value regular expression (cf. scrubber
-	// doc for usage). It matches credit card numbers with space, dash or no
-	// number separators.
-	configDefaultStripSensitiveValueRegexp = `(?:\d[ -]*?){13,16}`
+	// doc for usage).
+	configDefaultStripSensitiveValueRegexp = sqsanitize.DefaultValueRegexp
 	ScrubberRedactedString                 = `<Redacted by Sqreen>`
 )
 
@@ -279,6 +278,7 @@ func New(logger *plog.Logger) (*Config, error) {
 		{key: configKeyDisableSignalBackend, defaultValue: "", hidden: true},
 		{key: configKeyStripSensitiveKeyRegexp, defaultValue: configDefaultStripSensitiveKeyRegexp},
 		{key: configKeyStripSensitiveValueRegexp, defaultValue: configDefaultStripSensitiveValueRegexp},
+		{key: configKeyCookieSigningKey, defaultValue: "", hidden: true},
 	}
 	for _, p := range parameters {
 		manager.SetDefault(p.key, p.defaultValue)
@@ -459,6 +459,12 @@ func (c *Config) stripSensitiveValueRegexp() (*regexp.Regexp, error) {
 	return regexp.Compile(expr)
 }
 
+// CookieSigningKey returns the HMAC secret key to use to sign and verify the
+// cookies protected against tampering. Cookie signing is disabled when empty.
+func (c *Config) CookieSigningKey() string {
+	return sanitizeString(c.GetString(configKeyCookieSigningKey))
+}
+
 func sanitizeString(s string) string {
 	return strings.TrimSpace(s)
 }
@@ -54,6 +54,12 @@ func TestUserConfig(t *testing.T) {
 			ConfigKey:   configKeyBackendHTTPAPIProxy,
 			SomeValue:   testlib.RandUTF8String(2, 30),
 		},
+		{
+			Name:        "Cookie Signing Key",
+			GetCfgValue: cfg.CookieSigningKey,
+			ConfigKey:   configKeyCookieSigningKey,
+			SomeValue:   testlib.RandUTF8String(2, 30),
+		},
 	}
 	for _, tc := range stringValueTests {
 		testStringValue(t, cfg, tc.Name, tc.GetCfgValue, tc.ConfigKey, tc.DefaultValue, tc.SomeValue)
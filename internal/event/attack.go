@@ -20,6 +20,23 @@ func WithTest(t bool) AttackEventOption {
 	}
 }
 
+// WithBlocked allows callbacks mitigating the attack by other means than
+// blocking the request, such as rewriting a function argument, to report it as
+// blocked.
+func WithBlocked(b bool) AttackEventOption {
+	return func(e *AttackEvent) {
+		e.Blocked = b
+	}
+}
+
+// WithAttackType allows callbacks detecting a specific kind of attack to
+// override the attack type of the rule.
+func WithAttackType(t string) AttackEventOption {
+	return func(e *AttackEvent) {
+		e.AttackType = t
+	}
+}
+
 func WithStackTrace() AttackEventOption {
 	return func(e *AttackEvent) {
 		e.StackTrace = callers()
@@ -53,6 +53,18 @@ func (p *RootHTTPProtectionContext) DeadlineExceeded(needed time.Duration) (exce
 	return p.sqreenTime.Duration()+needed >= p.maxSqreenTime
 }
 
+func (p *RootHTTPProtectionContext) RemainingTime() (remaining time.Duration, limited bool) {
+	if p.maxSqreenTime <= 0 {
+		// No max time duration
+		return 0, false
+	}
+	remaining = p.maxSqreenTime - p.sqreenTime.Duration()
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
+
 func (p *RootHTTPProtectionContext) Config() http_protection_types.ConfigReader {
 	return p.agent.config
 }
@@ -63,6 +63,18 @@ func NewTimeHistogram(period time.Duration, maxLength int) *TimeHistogram {
 // Add delta to the given key, inserting it if it doesn't exist. This method
 // is thread-safe and optimized for updating existing keys.
 func (s *TimeHistogram) Add(key interface{}, delta uint64) error {
+	return s.update(key, delta, false)
+}
+
+// Set the value of the given key, inserting it if it doesn't exist. Unlike
+// Add, the previous value of the key in the current time bucket is replaced so
+// that the store keeps the last value, such as a gauge. This method is
+// thread-safe.
+func (s *TimeHistogram) Set(key interface{}, value uint64) error {
+	return s.update(key, value, true)
+}
+
+func (s *TimeHistogram) update(key interface{}, value uint64, set bool) error {
 	// Avoid panic-ing by checking the key type is not nil and comparable.
 	if key == nil {
 		return sqerrors.New("unexpected key value `nil`")
@@ -74,11 +86,11 @@ func (s *TimeHistogram) Add(key interface{}, delta uint64) error {
 	s.flushLock.RLock()
 	defer s.flushLock.RUnlock()
 
-	_, err := s.add(key, delta)
+	_, err := s.add(key, value, set)
 	return err
 }
 
-func (s *TimeHistogram) add(key interface{}, delta uint64) (TimeHistogramBucketKeyType, error) {
+func (s *TimeHistogram) add(key interface{}, delta uint64, set bool) (TimeHistogramBucketKeyType, error) {
 	s.once.Do(func() {
 		now := time.Now().Truncate(s.period)
 		s.start = now
@@ -119,8 +131,12 @@ func (s *TimeHistogram) add(key interface{}, delta uint64) (TimeHistogramBucketK
 	}
 
 	// The key value was loaded - atomically update the value.
-	sum := actual.(*uint64)
-	atomic.AddUint64(sum, delta)
+	v := actual.(*uint64)
+	if set {
+		atomic.StoreUint64(v, delta)
+	} else {
+		atomic.AddUint64(v, delta)
+	}
 
 	return bucket, nil
 }
@@ -289,7 +305,7 @@ func (s *PerfHistogram) Add(v float64) error {
 	defer s.timeHistogram.flushLock.RUnlock()
 
 	perfBucket := s.bucket(v)
-	timeBucket, err := s.timeHistogram.add(perfBucket, 1)
+	timeBucket, err := s.timeHistogram.add(perfBucket, 1, false)
 	if err != nil {
 		return err
 	}
@@ -169,6 +169,36 @@ func TestTimeHistogram(t *testing.T) {
 				ready)
 		})
 
+		t.Run("setting values replaces them", func(t *testing.T) {
+			t.Parallel()
+			period := MinTestPeriod
+			store := metrics.NewTimeHistogram(period, MaxStoreLen)
+
+			testStartedAt := time.Now()
+
+			require.NoError(t, store.Set("key 1", 10))
+			require.NoError(t, store.Set("key 1", 20))
+			require.NoError(t, store.Add("key 2", 1))
+			require.NoError(t, store.Set("key 2", 5))
+
+			testFinishedAt := time.Now()
+
+			time.Sleep(period)
+			require.True(t, store.Ready())
+
+			ready := store.Flush()
+			checkTimeHistogram(
+				t,
+				period,
+				testStartedAt,
+				testFinishedAt,
+				metrics.ReadyStoreMap{
+					"key 1": 20,
+					"key 2": 5,
+				},
+				ready)
+		})
+
 		t.Run("key types", func(t *testing.T) {
 			t.Parallel()
 			period := MinTestPeriod
@@ -16,8 +16,9 @@ type EventRecorderMockup struct {
 }
 
 var (
-	_ protection_context.EventRecorder = (*EventRecorderMockup)(nil)
-	_ protection_context.CustomEvent   = (*EventRecorderMockup)(nil)
+	_ protection_context.EventRecorder  = (*EventRecorderMockup)(nil)
+	_ protection_context.CustomEvent    = (*EventRecorderMockup)(nil)
+	_ protection_context.CSPNonceGetter = (*EventRecorderMockup)(nil)
 )
 
 func (e *EventRecorderMockup) TrackEvent(event string) protection_context.CustomEvent {
@@ -76,3 +77,11 @@ func (e *EventRecorderMockup) WithUserIdentifiers(id map[string]string) {
 func (e *EventRecorderMockup) ExpectWithUserIdentifiers(id interface{}) *mock.Call {
 	return e.On("WithUserIdentifiers", id)
 }
+
+func (e *EventRecorderMockup) CSPNonce() string {
+	return e.Called().String(0)
+}
+
+func (e *EventRecorderMockup) ExpectCSPNonce() *mock.Call {
+	return e.On("CSPNonce")
+}
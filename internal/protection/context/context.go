@@ -42,6 +42,14 @@ type (
 		IdentifyUser(id map[string]string) error
 	}
 
+	// CSPNonceGetter is the interface of protection contexts providing the
+	// Content-Security-Policy nonce of the request.
+	CSPNonceGetter interface {
+		// CSPNonce returns the random nonce of the request to use in the
+		// Content-Security-Policy header and in the HTML script and style tags.
+		CSPNonce() string
+	}
+
 	CustomEvent interface {
 		WithTimestamp(t time.Time)
 		WithProperties(props EventProperties)
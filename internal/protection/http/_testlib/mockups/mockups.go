@@ -58,6 +58,10 @@ func (r *RequestReaderMockup) Header(header string) (value *string) {
 	return value
 }
 
+func (r *RequestReaderMockup) ExpectHeader(header string) *mock.Call {
+	return r.On("Header", header)
+}
+
 func (r *RequestReaderMockup) Headers() http.Header {
 	h, _ := r.Called().Get(0).(http.Header)
 	return h
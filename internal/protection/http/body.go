@@ -0,0 +1,465 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package http
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"mime/multipart"
+	"strconv"
+	"strings"
+
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+)
+
+// Limits of the request body parsing performed by the agent.
+const (
+	// MaxStructuredBodySize is the maximum size of the XML and msgpack request
+	// bodies parsed by the agent, and the maximum size of the multipart bodies
+	// parsed before ignoring the following parts.
+	MaxStructuredBodySize = 256 * 1024
+	// MaxStructuredBodyDepth is the maximum nesting depth of the parsed values.
+	MaxStructuredBodyDepth = 32
+	// MaxStructuredBodyElements is the maximum number of elements of the
+	// parsed values, ie. XML elements and attributes, msgpack values or
+	// multipart parts.
+	MaxStructuredBodyElements = 4096
+	// maxMultipartFieldSize is the maximum size of the multipart field values.
+	maxMultipartFieldSize = 4096
+)
+
+// Request parameter sources of the request bodies parsed by the agent.
+const (
+	XMLRequestParamSource       = "xml"
+	MultipartRequestParamSource = "multipart"
+	MsgpackRequestParamSource   = "msgpack"
+)
+
+// parseBody parses the request body read so far according to its content
+// type, and adds the resulting value to the request parameters. It is a no-op
+// for the content types parsed by the frameworks, such as JSON or URL-encoded
+// forms, and for bodies that cannot be parsed within the limits.
+func (p *ProtectionContext) parseBody() {
+	r := p.requestReader
+	if r.bodyParsed {
+		return
+	}
+	r.bodyParsed = true
+
+	contentType := r.Header("Content-Type")
+	if contentType == nil {
+		return
+	}
+	mediaType, params, err := mime.ParseMediaType(*contentType)
+	if err != nil {
+		return
+	}
+
+	body := r.Body()
+	if len(body) == 0 {
+		return
+	}
+
+	var (
+		source string
+		value  interface{}
+	)
+	switch {
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		if len(body) > MaxStructuredBodySize {
+			return
+		}
+		source = XMLRequestParamSource
+		value, err = parseXMLBody(body)
+
+	case mediaType == "multipart/form-data":
+		source = MultipartRequestParamSource
+		value, err = parseMultipartBody(body, params["boundary"])
+
+	case mediaType == "application/msgpack" || mediaType == "application/x-msgpack" || mediaType == "application/vnd.msgpack":
+		if len(body) > MaxStructuredBodySize {
+			return
+		}
+		source = MsgpackRequestParamSource
+		value, err = parseMsgpackBody(body)
+
+	default:
+		return
+	}
+
+	if err != nil || value == nil {
+		return
+	}
+	p.AddRequestParam(source, value)
+}
+
+// parseXMLBody parses the XML document into a tree of maps where element
+// attributes are prefixed by `@`, child elements are lists of values indexed
+// by their name, and the element text is `#text`. Elements having text only
+// are parsed into their string value. The document type definitions are not
+// processed, so that external entities are never resolved.
+func parseXMLBody(body []byte) (interface{}, error) {
+	d := xml.NewDecoder(bytes.NewReader(body))
+	// Only the predefined XML entities are allowed, and documents in another
+	// encoding than UTF-8 are rejected since no charset reader is provided.
+	d.Strict = true
+	d.Entity = nil
+
+	elements := 0
+	var parse func(start xml.StartElement, depth int) (interface{}, error)
+	parse = func(start xml.StartElement, depth int) (interface{}, error) {
+		if depth > MaxStructuredBodyDepth {
+			return nil, sqerrors.New("maximum xml depth reached")
+		}
+		elements += 1 + len(start.Attr)
+		if elements > MaxStructuredBodyElements {
+			return nil, sqerrors.New("maximum number of xml elements reached")
+		}
+
+		node := make(map[string]interface{}, len(start.Attr))
+		for _, attr := range start.Attr {
+			node["@"+attr.Name.Local] = attr.Value
+		}
+		var text strings.Builder
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch tok := tok.(type) {
+			case xml.StartElement:
+				child, err := parse(tok, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				children, _ := node[tok.Name.Local].([]interface{})
+				node[tok.Name.Local] = append(children, child)
+			case xml.CharData:
+				text.Write(tok)
+			case xml.EndElement:
+				s := strings.TrimSpace(text.String())
+				if len(node) == 0 {
+					return s, nil
+				}
+				if s != "" {
+					node["#text"] = s
+				}
+				return node, nil
+			}
+		}
+	}
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = sqerrors.New("missing xml root element")
+			}
+			return nil, err
+		}
+		// Skip the prolog, including the document type definitions
+		if start, ok := tok.(xml.StartElement); ok {
+			root, err := parse(start, 1)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{start.Name.Local: root}, nil
+		}
+	}
+}
+
+// parseMultipartBody parses the multipart form into its field values and the
+// metadata of its files: their field name, file name, content type and size.
+// The file contents are not kept. Only the first MaxStructuredBodySize bytes
+// of the body are parsed: the parts following them are ignored, and the size
+// of a file truncated by the limit is the size of its parsed content.
+func parseMultipartBody(body []byte, boundary string) (interface{}, error) {
+	if boundary == "" {
+		return nil, sqerrors.New("missing multipart boundary")
+	}
+
+	truncated := len(body) > MaxStructuredBodySize
+	if truncated {
+		body = body[:MaxStructuredBodySize]
+	}
+
+	fields := map[string][]string{}
+	var files []interface{}
+	r := multipart.NewReader(bytes.NewReader(body), boundary)
+	for i := 0; ; i++ {
+		if i >= MaxStructuredBodyElements {
+			return nil, sqerrors.New("maximum number of multipart parts reached")
+		}
+		part, err := r.NextPart()
+		if err == io.EOF || err != nil && truncated {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		name := part.FormName()
+		if filename := multipartFileName(part); filename != "" {
+			size, err := io.Copy(ioutil.Discard, part)
+			if err != nil && !truncated {
+				return nil, err
+			}
+			files = append(files, map[string]interface{}{
+				"name":         name,
+				"filename":     filename,
+				"content_type": part.Header.Get("Content-Type"),
+				"size":         size,
+			})
+			if err != nil {
+				break
+			}
+			continue
+		}
+
+		value, err := ioutil.ReadAll(io.LimitReader(part, maxMultipartFieldSize))
+		if err != nil && !truncated {
+			return nil, err
+		}
+		fields[name] = append(fields[name], string(value))
+		if err != nil {
+			break
+		}
+	}
+
+	form := map[string]interface{}{
+		"fields": fields,
+	}
+	if len(files) > 0 {
+		form["files"] = files
+	}
+	return form, nil
+}
+
+// multipartFileName returns the file name of the part as sent by the client.
+// Unlike multipart.(*Part).FileName(), the file path is kept so that path
+// traversals can be detected.
+func multipartFileName(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	return params["filename"]
+}
+
+// parseMsgpackBody parses the msgpack value. Map keys are converted to
+// strings, binary values are parsed into strings and extension values are
+// ignored.
+func parseMsgpackBody(body []byte) (interface{}, error) {
+	d := msgpackDecoder{buf: body}
+	v, err := d.decode(1)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.buf) != 0 {
+		return nil, sqerrors.New("unexpected trailing msgpack data")
+	}
+	return v, nil
+}
+
+type msgpackDecoder struct {
+	buf      []byte
+	elements int
+}
+
+var errMsgpackUnexpectedEOF = sqerrors.New("unexpected end of msgpack data")
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || n > len(d.buf) {
+		return nil, errMsgpackUnexpectedEOF
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+// uint reads a big-endian unsigned integer of the given size.
+func (d *msgpackDecoder) uint(size int) (uint64, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
+
+// length reads a length of the given size.
+func (d *msgpackDecoder) length(size int) (int, error) {
+	n, err := d.uint(size)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.buf)) {
+		return 0, errMsgpackUnexpectedEOF
+	}
+	return int(n), nil
+}
+
+func (d *msgpackDecoder) decode(depth int) (interface{}, error) {
+	if depth > MaxStructuredBodyDepth {
+		return nil, sqerrors.New("maximum msgpack depth reached")
+	}
+	d.elements++
+	if d.elements > MaxStructuredBodyElements {
+		return nil, sqerrors.New("maximum number of msgpack values reached")
+	}
+
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	switch c := b[0]; {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c >= 0x80 && c <= 0x8f:
+		return d.decodeMap(int(c&0x0f), depth)
+	case c >= 0x90 && c <= 0x9f:
+		return d.decodeArray(int(c&0x0f), depth)
+	case c >= 0xa0 && c <= 0xbf:
+		return d.decodeString(int(c & 0x1f))
+	}
+
+	switch c := b[0]; c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6, 0xd9, 0xda, 0xdb:
+		// bin 8/16/32 and str 8/16/32
+		size := 1 << (c - 0xc4)
+		if c >= 0xd9 {
+			size = 1 << (c - 0xd9)
+		}
+		n, err := d.length(size)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeString(n)
+	case 0xc7, 0xc8, 0xc9:
+		// ext 8/16/32
+		n, err := d.length(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		_, err = d.next(n + 1)
+		return nil, err
+	case 0xca:
+		v, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := d.uint(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := d.uint(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if v > math.MaxInt64 {
+			return strconv.FormatUint(v, 10), nil
+		}
+		return int64(v), nil
+	case 0xd0:
+		v, err := d.uint(1)
+		return int64(int8(v)), err
+	case 0xd1:
+		v, err := d.uint(2)
+		return int64(int16(v)), err
+	case 0xd2:
+		v, err := d.uint(4)
+		return int64(int32(v)), err
+	case 0xd3:
+		v, err := d.uint(8)
+		return int64(v), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		// fixext 1/2/4/8/16
+		_, err := d.next(1 + 1<<(c-0xd4))
+		return nil, err
+	case 0xdc, 0xdd:
+		n, err := d.length(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.length(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(n, depth)
+	default:
+		return nil, sqerrors.Errorf("unexpected msgpack type 0x%x", c)
+	}
+}
+
+func (d *msgpackDecoder) decodeString(n int) (interface{}, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (d *msgpackDecoder) decodeArray(n int, depth int) (interface{}, error) {
+	// Every value is at least one byte long
+	if n > len(d.buf) {
+		return nil, errMsgpackUnexpectedEOF
+	}
+	array := make([]interface{}, n)
+	for i := range array {
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		array[i] = v
+	}
+	return array, nil
+}
+
+func (d *msgpackDecoder) decodeMap(n int, depth int) (interface{}, error) {
+	// Every key and value is at least one byte long
+	if 2*n > len(d.buf) {
+		return nil, errMsgpackUnexpectedEOF
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			key = fmt.Sprint(k)
+		}
+		m[key] = v
+	}
+	return m, nil
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package http
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"strings"
+	"testing"
+
+	http_protection_mockups "github.com/sqreen/go-agent/internal/protection/http/_testlib/mockups"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBody(t *testing.T) {
+	read := func(t *testing.T, contentType string, body []byte) *ProtectionContext {
+		req := &http_protection_mockups.RequestReaderMockup{}
+		req.ExpectHeader("Content-Type").Return(&contentType)
+		req.ExpectParams().Return(nil).Maybe()
+
+		p := NewTestProtectionContext(nil, nil, nil, req)
+		_, err := ioutil.ReadAll(p.wrapBody(ioutil.NopCloser(bytes.NewReader(body))))
+		require.NoError(t, err)
+		return p
+	}
+
+	t.Run("xml", func(t *testing.T) {
+		body := `<?xml version="1.0"?><user id="42"><name>Alice</name><role>admin</role><role>user</role><note lang="en">hi</note></user>`
+		p := read(t, "application/xml; charset=utf-8", []byte(body))
+		require.Equal(t, []interface{}{
+			map[string]interface{}{
+				"user": map[string]interface{}{
+					"@id":  "42",
+					"name": []interface{}{"Alice"},
+					"role": []interface{}{"admin", "user"},
+					"note": []interface{}{map[string]interface{}{"@lang": "en", "#text": "hi"}},
+				},
+			},
+		}, p.RequestReader.Params()[XMLRequestParamSource])
+	})
+
+	t.Run("xml external entity", func(t *testing.T) {
+		body := `<?xml version="1.0"?><!DOCTYPE foo [<!ENTITY xxe SYSTEM "file:///etc/passwd">]><foo>&xxe;</foo>`
+		p := read(t, "text/xml", []byte(body))
+		require.Empty(t, p.RequestReader.Params())
+	})
+
+	t.Run("xml depth limit", func(t *testing.T) {
+		depth := MaxStructuredBodyDepth + 1
+		body := strings.Repeat("<a>", depth) + strings.Repeat("</a>", depth)
+		p := read(t, "application/xml", []byte(body))
+		require.Empty(t, p.RequestReader.Params())
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		var body bytes.Buffer
+		mp := multipart.NewWriter(&body)
+		require.NoError(t, mp.WriteField("field", "value"))
+		f, err := mp.CreateFormFile("upload", "../../etc/passwd")
+		require.NoError(t, err)
+		_, err = f.Write([]byte("file content"))
+		require.NoError(t, err)
+		require.NoError(t, mp.Close())
+
+		p := read(t, mp.FormDataContentType(), body.Bytes())
+		require.Equal(t, []interface{}{
+			map[string]interface{}{
+				"fields": map[string][]string{"field": {"value"}},
+				"files": []interface{}{
+					map[string]interface{}{
+						"name":         "upload",
+						"filename":     "../../etc/passwd",
+						"content_type": "application/octet-stream",
+						"size":         int64(len("file content")),
+					},
+				},
+			},
+		}, p.RequestReader.Params()[MultipartRequestParamSource])
+	})
+
+	t.Run("multipart larger than the limit", func(t *testing.T) {
+		var body bytes.Buffer
+		mp := multipart.NewWriter(&body)
+		require.NoError(t, mp.WriteField("field", "value"))
+		f, err := mp.CreateFormFile("upload", "../../etc/passwd")
+		require.NoError(t, err)
+		_, err = f.Write(bytes.Repeat([]byte("a"), 2*MaxStructuredBodySize))
+		require.NoError(t, err)
+		// The parts following the limit are ignored
+		require.NoError(t, mp.WriteField("ignored", "value"))
+		require.NoError(t, mp.Close())
+
+		p := read(t, mp.FormDataContentType(), body.Bytes())
+		params := p.RequestReader.Params()[MultipartRequestParamSource]
+		require.Len(t, params, 1)
+		form := params[0].(map[string]interface{})
+		require.Equal(t, map[string][]string{"field": {"value"}}, form["fields"])
+		files := form["files"].([]interface{})
+		require.Len(t, files, 1)
+		file := files[0].(map[string]interface{})
+		require.Equal(t, "../../etc/passwd", file["filename"])
+		require.Less(t, file["size"].(int64), int64(MaxStructuredBodySize))
+	})
+
+	t.Run("msgpack", func(t *testing.T) {
+		body := []byte{
+			0x83,                                          // map of 3 entries
+			0xa4, 'n', 'a', 'm', 'e', 0xa3, 'b', 'o', 'b', // "name": "bob"
+			0xa3, 'a', 'g', 'e', 0xcc, 200, // "age": 200
+			0xa4, 't', 'a', 'g', 's', 0x92, 0xc3, 0xd0, 0xff, // "tags": [true, -1]
+		}
+		p := read(t, "application/msgpack", body)
+		require.Equal(t, []interface{}{
+			map[string]interface{}{
+				"name": "bob",
+				"age":  int64(200),
+				"tags": []interface{}{true, int64(-1)},
+			},
+		}, p.RequestReader.Params()[MsgpackRequestParamSource])
+	})
+
+	t.Run("truncated msgpack", func(t *testing.T) {
+		p := read(t, "application/x-msgpack", []byte{0xdd, 0xff, 0xff, 0xff, 0xff, 0xc0})
+		require.Empty(t, p.RequestReader.Params())
+	})
+
+	t.Run("not parsed", func(t *testing.T) {
+		p := read(t, "application/json", []byte(`{"a":1}`))
+		require.Empty(t, p.RequestReader.Params())
+	})
+}
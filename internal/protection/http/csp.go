@@ -0,0 +1,34 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package http
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+
+	protection_context "github.com/sqreen/go-agent/internal/protection/context"
+)
+
+// Size in bytes of the random Content-Security-Policy nonces.
+const cspNonceSize = 16
+
+// Static assert that ProtectionContext implements the expected interfaces.
+var _ protection_context.CSPNonceGetter = (*ProtectionContext)(nil)
+
+// CSPNonce returns the Content-Security-Policy nonce of the request. It is
+// generated on the first call so that every request gets a fresh one, and the
+// same value is then returned to both the CSP protection adding it to the
+// response headers and the request handler adding it to its HTML templates.
+func (p *ProtectionContext) CSPNonce() string {
+	p.cspNonceOnce.Do(func() {
+		var buf [cspNonceSize]byte
+		if _, err := rand.Read(buf[:]); err != nil {
+			// Not expected to happen, and an empty nonce never matches
+			return
+		}
+		p.cspNonce = base64.StdEncoding.EncodeToString(buf[:])
+	})
+	return p.cspNonce
+}
@@ -0,0 +1,27 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package http
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSPNonce(t *testing.T) {
+	p := NewTestProtectionContext(nil, nil, nil, nil)
+	nonce := p.CSPNonce()
+	buf, err := base64.StdEncoding.DecodeString(nonce)
+	require.NoError(t, err)
+	require.Len(t, buf, cspNonceSize)
+
+	// Stable for the same request
+	require.Equal(t, nonce, p.CSPNonce())
+
+	// Fresh for every request
+	other := NewTestProtectionContext(nil, nil, nil, nil)
+	require.NotEqual(t, nonce, other.CSPNonce())
+}
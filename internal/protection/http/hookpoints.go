@@ -0,0 +1,43 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package http
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Hookpoint is a method of the protection context the rules can hook. It is
+// called with the protection context as only argument.
+type Hookpoint struct {
+	// Method is the name of the ProtectionContext method.
+	Method string
+	// Blocking is true when the method returns an error aborting the request.
+	Blocking bool
+}
+
+var pkgPath = reflect.TypeOf((*ProtectionContext)(nil)).Elem().PkgPath()
+
+// Symbol returns the hookpoint symbol as referenced by the rules.
+func (h Hookpoint) Symbol() string {
+	return fmt.Sprintf("%s.(*ProtectionContext).%s", pkgPath, h.Method)
+}
+
+// BeforeHookpoints are the hookpoints called by Before(), in the same order.
+var BeforeHookpoints = []Hookpoint{
+	{Method: "addSecurityHeaders"},
+	{Method: "isIPBlocked", Blocking: true},
+	{Method: "ipSecurityResponse", Blocking: true},
+	{Method: "protocolAnomaly", Blocking: true},
+	{Method: "cookieProtection", Blocking: true},
+	{Method: "csrfProtection", Blocking: true},
+	{Method: "identifyUserFromRequest", Blocking: true},
+	{Method: "waf", Blocking: true},
+}
+
+// BodyWAFHookpoint is the hookpoint called when the request body is read.
+var BodyWAFHookpoint = Hookpoint{Method: "bodyWAF", Blocking: true}
@@ -0,0 +1,62 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package http
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBeforeHookpoints(t *testing.T) {
+	// Parse the methods Before() calls so that adding one without adding it to
+	// BeforeHookpoints, such as for the rule replays, fails.
+	f, err := parser.ParseFile(token.NewFileSet(), "http.go", nil, 0)
+	require.NoError(t, err)
+
+	var before *ast.FuncDecl
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv != nil && fn.Name.Name == "Before" {
+			before = fn
+			break
+		}
+	}
+	require.NotNil(t, before)
+	recv := before.Recv.List[0].Names[0].Name
+
+	var called []Hookpoint
+	ast.Inspect(before.Body, func(n ast.Node) bool {
+		var (
+			call     *ast.CallExpr
+			blocking bool
+		)
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			// Blocking methods return an error
+			if len(n.Rhs) == 1 {
+				call, _ = n.Rhs[0].(*ast.CallExpr)
+				blocking = true
+			}
+		case *ast.ExprStmt:
+			call, _ = n.X.(*ast.CallExpr)
+		}
+		if call == nil {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if x, ok := sel.X.(*ast.Ident); ok && x.Name == recv {
+			called = append(called, Hookpoint{Method: sel.Sel.Name, Blocking: blocking})
+		}
+		return false
+	})
+
+	require.Equal(t, BeforeHookpoints, called)
+}
@@ -10,12 +10,15 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sqreen/go-agent/internal/actor"
 	"github.com/sqreen/go-agent/internal/event"
 	protection_context "github.com/sqreen/go-agent/internal/protection/context"
 	"github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/sqreen/go-agent/internal/protection/taint"
 	"github.com/sqreen/go-agent/internal/sqlib/sqgls"
 )
 
@@ -29,6 +32,31 @@ type ProtectionContext struct {
 
 	requestReader *requestReader
 	start         time.Time
+
+	// userIdentifiers is the list of functions identifying the request user
+	// before the request handler gets called.
+	userIdentifiers []UserIdentifierFunc
+
+	// cspNonce is the Content-Security-Policy nonce of the request lazily
+	// generated by CSPNonce().
+	cspNonce     string
+	cspNonceOnce sync.Once
+
+	// taint is the taint tracker of the request inputs lazily created by
+	// TaintTracker(). taintMu serializes its creation with the request
+	// parameters added by AddRequestParam() so that none of them is missed.
+	taint   *taint.Tracker
+	taintMu sync.Mutex
+
+	// response is the response being checked by ResponseWAF().
+	response *ResponseBindingAccessorContext
+
+	// callbackStates are the request states of the callbacks returned by
+	// CallbackState().
+	callbackStates sync.Map
+
+	// closed is non-zero once Close() was called.
+	closed int32
 }
 
 type SecurityResponseStore interface {
@@ -36,7 +64,11 @@ type SecurityResponseStore interface {
 	FindActionByUserID(id map[string]string) (actor.Action, bool)
 }
 
-func NewProtectionContext(ctx types.RootProtectionContext, w types.ResponseWriter, r types.RequestReader) *ProtectionContext {
+func NewProtectionContext(ctx types.RootProtectionContext, w types.ResponseWriter, r types.RequestReader, opts ...Option) *ProtectionContext {
+	// Always take the removed headers over so that they are not left in the
+	// goroutine-local storage of the request handler.
+	removedHeaders := takeRemovedTransferHeaders()
+
 	if ctx == nil {
 		return nil
 	}
@@ -53,9 +85,10 @@ func NewProtectionContext(ctx types.RootProtectionContext, w types.ResponseWrite
 	}
 
 	rr := &requestReader{
-		RequestReader: r,
-		clientIP:      clientIP,
-		requestParams: make(types.RequestParamMap),
+		RequestReader:  r,
+		clientIP:       clientIP,
+		requestParams:  make(types.RequestParamMap),
+		removedHeaders: removedHeaders,
 	}
 
 	p := &ProtectionContext{
@@ -64,6 +97,11 @@ func NewProtectionContext(ctx types.RootProtectionContext, w types.ResponseWrite
 		RequestReader:         rr,
 		requestReader:         rr,
 	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
 	return p
 }
 
@@ -98,9 +136,14 @@ type (
 	BodyWAFPrologCallbackType = WAFPrologCallbackType
 	BodyWAFEpilogCallbackType = WAFEpilogCallbackType
 
+	ResponseWAFPrologCallbackType = WAFPrologCallbackType
+	ResponseWAFEpilogCallbackType = WAFEpilogCallbackType
+
 	IdentifyUserPrologCallbackType = func(**ProtectionContext, *map[string]string) (BlockingEpilogCallbackType, error)
 
 	ResponseMonitoringPrologCallbackType = func(**ProtectionContext, *types.ResponseFace) (NonBlockingEpilogCallbackType, error)
+
+	ResponseHeaderPrologCallbackType = func(**ProtectionContext, *http.Header) (NonBlockingEpilogCallbackType, error)
 )
 
 // Static assert that ProtectionContext implements the expected interfaces.
@@ -141,6 +184,18 @@ func (p *ProtectionContext) Before() (err error) {
 	if err := p.ipSecurityResponse(); err != nil {
 		return err
 	}
+	if err := p.protocolAnomaly(); err != nil {
+		return err
+	}
+	if err := p.cookieProtection(); err != nil {
+		return err
+	}
+	if err := p.csrfProtection(); err != nil {
+		return err
+	}
+	if err := p.identifyUserFromRequest(); err != nil {
+		return err
+	}
 	if err := p.waf(); err != nil {
 		return err
 	}
@@ -162,6 +217,15 @@ func (p *ProtectionContext) addSecurityHeaders() { /* dynamically instrumented *
 //go:noinline
 func (p *ProtectionContext) ipSecurityResponse() error { /* dynamically instrumented */ return nil }
 
+//go:noinline
+func (p *ProtectionContext) protocolAnomaly() error { /* dynamically instrumented */ return nil }
+
+//go:noinline
+func (p *ProtectionContext) cookieProtection() error { /* dynamically instrumented */ return nil }
+
+//go:noinline
+func (p *ProtectionContext) csrfProtection() error { /* dynamically instrumented */ return nil }
+
 type canceledHandlerContextError struct{}
 
 func (canceledHandlerContextError) Error() string { return "canceled handler context" }
@@ -187,6 +251,8 @@ func (p *ProtectionContext) isContextHandlerCanceled() bool {
 func (p *ProtectionContext) HandleAttack(block bool, attack *event.AttackEvent) (blocked bool) {
 	if block {
 		defer p.CancelContext()
+		// Replace the response when blocking it from the response WAF
+		p.resetResponse()
 		p.WriteDefaultBlockingResponse()
 		blocked = true
 	}
@@ -202,6 +268,8 @@ func (p *ProtectionContext) Close(response types.ResponseFace) {
 	// Compute the request duration
 	duration := time.Since(p.start)
 
+	atomic.StoreInt32(&p.closed, 1)
+
 	// Make sure to clear the goroutine local storage to avoid keeping it if some
 	// memory pools are used under the hood.
 	// TODO: enforce this by design of the gls instrumentation
@@ -220,12 +288,33 @@ func (p *ProtectionContext) Close(response types.ResponseFace) {
 	})
 }
 
+// Closed returns true when the protection context was closed or when its
+// context was canceled. The goroutines created by the request handler inherit
+// its goroutine-local protection context and can outlive the request: the
+// protection context shouldn't be used by them anymore once closed.
+func (p *ProtectionContext) Closed() bool {
+	if atomic.LoadInt32(&p.closed) != 0 {
+		return true
+	}
+	if p.RootProtectionContext == nil {
+		return false
+	}
+	ctx := p.Context()
+	return ctx != nil && ctx.Err() != nil
+}
+
 // Write the default blocking response. This method only write the response, it
 // doesn't block nor cancel the handler context. Users of this method must
 // handle their
 //go:noinline
 func (p *ProtectionContext) WriteDefaultBlockingResponse() { /* dynamically instrumented */ }
 
+// BeforeWriteHeader must be called by the middleware response writers right
+// before the response headers get written so that protections can still
+// modify them.
+//go:noinline
+func (p *ProtectionContext) BeforeWriteHeader(headers http.Header) { /* dynamically instrumented */ }
+
 //go:noinline
 func (p *ProtectionContext) monitorObservedResponse(response types.ResponseFace) {
 	/* dynamically instrumented */
@@ -253,6 +342,8 @@ func (p *ProtectionContext) wrapBody(body io.ReadCloser) io.ReadCloser {
 // result of a JSON parsing, query-string parsing, etc. The source allows to
 // specify where it was taken from.
 func (p *ProtectionContext) AddRequestParam(name string, param interface{}) {
+	p.taintMu.Lock()
+	defer p.taintMu.Unlock()
 	params := p.requestReader.requestParams[name]
 	var v interface{}
 	switch actual := param.(type) {
@@ -263,6 +354,41 @@ func (p *ProtectionContext) AddRequestParam(name string, param interface{}) {
 		v = map[string][]string(actual)
 	}
 	p.requestReader.requestParams[name] = append(params, v)
+	if p.taint != nil {
+		p.taint.TaintValue(name, v)
+	}
+}
+
+// CallbackState returns the request state of the callback identified by the
+// given key. It is created by function `newState` on the first call, and
+// allows callbacks to keep their results across the calls performed during
+// the request, such as the incremental checks of the request body. States
+// are shared by concurrent calls and must therefore be safe for concurrent
+// use.
+func (p *ProtectionContext) CallbackState(key interface{}, newState func() interface{}) interface{} {
+	if state, ok := p.callbackStates.Load(key); ok {
+		return state
+	}
+	state, _ := p.callbackStates.LoadOrStore(key, newState())
+	return state
+}
+
+// TaintTracker returns the taint tracker of the request inputs. It is lazily
+// created with the request parameters known so far, and the request parameters
+// added afterwards are tainted too.
+func (p *ProtectionContext) TaintTracker() *taint.Tracker {
+	p.taintMu.Lock()
+	defer p.taintMu.Unlock()
+	if p.taint == nil {
+		t := taint.NewTracker()
+		t.TaintValue("query", p.RequestReader.QueryForm())
+		t.TaintValue("form", p.RequestReader.PostForm())
+		for name, values := range p.RequestReader.Params() {
+			t.TaintValue(name, values)
+		}
+		p.taint = t
+	}
+	return p.taint
 }
 
 func (p *ProtectionContext) ClientIP() net.IP {
@@ -5,6 +5,7 @@
 package http
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"net"
@@ -142,16 +143,54 @@ func TestProtectionAPI(t *testing.T) {
 		req.ExpectParams().Return(nil)
 
 		// Close the protection context
+		r.ExpectContext().Return(context.Background()).Once()
+		require.False(t, p.Closed())
 		r.ExpectClose(mock.MatchedBy(func(closed types.ClosedProtectionContextFace) bool {
 			events := closed.Events()
 			require.Len(t, events.AttackEvents, 2)
 			return true
 		}))
 		p.Close(response)
+		require.True(t, p.Closed())
 		r.AssertExpectations(t)
 	})
 }
 
+func TestResponseWAF(t *testing.T) {
+	t.Run("response binding accessor context", func(t *testing.T) {
+		root := &middleware_mockups.RootHTTPProtectionContextMockup{}
+		root.ExpectContext().Return(context.Background())
+		defer root.AssertExpectations(t)
+
+		p := NewTestProtectionContext(root, nil, nil, &http_protection_mockups.RequestReaderMockup{})
+		require.Nil(t, p.Response())
+
+		headers := http.Header{"Content-Type": []string{"text/plain"}}
+		require.NoError(t, p.ResponseWAF(0, headers, []byte("hello")))
+
+		response := p.Response()
+		require.NotNil(t, response)
+		require.Equal(t, http.StatusOK, response.Status())
+		require.Equal(t, headers, response.Headers())
+		require.Equal(t, "text/plain", *response.Header("content-type"))
+		require.Nil(t, response.Header("X-Unknown"))
+		require.Equal(t, "hello", response.Body().String())
+	})
+
+	t.Run("blocked request", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		root := &middleware_mockups.RootHTTPProtectionContextMockup{}
+		root.ExpectContext().Return(ctx)
+		defer root.AssertExpectations(t)
+
+		// The response is the blocking response and is not checked
+		p := NewTestProtectionContext(root, nil, nil, &http_protection_mockups.RequestReaderMockup{})
+		require.NoError(t, p.ResponseWAF(http.StatusForbidden, http.Header{}, nil))
+		require.Nil(t, p.Response())
+	})
+}
+
 func TestParseClientIPHeaderHeaderValue(t *testing.T) {
 	// Tests with malformed values
 	// A buffer of random bytes.
@@ -37,10 +37,41 @@ type requestReader struct {
 
 	// bodyReadBuffer is the buffers body reads
 	bodyReadBuffer bytes.Buffer
+
+	// bodyParsed is true once the body was parsed by parseBody().
+	bodyParsed bool
+
+	// removedHeaders are the request headers removed by net/http while reading
+	// the request, cf. HookRequestTransfer().
+	removedHeaders http.Header
 }
 
 func (r *requestReader) Body() []byte { return r.bodyReadBuffer.Bytes() }
 
+// Headers returns the request headers as they were received, including the
+// ones removed by net/http.
+func (r *requestReader) Headers() http.Header {
+	headers := r.RequestReader.Headers()
+	if len(r.removedHeaders) == 0 {
+		return headers
+	}
+	res := make(http.Header, len(headers)+len(r.removedHeaders))
+	for k, v := range headers {
+		res[k] = v
+	}
+	for k, v := range r.removedHeaders {
+		res[k] = v
+	}
+	return res
+}
+
+func (r *requestReader) Header(header string) (value *string) {
+	if v := r.removedHeaders[textproto.CanonicalMIMEHeaderKey(header)]; len(v) > 0 {
+		return &v[0]
+	}
+	return r.RequestReader.Header(header)
+}
+
 func (r *requestReader) ClientIP() net.IP { return r.clientIP }
 
 func (r *requestReader) Params() types.RequestParamMap {
@@ -68,7 +99,8 @@ type rawBodyWAF struct {
 	c *ProtectionContext
 }
 
-// Read buffers what has been read and ultimately calls the WAF on EOF.
+// Read buffers what has been read and ultimately parses the body and calls the
+// WAF on EOF.
 func (t rawBodyWAF) Read(p []byte) (n int, err error) {
 	n, err = t.ReadCloser.Read(p)
 	if n > 0 {
@@ -76,6 +108,7 @@ func (t rawBodyWAF) Read(p []byte) (n int, err error) {
 	}
 
 	if err == io.EOF {
+		t.c.parseBody()
 		if wafErr := t.c.bodyWAF(); wafErr != nil {
 			// Return 0 and the sqreen error so that the caller doesn't take anything
 			// into account.
@@ -0,0 +1,119 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"reflect"
+	"sync"
+
+	"github.com/sqreen/go-agent/internal/sqlib/sqgo"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+)
+
+// MaxResponseWAFBodySize is the maximum size of the response body prefix the
+// middleware response writers buffer and pass to ResponseWAF.
+const MaxResponseWAFBodySize = 16 * 1024
+
+// ResponseBindingAccessorContext is the binding accessor context of the
+// response about to be sent, provided to the response WAF protections:
+// - `.Status` returns the response status code.
+// - `.Headers` and `.Header` return the response headers.
+// - `.Body` returns the prefix of the response body.
+type ResponseBindingAccessorContext struct {
+	status  int
+	headers http.Header
+	body    []byte
+}
+
+func (r *ResponseBindingAccessorContext) Status() int {
+	return r.status
+}
+
+func (r *ResponseBindingAccessorContext) Headers() http.Header {
+	return r.headers
+}
+
+func (r *ResponseBindingAccessorContext) Header(h string) *string {
+	v := r.headers[textproto.CanonicalMIMEHeaderKey(h)]
+	if len(v) == 0 {
+		return nil
+	}
+	return &v[0]
+}
+
+// Body returns the response body prefix of at most MaxResponseWAFBodySize
+// bytes.
+func (r *ResponseBindingAccessorContext) Body() RequestBodyBindingAccessorContext {
+	return r.body
+}
+
+// ResponseWAF must be called by the middleware response writers before
+// writing the response with its status code, its headers and the prefix of
+// its body of at most MaxResponseWAFBodySize bytes. When a non-nil error is
+// returned, the response was blocked: the blocking response was written
+// instead and the response writers must drop the response.
+func (p *ProtectionContext) ResponseWAF(status int, headers http.Header, body []byte) error {
+	if p.isContextHandlerCanceled() {
+		// The request was already blocked and the response is the blocking one
+		return nil
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	p.response = &ResponseBindingAccessorContext{
+		status:  status,
+		headers: headers,
+		body:    body,
+	}
+	return p.responseWAF()
+}
+
+// ResponseWAFEnabled returns true when a callback is attached to the response
+// WAF hookpoint. The middleware response writers only need to buffer the
+// response for ResponseWAF when it is enabled, and can otherwise write the
+// response straight through.
+func (p *ProtectionContext) ResponseWAFEnabled() bool {
+	hook := findResponseWAFHook()
+	return hook != nil && hook.Attached()
+}
+
+// findResponseWAFHook returns the hook of the response WAF hookpoint, or nil
+// when the program is not instrumented.
+var findResponseWAFHook = func() func() *sqhook.Hook {
+	var (
+		once sync.Once
+		hook *sqhook.Hook
+	)
+	return func() *sqhook.Hook {
+		once.Do(func() {
+			pkgPath := sqgo.Unvendor(reflect.TypeOf(ProtectionContext{}).PkgPath())
+			hook, _ = sqhook.Find(fmt.Sprintf("%s.(*ProtectionContext).responseWAF", pkgPath))
+		})
+		return hook
+	}
+}()
+
+// Response returns the response being checked by the response WAF, or nil
+// before the response is written.
+func (p *ProtectionContext) Response() *ResponseBindingAccessorContext {
+	return p.response
+}
+
+//go:noinline
+func (p *ProtectionContext) responseWAF() error { /* dynamically instrumented */ return nil }
+
+// resetResponse clears the headers of the response being checked by the
+// response WAF so that the blocking response replaces it.
+func (p *ProtectionContext) resetResponse() {
+	if p.response == nil {
+		return
+	}
+	for k := range p.response.headers {
+		delete(p.response.headers, k)
+	}
+}
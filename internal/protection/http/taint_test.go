@@ -0,0 +1,44 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package http
+
+import (
+	"net/url"
+	"testing"
+
+	http_protection_mockups "github.com/sqreen/go-agent/internal/protection/http/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/sqreen/go-agent/internal/protection/taint"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaintTracker(t *testing.T) {
+	req := &http_protection_mockups.RequestReaderMockup{}
+	defer req.AssertExpectations(t)
+	req.ExpectQueryForm().Return(url.Values{"q": []string{"query value"}}).Once()
+	req.ExpectPostForm().Return(url.Values{"f": []string{"form value"}}).Once()
+	req.ExpectParams().Return(types.RequestParamMap{"path": []interface{}{"path value"}}).Once()
+
+	p := NewTestProtectionContext(nil, nil, nil, req)
+	tracker := p.TaintTracker()
+	// Lazily created once
+	require.True(t, tracker == p.TaintTracker())
+
+	for _, expected := range []taint.Source{
+		{Name: "query", Value: "query value"},
+		{Name: "form", Value: "form value"},
+		{Name: "path", Value: "path value"},
+	} {
+		src, tainted := tracker.IsTainted(expected.Value)
+		require.True(t, tainted)
+		require.Equal(t, expected, src)
+	}
+
+	// Request parameters added afterwards are tainted too
+	p.AddRequestParam("json", map[string]interface{}{"name": "json value"})
+	src, tainted := tracker.IsTainted("json value")
+	require.True(t, tainted)
+	require.Equal(t, taint.Source{Name: "json", Value: "json value"}, src)
+}
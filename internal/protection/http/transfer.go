@@ -0,0 +1,109 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package http
+
+import (
+	"bufio"
+	"net/http"
+	"reflect"
+
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqgls"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+)
+
+// The net/http server accepts the requests having both a `Transfer-Encoding`
+// header and a `Content-Length` header, which is a common request smuggling
+// attempt against the proxies in front of the server, but removes them from
+// the request headers before calling the request handler. Its request transfer
+// parsing function is therefore hooked in order to keep the removed headers so
+// that the protections see the request headers as they were received.
+// The HTTP/1 server reads the requests in the goroutine then calling their
+// handler, so that the removed headers are kept in the goroutine-local storage
+// until the protection context of the request takes them over.
+
+// requestTransferHookSymbol is the symbol of the net/http function parsing the
+// transfer headers of the requests and responses it reads, before it removes
+// them from the request headers.
+const requestTransferHookSymbol = "net/http.readTransfer"
+
+// Prolog types of the supported signatures of the hooked function. Recent Go
+// versions also pass the maximum number of trailer headers.
+type (
+	readTransferPrologCallbackType                = func(msg *interface{}, r **bufio.Reader) (func(*error), error)
+	readTransferWithMaxTrailersPrologCallbackType = func(msg *interface{}, r **bufio.Reader, maxTrailerHeaders *int64) (func(*error), error)
+)
+
+// removedTransferHeaders is the goroutine-local storage value of the transfer
+// headers net/http removes from the request being read by the goroutine.
+type removedTransferHeaders http.Header
+
+// HookRequestTransfer attaches the callback keeping the request transfer
+// headers removed by net/http. It does nothing when the program is not
+// instrumented.
+func HookRequestTransfer() error {
+	hook, err := sqhook.Find(requestTransferHookSymbol)
+	if err != nil || hook == nil {
+		return err
+	}
+	var prolog sqhook.PrologCallback
+	switch typ := hook.PrologFuncType(); typ {
+	case reflect.TypeOf(readTransferPrologCallbackType(nil)):
+		prolog = readTransferPrologCallbackType(func(msg *interface{}, _ **bufio.Reader) (func(*error), error) {
+			requestTransferProlog(*msg)
+			return nil, nil
+		})
+	case reflect.TypeOf(readTransferWithMaxTrailersPrologCallbackType(nil)):
+		prolog = readTransferWithMaxTrailersPrologCallbackType(func(msg *interface{}, _ **bufio.Reader, _ *int64) (func(*error), error) {
+			requestTransferProlog(*msg)
+			return nil, nil
+		})
+	default:
+		return sqerrors.Errorf("unexpected prolog type `%s` of hook `%s`", typ, hook)
+	}
+	return hook.Attach(prolog)
+}
+
+func requestTransferProlog(msg interface{}) {
+	req, ok := msg.(*http.Request)
+	if !ok {
+		return
+	}
+	// Leave the goroutine-local storage of the request handlers reading
+	// requests themselves, such as proxies using `http.ReadRequest()`.
+	switch sqgls.Get().(type) {
+	case nil, removedTransferHeaders:
+	default:
+		return
+	}
+	sqgls.Set(newRemovedTransferHeaders(req.Header))
+}
+
+// newRemovedTransferHeaders returns the conflicting transfer headers net/http
+// is about to remove from the given request headers, or nil when none.
+func newRemovedTransferHeaders(header http.Header) interface{} {
+	contentLength := header["Content-Length"]
+	transferEncoding := header["Transfer-Encoding"]
+	if len(contentLength) == 0 || len(transferEncoding) == 0 {
+		return nil
+	}
+	return removedTransferHeaders{
+		"Content-Length":    contentLength,
+		"Transfer-Encoding": transferEncoding,
+	}
+}
+
+// takeRemovedTransferHeaders returns the transfer headers removed from the
+// current request and clears them from the goroutine-local storage.
+func takeRemovedTransferHeaders() http.Header {
+	removed, ok := sqgls.Get().(removedTransferHeaders)
+	if !ok {
+		return nil
+	}
+	sqgls.Set(nil)
+	return http.Header(removed)
+}
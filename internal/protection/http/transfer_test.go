@@ -0,0 +1,70 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package http
+
+import (
+	"net/http"
+	"testing"
+
+	http_protection_mockups "github.com/sqreen/go-agent/internal/protection/http/_testlib/mockups"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemovedTransferHeaders(t *testing.T) {
+	t.Run("removed headers", func(t *testing.T) {
+		for _, tc := range []struct {
+			name     string
+			header   http.Header
+			expected interface{}
+		}{
+			{
+				name:   "content length",
+				header: http.Header{"Content-Length": {"3"}},
+			},
+			{
+				name:   "transfer encoding",
+				header: http.Header{"Transfer-Encoding": {"chunked"}},
+			},
+			{
+				name:     "transfer encoding and content length",
+				header:   http.Header{"Content-Length": {"3"}, "Transfer-Encoding": {"Chunked"}, "Host": {"my.site"}},
+				expected: removedTransferHeaders{"Content-Length": {"3"}, "Transfer-Encoding": {"Chunked"}},
+			},
+		} {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				require.Equal(t, tc.expected, newRemovedTransferHeaders(tc.header))
+			})
+		}
+	})
+
+	t.Run("request reader", func(t *testing.T) {
+		headers := http.Header{"Host": {"my.site"}}
+		req := &http_protection_mockups.RequestReaderMockup{}
+		req.ExpectHeaders().Return(headers)
+		req.ExpectHeader("Host").Return(&headers["Host"][0])
+		defer req.AssertExpectations(t)
+
+		rr := &requestReader{
+			RequestReader:  req,
+			removedHeaders: http.Header{"Content-Length": {"3"}, "Transfer-Encoding": {"chunked"}},
+		}
+		require.Equal(t, http.Header{"Host": {"my.site"}, "Content-Length": {"3"}, "Transfer-Encoding": {"chunked"}}, rr.Headers())
+		require.Equal(t, "3", *rr.Header("content-length"))
+		require.Equal(t, "my.site", *rr.Header("Host"))
+		// The request headers are left unchanged
+		require.Equal(t, http.Header{"Host": {"my.site"}}, headers)
+	})
+
+	t.Run("request reader without removed headers", func(t *testing.T) {
+		headers := http.Header{"Host": {"my.site"}}
+		req := &http_protection_mockups.RequestReaderMockup{}
+		req.ExpectHeaders().Return(headers)
+		defer req.AssertExpectations(t)
+
+		rr := &requestReader{RequestReader: req}
+		require.Equal(t, headers, rr.Headers())
+	})
+}
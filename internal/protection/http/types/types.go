@@ -21,6 +21,9 @@ type RootProtectionContext interface {
 	CancelContext()
 	SqreenTime() *sqtime.SharedStopWatch
 	DeadlineExceeded(needed time.Duration) (exceeded bool)
+	// RemainingTime returns the execution time left to Sqreen in the request,
+	// and false when it is not limited.
+	RemainingTime() (remaining time.Duration, limited bool)
 	FindActionByIP(ip net.IP) (action actor.Action, exists bool, err error)
 	FindActionByUserID(userID map[string]string) (action actor.Action, exists bool)
 	IsIPAllowed(ip net.IP) bool
@@ -32,6 +35,7 @@ type RootProtectionContext interface {
 type ConfigReader interface {
 	HTTPClientIPHeader() string
 	HTTPClientIPHeaderFormat() string
+	CookieSigningKey() string
 }
 
 // RequestReader is the read-only interface to the request.
@@ -0,0 +1,269 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package http
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+)
+
+// Option is a protection context option allowing to configure it from the
+// middleware functions.
+type Option func(*ProtectionContext)
+
+// UserIdentifierFunc returns the user identifier found in the request, and
+// false when none was found.
+type UserIdentifierFunc func(r types.RequestReader) (key, value string, found bool)
+
+// WithUserIdentifiers configures the protection context so that it
+// automatically identifies the user of the request with the given user
+// identifier functions before the request handler gets called. The user
+// identifiers found are merged into a single map of user identifiers.
+func WithUserIdentifiers(identifiers ...UserIdentifierFunc) Option {
+	return func(p *ProtectionContext) {
+		p.userIdentifiers = append(p.userIdentifiers, identifiers...)
+	}
+}
+
+// WithUserIdentifierFromHeader returns the option identifying the request user
+// with the value of the request header `header` as value of the user
+// identifier `key`. It is the shared implementation of the middleware option
+// of the same name.
+func WithUserIdentifierFromHeader(header, key string) Option {
+	return WithUserIdentifiers(UserIdentifierFromHeader(header, key))
+}
+
+// WithUserIdentifierFromCookie returns the option identifying the request user
+// with the value of the request cookie `cookie` as value of the user
+// identifier `key`. It is the shared implementation of the middleware option
+// of the same name.
+func WithUserIdentifierFromCookie(cookie, key string) Option {
+	return WithUserIdentifiers(UserIdentifierFromCookie(cookie, key))
+}
+
+// WithUserIdentifierFromJWTClaim returns the option identifying the request
+// user with the value of a claim of the request's verified JSON Web Token. It
+// is the shared implementation of the middleware option of the same name.
+func WithUserIdentifierFromJWTClaim(cfg JWTUserIdentifierConfig) (Option, error) {
+	identifier, err := UserIdentifierFromJWTClaim(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return WithUserIdentifiers(identifier), nil
+}
+
+// identifyUserFromRequest identifies the user of the request using the user
+// identifier functions the protection context was configured with. A non-nil
+// error is returned when the user got blocked.
+func (p *ProtectionContext) identifyUserFromRequest() error {
+	if len(p.userIdentifiers) == 0 {
+		return nil
+	}
+	var id map[string]string
+	for _, identifier := range p.userIdentifiers {
+		key, value, found := identifier(p.RequestReader)
+		if !found {
+			continue
+		}
+		if id == nil {
+			id = make(map[string]string, len(p.userIdentifiers))
+		}
+		id[key] = value
+	}
+	if len(id) == 0 {
+		return nil
+	}
+	return p.IdentifyUser(id)
+}
+
+// UserIdentifierFromHeader returns a user identifier function using the value
+// of the request header `header` as value of the user identifier `key`.
+func UserIdentifierFromHeader(header, key string) UserIdentifierFunc {
+	return func(r types.RequestReader) (string, string, bool) {
+		v := r.Header(header)
+		if v == nil || *v == "" {
+			return "", "", false
+		}
+		return key, *v, true
+	}
+}
+
+// UserIdentifierFromCookie returns a user identifier function using the value
+// of the request cookie `cookie` as value of the user identifier `key`.
+func UserIdentifierFromCookie(cookie, key string) UserIdentifierFunc {
+	return func(r types.RequestReader) (string, string, bool) {
+		v, found := requestCookie(r, cookie)
+		if !found || v == "" {
+			return "", "", false
+		}
+		return key, v, true
+	}
+}
+
+func requestCookie(r types.RequestReader, name string) (value string, found bool) {
+	headers := r.Headers()
+	if len(headers) == 0 {
+		return "", false
+	}
+	c, err := (&http.Request{Header: headers}).Cookie(name)
+	if err != nil {
+		return "", false
+	}
+	return c.Value, true
+}
+
+// JWTUserIdentifierConfig is the configuration of the user identifier function
+// taking the user identifier from a claim of a verified JSON Web Token.
+type JWTUserIdentifierConfig struct {
+	// Claim is the name of the JWT claim to use as user identifier value.
+	Claim string
+	// Key is the user identifier key. The claim name is used when empty.
+	Key string
+	// Cookie is the name of the cookie containing the token. The token is taken
+	// from the `Authorization: Bearer` request header when empty.
+	Cookie string
+	// Secret is the HMAC secret key to verify HS256 tokens.
+	Secret []byte
+	// PublicKey is the RSA public key to verify RS256 tokens.
+	PublicKey *rsa.PublicKey
+}
+
+// UserIdentifierFromJWTClaim returns a user identifier function using the
+// value of a claim of the request's JSON Web Token. Only tokens whose
+// signature is successfully verified with the configured key are used. Tokens
+// signed with an algorithm other than the configured one, or expired, are
+// ignored.
+func UserIdentifierFromJWTClaim(cfg JWTUserIdentifierConfig) (UserIdentifierFunc, error) {
+	if cfg.Claim == "" {
+		return nil, sqerrors.New("unexpected empty jwt claim name")
+	}
+	if (cfg.Secret == nil) == (cfg.PublicKey == nil) {
+		return nil, sqerrors.New("exactly one of the hs256 secret or rs256 public key must be provided")
+	}
+	key := cfg.Key
+	if key == "" {
+		key = cfg.Claim
+	}
+	return func(r types.RequestReader) (string, string, bool) {
+		token, found := requestJWT(r, cfg.Cookie)
+		if !found {
+			return "", "", false
+		}
+		claims, err := verifyJWT(token, cfg.Secret, cfg.PublicKey, time.Now())
+		if err != nil {
+			return "", "", false
+		}
+		v, found := jwtClaimString(claims[cfg.Claim])
+		if !found {
+			return "", "", false
+		}
+		return key, v, true
+	}, nil
+}
+
+func requestJWT(r types.RequestReader, cookie string) (token string, found bool) {
+	if cookie != "" {
+		return requestCookie(r, cookie)
+	}
+	v := r.Header("Authorization")
+	if v == nil {
+		return "", false
+	}
+	const prefix = "bearer "
+	if len(*v) <= len(prefix) || !strings.EqualFold((*v)[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace((*v)[len(prefix):]), true
+}
+
+// verifyJWT verifies the signature of the JWT with either the HMAC secret
+// (HS256) or the RSA public key (RS256) and returns its claims. The algorithm
+// of the token header must match the provided key in order to avoid algorithm
+// confusion attacks.
+func verifyJWT(token string, secret []byte, publicKey *rsa.PublicKey, now time.Time) (claims map[string]interface{}, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, sqerrors.New("unexpected number of jwt segments")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, sqerrors.Wrap(err, "jwt header")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, sqerrors.Wrap(err, "jwt signature")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	switch header.Alg {
+	case "HS256":
+		if secret == nil {
+			return nil, sqerrors.New("unexpected jwt algorithm `HS256`")
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, sqerrors.New("invalid jwt signature")
+		}
+	case "RS256":
+		if publicKey == nil {
+			return nil, sqerrors.New("unexpected jwt algorithm `RS256`")
+		}
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, sqerrors.Wrap(err, "invalid jwt signature")
+		}
+	default:
+		return nil, sqerrors.Errorf("unexpected jwt algorithm `%s`", header.Alg)
+	}
+
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, sqerrors.Wrap(err, "jwt claims")
+	}
+
+	if exp, ok := claims["exp"].(float64); ok && now.Unix() >= int64(exp) {
+		return nil, sqerrors.New("expired jwt")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Unix() < int64(nbf) {
+		return nil, sqerrors.New("jwt not valid yet")
+	}
+	return claims, nil
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	buf, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
+
+func jwtClaimString(claim interface{}) (string, bool) {
+	switch actual := claim.(type) {
+	case string:
+		return actual, actual != ""
+	case float64:
+		return strconv.FormatFloat(actual, 'f', -1, 64), true
+	default:
+		return "", false
+	}
+}
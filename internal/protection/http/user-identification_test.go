@@ -0,0 +1,202 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package http
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	http_protection_mockups "github.com/sqreen/go-agent/internal/protection/http/_testlib/mockups"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserIdentifiers(t *testing.T) {
+	t.Run("header", func(t *testing.T) {
+		identifier := UserIdentifierFromHeader("X-User-Id", "uid")
+
+		req := &http_protection_mockups.RequestReaderMockup{}
+		defer req.AssertExpectations(t)
+		uid := "my-uid"
+		req.ExpectHeader("X-User-Id").Return(&uid).Once()
+		key, value, found := identifier(req)
+		require.True(t, found)
+		require.Equal(t, "uid", key)
+		require.Equal(t, uid, value)
+
+		req.ExpectHeader("X-User-Id").Return(nil).Once()
+		_, _, found = identifier(req)
+		require.False(t, found)
+	})
+
+	t.Run("cookie", func(t *testing.T) {
+		identifier := UserIdentifierFromCookie("user", "uid")
+
+		req := &http_protection_mockups.RequestReaderMockup{}
+		defer req.AssertExpectations(t)
+		req.ExpectHeaders().Return(http.Header{"Cookie": []string{"session=abc; user=my-uid"}}).Once()
+		key, value, found := identifier(req)
+		require.True(t, found)
+		require.Equal(t, "uid", key)
+		require.Equal(t, "my-uid", value)
+
+		req.ExpectHeaders().Return(http.Header{"Cookie": []string{"session=abc"}}).Once()
+		_, _, found = identifier(req)
+		require.False(t, found)
+	})
+
+	t.Run("jwt", func(t *testing.T) {
+		secret := []byte("my secret")
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+
+		t.Run("configuration errors", func(t *testing.T) {
+			for _, cfg := range []JWTUserIdentifierConfig{
+				{},
+				{Claim: "sub"},
+				{Secret: secret},
+				{Claim: "sub", Secret: secret, PublicKey: &privateKey.PublicKey},
+			} {
+				_, err := UserIdentifierFromJWTClaim(cfg)
+				require.Error(t, err)
+			}
+		})
+
+		hs256, err := UserIdentifierFromJWTClaim(JWTUserIdentifierConfig{Claim: "sub", Key: "uid", Secret: secret})
+		require.NoError(t, err)
+		rs256, err := UserIdentifierFromJWTClaim(JWTUserIdentifierConfig{Claim: "sub", Cookie: "token", PublicKey: &privateKey.PublicKey})
+		require.NoError(t, err)
+
+		validClaims := map[string]interface{}{"sub": "my-uid", "exp": time.Now().Add(time.Hour).Unix()}
+		expiredClaims := map[string]interface{}{"sub": "my-uid", "exp": time.Now().Add(-time.Hour).Unix()}
+
+		t.Run("hs256", func(t *testing.T) {
+			for _, tc := range []struct {
+				token string
+				found bool
+			}{
+				{token: makeTestJWT(t, "HS256", validClaims, secret, nil), found: true},
+				{token: makeTestJWT(t, "HS256", validClaims, []byte("wrong secret"), nil)},
+				{token: makeTestJWT(t, "HS256", expiredClaims, secret, nil)},
+				{token: makeTestJWT(t, "RS256", validClaims, nil, privateKey)},
+				{token: makeTestJWT(t, "none", validClaims, nil, nil)},
+				{token: "not a jwt"},
+			} {
+				req := &http_protection_mockups.RequestReaderMockup{}
+				authorization := "Bearer " + tc.token
+				req.ExpectHeader("Authorization").Return(&authorization).Once()
+				key, value, found := hs256(req)
+				require.Equal(t, tc.found, found)
+				if found {
+					require.Equal(t, "uid", key)
+					require.Equal(t, "my-uid", value)
+				}
+				req.AssertExpectations(t)
+			}
+		})
+
+		t.Run("rs256", func(t *testing.T) {
+			for _, tc := range []struct {
+				token string
+				found bool
+			}{
+				{token: makeTestJWT(t, "RS256", validClaims, nil, privateKey), found: true},
+				{token: makeTestJWT(t, "RS256", expiredClaims, nil, privateKey)},
+				// Algorithm confusion using the public key as HMAC secret
+				{token: makeTestJWT(t, "HS256", validClaims, []byte("public key"), nil)},
+			} {
+				req := &http_protection_mockups.RequestReaderMockup{}
+				req.ExpectHeaders().Return(http.Header{"Cookie": []string{"token=" + tc.token}}).Once()
+				key, value, found := rs256(req)
+				require.Equal(t, tc.found, found)
+				if found {
+					require.Equal(t, "sub", key)
+					require.Equal(t, "my-uid", value)
+				}
+				req.AssertExpectations(t)
+			}
+		})
+	})
+}
+
+func TestIdentifyUserFromRequest(t *testing.T) {
+	req := &http_protection_mockups.RequestReaderMockup{}
+	defer req.AssertExpectations(t)
+	uid := "my-uid"
+	req.ExpectHeader("X-User-Id").Return(&uid).Once()
+	req.ExpectHeader("X-Tenant-Id").Return(nil).Once()
+	req.ExpectHeaders().Return(http.Header{"Cookie": []string{"org=my-org"}}).Once()
+
+	p := NewTestProtectionContext(nil, nil, nil, req)
+	WithUserIdentifiers(
+		UserIdentifierFromHeader("X-User-Id", "uid"),
+		UserIdentifierFromHeader("X-Tenant-Id", "tenant"),
+		UserIdentifierFromCookie("org", "org"),
+	)(p)
+
+	require.NoError(t, p.identifyUserFromRequest())
+	events := p.events.CloseRecord().CustomEvents
+	require.Len(t, events, 1)
+	require.Equal(t, map[string]string{"uid": "my-uid", "org": "my-org"}, events[0].UserID)
+}
+
+func TestUserIdentifierOptions(t *testing.T) {
+	secret := []byte("my secret")
+	_, err := WithUserIdentifierFromJWTClaim(JWTUserIdentifierConfig{Claim: "sub"})
+	require.Error(t, err)
+	jwtOption, err := WithUserIdentifierFromJWTClaim(JWTUserIdentifierConfig{Claim: "sub", Key: "uid", Secret: secret})
+	require.NoError(t, err)
+
+	req := &http_protection_mockups.RequestReaderMockup{}
+	defer req.AssertExpectations(t)
+	tenant := "my-tenant"
+	req.ExpectHeader("X-Tenant-Id").Return(&tenant).Once()
+	req.ExpectHeaders().Return(http.Header{"Cookie": []string{"org=my-org"}}).Once()
+	authorization := "Bearer " + makeTestJWT(t, "HS256", map[string]interface{}{"sub": "my-uid"}, secret, nil)
+	req.ExpectHeader("Authorization").Return(&authorization).Once()
+
+	p := NewTestProtectionContext(nil, nil, nil, req)
+	for _, opt := range []Option{
+		WithUserIdentifierFromHeader("X-Tenant-Id", "tenant"),
+		WithUserIdentifierFromCookie("org", "org"),
+		jwtOption,
+	} {
+		opt(p)
+	}
+
+	require.NoError(t, p.identifyUserFromRequest())
+	events := p.events.CloseRecord().CustomEvents
+	require.Len(t, events, 1)
+	require.Equal(t, map[string]string{"tenant": "my-tenant", "org": "my-org", "uid": "my-uid"}, events[0].UserID)
+}
+
+func makeTestJWT(t *testing.T, alg string, claims map[string]interface{}, secret []byte, privateKey *rsa.PrivateKey) string {
+	encode := func(v interface{}) string {
+		buf, err := json.Marshal(v)
+		require.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(buf)
+	}
+	signed := encode(map[string]string{"alg": alg, "typ": "JWT"}) + "." + encode(claims)
+	var signature []byte
+	switch {
+	case secret != nil:
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case privateKey != nil:
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
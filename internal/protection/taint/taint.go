@@ -0,0 +1,262 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+// Package taint implements the taint tracking of the request inputs. The
+// strings taken from the request parameters are tainted, and so are the
+// strings derived from tainted strings by the hooked string functions, such as
+// the functions of packages `strings`, `fmt` and `strconv` when the program is
+// fully instrumented. Injection callbacks can then check whether the arguments
+// of sink functions, such as SQL queries or commands, contain tainted strings.
+//
+// Go strings cannot carry extra information, so the tracker stores the
+// tainted string values. A string is then tainted when it contains one of
+// them as a whole token, which also covers the concatenations performed by the
+// `+` operator. Short strings are never tainted, and tainted strings found in
+// longer words are ignored, in order to limit the false positives.
+package taint
+
+import (
+	"reflect"
+	"sync"
+)
+
+const (
+	// MinLength is the minimum length of tainted strings.
+	MinLength = 5
+	// MaxValues is the maximum number of tainted strings per tracker.
+	MaxValues = 1024
+	// maxDepth is the maximum depth of the values walked in order to find
+	// strings.
+	maxDepth = 8
+)
+
+// Source is the request input a tainted string derives from.
+type Source struct {
+	// Name of the request parameter.
+	Name string `json:"name"`
+	// Value of the request parameter.
+	Value string `json:"value"`
+	// Key is true when the tainted string is a map key of the request
+	// parameter value, such as a query parameter name, rather than a value.
+	Key bool `json:"key,omitempty"`
+}
+
+// Tracker is the set of tainted strings of a request. It is safe for
+// concurrent use.
+type Tracker struct {
+	mu     sync.RWMutex
+	values map[string]Source
+	// index maps the prefixes of MinLength bytes of the tainted strings to
+	// them, so that the cost of looking up the tainted strings contained in a
+	// string doesn't depend on the number of tainted strings.
+	index map[string][]string
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		values: make(map[string]Source),
+		index:  make(map[string][]string),
+	}
+}
+
+// Taint taints string `s` with the given source. It returns false when the
+// string cannot be tainted because it is too short or because the tracker is
+// full.
+func (t *Tracker) Taint(s string, src Source) bool {
+	if len(s) < MinLength {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, exists := t.values[s]; exists {
+		return true
+	}
+	if len(t.values) >= MaxValues {
+		return false
+	}
+	t.values[s] = src
+	prefix := s[:MinLength]
+	t.index[prefix] = append(t.index[prefix], s)
+	return true
+}
+
+// TaintValue taints the strings found in value `v` of request parameter
+// `name`. Strings are searched in the byte slices, slices, arrays, maps,
+// structs, pointers and interfaces, up to a maximum depth.
+func (t *Tracker) TaintValue(name string, v interface{}) {
+	walkStrings(reflect.ValueOf(v), maxDepth, false, func(s string, key bool) bool {
+		t.Taint(s, Source{Name: name, Value: s, Key: key})
+		return true
+	})
+}
+
+// IsTainted returns the source of string `s` when it is tainted or when it
+// contains a tainted string. Tainted strings are only looked up on token
+// boundaries: a tainted string starting or ending with a letter, a digit or
+// an underscore cannot be preceded or followed by another one, so that for
+// example `admin` doesn't taint `administrator`.
+func (t *Tracker) IsTainted(s string) (src Source, tainted bool) {
+	return t.IsTaintedBy(s, nil)
+}
+
+// IsTaintedBy is like IsTainted but only considers the sources for which
+// function `match` returns true. Every source is considered when `match` is
+// nil. Its cost is linear in the length of `s` and doesn't depend on the
+// number of tainted strings.
+func (t *Tracker) IsTaintedBy(s string, match func(Source) bool) (src Source, tainted bool) {
+	if t == nil || len(s) < MinLength {
+		return Source{}, false
+	}
+	// Function `match` is called without holding the lock since it can call
+	// hooked functions propagating the taint.
+	for _, src := range t.lookup(s, match == nil) {
+		if match == nil || match(src) {
+			return src, true
+		}
+	}
+	return Source{}, false
+}
+
+// lookup returns the sources of the tainted strings `s` contains, or only the
+// first one when `first` is true.
+func (t *Tracker) lookup(s string, first bool) (sources []Source) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	// Look up the tainted strings starting at every token boundary of `s`
+	// using the index of their prefixes.
+	for start := 0; start+MinLength <= len(s); start++ {
+		if start > 0 && isWordByte(s[start-1]) && isWordByte(s[start]) {
+			continue
+		}
+		for _, v := range t.index[s[start:start+MinLength]] {
+			end := start + len(v)
+			if end > len(s) || s[start:end] != v {
+				continue
+			}
+			if end < len(s) && isWordByte(v[len(v)-1]) && isWordByte(s[end]) {
+				continue
+			}
+			sources = append(sources, t.values[v])
+			if first {
+				return sources
+			}
+		}
+	}
+	return sources
+}
+
+// isWordByte returns true for the letters, digits, underscores and non-ASCII
+// bytes of UTF-8 characters.
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c >= 0x80
+}
+
+// Len returns the number of tainted strings.
+func (t *Tracker) Len() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.values)
+}
+
+// Propagate taints the strings found in the results of a function call when
+// one of the strings found in its parameters is tainted. Parameters and
+// results are walked the same way as TaintValue. It returns true when the
+// results were tainted. Results having no string long enough to be tainted
+// are skipped without walking the parameters.
+func (t *Tracker) Propagate(params, results []interface{}) (propagated bool) {
+	if t.Len() == 0 {
+		return false
+	}
+
+	var candidates []string
+	for _, r := range results {
+		walkStrings(reflect.ValueOf(r), maxDepth, false, func(s string, _ bool) bool {
+			if len(s) >= MinLength {
+				candidates = append(candidates, s)
+			}
+			return true
+		})
+	}
+	if len(candidates) == 0 {
+		return false
+	}
+
+	var (
+		src     Source
+		tainted bool
+	)
+	for _, p := range params {
+		walkStrings(reflect.ValueOf(p), maxDepth, false, func(s string, _ bool) bool {
+			src, tainted = t.IsTainted(s)
+			return !tainted
+		})
+		if tainted {
+			break
+		}
+	}
+	if !tainted {
+		return false
+	}
+
+	for _, s := range candidates {
+		if t.Taint(s, src) {
+			propagated = true
+		}
+	}
+	return propagated
+}
+
+// walkStrings calls `fn` with the strings found in `v` until it returns false.
+// The strings found in map keys are given with `key` set to true. It returns
+// false when the walk was stopped.
+func walkStrings(v reflect.Value, depth int, key bool, fn func(s string, key bool) bool) bool {
+	if depth <= 0 || !v.IsValid() {
+		return true
+	}
+	depth--
+
+	switch v.Kind() {
+	case reflect.String:
+		return fn(v.String(), key)
+
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return true
+		}
+		return walkStrings(v.Elem(), depth, key, fn)
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return fn(string(v.Bytes()), key)
+		}
+		fallthrough
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !walkStrings(v.Index(i), depth, key, fn) {
+				return false
+			}
+		}
+
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if !walkStrings(iter.Key(), depth, true, fn) || !walkStrings(iter.Value(), depth, key, fn) {
+				return false
+			}
+		}
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !walkStrings(v.Field(i), depth, key, fn) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package taint_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/protection/taint"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracker(t *testing.T) {
+	t.Run("taint", func(t *testing.T) {
+		tracker := taint.NewTracker()
+		src := taint.Source{Name: "query", Value: "' OR 1=1 --"}
+		require.True(t, tracker.Taint(src.Value, src))
+		// Too short
+		require.False(t, tracker.Taint("abcd", src))
+		require.Equal(t, 1, tracker.Len())
+
+		got, tainted := tracker.IsTainted(src.Value)
+		require.True(t, tainted)
+		require.Equal(t, src, got)
+
+		// Concatenation
+		got, tainted = tracker.IsTainted("SELECT * FROM users WHERE name = '" + src.Value + "'")
+		require.True(t, tainted)
+		require.Equal(t, src, got)
+
+		_, tainted = tracker.IsTainted("SELECT * FROM users")
+		require.False(t, tainted)
+	})
+
+	t.Run("token boundaries", func(t *testing.T) {
+		tracker := taint.NewTracker()
+		src := taint.Source{Name: "query", Value: "admin"}
+		tracker.Taint(src.Value, src)
+
+		for _, s := range []string{"admin", "user=admin", "/home/admin/.ssh", "admin's", "élan admin"} {
+			got, tainted := tracker.IsTainted(s)
+			require.True(t, tainted, s)
+			require.Equal(t, src, got)
+		}
+		for _, s := range []string{"administrator", "sysadmin", "admin_user", "admin2", "éadmin", "badmin admins"} {
+			_, tainted := tracker.IsTainted(s)
+			require.False(t, tainted, s)
+		}
+	})
+
+	t.Run("taint matching", func(t *testing.T) {
+		tracker := taint.NewTracker()
+		key := taint.Source{Name: "query", Value: "language"}
+		value := taint.Source{Name: "query", Value: "fr; Domain=evil.com"}
+		tracker.Taint(key.Value, key)
+		tracker.Taint(value.Value, value)
+
+		hasSeparator := func(src taint.Source) bool { return strings.Contains(src.Value, ";") }
+		got, tainted := tracker.IsTaintedBy("language=fr; Domain=evil.com", hasSeparator)
+		require.True(t, tainted)
+		require.Equal(t, value, got)
+
+		_, tainted = tracker.IsTaintedBy("language=en", hasSeparator)
+		require.False(t, tainted)
+	})
+
+	t.Run("nil tracker", func(t *testing.T) {
+		var tracker *taint.Tracker
+		_, tainted := tracker.IsTainted("value")
+		require.False(t, tainted)
+		require.False(t, tracker.Propagate([]interface{}{"value"}, []interface{}{"value"}))
+	})
+
+	t.Run("maximum number of values", func(t *testing.T) {
+		tracker := taint.NewTracker()
+		for i := 0; i < taint.MaxValues; i++ {
+			require.True(t, tracker.Taint(fmt.Sprintf("value-%d", i), taint.Source{}))
+		}
+		require.False(t, tracker.Taint("one more", taint.Source{}))
+		require.Equal(t, taint.MaxValues, tracker.Len())
+	})
+
+	t.Run("taint value", func(t *testing.T) {
+		type body struct {
+			Name     string
+			Tags     []string
+			Nested   map[string]interface{}
+			password string
+		}
+		tracker := taint.NewTracker()
+		tracker.TaintValue("json", &body{
+			Name:     "name value",
+			Tags:     []string{"tag value"},
+			Nested:   map[string]interface{}{"key value": []byte("bytes value")},
+			password: "password value",
+		})
+		for _, s := range []string{"name value", "tag value", "key value", "bytes value", "password value"} {
+			src, tainted := tracker.IsTainted(s)
+			require.True(t, tainted, s)
+			require.Equal(t, taint.Source{Name: "json", Value: s, Key: s == "key value"}, src)
+		}
+	})
+
+	t.Run("propagate", func(t *testing.T) {
+		tracker := taint.NewTracker()
+		src := taint.Source{Name: "query", Value: "  ../../etc/passwd  "}
+		tracker.Taint(src.Value, src)
+
+		// strings.TrimSpace
+		trimmed := strings.TrimSpace(src.Value)
+		require.True(t, tracker.Propagate([]interface{}{&src.Value}, []interface{}{&trimmed}))
+		got, tainted := tracker.IsTainted(trimmed)
+		require.True(t, tainted)
+		require.Equal(t, src, got)
+
+		// fmt.Sprintf
+		format := "/var/www/%s"
+		formatted := fmt.Sprintf(format, trimmed)
+		require.True(t, tracker.Propagate([]interface{}{&format, &[]interface{}{trimmed}}, []interface{}{&formatted}))
+		got, tainted = tracker.IsTainted(formatted)
+		require.True(t, tainted)
+		require.Equal(t, src, got)
+
+		// Not tainted
+		untainted := "untainted"
+		upper := strings.ToUpper(untainted)
+		require.False(t, tracker.Propagate([]interface{}{&untainted}, []interface{}{&upper}))
+		_, tainted = tracker.IsTainted(upper)
+		require.False(t, tainted)
+	})
+
+	t.Run("shared prefixes", func(t *testing.T) {
+		tracker := taint.NewTracker()
+		short := taint.Source{Name: "query", Value: "admin"}
+		long := taint.Source{Name: "json", Value: "admin' --"}
+		tracker.Taint(short.Value, short)
+		tracker.Taint(long.Value, long)
+
+		got, tainted := tracker.IsTainted("name = 'admin' --'")
+		require.True(t, tainted)
+		require.Equal(t, short, got)
+
+		hasComment := func(src taint.Source) bool { return strings.Contains(src.Value, "--") }
+		got, tainted = tracker.IsTaintedBy("name = 'admin' --'", hasComment)
+		require.True(t, tainted)
+		require.Equal(t, long, got)
+
+		_, tainted = tracker.IsTaintedBy("name = 'admin'", hasComment)
+		require.False(t, tainted)
+	})
+
+	t.Run("short results", func(t *testing.T) {
+		tracker := taint.NewTracker()
+		src := taint.Source{Name: "query", Value: "abc; rm -rf"}
+		tracker.Taint(src.Value, src)
+
+		// strings.Index
+		index := strings.Index(src.Value, ";")
+		require.False(t, tracker.Propagate([]interface{}{&src.Value}, []interface{}{&index}))
+		// strings.Split
+		parts := strings.Split(src.Value, ";")
+		require.True(t, tracker.Propagate([]interface{}{&src.Value}, []interface{}{&parts}))
+		_, tainted := tracker.IsTainted(parts[1])
+		require.True(t, tainted)
+		require.Equal(t, 2, tracker.Len())
+	})
+
+	t.Run("concurrent propagation", func(t *testing.T) {
+		tracker := taint.NewTracker()
+		src := taint.Source{Name: "query", Value: "../../etc/passwd"}
+		tracker.Taint(src.Value, src)
+
+		const n = 64
+		results := make([]string, n)
+		var wg sync.WaitGroup
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = fmt.Sprintf("/var/www/%d/%s", i, src.Value)
+				require.True(t, tracker.Propagate([]interface{}{&src.Value}, []interface{}{&results[i]}))
+			}(i)
+		}
+		wg.Wait()
+		require.Equal(t, n+1, tracker.Len())
+	})
+}
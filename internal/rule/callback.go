@@ -7,6 +7,7 @@
 package rule
 
 import (
+	"context"
 	"reflect"
 	"time"
 
@@ -16,6 +17,7 @@ import (
 	"github.com/sqreen/go-agent/internal/event"
 	"github.com/sqreen/go-agent/internal/metrics"
 	"github.com/sqreen/go-agent/internal/plog"
+	protection_context "github.com/sqreen/go-agent/internal/protection/context"
 	http_protection "github.com/sqreen/go-agent/internal/protection/http"
 	"github.com/sqreen/go-agent/internal/rule/callback"
 	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
@@ -28,12 +30,100 @@ type ProtectionContext interface {
 	HandleAttack(block bool, attack *event.AttackEvent) (blocked bool)
 }
 
+// FromGLS returns the protection context stored into the goroutine-local
+// storage, or nil when there is none or when it is closed.
 func FromGLS() ProtectionContext {
-	v := sqgls.Get()
+	return activeProtectionContext(sqgls.Get())
+}
+
+// activeProtectionContext returns the protection context of the given
+// goroutine-local storage or context value. The goroutines created by a
+// request handler inherit its goroutine-local storage value and its context,
+// and can outlive the request: closed protection contexts are therefore
+// ignored.
+func activeProtectionContext(v interface{}) ProtectionContext {
 	actual, _ := v.(ProtectionContext)
+	if actual == nil {
+		return nil
+	}
+	if closer, ok := actual.(interface{ Closed() bool }); ok && closer.Closed() {
+		return nil
+	}
 	return actual
 }
 
+// FromContext returns the protection context stored into the given context
+// using the protection context key, or nil when there is none or when it is
+// closed.
+func FromContext(ctx context.Context) ProtectionContext {
+	v := ctx.Value(protection_context.ContextKey)
+	if v == nil {
+		// Try with a string since frameworks such as Gin implement it with keys of
+		// type string.
+		v = ctx.Value(protection_context.ContextKey.String)
+	}
+	return activeProtectionContext(v)
+}
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// fromCallParams returns the protection context found in the `context.Context`
+// argument of the hooked function call according to the given configuration,
+// and falls back to the given goroutine protection context getter otherwise.
+// Without configuration, the first `context.Context` argument is used.
+func fromCallParams(cfg *api.ReflectedCallbackHTTPProtectionContextConfig, params []reflect.Value, fallback func() ProtectionContext) ProtectionContext {
+	var typ string
+	if cfg != nil {
+		typ = cfg.Type
+	}
+
+	switch typ {
+	case api.ProtectionContextFromGLSType:
+		// Skip the arguments
+
+	case api.ProtectionContextFromFuncArgType:
+		if i := cfg.ArgIndex; i < uint(len(params)) {
+			if ctx := contextParam(params[i]); ctx != nil {
+				if p := FromContext(ctx); p != nil {
+					return p
+				}
+			}
+		}
+
+	default:
+		for _, param := range params {
+			if ctx := contextParam(param); ctx != nil {
+				if p := FromContext(ctx); p != nil {
+					return p
+				}
+				break
+			}
+		}
+	}
+
+	return fallback()
+}
+
+// contextParam returns the `context.Context` value of the given hooked function
+// parameter, which is a pointer to the argument value, or nil when it is not a
+// non-nil `context.Context`.
+func contextParam(param reflect.Value) context.Context {
+	if param.Kind() != reflect.Ptr || param.IsNil() {
+		return nil
+	}
+	arg := param.Elem()
+	if !arg.Type().Implements(contextType) {
+		return nil
+	}
+	switch arg.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if arg.IsNil() {
+			return nil
+		}
+	}
+	return arg.Interface().(context.Context)
+}
+
 // Static assert that protection contexts correctly implement the
 // ProtectionContext interface
 var _ ProtectionContext = (*http_protection.ProtectionContext)(nil)
@@ -47,6 +137,12 @@ type nativeRuleContext struct {
 	attackType   string
 	rulepackID   string
 	logger       plog.DebugLevelLogger
+	// Configuration of the protection context lookup in the hooked function
+	// arguments of reflected callbacks.
+	protectionContextConfig *api.ReflectedCallbackHTTPProtectionContextConfig
+	// protectionContext returns the protection context of the current
+	// goroutine. It is FromGLS unless the rules are replayed.
+	protectionContext func() ProtectionContext
 
 	pre  []NativeCallbackMiddlewareFunc
 	post []NativeCallbackMiddlewareFunc
@@ -60,7 +156,7 @@ type nativeRuleContext struct {
 	perfHistogramPeriod time.Duration
 }
 
-var _ callback.RuleContext = &nativeRuleContext{}
+var _ callback.ReflectedRuleContext = &nativeRuleContext{}
 
 type (
 	NativeCallbackFunc           = func(c callback.CallbackContext) error
@@ -93,6 +189,11 @@ func newNativeRuleContext(rule *api.Rule, rulepackID string, metricsEngine *metr
 		perfHistogramPeriod: perfHistogramPeriod,
 		perfHistogramUnit:   perfHistogramUnit,
 		perfHistogramBase:   perfHistogramBase,
+		protectionContext:   FromGLS,
+	}
+
+	if cfg := rule.Hookpoint.Config; cfg != nil {
+		r.protectionContextConfig = cfg.ProtectionContext
 	}
 
 	r.buildMiddlewares()
@@ -197,8 +298,23 @@ func (r *nativeRuleContext) Post(post func(c callback.CallbackContext) error) {
 	r.call(post, r.post)
 }
 
+func (r *nativeRuleContext) Logger() callback.Logger {
+	return r.logger
+}
+
+func (r *nativeRuleContext) WithCallParams(params []reflect.Value) callback.RuleContext {
+	return callRuleContext{
+		nativeRuleContext: r,
+		p:                 fromCallParams(r.protectionContextConfig, params, r.protectionContext),
+	}
+}
+
 func (r *nativeRuleContext) call(cb NativeCallbackFunc, m []NativeCallbackMiddlewareFunc) {
-	c, ok := makeCallbackContext(r)
+	r.callWith(r.protectionContext(), cb, m)
+}
+
+func (r *nativeRuleContext) callWith(p ProtectionContext, cb NativeCallbackFunc, m []NativeCallbackMiddlewareFunc) {
+	c, ok := makeCallbackContext(r, p)
 	if !ok {
 		return
 	}
@@ -283,8 +399,22 @@ type (
 	}
 )
 
-func makeCallbackContext(r *nativeRuleContext) (c callbackContext, ok bool) {
-	p := FromGLS()
+// callRuleContext is the rule context of a hooked function call whose
+// protection context was found by WithCallParams.
+type callRuleContext struct {
+	*nativeRuleContext
+	p ProtectionContext
+}
+
+func (r callRuleContext) Pre(pre NativeCallbackFunc) {
+	r.callWith(r.p, pre, r.pre)
+}
+
+func (r callRuleContext) Post(post NativeCallbackFunc) {
+	r.callWith(r.p, post, r.post)
+}
+
+func makeCallbackContext(r *nativeRuleContext, p ProtectionContext) (c callbackContext, ok bool) {
 	if p == nil {
 		ok = false
 		return
@@ -337,13 +467,15 @@ func (c callbackContext) HandleAttack(shouldBlock bool, opts ...event.AttackEven
 type (
 	reflectedCallbackConfig struct {
 		callback.NativeCallbackConfig
-		strategy *api.ReflectedCallbackConfig
+		strategy       *api.ReflectedCallbackConfig
+		prologFuncType reflect.Type
 	}
 
 	jsReflectedCallbackConfig struct {
 		callback.ReflectedCallbackConfig
-		pre  *jsCallbackFuncConfig
-		post *jsCallbackFuncConfig
+		pre    *jsCallbackFuncConfig
+		post   *jsCallbackFuncConfig
+		limits callback.JSLimits
 	}
 
 	jsCallbackFuncConfig struct {
@@ -362,6 +494,10 @@ func (c *reflectedCallbackConfig) Strategy() *api.ReflectedCallbackConfig {
 	return c.strategy
 }
 
+func (c *reflectedCallbackConfig) PrologFuncType() reflect.Type {
+	return c.prologFuncType
+}
+
 func (c *jsReflectedCallbackConfig) Pre() (*goja.Program, []bindingaccessor.BindingAccessorFunc) {
 	if c.pre == nil {
 		return nil, nil
@@ -369,6 +505,10 @@ func (c *jsReflectedCallbackConfig) Pre() (*goja.Program, []bindingaccessor.Bind
 	return c.pre.FuncDecl, c.pre.FuncCallParams
 }
 
+func (c *jsReflectedCallbackConfig) Limits() callback.JSLimits {
+	return c.limits
+}
+
 func (c *jsReflectedCallbackConfig) Post() (*goja.Program, []bindingaccessor.BindingAccessorFunc) {
 	if c.post == nil {
 		return nil, nil
@@ -398,7 +538,7 @@ func newNativeCallbackConfig(r *api.Rule) (callback.NativeCallbackConfig, error)
 	return cfg, nil
 }
 
-func newReflectedCallbackConfig(r *api.Rule) (callback.ReflectedCallbackConfig, error) {
+func newReflectedCallbackConfig(r *api.Rule, prologFuncType reflect.Type) (callback.ReflectedCallbackConfig, error) {
 	if s := r.Hookpoint.Strategy; s != "reflected" {
 		return nil, sqerrors.Errorf("callback config: unexpected hookpoint strategy `%s`", s)
 	}
@@ -410,11 +550,12 @@ func newReflectedCallbackConfig(r *api.Rule) (callback.ReflectedCallbackConfig,
 	return &reflectedCallbackConfig{
 		NativeCallbackConfig: nativeCfg,
 		strategy:             r.Hookpoint.Config,
+		prologFuncType:       prologFuncType,
 	}, nil
 }
 
-func newJSReflectedCallbackConfig(r *api.Rule) (callback.JSReflectedCallbackConfig, error) {
-	reflectedCfg, err := newReflectedCallbackConfig(r)
+func newJSReflectedCallbackConfig(r *api.Rule, prologFuncType reflect.Type) (callback.JSReflectedCallbackConfig, error) {
+	reflectedCfg, err := newReflectedCallbackConfig(r, prologFuncType)
 	if err != nil {
 		return nil, err
 	}
@@ -423,11 +564,12 @@ func newJSReflectedCallbackConfig(r *api.Rule) (callback.JSReflectedCallbackConf
 	if !ok {
 		return nil, sqerrors.Errorf("unexpected callbacks type `%T` instead of `%T`", r.Callbacks.RuleCallbacksNode, callbacks)
 	}
-	pre, err := newJSCallbackFuncConfig("pre", callbacks.Pre)
+	env := callback.NewReflectedCallbackBindingAccessorTypeEnv(prologFuncType, reflectedCfg.Data())
+	pre, err := newJSCallbackFuncConfig("pre", callbacks.Pre, env)
 	if err != nil {
 		return nil, err
 	}
-	post, err := newJSCallbackFuncConfig("post", callbacks.Post)
+	post, err := newJSCallbackFuncConfig("post", callbacks.Post, env)
 	if err != nil {
 		return nil, err
 	}
@@ -440,6 +582,11 @@ func newJSReflectedCallbackConfig(r *api.Rule) (callback.JSReflectedCallbackConf
 		ReflectedCallbackConfig: reflectedCfg,
 		pre:                     pre,
 		post:                    post,
+		limits: callback.JSLimits{
+			Timeout:       time.Duration(callbacks.MaxBudget) * time.Millisecond,
+			MaxSteps:      callbacks.MaxSteps,
+			MaxOverBudget: callbacks.MaxOverBudget,
+		},
 	}, nil
 }
 
@@ -464,7 +611,7 @@ func newCallbackConfigData(ruleData []api.RuleDataEntry) interface{} {
 	return dataArray
 }
 
-func newJSCallbackFuncConfig(name string, rule []string) (*jsCallbackFuncConfig, error) {
+func newJSCallbackFuncConfig(name string, rule []string, env *bindingaccessor.TypeEnv) (*jsCallbackFuncConfig, error) {
 	if len(rule) == 0 {
 		return nil, nil
 	}
@@ -476,7 +623,7 @@ func newJSCallbackFuncConfig(name string, rule []string) (*jsCallbackFuncConfig,
 	jsSrc := rule[last]
 
 	// Compile the JS source code
-	program, err := goja.Compile(name, jsSrc, true)
+	program, err := callback.CompileJSCallback(name, jsSrc)
 	if err != nil {
 		return nil, sqerrors.Wrapf(err, "could not compile the js function declaration `%s`", name)
 	}
@@ -494,7 +641,7 @@ func newJSCallbackFuncConfig(name string, rule []string) (*jsCallbackFuncConfig,
 
 	// Compile the binding accessors to use to get its call parameters
 	bindingAccessorSources := rule[:last]
-	bindingAccessors, err := compileBindingAccessorExpressions(bindingAccessorSources)
+	bindingAccessors, err := compileBindingAccessorExpressions(bindingAccessorSources, env)
 	if err != nil {
 		return nil, sqerrors.Wrapf(err, "could not compile the binding accessors of the js function call to `%s`", name)
 	}
@@ -505,10 +652,10 @@ func newJSCallbackFuncConfig(name string, rule []string) (*jsCallbackFuncConfig,
 	}, nil
 }
 
-func compileBindingAccessorExpressions(bindingAccessors []string) ([]bindingaccessor.BindingAccessorFunc, error) {
+func compileBindingAccessorExpressions(bindingAccessors []string, env *bindingaccessor.TypeEnv) ([]bindingaccessor.BindingAccessorFunc, error) {
 	args := make([]bindingaccessor.BindingAccessorFunc, len(bindingAccessors))
 	for i, expr := range bindingAccessors {
-		ba, err := bindingaccessor.Compile(expr)
+		ba, err := bindingaccessor.Compile(expr, bindingaccessor.WithTypeEnv(env))
 		if err != nil {
 			return nil, sqerrors.Wrapf(err, "could not compile the binding accessor of argument %d", i)
 		}
@@ -54,6 +54,15 @@ func (r *NativeRuleContextMockup) ExpectPost(cb interface{}) *mock.Call {
 	return r.On("Post", cb)
 }
 
+func (r *NativeRuleContextMockup) Logger() callback.Logger {
+	v, _ := r.Called().Get(0).(callback.Logger)
+	return v
+}
+
+func (r *NativeRuleContextMockup) ExpectLogger() *mock.Call {
+	return r.On("Logger")
+}
+
 type CallbackContextMockup struct {
 	mock.Mock
 }
@@ -132,3 +141,12 @@ func (p *ProtectionContextMockup) DeadlineExceeded(needed time.Duration) (exceed
 func (p *ProtectionContextMockup) ExpectDeadlineExceeded(needed time.Duration) *mock.Call {
 	return p.On("DeadlineExceeded", needed)
 }
+
+func (p *ProtectionContextMockup) RemainingTime() (remaining time.Duration, limited bool) {
+	ret := p.Called()
+	return ret.Get(0).(time.Duration), ret.Bool(1)
+}
+
+func (p *ProtectionContextMockup) ExpectRemainingTime() *mock.Call {
+	return p.On("RemainingTime")
+}
@@ -305,6 +305,56 @@ func TestBindingAccessor(t *testing.T) {
 	}
 }
 
+func TestBindingAccessorTypeEnv(t *testing.T) {
+	t.Run("reflected callbacks", func(t *testing.T) {
+		// Prolog type of function `func(*sql.DB, string) (*sql.Rows, error)`
+		type epilog = func(**sql.Rows, *error)
+		type prolog = func(**sql.DB, *string) (epilog, error)
+		ruleValues := map[string]interface{}{"dialects": map[string]interface{}{}}
+		env := callback.NewReflectedCallbackBindingAccessorTypeEnv(reflect.TypeOf(prolog(nil)), ruleValues)
+
+		for _, tc := range []struct {
+			Expr  string
+			Valid bool
+		}{
+			{Expr: "#.SQL.Dialect(#.Func.Args[0], #.Rule.Data.Values['dialects'])", Valid: true},
+			{Expr: "len(#.Func.Args[1]) > 0 && #.Func.Rets[1] == nil", Valid: true},
+			{Expr: "#.Func.Rets[0].Next", Valid: true},
+			{Expr: "#.Request.FilteredParams | flat_values", Valid: true},
+			{Expr: "#.SQL.Dialect(#.Func.Args[1], #.Rule.Data.Values['dialects'])"},
+			{Expr: "#.SQL.Dialect(#.Func.Args[0], 'dialects')"},
+			{Expr: "#.Func.Args[0].Oops"},
+			{Expr: "#.Rule.Data.Values[0]"},
+			{Expr: "#.Oops"},
+		} {
+			tc := tc
+			t.Run(tc.Expr, func(t *testing.T) {
+				_, err := bindingaccessor.Compile(tc.Expr, bindingaccessor.WithTypeEnv(env))
+				if tc.Valid {
+					require.NoError(t, err)
+				} else {
+					require.Error(t, err)
+				}
+			})
+		}
+	})
+
+	t.Run("unknown prolog type", func(t *testing.T) {
+		env := callback.NewReflectedCallbackBindingAccessorTypeEnv(nil, nil)
+		_, err := bindingaccessor.Compile("#.Func.Args[0].Whatever", bindingaccessor.WithTypeEnv(env))
+		require.NoError(t, err)
+		_, err = bindingaccessor.Compile("#.Func.Oops", bindingaccessor.WithTypeEnv(env))
+		require.Error(t, err)
+	})
+
+	t.Run("waf callbacks", func(t *testing.T) {
+		_, err := bindingaccessor.Compile("#.Request.Header['User-Agent']", bindingaccessor.WithTypeEnv(callback.WAFBindingAccessorTypeEnv))
+		require.NoError(t, err)
+		_, err = bindingaccessor.Compile("#.Func.Args[0]", bindingaccessor.WithTypeEnv(callback.WAFBindingAccessorTypeEnv))
+		require.Error(t, err)
+	})
+}
+
 type fakeSQLDriver struct{}
 
 func (f *fakeSQLDriver) Open(string) (driver.Conn, error)             { return nil, nil }
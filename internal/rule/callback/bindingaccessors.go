@@ -8,11 +8,14 @@ package callback
 
 import (
 	"database/sql"
+	"fmt"
 	"reflect"
 	"strings"
 
+	bindingaccessor "github.com/sqreen/go-agent/internal/binding-accessor"
 	http_protection "github.com/sqreen/go-agent/internal/protection/http"
 	http_protection_types "github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/sqreen/go-agent/internal/protection/taint"
 	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
 	"github.com/sqreen/go-agent/internal/sqlib/sqgo"
 	"github.com/sqreen/go-agent/internal/sqlib/sqsql"
@@ -38,6 +41,8 @@ func NewReflectedCallbackBindingAccessorContext(capabilities []string, p Protect
 			ctx.Lib = NewLibraryBindingAccessorContext()
 		case "cache":
 			ctx.BindingAccessorResultCache = MakeBindingAccessorResultCache()
+		case "taint":
+			ctx.Taint = NewTaintBindingAccessorContext(p)
 		default:
 			return nil, sqerrors.Errorf("unknown binding accessor capability `%s`", cap)
 		}
@@ -45,6 +50,49 @@ func NewReflectedCallbackBindingAccessorContext(capabilities []string, p Protect
 	return ctx, nil
 }
 
+// NewReflectedCallbackBindingAccessorTypeEnv returns the static type
+// environment of the binding accessor contexts returned by
+// NewReflectedCallbackBindingAccessorContext for the hooked function whose
+// prolog type is `prologType`. The types of the function call arguments and
+// results are unknown when `prologType` is nil.
+func NewReflectedCallbackBindingAccessorTypeEnv(prologType reflect.Type, ruleValues interface{}) *bindingaccessor.TypeEnv {
+	env := &bindingaccessor.TypeEnv{
+		Context: reflect.TypeOf((*BindingAccessorContextType)(nil)),
+		Values:  make(map[string]reflect.Type),
+	}
+	if ruleValues != nil {
+		env.Values["#.Rule.Data.Values"] = reflect.TypeOf(ruleValues)
+	}
+	if prologType == nil || prologType.Kind() != reflect.Func {
+		return env
+	}
+	addPointerTypes(env, "#.Func.Args", prologType)
+	if prologType.NumOut() == 2 {
+		if epilogType := prologType.Out(0); epilogType.Kind() == reflect.Func {
+			addPointerTypes(env, "#.Func.Rets", epilogType)
+		}
+	}
+	return env
+}
+
+// addPointerTypes adds the types pointed to by the parameters of the given
+// callback function type, such as the prolog parameters `*A` of a hooked
+// function parameter `A`. Other parameter types, like those of generic
+// prologs, are not the types of the function call values and are ignored.
+func addPointerTypes(env *bindingaccessor.TypeEnv, path string, callbackType reflect.Type) {
+	for i := 0; i < callbackType.NumIn(); i++ {
+		if t := callbackType.In(i); t.Kind() == reflect.Ptr {
+			env.Values[fmt.Sprintf("%s[%d]", path, i)] = t.Elem()
+		}
+	}
+}
+
+// WAFBindingAccessorTypeEnv is the static type environment of the binding
+// accessor contexts returned by MakeWAFCallbackBindingAccessorContext.
+var WAFBindingAccessorTypeEnv = &bindingaccessor.TypeEnv{
+	Context: reflect.TypeOf(WAFBindingAccessorContextType{}),
+}
+
 type RuleBindingAccessorContextType struct {
 	Data RuleDataBindingAccessorContextType
 }
@@ -137,23 +185,28 @@ loop:
 // capabilities and are nil by default. This mainly allows to avoid their
 // creation cost when not needed.
 type BindingAccessorContextType struct {
-	Lib  *LibraryBindingAccessorContextType
-	Func *FuncCallBindingAccessorContextType
-	SQL  *SQLBindingAccessorContextType
-	Rule *RuleBindingAccessorContextType
+	Lib   *LibraryBindingAccessorContextType
+	Func  *FuncCallBindingAccessorContextType
+	SQL   *SQLBindingAccessorContextType
+	Rule  *RuleBindingAccessorContextType
+	Taint *TaintBindingAccessorContextType
 	*HTTPRequestBindingAccessorContext
 	BindingAccessorResultCache
 }
 
 type WAFBindingAccessorContextType struct {
 	HTTPRequestBindingAccessorContext
+	// Response is the response checked by the response WAF, and nil before.
+	Response *http_protection.ResponseBindingAccessorContext
 	BindingAccessorResultCache
 }
 
 func MakeWAFCallbackBindingAccessorContext(c CallbackContext) (WAFBindingAccessorContextType, error) {
 	switch protCtx := c.ProtectionContext().(type) {
 	case *http_protection.ProtectionContext:
-		return makeHTTPWAFCallbackBindingAccessorContext(protCtx.RequestReader), nil
+		ctx := makeHTTPWAFCallbackBindingAccessorContext(protCtx.RequestReader)
+		ctx.Response = protCtx.Response()
+		return ctx, nil
 	default:
 		return WAFBindingAccessorContextType{}, sqerrors.Errorf("unexpected protection context type `%T`", protCtx)
 	}
@@ -173,6 +226,33 @@ type FuncCallBindingAccessorContextType struct {
 
 type SQLBindingAccessorContextType struct{}
 
+// TaintBindingAccessorContextType gives access to the taint tracker of the
+// request inputs.
+type TaintBindingAccessorContextType struct {
+	tracker *taint.Tracker
+}
+
+func NewTaintBindingAccessorContext(p ProtectionContext) *TaintBindingAccessorContextType {
+	return &TaintBindingAccessorContextType{tracker: taintTracker(p)}
+}
+
+// IsTainted returns true when the value is tainted or contains a tainted
+// string.
+func (t *TaintBindingAccessorContextType) IsTainted(value string) bool {
+	_, tainted := t.tracker.IsTainted(value)
+	return tainted
+}
+
+// Source returns the request input the value derives from when it is
+// tainted, or nil otherwise.
+func (t *TaintBindingAccessorContextType) Source(value string) *taint.Source {
+	src, tainted := t.tracker.IsTainted(value)
+	if !tainted {
+		return nil
+	}
+	return &src
+}
+
 type HTTPRequestBindingAccessorContext struct {
 	Request *http_protection.RequestBindingAccessorContext
 }
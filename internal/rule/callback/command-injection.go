@@ -0,0 +1,120 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sqreen/go-agent/internal/event"
+	"github.com/sqreen/go-agent/internal/protection/taint"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	"github.com/sqreen/go-agent/sdk/types"
+)
+
+// Command injection reasons.
+const (
+	CommandInjectionTaintedCommand = "command from request parameters"
+	CommandInjectionTaintedScript  = "shell script from request parameters"
+)
+
+// Shells executing the script given to their `-c` option, or `/c` for the
+// Windows command prompt.
+var commandInjectionShells = map[string]string{
+	"sh":      "-c",
+	"bash":    "-c",
+	"dash":    "-c",
+	"zsh":     "-c",
+	"ksh":     "-c",
+	"cmd":     "/c",
+	"cmd.exe": "/c",
+}
+
+// Shell metacharacters allowing to inject commands into shell scripts.
+const shellMetacharacters = ";|&$`<>()\n"
+
+// NewCommandInjectionCallback returns the native prolog callback to be
+// attached to `os.StartProcess()`, also called by package `os/exec`. The
+// executed command is checked against the taint of the request inputs: an
+// attack is reported when the command itself is taken from request
+// parameters, or when a shell script includes a request parameter having shell
+// metacharacters. The process execution is aborted according to the rule
+// blocking mode.
+func NewCommandInjectionCallback(r RuleContext, cfg NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	sqassert.NotNil(cfg)
+	return newCommandInjectionPrologCallback(r), nil
+}
+
+type CommandInjectionPrologCallbackType = func(name *string, argv *[]string, attr **os.ProcAttr) (CommandInjectionEpilogCallbackType, error)
+type CommandInjectionEpilogCallbackType = func(**os.Process, *error)
+
+type CommandInjectionAttackInfo struct {
+	Reason string `json:"reason"`
+	Source string `json:"source"`
+}
+
+type CommandInjectionError struct {
+	Reason string
+}
+
+func (e CommandInjectionError) Error() string {
+	return fmt.Sprintf("command injection: %s", e.Reason)
+}
+
+func newCommandInjectionPrologCallback(r RuleContext) CommandInjectionPrologCallbackType {
+	return func(name *string, argv *[]string, _ **os.ProcAttr) (epilog CommandInjectionEpilogCallbackType, prologErr error) {
+		r.Pre(func(c CallbackContext) error {
+			tracker := taintTracker(c.ProtectionContext())
+			if tracker.Len() == 0 {
+				return nil
+			}
+
+			info, injected := checkCommand(tracker, *name, *argv)
+			if !injected {
+				return nil
+			}
+
+			if blocked := c.HandleAttack(true, event.WithAttackInfo(info), event.WithStackTrace()); !blocked {
+				return nil
+			}
+			epilog = func(_ **os.Process, callErr *error) {
+				*callErr = types.SqreenError{Err: CommandInjectionError{Reason: info.Reason}}
+			}
+			prologErr = sqhook.AbortError
+			return nil
+		})
+		return
+	}
+}
+
+func checkCommand(tracker *taint.Tracker, name string, argv []string) (info CommandInjectionAttackInfo, injected bool) {
+	if src, tainted := tracker.IsTainted(name); tainted {
+		return CommandInjectionAttackInfo{Reason: CommandInjectionTaintedCommand, Source: src.Name}, true
+	}
+
+	// Arguments are passed as is to the executed program, unless it is a shell
+	// executing them as a script.
+	// Windows paths are also split on backslashes whatever the current OS is.
+	base := name[strings.LastIndexAny(name, `/\`)+1:]
+	option, isShell := commandInjectionShells[strings.ToLower(base)]
+	if !isShell {
+		return info, false
+	}
+	hasMetacharacters := func(src taint.Source) bool { return strings.ContainsAny(src.Value, shellMetacharacters) }
+	for i := 1; i < len(argv)-1; i++ {
+		if !strings.EqualFold(argv[i], option) {
+			continue
+		}
+		if src, tainted := tracker.IsTaintedBy(argv[i+1], hasMetacharacters); tainted {
+			return CommandInjectionAttackInfo{Reason: CommandInjectionTaintedScript, Source: src.Name}, true
+		}
+	}
+	return info, false
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	"github.com/sqreen/go-agent/sdk/types"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestCommandInjectionCallback(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		target   string
+		argv     []string
+		expected *callback.CommandInjectionAttackInfo
+	}{
+		{
+			name:   "untainted command",
+			target: "/?file=report.pdf",
+			argv:   []string{"/usr/bin/convert", "invoice.pdf", "invoice.png"},
+		},
+		{
+			name:   "tainted argument",
+			target: "/?file=report.pdf",
+			argv:   []string{"/usr/bin/convert", "report.pdf", "report.png"},
+		},
+		{
+			name:   "tainted shell script argument",
+			target: "/?file=report.pdf",
+			argv:   []string{"/bin/sh", "-c", "convert report.pdf report.png"},
+		},
+		{
+			name:     "tainted command",
+			target:   "/?cmd=/usr/bin/id",
+			argv:     []string{"/usr/bin/id"},
+			expected: &callback.CommandInjectionAttackInfo{Reason: callback.CommandInjectionTaintedCommand, Source: "query"},
+		},
+		{
+			name:     "shell script injection",
+			target:   "/?file=report.pdf%3Bcat%20/etc/passwd",
+			argv:     []string{"/bin/sh", "-c", "convert report.pdf;cat /etc/passwd report.png"},
+			expected: &callback.CommandInjectionAttackInfo{Reason: callback.CommandInjectionTaintedScript, Source: "query"},
+		},
+		{
+			name:     "windows shell script injection",
+			target:   "/?file=report.pdf%20%26%20whoami",
+			argv:     []string{`C:\Windows\System32\cmd.exe`, "/C", "convert report.pdf & whoami"},
+			expected: &callback.CommandInjectionAttackInfo{Reason: callback.CommandInjectionTaintedScript, Source: "query"},
+		},
+	} {
+		tc := tc
+		for _, blocking := range []bool{false, true} {
+			blocking := blocking
+			t.Run(tc.name, func(t *testing.T) {
+				r := &mockups.NativeRuleContextMockup{}
+				defer r.AssertExpectations(t)
+
+				cb, err := callback.NewCommandInjectionCallback(r, &mockups.NativeCallbackConfigMockup{})
+				require.NoError(t, err)
+				prolog, ok := cb.(callback.CommandInjectionPrologCallbackType)
+				require.True(t, ok)
+
+				req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+				p := http_protection.NewTestProtectionContext(nil, net.IPv4(1, 2, 3, 4), nil, &requestReader{req: req})
+
+				// The callback is only called once since the matcher is called again
+				// by AssertExpectations()
+				var called bool
+				r.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+					if called {
+						return true
+					}
+					called = true
+					c := &mockups.CallbackContextMockup{}
+					defer c.AssertExpectations(t)
+					c.ExpectProtectionContext().Return(p)
+					if tc.expected != nil {
+						c.ExpectHandleAttack(true, mock.MatchedBy(func(opts []event.AttackEventOption) bool {
+							var attack event.AttackEvent
+							for _, opt := range opts {
+								opt(&attack)
+							}
+							require.Equal(t, *tc.expected, attack.Info)
+							return true
+						})).Return(blocking).Once()
+					}
+					require.NoError(t, cb(c))
+					return true
+				})).Once()
+
+				name, argv := tc.argv[0], tc.argv
+				var attr *os.ProcAttr
+				epilog, err := prolog(&name, &argv, &attr)
+				if tc.expected == nil || !blocking {
+					require.NoError(t, err)
+					require.Nil(t, epilog)
+					return
+				}
+
+				require.Equal(t, sqhook.AbortError, err)
+				require.NotNil(t, epilog)
+				var callErr error
+				epilog(nil, &callErr)
+				var sqErr types.SqreenError
+				require.True(t, xerrors.As(callErr, &sqErr))
+				var injectionErr callback.CommandInjectionError
+				require.True(t, xerrors.As(sqErr.Err, &injectionErr))
+				require.Equal(t, tc.expected.Reason, injectionErr.Reason)
+			})
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"strings"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	httpprotection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+)
+
+// CSPNoncePlaceholder is the placeholder of the CSP rule policy replaced by
+// the request nonce, eg. `script-src 'nonce-{nonce}'`.
+const CSPNoncePlaceholder = "{nonce}"
+
+// NewContentSecurityPolicyCallback returns the native prolog callback to be
+// attached to compatible HTTP protection middlewares such as
+// `protection/http`. It adds the Content-Security-Policy header of the rule's
+// configuration, with its nonce placeholders replaced by the request nonce
+// also available to request handlers through `sdk.FromContext()`. The report
+// URI of the rule's configuration is added to the policy, and is expected to
+// be served by `sdk.CSPReportHandler()`.
+func NewContentSecurityPolicyCallback(_ RuleContext, cfg NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(cfg)
+	data, ok := cfg.Data().(*api.CSPRuleDataEntry)
+	if !ok {
+		return nil, sqerrors.Errorf("unexpected callback data type: got `%T` instead of `%T`", cfg.Data(), data)
+	}
+	policy := strings.TrimSpace(data.Policy)
+	if policy == "" {
+		return nil, sqerrors.New("unexpected empty content security policy")
+	}
+	if data.ReportURI != "" {
+		policy = strings.TrimSuffix(policy, ";") + "; report-uri " + data.ReportURI
+	}
+	header := "Content-Security-Policy"
+	if data.ReportOnly {
+		header = "Content-Security-Policy-Report-Only"
+	}
+	return newContentSecurityPolicyPrologCallback(header, policy), nil
+}
+
+type ContentSecurityPolicyPrologCallbackType = httpprotection.NonBlockingPrologCallbackType
+type ContentSecurityPolicyEpilogCallbackType = httpprotection.NonBlockingEpilogCallbackType
+
+func newContentSecurityPolicyPrologCallback(header, policy string) ContentSecurityPolicyPrologCallbackType {
+	withNonce := strings.Contains(policy, CSPNoncePlaceholder)
+	return func(p **httpprotection.ProtectionContext) (httpprotection.NonBlockingEpilogCallbackType, error) {
+		ctx := *p
+		value := policy
+		if withNonce {
+			value = strings.ReplaceAll(policy, CSPNoncePlaceholder, ctx.CSPNonce())
+		}
+		ctx.ResponseWriter.Header().Set(header, value)
+		return nil, nil
+	}
+}
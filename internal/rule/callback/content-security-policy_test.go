@@ -0,0 +1,90 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentSecurityPolicyCallback(t *testing.T) {
+	t.Run("Configuration errors", func(t *testing.T) {
+		for _, tc := range []interface{}{
+			nil,
+			33,
+			&api.CSPRuleDataEntry{},
+			&api.CSPRuleDataEntry{Policy: "  "},
+		} {
+			tc := tc
+			t.Run("", func(t *testing.T) {
+				cfg := &mockups.NativeCallbackConfigMockup{}
+				cfg.ExpectData().Return(tc)
+				defer cfg.AssertExpectations(t)
+
+				_, err := callback.NewContentSecurityPolicyCallback(nil, cfg)
+				require.Error(t, err)
+			})
+		}
+	})
+
+	for _, tc := range []struct {
+		name           string
+		data           *api.CSPRuleDataEntry
+		expectedHeader string
+		expectedPolicy func(nonce string) string
+	}{
+		{
+			name:           "static policy",
+			data:           &api.CSPRuleDataEntry{Policy: "default-src 'self'"},
+			expectedHeader: "Content-Security-Policy",
+			expectedPolicy: func(string) string { return "default-src 'self'" },
+		},
+		{
+			name:           "nonce",
+			data:           &api.CSPRuleDataEntry{Policy: "script-src 'nonce-{nonce}'; style-src 'nonce-{nonce}'"},
+			expectedHeader: "Content-Security-Policy",
+			expectedPolicy: func(nonce string) string {
+				return "script-src 'nonce-" + nonce + "'; style-src 'nonce-" + nonce + "'"
+			},
+		},
+		{
+			name:           "report only with report uri",
+			data:           &api.CSPRuleDataEntry{Policy: "script-src 'nonce-{nonce}';", ReportOnly: true, ReportURI: "/csp-report"},
+			expectedHeader: "Content-Security-Policy-Report-Only",
+			expectedPolicy: func(nonce string) string {
+				return "script-src 'nonce-" + nonce + "'; report-uri /csp-report"
+			},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &mockups.NativeCallbackConfigMockup{}
+			cfg.ExpectData().Return(tc.data)
+			defer cfg.AssertExpectations(t)
+
+			cb, err := callback.NewContentSecurityPolicyCallback(nil, cfg)
+			require.NoError(t, err)
+			prolog, ok := cb.(callback.ContentSecurityPolicyPrologCallbackType)
+			require.True(t, ok)
+
+			rec := httptest.NewRecorder()
+			p := http_protection.NewTestProtectionContext(nil, net.IPv4(1, 2, 3, 4), rec, nil)
+			epilog, err := prolog(&p)
+			require.NoError(t, err)
+			require.Nil(t, epilog)
+
+			nonce := p.CSPNonce()
+			require.NotEmpty(t, nonce)
+			require.Equal(t, tc.expectedPolicy(nonce), rec.Header().Get(tc.expectedHeader))
+		})
+	}
+}
@@ -6,10 +6,12 @@ package callback
 
 import (
 	"net"
+	"reflect"
 	"time"
 
 	"github.com/sqreen/go-agent/internal/event"
 	"github.com/sqreen/go-agent/internal/plog"
+	"github.com/sqreen/go-agent/internal/protection/taint"
 	"github.com/sqreen/go-agent/internal/sqlib/sqtime"
 )
 
@@ -17,10 +19,32 @@ type (
 	RuleContext interface {
 		Pre(pre CallbackFunc)
 		Post(post CallbackFunc)
+		Logger() Logger
 	}
 	CallbackFunc = func(c CallbackContext) error
+
+	// ReflectedRuleContext is the optional interface of rule contexts able to
+	// find the protection context of the hooked function call in its
+	// arguments, such as a `context.Context` argument, rather than in the
+	// goroutine-local storage only.
+	ReflectedRuleContext interface {
+		RuleContext
+		// WithCallParams returns the rule context of the hooked function call
+		// having the given parameters.
+		WithCallParams(params []reflect.Value) RuleContext
+	}
 )
 
+// withCallParams returns the rule context of the hooked function call when
+// the rule context is a ReflectedRuleContext, or the rule context itself
+// otherwise.
+func withCallParams(r RuleContext, params []reflect.Value) RuleContext {
+	if reflected, ok := r.(ReflectedRuleContext); ok {
+		return reflected.WithCallParams(params)
+	}
+	return r
+}
+
 type CallbackContext interface {
 	HandleAttack(shouldBock bool, opt ...event.AttackEventOption) (blocked bool)
 	ProtectionContext() ProtectionContext
@@ -33,6 +57,22 @@ type ProtectionContext interface {
 	ClientIP() net.IP
 	SqreenTime() *sqtime.SharedStopWatch
 	DeadlineExceeded(needed time.Duration) (exceeded bool)
+	RemainingTime() (remaining time.Duration, limited bool)
+}
+
+// TaintTrackerGetter is the interface of protection contexts tracking the
+// taint of the request inputs.
+type TaintTrackerGetter interface {
+	TaintTracker() *taint.Tracker
+}
+
+// taintTracker returns the taint tracker of the given protection context, or
+// nil when it doesn't track the taint of the request inputs.
+func taintTracker(p ProtectionContext) *taint.Tracker {
+	if getter, ok := p.(TaintTrackerGetter); ok {
+		return getter.TaintTracker()
+	}
+	return nil
 }
 
 type Logger interface {
@@ -0,0 +1,278 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	sdk_types "github.com/sqreen/go-agent/sdk/types"
+)
+
+// CookieSignatureSuffix is the suffix of the name of the cookie holding the
+// signature of a protected cookie.
+const CookieSignatureSuffix = ".sqsig"
+
+// NewCookieSigningCallback returns the native prolog callback to be attached
+// to the HTTP protection hookpoint `BeforeWriteHeader()`. It signs the
+// protected cookies set by the response with the HMAC key of the agent
+// configuration by adding a signature cookie having the same attributes. The
+// protected cookies missing the `Secure`, `HttpOnly` or `SameSite` attributes
+// are reported once per cookie name, as such an application misconfiguration
+// affects every response setting it.
+func NewCookieSigningCallback(r RuleContext, cfg NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	sqassert.NotNil(cfg)
+	data, err := cookieProtectionRuleData(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cookies, err := newProtectedCookieSet(data)
+	if err != nil {
+		return nil, err
+	}
+	return newCookieSigningPrologCallback(r, cookies), nil
+}
+
+// NewCookieVerificationCallback returns the native prolog callback to be
+// attached to the HTTP protection hookpoint `cookieProtection()`. It verifies
+// the signature of the protected cookies of the request. Forged cookies, ie.
+// without signature, and tampered ones, ie. with an invalid signature, are
+// reported as attacks and blocked according to the rule blocking mode. They
+// are only reported until the end of the monitor-only rollout period of the
+// rule data.
+func NewCookieVerificationCallback(r RuleContext, cfg NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	sqassert.NotNil(cfg)
+	data, err := cookieProtectionRuleData(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cookies, err := newProtectedCookieSet(data)
+	if err != nil {
+		return nil, err
+	}
+	return newCookieVerificationPrologCallback(r, cookies, data.MonitorOnlyUntil), nil
+}
+
+func cookieProtectionRuleData(cfg NativeCallbackConfig) (*api.CookieProtectionRuleDataEntry, error) {
+	data, ok := cfg.Data().(*api.CookieProtectionRuleDataEntry)
+	if !ok {
+		return nil, sqerrors.Errorf("unexpected callback data type: got `%T` instead of `%T`", cfg.Data(), data)
+	}
+	return data, nil
+}
+
+func newProtectedCookieSet(data *api.CookieProtectionRuleDataEntry) (map[string]struct{}, error) {
+	if len(data.Cookies) == 0 {
+		return nil, sqerrors.New("unexpected empty list of cookies to protect")
+	}
+	cookies := make(map[string]struct{}, len(data.Cookies))
+	for _, name := range data.Cookies {
+		if name == "" {
+			return nil, sqerrors.New("unexpected empty cookie name")
+		}
+		cookies[name] = struct{}{}
+	}
+	return cookies, nil
+}
+
+type CookieSigningPrologCallbackType = http_protection.ResponseHeaderPrologCallbackType
+type CookieSigningEpilogCallbackType = http_protection.NonBlockingEpilogCallbackType
+
+type CookieVerificationPrologCallbackType = http_protection.BlockingPrologCallbackType
+type CookieVerificationEpilogCallbackType = http_protection.BlockingEpilogCallbackType
+
+type CookieAttributesAttackInfo struct {
+	Cookie            string   `json:"cookie"`
+	MissingAttributes []string `json:"missing_attributes"`
+}
+
+type CookieTamperingAttackInfo struct {
+	Cookie string `json:"cookie"`
+	Reason string `json:"reason"`
+}
+
+type CookieTamperingError struct {
+	Cookie string
+	Reason string
+}
+
+func (e CookieTamperingError) Error() string {
+	return fmt.Sprintf("cookie `%s` was blocked: %s", e.Cookie, e.Reason)
+}
+
+func newCookieSigningPrologCallback(r RuleContext, cookies map[string]struct{}) CookieSigningPrologCallbackType {
+	var reported reportedCookieSet
+	return func(p **http_protection.ProtectionContext, headers *http.Header) (CookieSigningEpilogCallbackType, error) {
+		r.Pre(func(c CallbackContext) error {
+			key, err := cookieSigningKey(*p)
+			if err != nil {
+				return err
+			}
+
+			h := *headers
+			for _, cookie := range (&http.Response{Header: h}).Cookies() {
+				if _, protected := cookies[cookie.Name]; !protected {
+					continue
+				}
+
+				if missing := missingCookieAttributes(cookie); len(missing) > 0 && reported.add(cookie.Name) {
+					info := CookieAttributesAttackInfo{
+						Cookie:            cookie.Name,
+						MissingAttributes: missing,
+					}
+					c.HandleAttack(false, event.WithAttackInfo(info))
+				}
+
+				signature := *cookie
+				signature.Name += CookieSignatureSuffix
+				signature.Value = SignCookie(key, cookie.Name, cookie.Value)
+				signature.Raw = ""
+				signature.Unparsed = nil
+				if v := signature.String(); v != "" {
+					h.Add("Set-Cookie", v)
+				}
+			}
+			return nil
+		})
+		return nil, nil
+	}
+}
+
+func newCookieVerificationPrologCallback(r RuleContext, cookies map[string]struct{}, monitorOnlyUntil time.Time) CookieVerificationPrologCallbackType {
+	return func(p **http_protection.ProtectionContext) (epilog CookieVerificationEpilogCallbackType, prologErr error) {
+		r.Pre(func(c CallbackContext) error {
+			// The cookies set before the signing was deployed have no signature
+			// yet during the rollout period.
+			blockable := !time.Now().Before(monitorOnlyUntil)
+			ctx := *p
+			key, err := cookieSigningKey(ctx)
+			if err != nil {
+				return err
+			}
+
+			headers := ctx.RequestReader.Headers()
+			if len(headers) == 0 {
+				return nil
+			}
+			req := &http.Request{Header: headers}
+			for _, cookie := range req.Cookies() {
+				if _, protected := cookies[cookie.Name]; !protected {
+					continue
+				}
+
+				var reason string
+				if signature, err := req.Cookie(cookie.Name + CookieSignatureSuffix); err != nil {
+					reason = "missing signature"
+				} else if !VerifyCookie(key, cookie.Name, cookie.Value, signature.Value) {
+					reason = "invalid signature"
+				} else {
+					continue
+				}
+
+				info := CookieTamperingAttackInfo{
+					Cookie: cookie.Name,
+					Reason: reason,
+				}
+				if blocked := c.HandleAttack(blockable, event.WithAttackInfo(info)); !blocked {
+					continue
+				}
+
+				name := cookie.Name
+				epilog = func(e *error) {
+					sqassert.NotNil(e)
+					err := sdk_types.SqreenError{
+						Err: CookieTamperingError{
+							Cookie: name,
+							Reason: reason,
+						},
+					}
+					// Display the error message explaining why the request is denied.
+					c.Logger().Debug(err.Error())
+					*e = err
+				}
+				return nil
+			}
+			return nil
+		})
+		return
+	}
+}
+
+// reportedCookieSet is the set of cookie names already reported as missing
+// security attributes.
+type reportedCookieSet struct {
+	mu    sync.Mutex
+	names map[string]struct{}
+}
+
+// add adds the cookie name to the set and returns true when it was not
+// already in it.
+func (s *reportedCookieSet) add(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.names[name]; exists {
+		return false
+	}
+	if s.names == nil {
+		s.names = make(map[string]struct{})
+	}
+	s.names[name] = struct{}{}
+	return true
+}
+
+func cookieSigningKey(p *http_protection.ProtectionContext) ([]byte, error) {
+	key := p.Config().CookieSigningKey()
+	if key == "" {
+		type errKey struct{}
+		return nil, sqerrors.WithKey(sqerrors.New("cookie protection: the cookie signing key is not configured"), errKey{})
+	}
+	return []byte(key), nil
+}
+
+// SignCookie returns the signature of the cookie `name` having the value
+// `value` using the HMAC-SHA256 secret key `key`. The cookie name is part of
+// the signed message so that a signature cannot be reused for another cookie.
+func SignCookie(key []byte, name, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{'='})
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyCookie returns true when `signature` is the valid signature of the
+// cookie `name` having the value `value`.
+func VerifyCookie(key []byte, name, value, signature string) bool {
+	expected := SignCookie(key, name, value)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+func missingCookieAttributes(c *http.Cookie) (missing []string) {
+	if !c.Secure {
+		missing = append(missing, "Secure")
+	}
+	if !c.HttpOnly {
+		missing = append(missing, "HttpOnly")
+	}
+	if c.SameSite == 0 {
+		missing = append(missing, "SameSite")
+	}
+	return missing
+}
@@ -0,0 +1,323 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/sqreen/go-agent/internal/plog"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	http_protection_mockups "github.com/sqreen/go-agent/internal/protection/http/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	middleware_mockups "github.com/sqreen/go-agent/sdk/middleware/_testlib/mockups"
+	"github.com/sqreen/go-agent/sdk/types"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestCookieProtectionCallbacks(t *testing.T) {
+	t.Run("Configuration errors", func(t *testing.T) {
+		for _, tc := range []interface{}{
+			nil,
+			33,
+			&api.CookieProtectionRuleDataEntry{},
+			&api.CookieProtectionRuleDataEntry{Cookies: []string{""}},
+		} {
+			tc := tc
+			t.Run("", func(t *testing.T) {
+				cfg := &mockups.NativeCallbackConfigMockup{}
+				cfg.ExpectData().Return(tc)
+				defer cfg.AssertExpectations(t)
+
+				_, err := callback.NewCookieSigningCallback(&mockups.NativeRuleContextMockup{}, cfg)
+				require.Error(t, err)
+				_, err = callback.NewCookieVerificationCallback(&mockups.NativeRuleContextMockup{}, cfg)
+				require.Error(t, err)
+			})
+		}
+	})
+
+	const key = "my secret key"
+
+	newProtectionContext := func(t *testing.T, key string, requestHeaders http.Header) *http_protection.ProtectionContext {
+		cfg := &middleware_mockups.HTTPProtectionConfigMockup{}
+		cfg.ExpectCookieSigningKey().Return(key)
+		rootCtx := &middleware_mockups.RootHTTPProtectionContextMockup{}
+		rootCtx.ExpectConfig().Return(cfg)
+		rootCtx.ExpectCancelContext().Maybe()
+		requestReader := &http_protection_mockups.RequestReaderMockup{}
+		requestReader.ExpectHeaders().Return(requestHeaders).Maybe()
+		responseWriter := &http_protection_mockups.ResponseWriterMockup{}
+		return http_protection.NewTestProtectionContext(rootCtx, net.IPv4(1, 2, 3, 4), responseWriter, requestReader)
+	}
+
+	newCallbackConfig := func() *mockups.NativeCallbackConfigMockup {
+		cfg := &mockups.NativeCallbackConfigMockup{}
+		cfg.ExpectData().Return(&api.CookieProtectionRuleDataEntry{Cookies: []string{"session"}})
+		return cfg
+	}
+
+	t.Run("Signing", func(t *testing.T) {
+		newPrologCallback := func(t *testing.T) (*mockups.NativeRuleContextMockup, callback.CookieSigningPrologCallbackType) {
+			r := &mockups.NativeRuleContextMockup{}
+			cb, err := callback.NewCookieSigningCallback(r, newCallbackConfig())
+			require.NoError(t, err)
+			prolog, ok := cb.(callback.CookieSigningPrologCallbackType)
+			require.True(t, ok)
+			return r, prolog
+		}
+
+		t.Run("secure cookie", func(t *testing.T) {
+			r, prolog := newPrologCallback(t)
+			defer r.AssertExpectations(t)
+			p := newProtectionContext(t, key, nil)
+			headers := http.Header{}
+			http.SetCookie(headerWriter(headers), &http.Cookie{Name: "session", Value: "user-1", Path: "/", Secure: true, HttpOnly: true, SameSite: http.SameSiteStrictMode})
+			http.SetCookie(headerWriter(headers), &http.Cookie{Name: "other", Value: "value"})
+
+			r.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+				c := &mockups.CallbackContextMockup{}
+				defer c.AssertExpectations(t)
+				require.NoError(t, cb(c))
+				return true
+			})).Once()
+
+			epilog, err := prolog(&p, &headers)
+			require.NoError(t, err)
+			require.Nil(t, epilog)
+
+			cookies := (&http.Response{Header: headers}).Cookies()
+			require.Len(t, cookies, 3)
+			signature := cookies[2]
+			require.Equal(t, "session"+callback.CookieSignatureSuffix, signature.Name)
+			require.True(t, callback.VerifyCookie([]byte(key), "session", "user-1", signature.Value))
+			require.Equal(t, "/", signature.Path)
+			require.True(t, signature.Secure)
+			require.True(t, signature.HttpOnly)
+			require.Equal(t, http.SameSiteStrictMode, signature.SameSite)
+		})
+
+		t.Run("insecure cookie", func(t *testing.T) {
+			r, prolog := newPrologCallback(t)
+			defer r.AssertExpectations(t)
+			p := newProtectionContext(t, key, nil)
+
+			var calls int
+			r.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+				calls++
+				c := &mockups.CallbackContextMockup{}
+				defer c.AssertExpectations(t)
+				if calls == 1 {
+					// The misconfigured cookie is only reported once
+					c.ExpectHandleAttack(false, mock.Anything).Return(false).Once()
+				}
+				require.NoError(t, cb(c))
+				return true
+			})).Twice()
+
+			for _, value := range []string{"user-1", "user-2"} {
+				headers := http.Header{}
+				http.SetCookie(headerWriter(headers), &http.Cookie{Name: "session", Value: value, HttpOnly: true})
+				_, err := prolog(&p, &headers)
+				require.NoError(t, err)
+				// But it is still signed
+				require.Len(t, headers["Set-Cookie"], 2)
+			}
+		})
+
+		t.Run("missing signing key", func(t *testing.T) {
+			r, prolog := newPrologCallback(t)
+			defer r.AssertExpectations(t)
+			p := newProtectionContext(t, "", nil)
+			headers := http.Header{}
+			http.SetCookie(headerWriter(headers), &http.Cookie{Name: "session", Value: "user-1"})
+
+			r.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+				c := &mockups.CallbackContextMockup{}
+				defer c.AssertExpectations(t)
+				require.Error(t, cb(c))
+				return true
+			})).Once()
+
+			_, err := prolog(&p, &headers)
+			require.NoError(t, err)
+			require.Len(t, headers["Set-Cookie"], 1)
+		})
+	})
+
+	t.Run("Verification", func(t *testing.T) {
+		newPrologCallback := func(t *testing.T) (*mockups.NativeRuleContextMockup, callback.CookieVerificationPrologCallbackType) {
+			r := &mockups.NativeRuleContextMockup{}
+			cb, err := callback.NewCookieVerificationCallback(r, newCallbackConfig())
+			require.NoError(t, err)
+			prolog, ok := cb.(callback.CookieVerificationPrologCallbackType)
+			require.True(t, ok)
+			return r, prolog
+		}
+
+		signature := callback.SignCookie([]byte(key), "session", "user-1")
+
+		t.Run("valid signature", func(t *testing.T) {
+			r, prolog := newPrologCallback(t)
+			defer r.AssertExpectations(t)
+			p := newProtectionContext(t, key, http.Header{
+				"Cookie": []string{"other=value; session=user-1; session" + callback.CookieSignatureSuffix + "=" + signature},
+			})
+
+			r.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+				c := &mockups.CallbackContextMockup{}
+				defer c.AssertExpectations(t)
+				require.NoError(t, cb(c))
+				return true
+			})).Once()
+
+			epilog, err := prolog(&p)
+			require.NoError(t, err)
+			require.Nil(t, epilog)
+		})
+
+		for _, tc := range []struct {
+			name    string
+			cookies string
+		}{
+			{name: "forged cookie", cookies: "session=user-1"},
+			{name: "tampered cookie", cookies: "session=admin; session" + callback.CookieSignatureSuffix + "=" + signature},
+			{name: "signature of another cookie", cookies: "session=user-1; session" + callback.CookieSignatureSuffix + "=" + callback.SignCookie([]byte(key), "other", "user-1")},
+		} {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				t.Run("monitoring", func(t *testing.T) {
+					r, prolog := newPrologCallback(t)
+					defer r.AssertExpectations(t)
+					p := newProtectionContext(t, key, http.Header{"Cookie": []string{tc.cookies}})
+
+					r.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+						c := &mockups.CallbackContextMockup{}
+						defer c.AssertExpectations(t)
+						c.ExpectHandleAttack(true, mock.Anything).Return(false).Once()
+						require.NoError(t, cb(c))
+						return true
+					})).Once()
+
+					epilog, err := prolog(&p)
+					require.NoError(t, err)
+					require.Nil(t, epilog)
+				})
+
+				t.Run("blocking", func(t *testing.T) {
+					r, prolog := newPrologCallback(t)
+					defer r.AssertExpectations(t)
+					p := newProtectionContext(t, key, http.Header{"Cookie": []string{tc.cookies}})
+
+					r.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+						c := &mockups.CallbackContextMockup{}
+						defer c.AssertExpectations(t)
+						c.ExpectHandleAttack(true, mock.Anything).Return(true).Once()
+						c.On("Logger").Return(plog.NewLogger(plog.Disabled, nil, nil)).Maybe()
+						require.NoError(t, cb(c))
+						return true
+					})).Once()
+
+					epilog, err := prolog(&p)
+					require.NoError(t, err)
+					require.NotNil(t, epilog)
+
+					var blockErr error
+					epilog(&blockErr)
+					var sqErr types.SqreenError
+					require.True(t, xerrors.As(blockErr, &sqErr))
+					var tamperingErr callback.CookieTamperingError
+					require.True(t, errors.As(sqErr.Err, &tamperingErr))
+					require.Equal(t, "session", tamperingErr.Cookie)
+				})
+			})
+		}
+
+		t.Run("rollout period", func(t *testing.T) {
+			newPrologCallback := func(t *testing.T, monitorOnlyUntil time.Time) (*mockups.NativeRuleContextMockup, callback.CookieVerificationPrologCallbackType) {
+				r := &mockups.NativeRuleContextMockup{}
+				cfg := &mockups.NativeCallbackConfigMockup{}
+				cfg.ExpectData().Return(&api.CookieProtectionRuleDataEntry{
+					Cookies:          []string{"session"},
+					MonitorOnlyUntil: monitorOnlyUntil,
+				})
+				cb, err := callback.NewCookieVerificationCallback(r, cfg)
+				require.NoError(t, err)
+				prolog, ok := cb.(callback.CookieVerificationPrologCallbackType)
+				require.True(t, ok)
+				return r, prolog
+			}
+
+			t.Run("ongoing", func(t *testing.T) {
+				r, prolog := newPrologCallback(t, time.Now().Add(time.Hour))
+				defer r.AssertExpectations(t)
+				p := newProtectionContext(t, key, http.Header{"Cookie": []string{"session=user-1"}})
+
+				r.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+					c := &mockups.CallbackContextMockup{}
+					defer c.AssertExpectations(t)
+					// The attack is reported but cannot be blocked
+					c.ExpectHandleAttack(false, mock.Anything).Return(false).Once()
+					require.NoError(t, cb(c))
+					return true
+				})).Once()
+
+				epilog, err := prolog(&p)
+				require.NoError(t, err)
+				require.Nil(t, epilog)
+			})
+
+			t.Run("over", func(t *testing.T) {
+				r, prolog := newPrologCallback(t, time.Now().Add(-time.Hour))
+				defer r.AssertExpectations(t)
+				p := newProtectionContext(t, key, http.Header{"Cookie": []string{"session=user-1"}})
+
+				r.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+					c := &mockups.CallbackContextMockup{}
+					defer c.AssertExpectations(t)
+					c.ExpectHandleAttack(true, mock.Anything).Return(true).Once()
+					c.On("Logger").Return(plog.NewLogger(plog.Disabled, nil, nil)).Maybe()
+					require.NoError(t, cb(c))
+					return true
+				})).Once()
+
+				epilog, err := prolog(&p)
+				require.NoError(t, err)
+				require.NotNil(t, epilog)
+			})
+		})
+
+		t.Run("unprotected cookies", func(t *testing.T) {
+			r, prolog := newPrologCallback(t)
+			defer r.AssertExpectations(t)
+			p := newProtectionContext(t, key, http.Header{"Cookie": []string{"other=value"}})
+
+			r.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+				c := &mockups.CallbackContextMockup{}
+				defer c.AssertExpectations(t)
+				require.NoError(t, cb(c))
+				return true
+			})).Once()
+
+			epilog, err := prolog(&p)
+			require.NoError(t, err)
+			require.Nil(t, epilog)
+		})
+	})
+}
+
+// headerWriter is a response writer only allowing to set headers.
+type headerWriter http.Header
+
+func (w headerWriter) Header() http.Header       { return http.Header(w) }
+func (headerWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (headerWriter) WriteHeader(int)             {}
@@ -0,0 +1,264 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	sdk_types "github.com/sqreen/go-agent/sdk/types"
+)
+
+// Default request header holding the double-submit CSRF token.
+const defaultCSRFDoubleSubmitHeader = "X-CSRF-Token"
+
+// NewCSRFProtectionCallback returns the native prolog callback to be attached
+// to the HTTP protection hookpoint `csrfProtection()` called by `Before()`.
+// The `Origin` header, or the `Referer` header when missing, of the
+// state-changing requests (POST, PUT, PATCH and DELETE) must match the request
+// host or one of the allowed hosts. When a double-submit cookie is configured,
+// its value must also be equal to the value of the double-submit header.
+// Requests to the bearer authentication paths having an `Authorization: Bearer`
+// header are not checked since they cannot be forged by a browser. Violations
+// are reported as attacks and blocked according to the rule blocking mode.
+func NewCSRFProtectionCallback(r RuleContext, cfg NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	sqassert.NotNil(cfg)
+
+	data, ok := cfg.Data().(*api.CSRFProtectionRuleDataEntry)
+	if !ok {
+		return nil, sqerrors.Errorf("unexpected callback data type: got `%T` instead of `%T`", cfg.Data(), data)
+	}
+
+	allowedHosts, err := newAllowedHosts(data.AllowedHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range data.BearerAuthPaths {
+		if path == "" {
+			return nil, sqerrors.New("unexpected empty bearer authentication path")
+		}
+	}
+
+	bearerAuthPaths := make([]string, len(data.BearerAuthPaths))
+	for i, p := range data.BearerAuthPaths {
+		bearerAuthPaths[i] = path.Clean(p)
+	}
+
+	header := data.DoubleSubmitHeader
+	if header == "" {
+		header = defaultCSRFDoubleSubmitHeader
+	}
+
+	return newCSRFProtectionPrologCallback(r, &csrfProtection{
+		allowedHosts:       allowedHosts,
+		doubleSubmitCookie: data.DoubleSubmitCookie,
+		doubleSubmitHeader: header,
+		bearerAuthPaths:    bearerAuthPaths,
+	}), nil
+}
+
+type CSRFProtectionPrologCallbackType = http_protection.BlockingPrologCallbackType
+type CSRFProtectionEpilogCallbackType = http_protection.BlockingEpilogCallbackType
+
+type CSRFAttackInfo struct {
+	Reason string `json:"reason"`
+	Origin string `json:"origin,omitempty"`
+}
+
+type CSRFError struct {
+	Reason string
+}
+
+func (e CSRFError) Error() string {
+	return fmt.Sprintf("cross-site request forgery: %s", e.Reason)
+}
+
+func newCSRFProtectionPrologCallback(r RuleContext, csrf *csrfProtection) CSRFProtectionPrologCallbackType {
+	return func(p **http_protection.ProtectionContext) (epilog CSRFProtectionEpilogCallbackType, prologErr error) {
+		r.Pre(func(c CallbackContext) error {
+			info, violated := csrf.check((*p).RequestReader)
+			if !violated {
+				return nil
+			}
+
+			if blocked := c.HandleAttack(true, event.WithAttackInfo(info)); !blocked {
+				return nil
+			}
+
+			epilog = func(e *error) {
+				sqassert.NotNil(e)
+				err := sdk_types.SqreenError{
+					Err: CSRFError{Reason: info.Reason},
+				}
+				// Display the error message explaining why the request is denied.
+				c.Logger().Debug(err.Error())
+				*e = err
+			}
+			return nil
+		})
+		return
+	}
+}
+
+type csrfProtection struct {
+	allowedHosts       allowedHosts
+	doubleSubmitCookie string
+	doubleSubmitHeader string
+	bearerAuthPaths    []string
+}
+
+func (csrf *csrfProtection) check(r types.RequestReader) (info CSRFAttackInfo, violated bool) {
+	switch r.Method() {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return info, false
+	}
+
+	if csrf.isBearerAuthRequest(r) {
+		return info, false
+	}
+
+	if origin, found := requestOrigin(r); found {
+		host, err := originHost(origin)
+		if err != nil {
+			return CSRFAttackInfo{Reason: "invalid origin", Origin: origin}, true
+		}
+		if !csrf.isAllowedHost(host, r.Host()) {
+			return CSRFAttackInfo{Reason: "unexpected origin", Origin: origin}, true
+		}
+	}
+
+	if csrf.doubleSubmitCookie != "" {
+		if reason, ok := csrf.checkDoubleSubmitToken(r); !ok {
+			return CSRFAttackInfo{Reason: reason}, true
+		}
+	}
+
+	return info, false
+}
+
+func (csrf *csrfProtection) isBearerAuthRequest(r types.RequestReader) bool {
+	if len(csrf.bearerAuthPaths) == 0 {
+		return false
+	}
+	authorization := r.Header("Authorization")
+	if authorization == nil || !strings.HasPrefix(strings.ToLower(*authorization), "bearer ") {
+		return false
+	}
+	p := path.Clean(r.URL().Path)
+	for _, prefix := range csrf.bearerAuthPaths {
+		if isSubpath(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSubpath returns true when the cleaned path `p` is `dir` or one of its
+// subpaths, so that `/api` matches `/api/users` but not `/apiary`.
+func isSubpath(p, dir string) bool {
+	if dir == "/" || p == dir {
+		return true
+	}
+	return strings.HasPrefix(p, dir+"/")
+}
+
+func (csrf *csrfProtection) isAllowedHost(host, requestHost string) bool {
+	return csrf.allowedHosts.isAllowed(host, requestHost)
+}
+
+// allowedHosts is a set of lowercase hosts, with or without port.
+type allowedHosts map[string]struct{}
+
+func newAllowedHosts(hosts []string) (allowedHosts, error) {
+	allowed := make(allowedHosts, len(hosts))
+	for _, host := range hosts {
+		if host == "" {
+			return nil, sqerrors.New("unexpected empty allowed host")
+		}
+		allowed[strings.ToLower(host)] = struct{}{}
+	}
+	return allowed, nil
+}
+
+// isAllowed returns true when the host is the request host or one of the
+// allowed hosts.
+func (a allowedHosts) isAllowed(host, requestHost string) bool {
+	host = strings.ToLower(host)
+	if host == strings.ToLower(requestHost) {
+		return true
+	}
+	_, allowed := a[host]
+	if allowed {
+		return true
+	}
+	// Allow the configuration of hostnames without port
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		_, allowed = a[hostname]
+	}
+	return allowed
+}
+
+func (csrf *csrfProtection) checkDoubleSubmitToken(r types.RequestReader) (reason string, ok bool) {
+	headers := r.Headers()
+	if len(headers) == 0 {
+		return "missing csrf cookie", false
+	}
+	cookie, err := (&http.Request{Header: headers}).Cookie(csrf.doubleSubmitCookie)
+	if err != nil || cookie.Value == "" {
+		return "missing csrf cookie", false
+	}
+	token := r.Header(csrf.doubleSubmitHeader)
+	if token == nil || *token == "" {
+		return "missing csrf token", false
+	}
+	if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(*token)) != 1 {
+		return "invalid csrf token", false
+	}
+	return "", true
+}
+
+// requestOrigin returns the origin of the request taken from the `Origin`
+// header, or the `Referer` header when missing. Requests without any of them
+// are not from browsers and are considered as same-origin.
+func requestOrigin(r types.RequestReader) (origin string, found bool) {
+	if v := r.Header("Origin"); v != nil && *v != "" {
+		return *v, true
+	}
+	if v := r.Header("Referer"); v != nil && *v != "" {
+		return *v, true
+	}
+	return "", false
+}
+
+func originHost(origin string) (string, error) {
+	if origin == "null" {
+		return "", sqerrors.New("opaque origin")
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", sqerrors.New("unexpected empty origin host")
+	}
+	return u.Host, nil
+}
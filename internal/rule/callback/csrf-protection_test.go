@@ -0,0 +1,207 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/sqreen/go-agent/internal/plog"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	http_protection_mockups "github.com/sqreen/go-agent/internal/protection/http/_testlib/mockups"
+	http_protection_types "github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	"github.com/sqreen/go-agent/sdk/types"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestCSRFProtectionCallback(t *testing.T) {
+	t.Run("Configuration errors", func(t *testing.T) {
+		for _, tc := range []interface{}{
+			nil,
+			33,
+			&api.CSRFProtectionRuleDataEntry{AllowedHosts: []string{""}},
+			&api.CSRFProtectionRuleDataEntry{BearerAuthPaths: []string{""}},
+		} {
+			tc := tc
+			t.Run("", func(t *testing.T) {
+				cfg := &mockups.NativeCallbackConfigMockup{}
+				cfg.ExpectData().Return(tc)
+				defer cfg.AssertExpectations(t)
+
+				_, err := callback.NewCSRFProtectionCallback(&mockups.NativeRuleContextMockup{}, cfg)
+				require.Error(t, err)
+			})
+		}
+	})
+
+	data := &api.CSRFProtectionRuleDataEntry{
+		AllowedHosts:       []string{"allowed.com", "other.allowed.com:8080"},
+		DoubleSubmitCookie: "csrf",
+		BearerAuthPaths:    []string{"/api/"},
+	}
+
+	newRequest := func(method, target string, headers map[string]string) *http.Request {
+		req := httptest.NewRequest(method, target, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return req
+	}
+
+	for _, tc := range []struct {
+		name      string
+		req       *http.Request
+		violation string
+	}{
+		{
+			name: "safe method",
+			req:  newRequest("GET", "http://my.site/", map[string]string{"Origin": "http://evil.com"}),
+		},
+		{
+			name: "same origin",
+			req:  newRequest("POST", "http://my.site/", map[string]string{"Origin": "http://my.site", "Cookie": "csrf=token", "X-CSRF-Token": "token"}),
+		},
+		{
+			name: "allowed origin",
+			req:  newRequest("PUT", "http://my.site/", map[string]string{"Origin": "https://allowed.com:8443", "Cookie": "csrf=token", "X-CSRF-Token": "token"}),
+		},
+		{
+			name: "allowed referer",
+			req:  newRequest("DELETE", "http://my.site/", map[string]string{"Referer": "http://other.allowed.com:8080/some/page", "Cookie": "csrf=token", "X-CSRF-Token": "token"}),
+		},
+		{
+			name: "bearer authentication",
+			req:  newRequest("POST", "http://my.site/api/users", map[string]string{"Origin": "http://evil.com", "Authorization": "Bearer token"}),
+		},
+		{
+			name:      "unexpected origin",
+			req:       newRequest("POST", "http://my.site/", map[string]string{"Origin": "http://evil.com", "Cookie": "csrf=token", "X-CSRF-Token": "token"}),
+			violation: "unexpected origin",
+		},
+		{
+			name:      "unexpected referer",
+			req:       newRequest("PATCH", "http://my.site/", map[string]string{"Referer": "http://other.allowed.com/", "Cookie": "csrf=token", "X-CSRF-Token": "token"}),
+			violation: "unexpected origin",
+		},
+		{
+			name:      "opaque origin",
+			req:       newRequest("POST", "http://my.site/", map[string]string{"Origin": "null"}),
+			violation: "invalid origin",
+		},
+		{
+			name:      "bearer authentication outside of the api paths",
+			req:       newRequest("POST", "http://my.site/users", map[string]string{"Origin": "http://evil.com", "Authorization": "Bearer token"}),
+			violation: "unexpected origin",
+		},
+		{
+			name:      "bearer authentication on a path sharing the api path prefix",
+			req:       newRequest("POST", "http://my.site/apiary", map[string]string{"Origin": "http://evil.com", "Authorization": "Bearer token"}),
+			violation: "unexpected origin",
+		},
+		{
+			name:      "bearer authentication on an uncleaned path leaving the api paths",
+			req:       newRequest("POST", "http://my.site/api/../users", map[string]string{"Origin": "http://evil.com", "Authorization": "Bearer token"}),
+			violation: "unexpected origin",
+		},
+		{
+			name: "bearer authentication on the api path itself",
+			req:  newRequest("POST", "http://my.site/api", map[string]string{"Origin": "http://evil.com", "Authorization": "Bearer token"}),
+		},
+		{
+			name:      "missing csrf cookie",
+			req:       newRequest("POST", "http://my.site/", map[string]string{"X-CSRF-Token": "token"}),
+			violation: "missing csrf cookie",
+		},
+		{
+			name:      "missing csrf token",
+			req:       newRequest("POST", "http://my.site/", map[string]string{"Cookie": "csrf=token"}),
+			violation: "missing csrf token",
+		},
+		{
+			name:      "invalid csrf token",
+			req:       newRequest("POST", "http://my.site/", map[string]string{"Cookie": "csrf=token", "X-CSRF-Token": "forged"}),
+			violation: "invalid csrf token",
+		},
+	} {
+		tc := tc
+		for _, blocking := range []bool{false, true} {
+			blocking := blocking
+			t.Run(tc.name, func(t *testing.T) {
+				cfg := &mockups.NativeCallbackConfigMockup{}
+				cfg.ExpectData().Return(data)
+				defer cfg.AssertExpectations(t)
+
+				r := &mockups.NativeRuleContextMockup{}
+				defer r.AssertExpectations(t)
+
+				cb, err := callback.NewCSRFProtectionCallback(r, cfg)
+				require.NoError(t, err)
+				prolog, ok := cb.(callback.CSRFProtectionPrologCallbackType)
+				require.True(t, ok)
+
+				p := http_protection.NewTestProtectionContext(nil, net.IPv4(1, 2, 3, 4), nil, &requestReader{req: tc.req})
+
+				r.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+					c := &mockups.CallbackContextMockup{}
+					defer c.AssertExpectations(t)
+					if tc.violation != "" {
+						c.ExpectHandleAttack(true, mock.Anything).Return(blocking).Once()
+						c.On("Logger").Return(plog.NewLogger(plog.Disabled, nil, nil)).Maybe()
+					}
+					require.NoError(t, cb(c))
+					return true
+				})).Once()
+
+				epilog, err := prolog(&p)
+				require.NoError(t, err)
+				if tc.violation == "" || !blocking {
+					require.Nil(t, epilog)
+					return
+				}
+
+				require.NotNil(t, epilog)
+				var blockErr error
+				epilog(&blockErr)
+				var sqErr types.SqreenError
+				require.True(t, xerrors.As(blockErr, &sqErr))
+				var csrfErr callback.CSRFError
+				require.True(t, xerrors.As(sqErr.Err, &csrfErr))
+				require.Equal(t, tc.violation, csrfErr.Reason)
+			})
+		}
+	}
+}
+
+// requestReader is a request reader mockup reading the given HTTP request.
+type requestReader struct {
+	http_protection_mockups.RequestReaderMockup
+	req *http.Request
+}
+
+func (r *requestReader) Header(header string) *string {
+	v := r.req.Header.Get(header)
+	if v == "" {
+		return nil
+	}
+	return &v
+}
+
+func (r *requestReader) Headers() http.Header  { return r.req.Header }
+func (r *requestReader) Method() string        { return r.req.Method }
+func (r *requestReader) Host() string          { return r.req.Host }
+func (r *requestReader) URL() *url.URL         { return r.req.URL }
+func (r *requestReader) QueryForm() url.Values { return r.req.URL.Query() }
+func (r *requestReader) PostForm() url.Values  { return nil }
+func (r *requestReader) Params() http_protection_types.RequestParamMap {
+	return nil
+}
@@ -0,0 +1,302 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"fmt"
+	"mime"
+	"regexp"
+	"strings"
+
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	"github.com/sqreen/go-agent/internal/sqlib/sqsanitize"
+	sdk_types "github.com/sqreen/go-agent/sdk/types"
+)
+
+// Data leak categories.
+const (
+	DataLeakStackTrace = "stack_trace"
+	DataLeakSQLError   = "sql_error"
+	DataLeakCardNumber = "card_number"
+	DataLeakSecret     = "secret"
+)
+
+var (
+	dataLeakStackTraceRegexp = regexp.MustCompile(
+		// Go
+		`goroutine \d+ \[[^\]]+\]:|(?m:^panic: )` +
+			// Java
+			`|(?m:^\s+at [\w$.]+\([\w$]+\.java:\d+\))` +
+			// Python
+			`|Traceback \(most recent call last\):`)
+	dataLeakSQLErrorRegexp = regexp.MustCompile(
+		// MySQL
+		`You have an error in your SQL syntax` +
+			// Oracle
+			`|\bORA-\d{5}\b` +
+			// PostgreSQL
+			`|\bpq: ` +
+			// SQLite
+			`|\bsqlite3: ` +
+			// ANSI SQL
+			`|\bSQLSTATE\b`)
+	// Card number candidates of 13 to 19 digits, possibly separated by spaces
+	// or dashes, further checked by hasCardNumber().
+	dataLeakCardNumberRegexp = regexp.MustCompile(`\d(?:[ -]?\d){12,18}`)
+	dataLeakSecretRegexp     = newDataLeakSecretRegexp(sqsanitize.SensitiveKeyPattern)
+	// Response bodies commonly and legitimately include tokens, such as OAuth
+	// access tokens or CSRF tokens, so that they are not checked for them.
+	dataLeakBodySecretRegexp = newDataLeakSecretRegexp(`passw(?:or)?d|passphrase|secret|authorization|api_?key`)
+)
+
+func newDataLeakSecretRegexp(keyPattern string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)(?:` + keyPattern + `)["']?\s*[:=]\s*["']?[^\s"'&,;]{8,}`)
+}
+
+// cardIssuerRange is a range of card issuer identification numbers (IIN)
+// along with the possible card number lengths.
+type cardIssuerRange struct {
+	// first and last are the IIN prefixes of the range, having the same length.
+	first, last string
+	// minLength and maxLength are the card number lengths of the range.
+	minLength, maxLength int
+}
+
+var cardIssuerRanges = []cardIssuerRange{
+	// Visa
+	{first: "4", last: "4", minLength: 13, maxLength: 19},
+	// Mastercard
+	{first: "51", last: "55", minLength: 16, maxLength: 16},
+	{first: "2221", last: "2720", minLength: 16, maxLength: 16},
+	// American Express
+	{first: "34", last: "34", minLength: 15, maxLength: 15},
+	{first: "37", last: "37", minLength: 15, maxLength: 15},
+	// Diners Club
+	{first: "300", last: "305", minLength: 14, maxLength: 19},
+	{first: "36", last: "36", minLength: 14, maxLength: 19},
+	{first: "38", last: "39", minLength: 16, maxLength: 19},
+	// Discover
+	{first: "6011", last: "6011", minLength: 16, maxLength: 19},
+	{first: "644", last: "649", minLength: 16, maxLength: 19},
+	{first: "65", last: "65", minLength: 16, maxLength: 19},
+	// JCB
+	{first: "3528", last: "3589", minLength: 16, maxLength: 19},
+	// UnionPay
+	{first: "62", last: "62", minLength: 16, maxLength: 19},
+}
+
+// NewDataLeakCallback returns the native prolog callback to be attached to the
+// HTTP protection hookpoint `responseWAF()` called by `ResponseWAF()` before
+// writing the response. The response headers and body prefix are checked for
+// stack traces, SQL errors, credit card numbers and secrets leaking to the
+// client. Leaks are reported as attacks without the leaked values, and
+// blocked according to the rule blocking mode by replacing the response with
+// the blocking response. Tokens are only looked for in the response headers
+// other than `Set-Cookie`, as response bodies legitimately include them.
+func NewDataLeakCallback(r RuleContext, cfg NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	sqassert.NotNil(cfg)
+	return newDataLeakPrologCallback(r), nil
+}
+
+type DataLeakPrologCallbackType = http_protection.ResponseWAFPrologCallbackType
+type DataLeakEpilogCallbackType = http_protection.ResponseWAFEpilogCallbackType
+
+// DataLeakAttackInfo is the attack information of a data leak. It never
+// includes the leaked value.
+type DataLeakAttackInfo struct {
+	Category string `json:"category"`
+	// Location of the leak: either `header` or `body`.
+	Location string `json:"location"`
+	Header   string `json:"header,omitempty"`
+}
+
+type DataLeakError struct {
+	Category string
+}
+
+func (e DataLeakError) Error() string {
+	return fmt.Sprintf("data leak: %s", e.Category)
+}
+
+func newDataLeakPrologCallback(r RuleContext) DataLeakPrologCallbackType {
+	return func(p **http_protection.ProtectionContext) (epilog DataLeakEpilogCallbackType, prologErr error) {
+		r.Pre(func(c CallbackContext) error {
+			response := (*p).Response()
+			if response == nil {
+				return nil
+			}
+
+			info, leaked := checkDataLeak(response)
+			if !leaked {
+				return nil
+			}
+
+			if blocked := c.HandleAttack(true, event.WithAttackInfo(info)); !blocked {
+				return nil
+			}
+
+			epilog = func(e *error) {
+				sqassert.NotNil(e)
+				err := sdk_types.SqreenError{
+					Err: DataLeakError{Category: info.Category},
+				}
+				c.Logger().Debug(err.Error())
+				*e = err
+			}
+			return nil
+		})
+		return
+	}
+}
+
+func checkDataLeak(response *http_protection.ResponseBindingAccessorContext) (info DataLeakAttackInfo, leaked bool) {
+	for name, values := range response.Headers() {
+		secretRegexp := dataLeakSecretRegexp
+		if name == "Set-Cookie" {
+			// Cookies are expected to hold session tokens
+			secretRegexp = nil
+		}
+		for _, v := range values {
+			if category, found := findDataLeak(v, secretRegexp); found {
+				return DataLeakAttackInfo{Category: category, Location: "header", Header: name}, true
+			}
+		}
+	}
+
+	if body := response.Body(); len(body) > 0 && isTextualContentType(response.Header("Content-Type")) {
+		if category, found := findDataLeak(body.String(), dataLeakBodySecretRegexp); found {
+			return DataLeakAttackInfo{Category: category, Location: "body"}, true
+		}
+	}
+
+	return info, false
+}
+
+// findDataLeak returns the category of the first data leak found in s. Secrets
+// are not checked when secretRegexp is nil.
+func findDataLeak(s string, secretRegexp *regexp.Regexp) (category string, found bool) {
+	switch {
+	case dataLeakStackTraceRegexp.MatchString(s):
+		return DataLeakStackTrace, true
+	case dataLeakSQLErrorRegexp.MatchString(s):
+		return DataLeakSQLError, true
+	case hasCardNumber(s):
+		return DataLeakCardNumber, true
+	case secretRegexp != nil && secretRegexp.MatchString(s):
+		return DataLeakSecret, true
+	default:
+		return "", false
+	}
+}
+
+// hasCardNumber returns true when a number matching the card number regular
+// expression is not part of a longer number, uses the same separator between
+// its digit groups, starts with a known card issuer prefix having its length
+// and passes the Luhn checksum, in order to avoid reporting any long number
+// such as identifiers or timestamps.
+func hasCardNumber(s string) bool {
+	for _, loc := range dataLeakCardNumberRegexp.FindAllStringIndex(s, -1) {
+		start, end := loc[0], loc[1]
+		if (start > 0 && isDigit(s[start-1])) || (end < len(s) && isDigit(s[end])) {
+			continue
+		}
+		digits, ok := cardNumberDigits(s[start:end])
+		if ok && isCardIssuerNumber(digits) && luhn(digits) {
+			return true
+		}
+	}
+	return false
+}
+
+// cardNumberDigits returns the digits of the given card number candidate when
+// its digit groups are separated by a single and consistent separator, such as
+// `4111111111111111`, `4111 1111 1111 1111` or `3782-822463-10005`.
+func cardNumberDigits(s string) (digits string, ok bool) {
+	sep := strings.IndexAny(s, " -")
+	if sep == -1 {
+		return s, true
+	}
+	groups := strings.Split(s, s[sep:sep+1])
+	if len(groups) < 3 || len(groups[0]) != 4 {
+		return "", false
+	}
+	for _, g := range groups {
+		if len(g) < 3 || len(g) > 6 || strings.ContainsAny(g, " -") {
+			return "", false
+		}
+	}
+	return strings.Join(groups, ""), true
+}
+
+// isCardIssuerNumber returns true when the given digits start with a known card
+// issuer identification number and have one of its card number lengths.
+func isCardIssuerNumber(digits string) bool {
+	for _, r := range cardIssuerRanges {
+		if len(digits) < r.minLength || len(digits) > r.maxLength {
+			continue
+		}
+		// The prefixes of the range have the same length and can be compared as
+		// strings.
+		prefix := digits[:len(r.first)]
+		if prefix >= r.first && prefix <= r.last {
+			return true
+		}
+	}
+	return false
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// luhn returns true when the digits of the given string pass the Luhn
+// checksum. Non-digit characters are ignored.
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if !isDigit(c) {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
+
+// isTextualContentType returns true when the content type is textual, or
+// unknown.
+func isTextualContentType(contentType *string) bool {
+	if contentType == nil || *contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(*contentType)
+	if err != nil {
+		return true
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "json"),
+		strings.HasSuffix(mediaType, "xml"),
+		strings.HasSuffix(mediaType, "javascript"),
+		mediaType == "application/x-www-form-urlencoded":
+		return true
+	default:
+		return false
+	}
+}
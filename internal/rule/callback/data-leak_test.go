@@ -0,0 +1,175 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/event"
+	"github.com/sqreen/go-agent/internal/plog"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	middleware_mockups "github.com/sqreen/go-agent/sdk/middleware/_testlib/mockups"
+	"github.com/sqreen/go-agent/sdk/types"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestDataLeakCallback(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		headers  http.Header
+		body     string
+		expected *callback.DataLeakAttackInfo
+	}{
+		{
+			name: "no leak",
+			headers: http.Header{
+				"Content-Type": []string{"text/html"},
+				"Set-Cookie":   []string{"session_token=0123456789abcdef"},
+			},
+			body: "<html><body>Order 1234567890123456789 of 2020-01-02</body></html>",
+		},
+		{
+			name:     "go stack trace",
+			body:     "panic: runtime error: index out of range\n\ngoroutine 1 [running]:\nmain.main()",
+			expected: &callback.DataLeakAttackInfo{Category: callback.DataLeakStackTrace, Location: "body"},
+		},
+		{
+			name:     "java stack trace",
+			body:     "java.lang.NullPointerException\n\tat com.example.App.main(App.java:14)",
+			expected: &callback.DataLeakAttackInfo{Category: callback.DataLeakStackTrace, Location: "body"},
+		},
+		{
+			name:     "python stack trace",
+			body:     "Traceback (most recent call last):\n  File \"app.py\", line 1",
+			expected: &callback.DataLeakAttackInfo{Category: callback.DataLeakStackTrace, Location: "body"},
+		},
+		{
+			name:     "mysql error",
+			body:     "You have an error in your SQL syntax; check the manual",
+			expected: &callback.DataLeakAttackInfo{Category: callback.DataLeakSQLError, Location: "body"},
+		},
+		{
+			name:     "postgresql error",
+			headers:  http.Header{"X-Error": []string{`pq: syntax error at or near "'"`}},
+			expected: &callback.DataLeakAttackInfo{Category: callback.DataLeakSQLError, Location: "header", Header: "X-Error"},
+		},
+		{
+			name:     "card number",
+			body:     `{"card":"4111 1111 1111 1111"}`,
+			expected: &callback.DataLeakAttackInfo{Category: callback.DataLeakCardNumber, Location: "body"},
+		},
+		{
+			name: "not a card number",
+			body: `{"card":"4111 1111 1111 1112"}`,
+		},
+		{
+			name:     "13-digit card number",
+			body:     `{"card":"4222222222222"}`,
+			expected: &callback.DataLeakAttackInfo{Category: callback.DataLeakCardNumber, Location: "body"},
+		},
+		{
+			name:     "dashed american express card number",
+			body:     `{"card":"3782-822463-10005"}`,
+			expected: &callback.DataLeakAttackInfo{Category: callback.DataLeakCardNumber, Location: "body"},
+		},
+		{
+			name: "millisecond timestamp",
+			body: `{"created_at":1600000000006}`,
+		},
+		{
+			name: "number without card issuer prefix",
+			body: `{"id":"1234567812345670"}`,
+		},
+		{
+			name: "inconsistent card number separators",
+			body: `{"card":"4111 1111-1111 1111"}`,
+		},
+		{
+			name:     "secret",
+			body:     `{"api_key": "s3cr3t-v4lu3"}`,
+			expected: &callback.DataLeakAttackInfo{Category: callback.DataLeakSecret, Location: "body"},
+		},
+		{
+			name:    "oauth access token",
+			headers: http.Header{"Content-Type": []string{"application/json"}},
+			body:    `{"access_token":"2YotnFZFEjr1zCsicMWpAA","token_type":"Bearer","expires_in":3600}`,
+		},
+		{
+			name:    "csrf token",
+			headers: http.Header{"Content-Type": []string{"text/html"}},
+			body:    `<script>var token = "0123456789abcdef";</script>`,
+		},
+		{
+			name:     "token in a header",
+			headers:  http.Header{"X-Debug": []string{"access_token=2YotnFZFEjr1zCsicMWpAA"}},
+			expected: &callback.DataLeakAttackInfo{Category: callback.DataLeakSecret, Location: "header", Header: "X-Debug"},
+		},
+		{
+			name:    "binary body",
+			headers: http.Header{"Content-Type": []string{"image/png"}},
+			body:    "Traceback (most recent call last):",
+		},
+	} {
+		tc := tc
+		for _, blocking := range []bool{false, true} {
+			blocking := blocking
+			t.Run(tc.name, func(t *testing.T) {
+				r := &mockups.NativeRuleContextMockup{}
+				defer r.AssertExpectations(t)
+
+				cb, err := callback.NewDataLeakCallback(r, &mockups.NativeCallbackConfigMockup{})
+				require.NoError(t, err)
+				prolog, ok := cb.(callback.DataLeakPrologCallbackType)
+				require.True(t, ok)
+
+				root := &middleware_mockups.RootHTTPProtectionContextMockup{}
+				root.ExpectContext().Return(context.Background())
+				p := http_protection.NewTestProtectionContext(root, net.IPv4(1, 2, 3, 4), nil, &requestReader{})
+				require.NoError(t, p.ResponseWAF(http.StatusInternalServerError, tc.headers, []byte(tc.body)))
+
+				r.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+					c := &mockups.CallbackContextMockup{}
+					defer c.AssertExpectations(t)
+					if tc.expected != nil {
+						c.ExpectHandleAttack(true, mock.MatchedBy(func(opts []event.AttackEventOption) bool {
+							var attack event.AttackEvent
+							for _, opt := range opts {
+								opt(&attack)
+							}
+							require.Equal(t, *tc.expected, attack.Info)
+							return true
+						})).Return(blocking).Once()
+						c.On("Logger").Return(plog.NewLogger(plog.Disabled, nil, nil)).Maybe()
+					}
+					require.NoError(t, cb(c))
+					return true
+				})).Once()
+
+				epilog, err := prolog(&p)
+				require.NoError(t, err)
+				if tc.expected == nil || !blocking {
+					require.Nil(t, epilog)
+					return
+				}
+
+				require.NotNil(t, epilog)
+				var blockErr error
+				epilog(&blockErr)
+				var sqErr types.SqreenError
+				require.True(t, xerrors.As(blockErr, &sqErr))
+				var leakErr callback.DataLeakError
+				require.True(t, xerrors.As(sqErr.Err, &leakErr))
+				require.Equal(t, tc.expected.Category, leakErr.Category)
+			})
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/protection/taint"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	"golang.org/x/net/http/httpguts"
+)
+
+// Response header injection reasons.
+const (
+	HeaderInjectionInvalidName  = "invalid header name"
+	HeaderInjectionInvalidValue = "invalid header value"
+	HeaderInjectionTaintedName  = "header name from request parameters"
+	HeaderInjectionTaintedValue = "header value from request parameters"
+)
+
+// Default response headers whose values are protected against the injection
+// of attributes or list elements from request parameters.
+var defaultProtectedResponseHeaders = []string{
+	"Set-Cookie",
+	"Content-Security-Policy",
+	"Access-Control-Allow-Origin",
+	"Strict-Transport-Security",
+}
+
+// NewResponseHeaderInjectionCallback returns the native prolog callback to be
+// attached to the HTTP protection hookpoint `BeforeWriteHeader()`. Response
+// headers having control characters, such as CRLF, in their names or values
+// are reported as header injections. So are header names taken from request
+// parameters, and values of the protected headers including request
+// parameters having attribute or list separators. In blocking mode, the
+// injected headers are removed from the response instead of blocking it. The
+// rule data is optional.
+func NewResponseHeaderInjectionCallback(r RuleContext, cfg NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	sqassert.NotNil(cfg)
+
+	protectedHeaders := defaultProtectedResponseHeaders
+	switch data := cfg.Data().(type) {
+	case nil:
+	case *api.ResponseHeaderInjectionRuleDataEntry:
+		if len(data.ProtectedHeaders) > 0 {
+			protectedHeaders = data.ProtectedHeaders
+		}
+	default:
+		return nil, sqerrors.Errorf("unexpected callback data type: got `%T` instead of `%T`", data, (*api.ResponseHeaderInjectionRuleDataEntry)(nil))
+	}
+
+	protected := make(map[string]struct{}, len(protectedHeaders))
+	for _, h := range protectedHeaders {
+		if !httpguts.ValidHeaderFieldName(h) {
+			return nil, sqerrors.Errorf("unexpected invalid protected header `%s`", h)
+		}
+		protected[http.CanonicalHeaderKey(h)] = struct{}{}
+	}
+
+	return newResponseHeaderInjectionPrologCallback(r, cfg.BlockingMode(), protected), nil
+}
+
+type ResponseHeaderInjectionPrologCallbackType = http_protection.ResponseHeaderPrologCallbackType
+type ResponseHeaderInjectionEpilogCallbackType = http_protection.NonBlockingEpilogCallbackType
+
+type HeaderInjectionAttackInfo struct {
+	Reason string `json:"reason"`
+	Header string `json:"header"`
+	Source string `json:"source,omitempty"`
+}
+
+func newResponseHeaderInjectionPrologCallback(r RuleContext, blockingMode bool, protected map[string]struct{}) ResponseHeaderInjectionPrologCallbackType {
+	return func(p **http_protection.ProtectionContext, headers *http.Header) (ResponseHeaderInjectionEpilogCallbackType, error) {
+		r.Pre(func(c CallbackContext) error {
+			h := *headers
+			tracker := (*p).TaintTracker()
+
+			// Sort the header names so that attacks are reported in a stable order
+			names := make([]string, 0, len(h))
+			for name := range h {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				values := h[name]
+				// kept is only allocated once an injected value needs to be removed
+				var kept []string
+				injections := 0
+				for i, v := range values {
+					info, injected := checkResponseHeader(tracker, protected, name, v)
+					if !injected {
+						if injections > 0 {
+							kept = append(kept, v)
+						}
+						continue
+					}
+					c.HandleAttack(false, event.WithAttackInfo(info), event.WithBlocked(blockingMode))
+					if injections == 0 {
+						kept = append(kept, values[:i]...)
+					}
+					injections++
+				}
+
+				if !blockingMode || injections == 0 {
+					continue
+				}
+				c.Logger().Debugf("removing injected values of response header `%s`", name)
+				if len(kept) == 0 {
+					delete(h, name)
+				} else {
+					h[name] = kept
+				}
+			}
+			return nil
+		})
+		return nil, nil
+	}
+}
+
+func checkResponseHeader(tracker *taint.Tracker, protected map[string]struct{}, name, value string) (info HeaderInjectionAttackInfo, injected bool) {
+	if !httpguts.ValidHeaderFieldName(name) {
+		return HeaderInjectionAttackInfo{Reason: HeaderInjectionInvalidName, Header: name}, true
+	}
+	if !httpguts.ValidHeaderFieldValue(value) {
+		return HeaderInjectionAttackInfo{Reason: HeaderInjectionInvalidValue, Header: name}, true
+	}
+
+	// Header names are canonicalized by http.Header.Set() so that the lowercase
+	// name is also looked up. Only exact matches are considered to avoid
+	// reporting every header name including a short request parameter. The
+	// request parameter names are not considered either so that parameters
+	// named after the response headers, such as `location`, do not taint them.
+	isValue := func(src taint.Source) bool { return !src.Key }
+	for _, n := range []string{name, strings.ToLower(name)} {
+		if src, tainted := tracker.IsTaintedBy(n, isValue); tainted && strings.EqualFold(src.Value, n) {
+			return HeaderInjectionAttackInfo{Reason: HeaderInjectionTaintedName, Header: name, Source: src.Name}, true
+		}
+	}
+
+	if _, ok := protected[http.CanonicalHeaderKey(name)]; !ok {
+		return info, false
+	}
+	// Reflecting request parameters into header values is common and safe as
+	// long as they cannot add attributes or list elements to the header value.
+	hasSeparator := func(src taint.Source) bool { return strings.ContainsAny(src.Value, ";,") }
+	if src, tainted := tracker.IsTaintedBy(value, hasSeparator); tainted {
+		return HeaderInjectionAttackInfo{Reason: HeaderInjectionTaintedValue, Header: name, Source: src.Name}, true
+	}
+	return info, false
+}
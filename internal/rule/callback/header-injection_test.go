@@ -0,0 +1,183 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/sqreen/go-agent/internal/event"
+	"github.com/sqreen/go-agent/internal/plog"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseHeaderInjectionCallback(t *testing.T) {
+	t.Run("Configuration errors", func(t *testing.T) {
+		for _, tc := range []interface{}{
+			33,
+			&api.ResponseHeaderInjectionRuleDataEntry{ProtectedHeaders: []string{"Set Cookie"}},
+		} {
+			tc := tc
+			t.Run("", func(t *testing.T) {
+				cfg := &mockups.NativeCallbackConfigMockup{}
+				cfg.ExpectData().Return(tc)
+				defer cfg.AssertExpectations(t)
+
+				_, err := callback.NewResponseHeaderInjectionCallback(&mockups.NativeRuleContextMockup{}, cfg)
+				require.Error(t, err)
+			})
+		}
+	})
+
+	for _, tc := range []struct {
+		name     string
+		target   string
+		headers  http.Header
+		expected []callback.HeaderInjectionAttackInfo
+		// stripped are the response headers once the injected values removed
+		stripped http.Header
+	}{
+		{
+			name:    "safe headers",
+			target:  "/?lang=french&type=json",
+			headers: http.Header{"Content-Type": {"application/json"}, "Set-Cookie": {"lang=french; Path=/"}},
+		},
+		{
+			name:   "crlf in header value",
+			target: "/",
+			headers: http.Header{
+				"Content-Type": {"text/plain"},
+				"X-Lang":       {"fr", "fr\r\nSet-Cookie: admin=1"},
+			},
+			expected: []callback.HeaderInjectionAttackInfo{
+				{Reason: callback.HeaderInjectionInvalidValue, Header: "X-Lang"},
+			},
+			stripped: http.Header{
+				"Content-Type": {"text/plain"},
+				"X-Lang":       {"fr"},
+			},
+		},
+		{
+			name:   "crlf in header name",
+			target: "/",
+			headers: http.Header{
+				"Content-Type":              {"text/plain"},
+				"X-Lang\r\nSet-Cookie: a=1": {"fr"},
+			},
+			expected: []callback.HeaderInjectionAttackInfo{
+				{Reason: callback.HeaderInjectionInvalidName, Header: "X-Lang\r\nSet-Cookie: a=1"},
+			},
+			stripped: http.Header{"Content-Type": {"text/plain"}},
+		},
+		{
+			name:   "header name from request parameters",
+			target: "/?header=x-debug-mode",
+			headers: http.Header{
+				"Content-Type": {"text/plain"},
+				"X-Debug-Mode": {"1"},
+			},
+			expected: []callback.HeaderInjectionAttackInfo{
+				{Reason: callback.HeaderInjectionTaintedName, Header: "X-Debug-Mode", Source: "query"},
+			},
+			stripped: http.Header{"Content-Type": {"text/plain"}},
+		},
+		{
+			name:   "request parameter named after a header",
+			target: "/?location=/home&content-type=json",
+			headers: http.Header{
+				"Location":     {"/home"},
+				"Content-Type": {"application/json"},
+			},
+		},
+		{
+			name:   "cookie attributes from request parameters",
+			target: "/?lang=fr%3B%20Domain%3Devil.com",
+			headers: http.Header{
+				"Set-Cookie": {"session=1234; HttpOnly", "lang=fr; Domain=evil.com; Path=/"},
+			},
+			expected: []callback.HeaderInjectionAttackInfo{
+				{Reason: callback.HeaderInjectionTaintedValue, Header: "Set-Cookie", Source: "query"},
+			},
+			stripped: http.Header{"Set-Cookie": {"session=1234; HttpOnly"}},
+		},
+		{
+			name:   "unprotected header value from request parameters",
+			target: "/?lang=fr%3B%20Domain%3Devil.com",
+			headers: http.Header{
+				"X-Lang": {"fr; Domain=evil.com"},
+			},
+		},
+	} {
+		tc := tc
+		for _, blocking := range []bool{false, true} {
+			blocking := blocking
+			t.Run(tc.name, func(t *testing.T) {
+				cfg := &mockups.NativeCallbackConfigMockup{}
+				defer cfg.AssertExpectations(t)
+				cfg.ExpectData().Return(nil)
+				cfg.ExpectBlockingMode().Return(blocking)
+
+				r := &mockups.NativeRuleContextMockup{}
+				defer r.AssertExpectations(t)
+
+				cb, err := callback.NewResponseHeaderInjectionCallback(r, cfg)
+				require.NoError(t, err)
+				prolog, ok := cb.(callback.ResponseHeaderInjectionPrologCallbackType)
+				require.True(t, ok)
+
+				req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+				p := http_protection.NewTestProtectionContext(nil, net.IPv4(1, 2, 3, 4), nil, &requestReader{req: req})
+
+				headers := http.Header{}
+				for k, v := range tc.headers {
+					headers[k] = append([]string(nil), v...)
+				}
+
+				// The callback is only called once since the matcher is called again
+				// by AssertExpectations()
+				var called bool
+				r.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+					if called {
+						return true
+					}
+					called = true
+					c := &mockups.CallbackContextMockup{}
+					defer c.AssertExpectations(t)
+					for _, expected := range tc.expected {
+						expected := expected
+						c.ExpectHandleAttack(false, mock.MatchedBy(func(opts []event.AttackEventOption) bool {
+							var attack event.AttackEvent
+							for _, opt := range opts {
+								opt(&attack)
+							}
+							require.Equal(t, blocking, attack.Blocked)
+							return expected == attack.Info
+						})).Return(false).Once()
+					}
+					c.On("Logger").Return(plog.NewLogger(plog.Disabled, nil, nil)).Maybe()
+					require.NoError(t, cb(c))
+					return true
+				})).Once()
+
+				epilog, err := prolog(&p, &headers)
+				require.NoError(t, err)
+				require.Nil(t, epilog)
+
+				if len(tc.expected) > 0 && blocking {
+					require.Equal(t, tc.stripped, headers)
+				} else {
+					require.Equal(t, tc.headers, headers)
+				}
+			})
+		}
+	}
+}
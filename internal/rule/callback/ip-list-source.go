@@ -0,0 +1,126 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/sqreen/go-agent/internal/actor"
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	sdk_types "github.com/sqreen/go-agent/sdk/types"
+)
+
+// NewIPListSourceCallback returns the callback object blocking or monitoring
+// the requests whose client IP address belongs to an IP list periodically
+// loaded from a file or a URL, such as Tor exit nodes or public proxy lists.
+// Whether the request is blocked or only monitored depends on the rule
+// blocking mode.
+func NewIPListSourceCallback(r RuleContext, cfg NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	sqassert.NotNil(cfg)
+
+	data, ok := cfg.Data().(*api.IPListSourceRuleDataEntry)
+	if !ok {
+		return nil, sqerrors.Errorf("unexpected callback data type: got `%T` instead of `%T`", cfg.Data(), data)
+	}
+
+	refresh := time.Duration(data.RefreshInterval) * time.Second
+	source, err := actor.NewIPListSource(data.Name, data.Location, refresh, r.Logger())
+	if err != nil {
+		return nil, sqerrors.Wrap(err, "unexpected error while creating the ip list source")
+	}
+	source.Start()
+
+	return &ipListSourceCallbackObject{
+		source: source,
+		prolog: newIPListSourcePrologCallback(r, source),
+	}, nil
+}
+
+type ipListSourceCallbackObject struct {
+	source *actor.IPListSource
+	prolog IPListSourcePrologCallbackType
+}
+
+func (o *ipListSourceCallbackObject) PrologCallback() sqhook.PrologCallback {
+	return o.prolog
+}
+
+// Close stops the periodic refresh of the IP list.
+func (o *ipListSourceCallbackObject) Close() error {
+	return o.source.Close()
+}
+
+type IPListSourcePrologCallbackType = http_protection.BlockingPrologCallbackType
+type IPListSourceEpilogCallbackType = http_protection.BlockingEpilogCallbackType
+
+type IPListSourceAttackInfo struct {
+	IPList      string `json:"ip_list"`
+	IPListEntry string `json:"ip_list_entry"`
+	IP          string `json:"ip"`
+}
+
+type IPListSourceError struct {
+	IP          net.IP
+	IPList      string
+	IPListEntry string
+}
+
+func (e IPListSourceError) Error() string {
+	return fmt.Sprintf("ip address `%s` matched the entry `%s` of the ip list `%s` and was blocked", e.IP.String(), e.IPListEntry, e.IPList)
+}
+
+func newIPListSourcePrologCallback(r RuleContext, source *actor.IPListSource) IPListSourcePrologCallbackType {
+	return func(**http_protection.ProtectionContext) (epilog IPListSourceEpilogCallbackType, prologErr error) {
+		r.Pre(func(c CallbackContext) error {
+			ip := c.ProtectionContext().ClientIP()
+			exists, matched, err := source.Find(ip)
+			if err != nil {
+				type errKey struct{}
+				return sqerrors.WithKey(sqerrors.Wrapf(err, "unexpected error while searching IP address `%#+v` in the IP list `%s`", ip, source.Name()), errKey{})
+			}
+
+			if !exists {
+				return nil
+			}
+
+			_ = c.AddMetricsValue(source.Name(), 1)
+
+			info := IPListSourceAttackInfo{
+				IPList:      source.Name(),
+				IPListEntry: matched,
+				IP:          ip.String(),
+			}
+			if blocked := c.HandleAttack(true, event.WithAttackInfo(info)); !blocked {
+				return nil
+			}
+
+			epilog = func(e *error) {
+				sqassert.NotNil(e)
+				err := sdk_types.SqreenError{
+					Err: IPListSourceError{
+						IP:          ip,
+						IPList:      source.Name(),
+						IPListEntry: matched,
+					},
+				}
+				// Display the error message explaining why the request is denied.
+				c.Logger().Debug(err.Error())
+				*e = err
+			}
+			return nil
+		})
+		return
+	}
+}
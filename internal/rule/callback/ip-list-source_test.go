@@ -0,0 +1,117 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPListSourceCallback(t *testing.T) {
+	t.Run("Configuration errors", func(t *testing.T) {
+		for _, tc := range []interface{}{
+			nil,
+			33,                               // wrong type
+			[]interface{}{[]string{"a"}},     // wrong type
+			&api.IPListSourceRuleDataEntry{}, // empty name and location
+			&api.IPListSourceRuleDataEntry{Name: "tor"},                                           // empty location
+			&api.IPListSourceRuleDataEntry{Location: "list.txt"},                                  // empty name
+			&api.IPListSourceRuleDataEntry{Name: "tor", Location: "list.txt", RefreshInterval: 1}, // too short refresh interval
+		} {
+			tc := tc
+			t.Run("", func(t *testing.T) {
+				r := &mockups.NativeRuleContextMockup{}
+				r.ExpectLogger().Return(nil).Maybe()
+				defer r.AssertExpectations(t)
+
+				cfg := &mockups.NativeCallbackConfigMockup{}
+				cfg.ExpectData().Return(tc)
+				defer cfg.AssertExpectations(t)
+
+				_, err := callback.NewIPListSourceCallback(r, cfg)
+				require.Error(t, err)
+			})
+		}
+	})
+
+	t.Run("Callback", func(t *testing.T) {
+		f, err := ioutil.TempFile("", "iplist")
+		require.NoError(t, err)
+		defer os.Remove(f.Name())
+		_, err = f.WriteString("1.2.3.4\n")
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		r := &mockups.NativeRuleContextMockup{}
+		r.ExpectLogger().Return(nil)
+
+		cfg := &mockups.NativeCallbackConfigMockup{}
+		cfg.ExpectData().Return(&api.IPListSourceRuleDataEntry{
+			Name:     "tor",
+			Location: f.Name(),
+		}).Once()
+		defer cfg.AssertExpectations(t)
+
+		cb, err := callback.NewIPListSourceCallback(r, cfg)
+		require.NoError(t, err)
+		require.NotNil(t, cb)
+		closer, ok := cb.(io.Closer)
+		require.True(t, ok)
+		defer closer.Close()
+
+		getter, ok := cb.(sqhook.PrologCallbackGetter)
+		require.True(t, ok)
+		prolog, ok := getter.PrologCallback().(callback.IPListSourcePrologCallbackType)
+		require.True(t, ok)
+
+		// Wait for the background loading of the list
+		require.Eventually(t, func() bool {
+			var listed bool
+			r.ExpectPre(mock.MatchedBy(func(cb func(c callback.CallbackContext) error) bool {
+				c := &mockups.CallbackContextMockup{}
+				p := &mockups.ProtectionContextMockup{}
+				c.ExpectProtectionContext().Return(p)
+				p.ExpectClientIP().Return(net.ParseIP("1.2.3.4"))
+				c.ExpectAddMetricsValue("tor", uint64(1)).Return(true)
+				c.ExpectHandleAttack(true, mock.Anything).Return(false).Run(func(mock.Arguments) {
+					listed = true
+				})
+				require.NoError(t, cb(c))
+				return true
+			})).Once()
+			epilog, err := prolog(nil)
+			require.NoError(t, err)
+			// Monitoring mode: the attack is not blocked
+			require.Nil(t, epilog)
+			return listed
+		}, time.Second, time.Millisecond)
+
+		// Not listed
+		r.ExpectPre(mock.MatchedBy(func(cb func(c callback.CallbackContext) error) bool {
+			c := &mockups.CallbackContextMockup{}
+			defer c.AssertExpectations(t)
+			p := &mockups.ProtectionContextMockup{}
+			defer p.AssertExpectations(t)
+			c.ExpectProtectionContext().Return(p)
+			p.ExpectClientIP().Return(net.ParseIP("11.22.33.44")).Once()
+			require.NoError(t, cb(c))
+			return true
+		})).Once()
+		epilog, err := prolog(nil)
+		require.NoError(t, err)
+		require.Nil(t, epilog)
+	})
+}
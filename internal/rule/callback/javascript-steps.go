@@ -0,0 +1,222 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"strconv"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/file"
+	"github.com/dop251/goja/parser"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+)
+
+// The javascript runtime doesn't provide any step counter. The steps of the
+// callbacks are therefore counted by calling the native step function, defined
+// in every runtime, at the beginning of every function call and loop iteration
+// of the callback source code. The steps of the native built-in functions are
+// not counted.
+const jsStepFuncName = "__sqreen_step__"
+
+// CompileJSCallback compiles the javascript source code of a callback after
+// instrumenting it so that its steps are counted and limited by the
+// JSLimits.MaxSteps limit of the callback calls.
+func CompileJSCallback(name, src string) (*goja.Program, error) {
+	prg, err := parser.ParseFile(nil, name, src, 0)
+	if err != nil {
+		return nil, sqerrors.Wrap(err, "parsing error")
+	}
+	i := jsStepInstrumentation{visited: make(map[*ast.FunctionLiteral]struct{})}
+	i.walkDeclarations(prg.DeclarationList)
+	i.walkStatements(prg.Body)
+	return goja.CompileAST(prg, true)
+}
+
+type jsStepInstrumentation struct {
+	visited map[*ast.FunctionLiteral]struct{}
+}
+
+// newStepStatement returns the call statement of the step function located at
+// the given source index.
+func newStepStatement(idx file.Idx) ast.Statement {
+	return &ast.ExpressionStatement{
+		Expression: &ast.CallExpression{
+			Callee:           &ast.Identifier{Name: jsStepFuncName, Idx: idx},
+			LeftParenthesis:  idx,
+			RightParenthesis: idx,
+		},
+	}
+}
+
+// withStep returns the given loop body starting with a step.
+func withStep(body ast.Statement) ast.Statement {
+	return &ast.BlockStatement{
+		LeftBrace:  body.Idx0(),
+		List:       []ast.Statement{newStepStatement(body.Idx0()), body},
+		RightBrace: body.Idx1(),
+	}
+}
+
+func (i *jsStepInstrumentation) walkFunction(fn *ast.FunctionLiteral) {
+	if _, visited := i.visited[fn]; visited {
+		return
+	}
+	i.visited[fn] = struct{}{}
+
+	i.walkDeclarations(fn.DeclarationList)
+	body, ok := fn.Body.(*ast.BlockStatement)
+	if !ok {
+		i.walkStatement(fn.Body)
+		fn.Body = withStep(fn.Body)
+		return
+	}
+	i.walkStatements(body.List)
+	// Insert the step after the directive prologue, such as "use strict", which
+	// must remain the first statements of the function body.
+	n := 0
+	for ; n < len(body.List); n++ {
+		stmt, ok := body.List[n].(*ast.ExpressionStatement)
+		if !ok {
+			break
+		}
+		if _, ok := stmt.Expression.(*ast.StringLiteral); !ok {
+			break
+		}
+	}
+	list := make([]ast.Statement, 0, len(body.List)+1)
+	list = append(list, body.List[:n]...)
+	list = append(list, newStepStatement(body.LeftBrace))
+	body.List = append(list, body.List[n:]...)
+}
+
+func (i *jsStepInstrumentation) walkDeclarations(decls []ast.Declaration) {
+	for _, decl := range decls {
+		if fn, ok := decl.(*ast.FunctionDeclaration); ok {
+			i.walkFunction(fn.Function)
+		}
+	}
+}
+
+func (i *jsStepInstrumentation) walkStatements(stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		i.walkStatement(stmt)
+	}
+}
+
+func (i *jsStepInstrumentation) walkStatement(stmt ast.Statement) {
+	switch s := stmt.(type) {
+	case *ast.BlockStatement:
+		i.walkStatements(s.List)
+	case *ast.CaseStatement:
+		i.walkExpression(s.Test)
+		i.walkStatements(s.Consequent)
+	case *ast.CatchStatement:
+		i.walkStatement(s.Body)
+	case *ast.DoWhileStatement:
+		i.walkExpression(s.Test)
+		i.walkStatement(s.Body)
+		s.Body = withStep(s.Body)
+	case *ast.ExpressionStatement:
+		i.walkExpression(s.Expression)
+	case *ast.ForInStatement:
+		i.walkExpression(s.Into)
+		i.walkExpression(s.Source)
+		i.walkStatement(s.Body)
+		s.Body = withStep(s.Body)
+	case *ast.ForStatement:
+		i.walkExpression(s.Initializer)
+		i.walkExpression(s.Update)
+		i.walkExpression(s.Test)
+		i.walkStatement(s.Body)
+		s.Body = withStep(s.Body)
+	case *ast.IfStatement:
+		i.walkExpression(s.Test)
+		i.walkStatement(s.Consequent)
+		i.walkStatement(s.Alternate)
+	case *ast.LabelledStatement:
+		i.walkStatement(s.Statement)
+	case *ast.ReturnStatement:
+		i.walkExpression(s.Argument)
+	case *ast.SwitchStatement:
+		i.walkExpression(s.Discriminant)
+		for _, c := range s.Body {
+			i.walkStatement(c)
+		}
+	case *ast.ThrowStatement:
+		i.walkExpression(s.Argument)
+	case *ast.TryStatement:
+		i.walkStatement(s.Body)
+		if s.Catch != nil {
+			i.walkStatement(s.Catch)
+		}
+		i.walkStatement(s.Finally)
+	case *ast.VariableStatement:
+		i.walkExpressions(s.List)
+	case *ast.WhileStatement:
+		i.walkExpression(s.Test)
+		i.walkStatement(s.Body)
+		s.Body = withStep(s.Body)
+	case *ast.WithStatement:
+		i.walkExpression(s.Object)
+		i.walkStatement(s.Body)
+	}
+}
+
+func (i *jsStepInstrumentation) walkExpressions(exprs []ast.Expression) {
+	for _, expr := range exprs {
+		i.walkExpression(expr)
+	}
+}
+
+func (i *jsStepInstrumentation) walkExpression(expr ast.Expression) {
+	switch e := expr.(type) {
+	case *ast.ArrayLiteral:
+		i.walkExpressions(e.Value)
+	case *ast.AssignExpression:
+		i.walkExpression(e.Left)
+		i.walkExpression(e.Right)
+	case *ast.BinaryExpression:
+		i.walkExpression(e.Left)
+		i.walkExpression(e.Right)
+	case *ast.BracketExpression:
+		i.walkExpression(e.Left)
+		i.walkExpression(e.Member)
+	case *ast.CallExpression:
+		i.walkExpression(e.Callee)
+		i.walkExpressions(e.ArgumentList)
+	case *ast.ConditionalExpression:
+		i.walkExpression(e.Test)
+		i.walkExpression(e.Consequent)
+		i.walkExpression(e.Alternate)
+	case *ast.DotExpression:
+		i.walkExpression(e.Left)
+	case *ast.FunctionLiteral:
+		i.walkFunction(e)
+	case *ast.NewExpression:
+		i.walkExpression(e.Callee)
+		i.walkExpressions(e.ArgumentList)
+	case *ast.ObjectLiteral:
+		for _, p := range e.Value {
+			i.walkExpression(p.Value)
+		}
+	case *ast.SequenceExpression:
+		i.walkExpressions(e.Sequence)
+	case *ast.UnaryExpression:
+		i.walkExpression(e.Operand)
+	case *ast.VariableExpression:
+		i.walkExpression(e.Initializer)
+	}
+}
+
+// jsStepLimitError is the interruption value of the callback calls exceeding
+// their maximum number of steps.
+type jsStepLimitError uint64
+
+func (e jsStepLimitError) Error() string {
+	return "javascript callback step limit of " + strconv.FormatUint(uint64(e), 10) + " steps exceeded"
+}
@@ -9,6 +9,8 @@ package callback
 import (
 	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/dop251/goja"
 	"github.com/sqreen/go-agent/internal/binding-accessor"
@@ -19,13 +21,33 @@ import (
 	"github.com/sqreen/go-agent/sdk/types"
 )
 
+const (
+	defaultMaxJSTimeBudget = 5 * time.Millisecond
+	defaultMaxJSSteps      = 100000
+)
+
+// NewJSExecCallback returns the reflected callback executing the javascript
+// `pre` and `post` functions of the rule. Every call is limited by the
+// execution time and step limits of the rule. Calls exceeding them are
+// interrupted and counted as over-budget, and the callback gets disabled once
+// the rule's maximum number of over-budget calls is reached. When the request
+// has less time left than the rule execution time limit, the calls are
+// interrupted once the request time is exhausted, without being counted as
+// over-budget, and are skipped when no time is left.
 func NewJSExecCallback(r RuleContext, cfg JSReflectedCallbackConfig) (sqhook.ReflectedPrologCallback, error) {
-	pool := newVMPool(cfg)
+	limits := jsLimitsWithDefaults(cfg.Limits())
+	pool := newVMPool(cfg, limits.MaxSteps)
 	sqassert.NotNil(pool)
 	strategy := cfg.Strategy()
 	sqassert.NotNil(strategy)
+	budget := &jsBudget{max: limits.MaxOverBudget}
 
 	return func(params []reflect.Value) (epilogFunc sqhook.ReflectedEpilogCallback, prologErr error) {
+		if budget.isDisabled() {
+			return nil, nil
+		}
+
+		r := withCallParams(r, params)
 		vm := pool.get()
 		defer pool.put(vm)
 
@@ -33,15 +55,21 @@ func NewJSExecCallback(r RuleContext, cfg JSReflectedCallbackConfig) (sqhook.Ref
 
 		if vm.hasPre() {
 			r.Pre(func(c CallbackContext) error {
+				// Check that we still have time for the call
+				timeout, ok := jsCallTimeout(c.ProtectionContext(), limits.Timeout)
+				if !ok {
+					return nil
+				}
+
 				baCtx, err := NewReflectedCallbackBindingAccessorContext(strategy.BindingAccessor.Capabilities, c.ProtectionContext(), params, nil, cfg.Data())
 				if err != nil {
 					type errKey struct{}
 					return sqerrors.WithKey(err, errKey{})
 				}
 
-				result, err := vm.callPre(baCtx)
+				result, err := vm.callPre(baCtx, timeout)
 				if err != nil {
-					return err
+					return budget.check(err)
 				}
 
 				if raise := result.Status == "raise"; !raise {
@@ -71,15 +99,21 @@ func NewJSExecCallback(r RuleContext, cfg JSReflectedCallbackConfig) (sqhook.Ref
 		if vm.hasPost() {
 			epilogFunc = func(results []reflect.Value) {
 				r.Post(func(c CallbackContext) error {
+					// Check that we still have time for the call
+					timeout, ok := jsCallTimeout(c.ProtectionContext(), limits.Timeout)
+					if !ok {
+						return nil
+					}
+
 					baCtx, err := NewReflectedCallbackBindingAccessorContext(strategy.BindingAccessor.Capabilities, c.ProtectionContext(), params, results, cfg.Data())
 					if err != nil {
 						type errKey struct{}
 						return sqerrors.WithKey(err, errKey{})
 					}
 
-					result, err := vm.callPost(baCtx)
+					result, err := vm.callPost(baCtx, timeout)
 					if err != nil {
-						return err
+						return budget.check(err)
 					}
 
 					if raise := result.Status == "raise"; !raise {
@@ -110,6 +144,8 @@ type vmPool sync.Pool
 type runtime struct {
 	vm        *goja.Runtime
 	pre, post *jsCallbackFunc
+	// Number of steps of the current call and maximum number of steps of a call.
+	steps, maxSteps uint64
 }
 
 type jsCallbackFunc struct {
@@ -128,7 +164,63 @@ func (m fileNameMapper) FieldName(t reflect.Type, f reflect.StructField) string
 	return f.Name
 }
 
-func newVMPool(cfg JSReflectedCallbackConfig) *vmPool {
+func jsLimitsWithDefaults(limits JSLimits) JSLimits {
+	if limits.Timeout == 0 {
+		limits.Timeout = defaultMaxJSTimeBudget
+	}
+	if limits.MaxSteps == 0 {
+		limits.MaxSteps = defaultMaxJSSteps
+	}
+	return limits
+}
+
+// jsCallTimeout returns the execution time limit of a callback call, which is
+// the rule execution time limit, or the time left to the request when shorter.
+// It returns false when no time is left, so that the call must be skipped.
+func jsCallTimeout(p ProtectionContext, timeout time.Duration) (jsCallTimeoutError, bool) {
+	remaining, limited := p.RemainingTime()
+	if !limited || remaining >= timeout {
+		return jsCallTimeoutError{timeout: timeout}, true
+	}
+	if remaining <= 0 {
+		return jsCallTimeoutError{}, false
+	}
+	return jsCallTimeoutError{timeout: remaining, request: true}, true
+}
+
+// jsBudget counts the over-budget calls of the javascript callbacks and
+// disables them when the maximum number of over-budget calls is reached.
+type jsBudget struct {
+	max      uint64
+	count    uint64
+	disabled int32
+}
+
+func (b *jsBudget) isDisabled() bool {
+	return atomic.LoadInt32(&b.disabled) != 0
+}
+
+// check counts the given call error when it is an over-budget error and
+// returns it with the number of over-budget calls. Calls interrupted because
+// the request has no time left are not counted.
+func (b *jsBudget) check(err error) error {
+	interrupted, ok := err.(*goja.InterruptedError)
+	if !ok {
+		return err
+	}
+	if timeout, ok := interrupted.Value().(jsCallTimeoutError); ok && timeout.request {
+		return nil
+	}
+
+	count := atomic.AddUint64(&b.count, 1)
+	if b.max != 0 && count >= b.max && atomic.CompareAndSwapInt32(&b.disabled, 0, 1) {
+		return sqerrors.Wrapf(err, "javascript callbacks disabled after %d over-budget calls", count)
+	}
+	type errKey struct{}
+	return sqerrors.WithKey(sqerrors.Wrapf(err, "javascript callback over budget (%d over-budget calls)", count), errKey{})
+}
+
+func newVMPool(cfg JSReflectedCallbackConfig, maxSteps uint64) *vmPool {
 	preFuncDecl, preFuncCallParams := cfg.Pre()
 	postFuncDecl, postFuncCallParams := cfg.Post()
 	sqassert.True(preFuncDecl != nil || postFuncDecl != nil)
@@ -137,8 +229,8 @@ func newVMPool(cfg JSReflectedCallbackConfig) *vmPool {
 		New: func() interface{} {
 			vm := goja.New()
 			vm.SetFieldNameMapper(fileNameMapper{goja.TagFieldNameMapper("goja", false)})
-
-			var pre, post *jsCallbackFunc
+			rt := &runtime{vm: vm, maxSteps: maxSteps}
+			vm.Set(jsStepFuncName, rt.step)
 
 			if preFuncDecl != nil {
 				_, err := vm.RunProgram(preFuncDecl)
@@ -148,7 +240,7 @@ func newVMPool(cfg JSReflectedCallbackConfig) *vmPool {
 					return sqerrors.Wrap(err, "retrieving `pre` function")
 				}
 
-				pre = &jsCallbackFunc{
+				rt.pre = &jsCallbackFunc{
 					callback:       fn,
 					funcCallParams: preFuncCallParams,
 				}
@@ -162,17 +254,13 @@ func newVMPool(cfg JSReflectedCallbackConfig) *vmPool {
 					return sqerrors.Wrap(err, "retrieving `post` function")
 				}
 
-				post = &jsCallbackFunc{
+				rt.post = &jsCallbackFunc{
 					callback:       fn,
 					funcCallParams: postFuncCallParams,
 				}
 			}
 
-			return &runtime{
-				vm:   vm,
-				pre:  pre,
-				post: post,
-			}
+			return rt
 		},
 	})
 }
@@ -202,25 +290,41 @@ type jsCallbackResult struct {
 	Record map[string]interface{} `goja:"record"`
 }
 
-func (r *runtime) callPre(baCtx bindingaccessor.Context) (*jsCallbackResult, error) {
+// step counts a step of the current call and interrupts it once its maximum
+// number of steps is exceeded. The interruption happens when returning to the
+// javascript code.
+func (r *runtime) step(goja.FunctionCall) goja.Value {
+	r.steps++
+	if r.steps == r.maxSteps+1 {
+		r.vm.Interrupt(jsStepLimitError(r.maxSteps))
+	}
+	return goja.Undefined()
+}
+
+func (r *runtime) callPre(baCtx bindingaccessor.Context, timeout jsCallTimeoutError) (*jsCallbackResult, error) {
 	sqassert.True(r.hasPre())
 	result := &jsCallbackResult{}
-	if err := call(r.vm, r.pre, baCtx, result); err != nil {
+	r.steps = 0
+	if err := call(r.vm, r.pre, baCtx, result, timeout); err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-func (r *runtime) callPost(baCtx bindingaccessor.Context) (*jsCallbackResult, error) {
+func (r *runtime) callPost(baCtx bindingaccessor.Context, timeout jsCallTimeoutError) (*jsCallbackResult, error) {
 	sqassert.True(r.hasPost())
 	result := &jsCallbackResult{}
-	if err := call(r.vm, r.post, baCtx, result); err != nil {
+	r.steps = 0
+	if err := call(r.vm, r.post, baCtx, result, timeout); err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-func call(vm *goja.Runtime, descr *jsCallbackFunc, baCtx bindingaccessor.Context, result interface{}) error {
+// call calls the javascript function with the values of its binding
+// accessors. The function call is interrupted when it lasts longer than the
+// given timeout.
+func call(vm *goja.Runtime, descr *jsCallbackFunc, baCtx bindingaccessor.Context, result interface{}, timeout jsCallTimeoutError) error {
 	jsParams := make([]goja.Value, len(descr.funcCallParams))
 	for i, ba := range descr.funcCallParams {
 		v, err := ba(baCtx)
@@ -239,8 +343,12 @@ func call(vm *goja.Runtime, descr *jsCallbackFunc, baCtx bindingaccessor.Context
 		jsParams[i] = jsVal
 	}
 
-	v, err := descr.callback(goja.Undefined(), jsParams...)
+	v, err := callWithTimeout(vm, descr.callback, jsParams, timeout)
 	if err != nil {
+		if _, ok := err.(*goja.InterruptedError); ok {
+			// Over-budget errors are returned as-is to be counted
+			return err
+		}
 		type errKey struct{}
 		return sqerrors.WithKey(err, errKey{})
 	}
@@ -248,6 +356,46 @@ func call(vm *goja.Runtime, descr *jsCallbackFunc, baCtx bindingaccessor.Context
 	return vm.ExportTo(v, result)
 }
 
+// jsCallTimeoutError is the execution time limit of a callback call, and its
+// interruption value once exceeded. request is true when the limit is the time
+// left to the request.
+type jsCallTimeoutError struct {
+	timeout time.Duration
+	request bool
+}
+
+func (e jsCallTimeoutError) Error() string {
+	if e.request {
+		return "javascript callback interrupted after " + e.timeout.String() + ": no time left to the request"
+	}
+	return "javascript callback timeout after " + e.timeout.String()
+}
+
+// callWithTimeout calls the javascript function and interrupts it when it
+// lasts longer than the given timeout. The runtime interruption is cleared
+// before returning so that the pooled runtime can be reused.
+func callWithTimeout(vm *goja.Runtime, fn goja.Callable, params []goja.Value, timeout jsCallTimeoutError) (goja.Value, error) {
+	var (
+		mu   sync.Mutex
+		done bool
+	)
+	timer := time.AfterFunc(timeout.timeout, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if !done {
+			vm.Interrupt(timeout)
+		}
+	})
+	defer func() {
+		mu.Lock()
+		done = true
+		mu.Unlock()
+		timer.Stop()
+		vm.ClearInterrupt()
+	}()
+	return fn(goja.Undefined(), params...)
+}
+
 type noScrub map[string]interface{}
 
 func (n noScrub) NoScrub() {}
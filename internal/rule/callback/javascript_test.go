@@ -5,10 +5,19 @@
 package callback_test
 
 import (
+	"fmt"
+	"math"
 	"net/http"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/dop251/goja"
+	"github.com/sqreen/go-agent/internal/backend/api"
+	bindingaccessor "github.com/sqreen/go-agent/internal/binding-accessor"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -227,3 +236,223 @@ function foo() {
 		require.ElementsMatch(t, []interface{}{"Field1", "Field2"}, res.Export())
 	})
 }
+
+type jsCallbackConfig struct {
+	pre    *goja.Program
+	limits callback.JSLimits
+}
+
+func (c jsCallbackConfig) BlockingMode() bool { return false }
+func (c jsCallbackConfig) Data() interface{}  { return nil }
+func (c jsCallbackConfig) Strategy() *api.ReflectedCallbackConfig {
+	return &api.ReflectedCallbackConfig{}
+}
+func (c jsCallbackConfig) PrologFuncType() reflect.Type { return nil }
+func (c jsCallbackConfig) Limits() callback.JSLimits    { return c.limits }
+func (c jsCallbackConfig) Pre() (*goja.Program, []bindingaccessor.BindingAccessorFunc) {
+	return c.pre, nil
+}
+func (c jsCallbackConfig) Post() (*goja.Program, []bindingaccessor.BindingAccessorFunc) {
+	return nil, nil
+}
+
+func TestJSExecCallbackLimits(t *testing.T) {
+	for _, tc := range []struct {
+		Name   string
+		Source string
+		Limits callback.JSLimits
+	}{
+		{
+			Name:   "timeout",
+			Source: `function pre() { for(;;) {} }`,
+			Limits: callback.JSLimits{Timeout: 10 * time.Millisecond, MaxSteps: math.MaxUint64 - 1},
+		},
+		{
+			Name:   "step limit",
+			Source: `function pre() { for(;;) {} }`,
+			Limits: callback.JSLimits{Timeout: time.Hour},
+		},
+		{
+			Name:   "deep recursion",
+			Source: `function pre() { function f() { return f() } return f() }`,
+			Limits: callback.JSLimits{Timeout: time.Hour},
+		},
+	} {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			program, err := callback.CompileJSCallback("pre", tc.Source)
+			require.NoError(t, err)
+
+			limits := tc.Limits
+			limits.MaxOverBudget = 2
+			cfg := jsCallbackConfig{
+				pre:    program,
+				limits: limits,
+			}
+
+			p := &mockups.ProtectionContextMockup{}
+			defer p.AssertExpectations(t)
+			p.ExpectRemainingTime().Return(time.Duration(0), false)
+
+			r := &mockups.NativeRuleContextMockup{}
+			defer r.AssertExpectations(t)
+			r.ExpectPre(mock.Anything).Run(func(args mock.Arguments) {
+				c := &mockups.CallbackContextMockup{}
+				defer c.AssertExpectations(t)
+				c.ExpectProtectionContext().Return(p)
+				cb := args.Get(0).(func(callback.CallbackContext) error)
+				require.Error(t, cb(c))
+			}).Twice()
+
+			prolog, err := callback.NewJSExecCallback(r, cfg)
+			require.NoError(t, err)
+
+			// The over-budget calls are interrupted until the callback gets
+			// disabled by the second one.
+			for i := 0; i < 3; i++ {
+				start := time.Now()
+				epilog, err := prolog(nil)
+				require.NoError(t, err)
+				require.Nil(t, epilog)
+				require.Less(t, int64(time.Since(start)), int64(time.Second))
+			}
+		})
+	}
+
+	t.Run("no request time left", func(t *testing.T) {
+		program, err := callback.CompileJSCallback("pre", `function pre() { for(;;) {} }`)
+		require.NoError(t, err)
+
+		// The call is skipped.
+		p := &mockups.ProtectionContextMockup{}
+		defer p.AssertExpectations(t)
+		p.ExpectRemainingTime().Return(time.Duration(0), true).Once()
+
+		r := &mockups.NativeRuleContextMockup{}
+		defer r.AssertExpectations(t)
+		r.ExpectPre(mock.Anything).Run(func(args mock.Arguments) {
+			c := &mockups.CallbackContextMockup{}
+			defer c.AssertExpectations(t)
+			c.ExpectProtectionContext().Return(p)
+			cb := args.Get(0).(func(callback.CallbackContext) error)
+			require.NoError(t, cb(c))
+		}).Once()
+
+		prolog, err := callback.NewJSExecCallback(r, jsCallbackConfig{pre: program})
+		require.NoError(t, err)
+		_, err = prolog(nil)
+		require.NoError(t, err)
+	})
+
+	t.Run("request time left shorter than the timeout", func(t *testing.T) {
+		program, err := callback.CompileJSCallback("pre", `function pre() { for(;;) {} }`)
+		require.NoError(t, err)
+
+		// The calls are interrupted once the request time is exhausted, rather
+		// than skipped, without being counted as over-budget.
+		p := &mockups.ProtectionContextMockup{}
+		defer p.AssertExpectations(t)
+		p.ExpectRemainingTime().Return(time.Millisecond, true).Twice()
+
+		r := &mockups.NativeRuleContextMockup{}
+		defer r.AssertExpectations(t)
+		r.ExpectPre(mock.Anything).Run(func(args mock.Arguments) {
+			c := &mockups.CallbackContextMockup{}
+			defer c.AssertExpectations(t)
+			c.ExpectProtectionContext().Return(p)
+			cb := args.Get(0).(func(callback.CallbackContext) error)
+			require.NoError(t, cb(c))
+		}).Twice()
+
+		prolog, err := callback.NewJSExecCallback(r, jsCallbackConfig{
+			pre: program,
+			limits: callback.JSLimits{
+				Timeout:       time.Hour,
+				MaxSteps:      math.MaxUint64 - 1,
+				MaxOverBudget: 1,
+			},
+		})
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			start := time.Now()
+			_, err = prolog(nil)
+			require.NoError(t, err)
+			require.Less(t, int64(time.Since(start)), int64(time.Second))
+		}
+	})
+}
+
+func TestCompileJSCallback(t *testing.T) {
+	for _, tc := range []struct {
+		Name   string
+		Source string
+		Steps  uint64
+	}{
+		{
+			Name:   "function calls",
+			Source: `function pre() { return f() } function f() { return { status: "ok" } }`,
+			Steps:  2,
+		},
+		{
+			Name:   "for loop",
+			Source: `function pre() { for (var i = 0; i < 10; i++) ; return {} }`,
+			Steps:  11,
+		},
+		{
+			Name:   "while loops",
+			Source: `function pre() { var i = 0; while (i < 3) { i++ } do { i-- } while (i > 0); return {} }`,
+			Steps:  7,
+		},
+		{
+			Name:   "for-in loop",
+			Source: `function pre() { for (var k in { a: 1, b: 2 }) {} return {} }`,
+			Steps:  3,
+		},
+		{
+			Name:   "nested functions",
+			Source: `function pre() { "use strict"; var f = function(n) { return n ? g(n - 1) : 0 }; function g(n) { return f(n) } f(2); return {} }`,
+			Steps:  6,
+		},
+	} {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			program, err := callback.CompileJSCallback("pre", tc.Source)
+			require.NoError(t, err)
+
+			for _, maxSteps := range []uint64{tc.Steps, tc.Steps - 1} {
+				maxSteps := maxSteps
+				exceeded := maxSteps < tc.Steps
+				t.Run(fmt.Sprintf("max steps %d", maxSteps), func(t *testing.T) {
+					p := &mockups.ProtectionContextMockup{}
+					defer p.AssertExpectations(t)
+					p.ExpectRemainingTime().Return(time.Duration(0), false)
+
+					r := &mockups.NativeRuleContextMockup{}
+					defer r.AssertExpectations(t)
+					r.ExpectPre(mock.Anything).Run(func(args mock.Arguments) {
+						c := &mockups.CallbackContextMockup{}
+						defer c.AssertExpectations(t)
+						c.ExpectProtectionContext().Return(p)
+						cb := args.Get(0).(func(callback.CallbackContext) error)
+						if err := cb(c); exceeded {
+							require.Error(t, err)
+						} else {
+							require.NoError(t, err)
+						}
+					}).Once()
+
+					prolog, err := callback.NewJSExecCallback(r, jsCallbackConfig{
+						pre:    program,
+						limits: callback.JSLimits{Timeout: time.Hour, MaxSteps: maxSteps},
+					})
+					require.NoError(t, err)
+					_, err = prolog(nil)
+					require.NoError(t, err)
+				})
+			}
+		})
+	}
+
+	_, err := callback.CompileJSCallback("pre", `function pre() {`)
+	require.Error(t, err)
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/sqreen/go-agent/internal/event"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	"github.com/sqreen/go-agent/sdk/types"
+)
+
+// NewMonitorPanicsCallback returns the panic observer of the hooked function
+// recording its panics as attacks of the current request, such as crashes
+// caused by deserialization bombs. When the attack is blocked, the panic is
+// converted into the function error return value.
+func NewMonitorPanicsCallback(r RuleContext, _ NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	return newMonitorPanicsObserver(r), nil
+}
+
+type PanicAttackInfo struct {
+	Panic string `json:"panic"`
+}
+
+func newMonitorPanicsObserver(r RuleContext) sqhook.PanicObserver {
+	return func(recovered interface{}) (err error) {
+		r.Post(func(c CallbackContext) error {
+			info := PanicAttackInfo{Panic: fmt.Sprint(recovered)}
+			if blocked := c.HandleAttack(true, event.WithAttackInfo(info), event.WithStackTrace()); blocked {
+				err = types.SqreenError{Err: errors.Errorf("panic protection: %v", recovered)}
+			}
+			return nil
+		})
+		return err
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	"github.com/sqreen/go-agent/sdk/types"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestMonitorPanicsCallback(t *testing.T) {
+	for _, tc := range []struct {
+		Name    string
+		Blocked bool
+	}{
+		{Name: "not blocked", Blocked: false},
+		{Name: "blocked", Blocked: true},
+	} {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			r := &mockups.NativeRuleContextMockup{}
+			defer r.AssertExpectations(t)
+
+			c := &mockups.CallbackContextMockup{}
+			defer c.AssertExpectations(t)
+			c.ExpectHandleAttack(true, mock.Anything).Return(tc.Blocked).Once()
+
+			r.ExpectPost(mock.Anything).Run(func(args mock.Arguments) {
+				cb := args.Get(0).(func(callback.CallbackContext) error)
+				require.NoError(t, cb(c))
+			}).Once()
+
+			cb, err := callback.NewMonitorPanicsCallback(r, nil)
+			require.NoError(t, err)
+			observer, ok := cb.(sqhook.PanicObserver)
+			require.True(t, ok)
+
+			err = observer("oops")
+			if tc.Blocked {
+				require.Error(t, err)
+				require.True(t, xerrors.As(err, &types.SqreenError{}))
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
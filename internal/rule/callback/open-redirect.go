@@ -0,0 +1,150 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/sqreen/go-agent/internal/event"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+)
+
+// Default redirection target replacing the open redirections.
+const defaultOpenRedirectURL = "/"
+
+// NewOpenRedirectProtectionCallback returns the native prolog callback to be
+// attached to `net/http.Redirect()`. Redirections to hosts other than the
+// request host and the allowed hosts are reported as attacks when the target
+// comes from the request parameters. In blocking mode, the redirection target
+// is replaced by the configured default URL instead of blocking the request.
+func NewOpenRedirectProtectionCallback(r RuleContext, cfg NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	sqassert.NotNil(cfg)
+
+	data, ok := cfg.Data().(*api.OpenRedirectProtectionRuleDataEntry)
+	if !ok {
+		return nil, sqerrors.Errorf("unexpected callback data type: got `%T` instead of `%T`", cfg.Data(), data)
+	}
+
+	allowedHosts, err := newAllowedHosts(data.AllowedHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	defaultURL := data.DefaultURL
+	if defaultURL == "" {
+		defaultURL = defaultOpenRedirectURL
+	} else if _, err := url.Parse(defaultURL); err != nil {
+		return nil, sqerrors.Wrap(err, "unexpected default url")
+	}
+
+	return newOpenRedirectProtectionPrologCallback(r, cfg.BlockingMode(), allowedHosts, defaultURL), nil
+}
+
+type OpenRedirectProtectionPrologCallbackType = func(*http.ResponseWriter, **http.Request, *string, *int) (OpenRedirectProtectionEpilogCallbackType, error)
+type OpenRedirectProtectionEpilogCallbackType = func()
+
+type OpenRedirectAttackInfo struct {
+	Location string `json:"location"`
+	Host     string `json:"host"`
+	Source   string `json:"source"`
+}
+
+func newOpenRedirectProtectionPrologCallback(r RuleContext, blockingMode bool, allowedHosts allowedHosts, defaultURL string) OpenRedirectProtectionPrologCallbackType {
+	return func(_ *http.ResponseWriter, req **http.Request, location *string, _ *int) (OpenRedirectProtectionEpilogCallbackType, error) {
+		sqassert.NotNil(req)
+		sqassert.NotNil(location)
+
+		host := redirectionHost(*location)
+		if host == "" || allowedHosts.isAllowed(host, (*req).Host) {
+			return nil, nil
+		}
+
+		r.Pre(func(c CallbackContext) error {
+			tracker := taintTracker(c.ProtectionContext())
+			src, tainted := tracker.IsTainted(host)
+			if !tainted {
+				// The tainted value must include the host to control it
+				src, tainted = tracker.IsTainted(*location)
+				tainted = tainted && strings.Contains(strings.ToLower(src.Value), host)
+			}
+			if !tainted {
+				return nil
+			}
+
+			info := OpenRedirectAttackInfo{Location: *location, Host: host, Source: src.Name}
+			c.HandleAttack(false, event.WithAttackInfo(info), event.WithStackTrace(), event.WithBlocked(blockingMode))
+			if blockingMode {
+				c.Logger().Debugf("open redirection to `%s` replaced by `%s`", host, defaultURL)
+				*location = defaultURL
+			}
+			return nil
+		})
+		return nil, nil
+	}
+}
+
+// redirectionHost returns the lowercase host the browser is redirected to
+// by the given location, or an empty string for relative locations. Browsers
+// interpret backslashes like slashes, so that `/\evil.com` is a redirection to
+// `evil.com`.
+func redirectionHost(location string) string {
+	location = strings.TrimLeft(location, " \t\r\n")
+	location = strings.Replace(location, `\`, "/", -1)
+	if strings.HasPrefix(location, "//") {
+		// Browsers ignore the extra slashes of `///evil.com`
+		location = "//" + strings.TrimLeft(location, "/")
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		// http.Redirect() writes the location as-is when it cannot be parsed
+		return lenientRedirectionHost(location)
+	}
+	host := u.Host
+	if host == "" && u.Opaque != "" && (u.Scheme == "http" || u.Scheme == "https") {
+		// Browsers also accept locations such as `https:evil.com`
+		host = authorityHost(u.Opaque)
+	}
+	return strings.ToLower(host)
+}
+
+// lenientRedirectionHost returns the host of a location which cannot be
+// parsed by url.Parse(), such as `https://evil.com/%zz`, by only looking for
+// the scheme or `//` prefix.
+func lenientRedirectionHost(location string) string {
+	if strings.HasPrefix(location, "//") {
+		return strings.ToLower(authorityHost(location))
+	}
+	i := strings.Index(location, ":")
+	if i <= 0 || strings.IndexAny(location[:i], "/?#") != -1 {
+		// Relative location without scheme
+		return ""
+	}
+	scheme := strings.ToLower(location[:i])
+	if scheme != "http" && scheme != "https" {
+		return ""
+	}
+	return strings.ToLower(authorityHost(location[i+1:]))
+}
+
+// authorityHost returns the host of the authority starting the given string
+// after its leading slashes, without its user information.
+func authorityHost(s string) string {
+	host := strings.TrimLeft(s, "/")
+	if i := strings.IndexAny(host, "/?#"); i != -1 {
+		host = host[:i]
+	}
+	if i := strings.LastIndex(host, "@"); i != -1 {
+		host = host[i+1:]
+	}
+	return host
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/sqreen/go-agent/internal/event"
+	"github.com/sqreen/go-agent/internal/plog"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenRedirectProtectionCallback(t *testing.T) {
+	t.Run("Configuration errors", func(t *testing.T) {
+		for _, tc := range []interface{}{
+			nil,
+			33,
+			&api.OpenRedirectProtectionRuleDataEntry{AllowedHosts: []string{""}},
+			&api.OpenRedirectProtectionRuleDataEntry{DefaultURL: "%"},
+		} {
+			tc := tc
+			t.Run("", func(t *testing.T) {
+				cfg := &mockups.NativeCallbackConfigMockup{}
+				cfg.ExpectData().Return(tc)
+				defer cfg.AssertExpectations(t)
+
+				_, err := callback.NewOpenRedirectProtectionCallback(&mockups.NativeRuleContextMockup{}, cfg)
+				require.Error(t, err)
+			})
+		}
+	})
+
+	data := &api.OpenRedirectProtectionRuleDataEntry{
+		AllowedHosts: []string{"allowed.com"},
+		DefaultURL:   "/home",
+	}
+
+	for _, tc := range []struct {
+		name     string
+		target   string
+		location string
+		// checked is true when the location host is not allowed so that the
+		// request parameters are checked.
+		checked  bool
+		expected *callback.OpenRedirectAttackInfo
+	}{
+		{
+			name:     "relative location",
+			target:   "/login?next=/account",
+			location: "/account",
+		},
+		{
+			name:     "request host",
+			target:   "http://example.com/login?next=http://example.com/account",
+			location: "http://example.com/account",
+		},
+		{
+			name:     "allowed host",
+			target:   "/login?next=https://allowed.com/account",
+			location: "https://allowed.com/account",
+		},
+		{
+			name:     "host not from the request parameters",
+			target:   "/login",
+			location: "https://other.com/account",
+			checked:  true,
+		},
+		{
+			name:     "open redirect",
+			target:   "/login?next=https://evil.com/account",
+			location: "https://evil.com/account",
+			checked:  true,
+			expected: &callback.OpenRedirectAttackInfo{Location: "https://evil.com/account", Host: "evil.com", Source: "query"},
+		},
+		{
+			name:     "open redirect to a tainted host",
+			target:   "/login?host=evil.com",
+			location: "https://evil.com/account",
+			checked:  true,
+			expected: &callback.OpenRedirectAttackInfo{Location: "https://evil.com/account", Host: "evil.com", Source: "query"},
+		},
+		{
+			name:     "protocol-relative open redirect",
+			target:   `/login?next=/\/evil.com`,
+			location: `/\/evil.com`,
+			checked:  true,
+			expected: &callback.OpenRedirectAttackInfo{Location: `/\/evil.com`, Host: "evil.com", Source: "query"},
+		},
+		{
+			name:     "unparsable open redirect",
+			target:   "/login?next=https://evil.com/%25zz",
+			location: "https://evil.com/%zz",
+			checked:  true,
+			expected: &callback.OpenRedirectAttackInfo{Location: "https://evil.com/%zz", Host: "evil.com", Source: "query"},
+		},
+		{
+			name:     "unparsable protocol-relative open redirect",
+			target:   "/login?next=//evil.com/%25zz",
+			location: "//evil.com/%zz",
+			checked:  true,
+			expected: &callback.OpenRedirectAttackInfo{Location: "//evil.com/%zz", Host: "evil.com", Source: "query"},
+		},
+		{
+			name:     "unparsable backslash open redirect",
+			target:   "/login?next=/%5Cevil.com/%25zz",
+			location: `/\evil.com/%zz`,
+			checked:  true,
+			expected: &callback.OpenRedirectAttackInfo{Location: `/\evil.com/%zz`, Host: "evil.com", Source: "query"},
+		},
+		{
+			name:     "unparsable relative location",
+			target:   "/login?next=/account/%25zz",
+			location: "/account/%zz",
+		},
+		{
+			name:     "open redirect without slashes",
+			target:   "/login?next=https:evil.com",
+			location: "https:evil.com",
+			checked:  true,
+			expected: &callback.OpenRedirectAttackInfo{Location: "https:evil.com", Host: "evil.com", Source: "query"},
+		},
+	} {
+		tc := tc
+		for _, blocking := range []bool{false, true} {
+			blocking := blocking
+			t.Run(tc.name, func(t *testing.T) {
+				r := &mockups.NativeRuleContextMockup{}
+				defer r.AssertExpectations(t)
+
+				cfg := &mockups.NativeCallbackConfigMockup{}
+				defer cfg.AssertExpectations(t)
+				cfg.ExpectData().Return(data)
+				cfg.ExpectBlockingMode().Return(blocking)
+
+				cb, err := callback.NewOpenRedirectProtectionCallback(r, cfg)
+				require.NoError(t, err)
+				prolog, ok := cb.(callback.OpenRedirectProtectionPrologCallbackType)
+				require.True(t, ok)
+
+				req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+				p := http_protection.NewTestProtectionContext(nil, net.IPv4(1, 2, 3, 4), nil, &requestReader{req: req})
+
+				if tc.checked {
+					// The callback is only called once since the matcher is called again
+					// by AssertExpectations()
+					var called bool
+					r.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+						if called {
+							return true
+						}
+						called = true
+						c := &mockups.CallbackContextMockup{}
+						defer c.AssertExpectations(t)
+						c.ExpectProtectionContext().Return(p)
+						if tc.expected != nil {
+							c.ExpectHandleAttack(false, mock.MatchedBy(func(opts []event.AttackEventOption) bool {
+								var attack event.AttackEvent
+								for _, opt := range opts {
+									opt(&attack)
+								}
+								require.Equal(t, *tc.expected, attack.Info)
+								require.Equal(t, blocking, attack.Blocked)
+								return true
+							})).Return(false).Once()
+							c.On("Logger").Return(plog.NewLogger(plog.Disabled, nil, nil)).Maybe()
+						}
+						require.NoError(t, cb(c))
+						return true
+					})).Once()
+				}
+
+				location := tc.location
+				var w http.ResponseWriter = httptest.NewRecorder()
+				code := http.StatusFound
+				epilog, err := prolog(&w, &req, &location, &code)
+				require.NoError(t, err)
+				require.Nil(t, epilog)
+
+				if tc.expected != nil && blocking {
+					require.Equal(t, data.DefaultURL, location)
+				} else {
+					require.Equal(t, tc.location, location)
+				}
+			})
+		}
+	}
+}
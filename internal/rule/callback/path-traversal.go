@@ -0,0 +1,90 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sqreen/go-agent/internal/event"
+	"github.com/sqreen/go-agent/internal/protection/taint"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	"github.com/sqreen/go-agent/sdk/types"
+)
+
+// Path traversal reasons.
+const PathTraversalTaintedPath = "file path from request parameters"
+
+// NewPathTraversalCallback returns the native prolog callback to be attached
+// to `os.OpenFile()`, also called by `os.Open()` and `os.Create()`. The opened
+// file path is checked against the taint of the request inputs: an attack is
+// reported when it includes a request parameter that is an absolute path or
+// that has parent directory elements or null bytes. The file opening is
+// aborted according to the rule blocking mode.
+func NewPathTraversalCallback(r RuleContext, cfg NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	sqassert.NotNil(cfg)
+	return newPathTraversalPrologCallback(r), nil
+}
+
+type PathTraversalPrologCallbackType = func(name *string, flag *int, perm *os.FileMode) (PathTraversalEpilogCallbackType, error)
+type PathTraversalEpilogCallbackType = func(**os.File, *error)
+
+type PathTraversalAttackInfo struct {
+	Reason string `json:"reason"`
+	Source string `json:"source"`
+}
+
+type PathTraversalError struct {
+	Reason string
+}
+
+func (e PathTraversalError) Error() string {
+	return fmt.Sprintf("path traversal: %s", e.Reason)
+}
+
+func newPathTraversalPrologCallback(r RuleContext) PathTraversalPrologCallbackType {
+	return func(name *string, _ *int, _ *os.FileMode) (epilog PathTraversalEpilogCallbackType, prologErr error) {
+		r.Pre(func(c CallbackContext) error {
+			tracker := taintTracker(c.ProtectionContext())
+			if tracker.Len() == 0 {
+				return nil
+			}
+
+			info, injected := checkPath(tracker, *name)
+			if !injected {
+				return nil
+			}
+
+			if blocked := c.HandleAttack(true, event.WithAttackInfo(info), event.WithStackTrace()); !blocked {
+				return nil
+			}
+			epilog = func(_ **os.File, callErr *error) {
+				*callErr = types.SqreenError{Err: PathTraversalError{Reason: info.Reason}}
+			}
+			prologErr = sqhook.AbortError
+			return nil
+		})
+		return
+	}
+}
+
+func checkPath(tracker *taint.Tracker, path string) (info PathTraversalAttackInfo, injected bool) {
+	// Windows path separators are also checked whatever the current OS is.
+	traverses := func(src taint.Source) bool {
+		v := src.Value
+		return strings.HasPrefix(v, "/") || strings.HasPrefix(v, `\`) ||
+			strings.Contains(v, "../") || strings.Contains(v, `..\`) || strings.HasSuffix(v, "..") ||
+			strings.IndexByte(v, 0) != -1
+	}
+	if src, tainted := tracker.IsTaintedBy(path, traverses); tainted {
+		return PathTraversalAttackInfo{Reason: PathTraversalTaintedPath, Source: src.Name}, true
+	}
+	return info, false
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	"github.com/sqreen/go-agent/sdk/types"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestPathTraversalCallback(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		target   string
+		path     string
+		expected *callback.PathTraversalAttackInfo
+	}{
+		{
+			name:   "untainted path",
+			target: "/?file=report.pdf",
+			path:   "/var/www/uploads/invoice.pdf",
+		},
+		{
+			name:   "tainted file name",
+			target: "/?file=report.pdf",
+			path:   "/var/www/uploads/report.pdf",
+		},
+		{
+			name:     "parent directory",
+			target:   "/?file=../../etc/passwd",
+			path:     "/var/www/uploads/../../etc/passwd",
+			expected: &callback.PathTraversalAttackInfo{Reason: callback.PathTraversalTaintedPath, Source: "query"},
+		},
+		{
+			name:     "windows parent directory",
+			target:   "/?file=..%5C..%5Cwin.ini",
+			path:     `C:\www\uploads\..\..\win.ini`,
+			expected: &callback.PathTraversalAttackInfo{Reason: callback.PathTraversalTaintedPath, Source: "query"},
+		},
+		{
+			name:     "absolute path",
+			target:   "/?file=/etc/passwd",
+			path:     "/etc/passwd",
+			expected: &callback.PathTraversalAttackInfo{Reason: callback.PathTraversalTaintedPath, Source: "query"},
+		},
+		{
+			name:     "null byte",
+			target:   "/?file=passwd%00.pdf",
+			path:     "/etc/passwd\x00.pdf",
+			expected: &callback.PathTraversalAttackInfo{Reason: callback.PathTraversalTaintedPath, Source: "query"},
+		},
+	} {
+		tc := tc
+		for _, blocking := range []bool{false, true} {
+			blocking := blocking
+			t.Run(tc.name, func(t *testing.T) {
+				r := &mockups.NativeRuleContextMockup{}
+				defer r.AssertExpectations(t)
+
+				cb, err := callback.NewPathTraversalCallback(r, &mockups.NativeCallbackConfigMockup{})
+				require.NoError(t, err)
+				prolog, ok := cb.(callback.PathTraversalPrologCallbackType)
+				require.True(t, ok)
+
+				req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+				p := http_protection.NewTestProtectionContext(nil, net.IPv4(1, 2, 3, 4), nil, &requestReader{req: req})
+
+				// The callback is only called once since the matcher is called again
+				// by AssertExpectations()
+				var called bool
+				r.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+					if called {
+						return true
+					}
+					called = true
+					c := &mockups.CallbackContextMockup{}
+					defer c.AssertExpectations(t)
+					c.ExpectProtectionContext().Return(p)
+					if tc.expected != nil {
+						c.ExpectHandleAttack(true, mock.MatchedBy(func(opts []event.AttackEventOption) bool {
+							var attack event.AttackEvent
+							for _, opt := range opts {
+								opt(&attack)
+							}
+							require.Equal(t, *tc.expected, attack.Info)
+							return true
+						})).Return(blocking).Once()
+					}
+					require.NoError(t, cb(c))
+					return true
+				})).Once()
+
+				name, flag, perm := tc.path, os.O_RDONLY, os.FileMode(0)
+				epilog, err := prolog(&name, &flag, &perm)
+				if tc.expected == nil || !blocking {
+					require.NoError(t, err)
+					require.Nil(t, epilog)
+					return
+				}
+
+				require.Equal(t, sqhook.AbortError, err)
+				require.NotNil(t, epilog)
+				var callErr error
+				epilog(nil, &callErr)
+				var sqErr types.SqreenError
+				require.True(t, xerrors.As(callErr, &sqErr))
+				var traversalErr callback.PathTraversalError
+				require.True(t, xerrors.As(sqErr.Err, &traversalErr))
+				require.Equal(t, tc.expected.Reason, traversalErr.Reason)
+			})
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	sdk_types "github.com/sqreen/go-agent/sdk/types"
+	"golang.org/x/net/http/httpguts"
+)
+
+// ProtocolAnomalyAttackType is the attack type of the protocol anomalies,
+// regardless of the attack type of the rule.
+const ProtocolAnomalyAttackType = "protocol_anomaly"
+
+// Default maximum number of request header values.
+const defaultMaxRequestHeaders = 100
+
+// Protocol anomaly reasons.
+const (
+	ProtocolAnomalyInvalidMethod     = "invalid method"
+	ProtocolAnomalyUnexpectedMethod  = "unexpected method"
+	ProtocolAnomalyTooManyHeaders    = "too many headers"
+	ProtocolAnomalyInvalidHeader     = "invalid header"
+	ProtocolAnomalyConflictingLength = "conflicting content-length and transfer-encoding"
+)
+
+// Methods defined by the HTTP and WebDAV specifications.
+var knownHTTPMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodConnect,
+	http.MethodOptions,
+	http.MethodTrace,
+	"PROPFIND",
+	"PROPPATCH",
+	"MKCOL",
+	"COPY",
+	"MOVE",
+	"LOCK",
+	"UNLOCK",
+}
+
+// NewProtocolAnomalyCallback returns the native prolog callback to be attached
+// to the HTTP protection hookpoint `protocolAnomaly()` called by `Before()`.
+// The request is checked for conflicting `Content-Length` and
+// `Transfer-Encoding` headers, too many headers, unexpected methods and control
+// characters such as CRLF in the method or header names and values.
+// Anomalies are reported as attacks of type `protocol_anomaly` and blocked
+// according to the rule blocking mode, except unexpected methods which are
+// valid and only monitored. The rule data is optional.
+// Note that the Go HTTP server accepts the requests having both the
+// `Content-Length` and `Transfer-Encoding` headers but removes them from the
+// request headers, which the protection context restores when the program is
+// instrumented, cf. http_protection.HookRequestTransfer(). It otherwise
+// rejects the other request smuggling anomalies, such as duplicate `Host` or
+// conflicting `Content-Length` headers, before any request handler gets
+// called, so that they cannot be reported.
+func NewProtocolAnomalyCallback(r RuleContext, cfg NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	sqassert.NotNil(cfg)
+
+	check := &protocolAnomalyCheck{
+		maxHeaders: defaultMaxRequestHeaders,
+		methods:    make(map[string]struct{}, len(knownHTTPMethods)),
+	}
+	for _, method := range knownHTTPMethods {
+		check.methods[method] = struct{}{}
+	}
+
+	switch data := cfg.Data().(type) {
+	case nil:
+	case *api.ProtocolAnomalyRuleDataEntry:
+		if data.MaxHeaders < 0 {
+			return nil, sqerrors.Errorf("unexpected negative maximum number of headers `%d`", data.MaxHeaders)
+		} else if data.MaxHeaders > 0 {
+			check.maxHeaders = data.MaxHeaders
+		}
+		for _, method := range data.AllowedMethods {
+			if !httpguts.ValidHeaderFieldName(method) {
+				return nil, sqerrors.Errorf("unexpected invalid allowed method `%s`", method)
+			}
+			check.methods[method] = struct{}{}
+		}
+	default:
+		return nil, sqerrors.Errorf("unexpected callback data type: got `%T` instead of `%T`", data, (*api.ProtocolAnomalyRuleDataEntry)(nil))
+	}
+
+	return newProtocolAnomalyPrologCallback(r, check), nil
+}
+
+type ProtocolAnomalyPrologCallbackType = http_protection.BlockingPrologCallbackType
+type ProtocolAnomalyEpilogCallbackType = http_protection.BlockingEpilogCallbackType
+
+type ProtocolAnomalyAttackInfo struct {
+	Reason string `json:"reason"`
+	Header string `json:"header,omitempty"`
+}
+
+type ProtocolAnomalyError struct {
+	ProtocolAnomalyAttackInfo
+}
+
+func (e ProtocolAnomalyError) Error() string {
+	if e.Header == "" {
+		return fmt.Sprintf("http protocol anomaly: %s", e.Reason)
+	}
+	return fmt.Sprintf("http protocol anomaly: %s `%s`", e.Reason, e.Header)
+}
+
+func newProtocolAnomalyPrologCallback(r RuleContext, check *protocolAnomalyCheck) ProtocolAnomalyPrologCallbackType {
+	return func(p **http_protection.ProtectionContext) (epilog ProtocolAnomalyEpilogCallbackType, prologErr error) {
+		r.Pre(func(c CallbackContext) error {
+			info, anomaly := check.check((*p).RequestReader)
+			if !anomaly {
+				return nil
+			}
+
+			// Unexpected methods are valid and not blocked
+			shouldBlock := info.Reason != ProtocolAnomalyUnexpectedMethod
+			if blocked := c.HandleAttack(shouldBlock, event.WithAttackInfo(info), event.WithAttackType(ProtocolAnomalyAttackType)); !blocked {
+				return nil
+			}
+
+			epilog = func(e *error) {
+				sqassert.NotNil(e)
+				err := sdk_types.SqreenError{
+					Err: ProtocolAnomalyError{ProtocolAnomalyAttackInfo: info},
+				}
+				// Display the error message explaining why the request is denied.
+				c.Logger().Debug(err.Error())
+				*e = err
+			}
+			return nil
+		})
+		return
+	}
+}
+
+type protocolAnomalyCheck struct {
+	maxHeaders int
+	methods    map[string]struct{}
+}
+
+func (a *protocolAnomalyCheck) check(r types.RequestReader) (info ProtocolAnomalyAttackInfo, anomaly bool) {
+	method := r.Method()
+	if !httpguts.ValidHeaderFieldName(method) {
+		return ProtocolAnomalyAttackInfo{Reason: ProtocolAnomalyInvalidMethod}, true
+	}
+
+	headers := r.Headers()
+	if len(headers["Content-Length"]) > 0 && len(headers["Transfer-Encoding"]) > 0 {
+		return ProtocolAnomalyAttackInfo{Reason: ProtocolAnomalyConflictingLength}, true
+	}
+
+	count := 0
+	for name, values := range headers {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return ProtocolAnomalyAttackInfo{Reason: ProtocolAnomalyInvalidHeader, Header: name}, true
+		}
+		for _, v := range values {
+			if !httpguts.ValidHeaderFieldValue(v) {
+				return ProtocolAnomalyAttackInfo{Reason: ProtocolAnomalyInvalidHeader, Header: name}, true
+			}
+		}
+		count += len(values)
+	}
+	if count > a.maxHeaders {
+		return ProtocolAnomalyAttackInfo{Reason: ProtocolAnomalyTooManyHeaders}, true
+	}
+
+	// Checked last since only monitored
+	if _, known := a.methods[method]; !known {
+		return ProtocolAnomalyAttackInfo{Reason: ProtocolAnomalyUnexpectedMethod}, true
+	}
+	return info, false
+}
@@ -0,0 +1,255 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/sqreen/go-agent/internal/event"
+	"github.com/sqreen/go-agent/internal/plog"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	"github.com/sqreen/go-agent/sdk/types"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestProtocolAnomalyCallback(t *testing.T) {
+	t.Run("Configuration errors", func(t *testing.T) {
+		for _, tc := range []interface{}{
+			33,
+			&api.ProtocolAnomalyRuleDataEntry{MaxHeaders: -1},
+			&api.ProtocolAnomalyRuleDataEntry{AllowedMethods: []string{"GET POST"}},
+		} {
+			tc := tc
+			t.Run("", func(t *testing.T) {
+				cfg := &mockups.NativeCallbackConfigMockup{}
+				cfg.ExpectData().Return(tc)
+				defer cfg.AssertExpectations(t)
+
+				_, err := callback.NewProtocolAnomalyCallback(&mockups.NativeRuleContextMockup{}, cfg)
+				require.Error(t, err)
+			})
+		}
+	})
+
+	data := &api.ProtocolAnomalyRuleDataEntry{
+		MaxHeaders:     10,
+		AllowedMethods: []string{"PURGE"},
+	}
+
+	newRequest := func(method string, headers http.Header) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://my.site/", nil)
+		req.Method = method
+		for k, v := range headers {
+			req.Header[k] = v
+		}
+		return req
+	}
+
+	tooManyHeaders := http.Header{}
+	for i := 0; i <= data.MaxHeaders; i++ {
+		tooManyHeaders.Set(fmt.Sprintf("X-Header-%d", i), "value")
+	}
+
+	// run runs the callback with the given request and checks the expected
+	// anomaly is reported, and blocked unless only monitored.
+	run := func(t *testing.T, req *http.Request, expected *callback.ProtocolAnomalyAttackInfo, monitored, blocking bool) {
+		cfg := &mockups.NativeCallbackConfigMockup{}
+		cfg.ExpectData().Return(data)
+		defer cfg.AssertExpectations(t)
+
+		r := &mockups.NativeRuleContextMockup{}
+		defer r.AssertExpectations(t)
+
+		cb, err := callback.NewProtocolAnomalyCallback(r, cfg)
+		require.NoError(t, err)
+		prolog, ok := cb.(callback.ProtocolAnomalyPrologCallbackType)
+		require.True(t, ok)
+
+		p := http_protection.NewTestProtectionContext(nil, net.IPv4(1, 2, 3, 4), nil, &requestReader{req: req})
+
+		blocked := blocking && !monitored
+		// The callback is only called once since the matcher is called again by
+		// AssertExpectations()
+		var called bool
+		r.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+			if called {
+				return true
+			}
+			called = true
+			c := &mockups.CallbackContextMockup{}
+			defer c.AssertExpectations(t)
+			if expected != nil {
+				c.ExpectHandleAttack(!monitored, mock.MatchedBy(func(opts []event.AttackEventOption) bool {
+					var attack event.AttackEvent
+					for _, opt := range opts {
+						opt(&attack)
+					}
+					require.Equal(t, *expected, attack.Info)
+					require.Equal(t, callback.ProtocolAnomalyAttackType, attack.AttackType)
+					return true
+				})).Return(blocked).Once()
+				c.On("Logger").Return(plog.NewLogger(plog.Disabled, nil, nil)).Maybe()
+			}
+			require.NoError(t, cb(c))
+			return true
+		})).Once()
+
+		epilog, err := prolog(&p)
+		require.NoError(t, err)
+		if expected == nil || !blocked {
+			require.Nil(t, epilog)
+			return
+		}
+
+		require.NotNil(t, epilog)
+		var blockErr error
+		epilog(&blockErr)
+		var sqErr types.SqreenError
+		require.True(t, xerrors.As(blockErr, &sqErr))
+		var anomalyErr callback.ProtocolAnomalyError
+		require.True(t, xerrors.As(sqErr.Err, &anomalyErr))
+		require.Equal(t, expected.Reason, anomalyErr.Reason)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		req       *http.Request
+		expected  *callback.ProtocolAnomalyAttackInfo
+		monitored bool
+	}{
+		{
+			name: "regular request",
+			req:  newRequest("POST", http.Header{"Content-Length": {"42"}, "Content-Type": {"application/json"}}),
+		},
+		{
+			name: "allowed method",
+			req:  newRequest("PURGE", nil),
+		},
+		{
+			name:     "invalid method",
+			req:      newRequest("GET /", nil),
+			expected: &callback.ProtocolAnomalyAttackInfo{Reason: callback.ProtocolAnomalyInvalidMethod},
+		},
+		{
+			name:      "unexpected method",
+			req:       newRequest("GPOST", nil),
+			expected:  &callback.ProtocolAnomalyAttackInfo{Reason: callback.ProtocolAnomalyUnexpectedMethod},
+			monitored: true,
+		},
+		{
+			name:     "unexpected method with too many headers",
+			req:      newRequest("GPOST", tooManyHeaders),
+			expected: &callback.ProtocolAnomalyAttackInfo{Reason: callback.ProtocolAnomalyTooManyHeaders},
+		},
+		{
+			name:     "content length and transfer encoding",
+			req:      newRequest("POST", http.Header{"Content-Length": {"3"}, "Transfer-Encoding": {"chunked"}}),
+			expected: &callback.ProtocolAnomalyAttackInfo{Reason: callback.ProtocolAnomalyConflictingLength},
+		},
+		{
+			name:     "too many headers",
+			req:      newRequest("GET", tooManyHeaders),
+			expected: &callback.ProtocolAnomalyAttackInfo{Reason: callback.ProtocolAnomalyTooManyHeaders},
+		},
+		{
+			name:     "crlf in header value",
+			req:      newRequest("GET", http.Header{"X-Forwarded-For": {"1.2.3.4\r\nX-Injected: 1"}}),
+			expected: &callback.ProtocolAnomalyAttackInfo{Reason: callback.ProtocolAnomalyInvalidHeader, Header: "X-Forwarded-For"},
+		},
+		{
+			name:     "invalid header name",
+			req:      newRequest("GET", http.Header{"X-Header\r\n": {"value"}}),
+			expected: &callback.ProtocolAnomalyAttackInfo{Reason: callback.ProtocolAnomalyInvalidHeader, Header: "X-Header\r\n"},
+		},
+	} {
+		tc := tc
+		for _, blocking := range []bool{false, true} {
+			blocking := blocking
+			t.Run(tc.name, func(t *testing.T) {
+				run(t, tc.req, tc.expected, tc.monitored, blocking)
+			})
+		}
+	}
+
+	t.Run("Requests read by net/http", func(t *testing.T) {
+		var rawTooManyHeaders strings.Builder
+		rawTooManyHeaders.WriteString("GET / HTTP/1.1\r\nHost: my.site\r\n")
+		for i := 0; i <= data.MaxHeaders; i++ {
+			fmt.Fprintf(&rawTooManyHeaders, "X-Header-%d: value\r\n", i)
+		}
+		rawTooManyHeaders.WriteString("\r\n")
+
+		for _, tc := range []struct {
+			name      string
+			raw       string
+			expected  *callback.ProtocolAnomalyAttackInfo
+			monitored bool
+		}{
+			{
+				name: "chunked request",
+				raw:  "POST / HTTP/1.1\r\nHost: my.site\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+			},
+			{
+				// The Content-Length header is removed by net/http and only restored
+				// by the protection context of instrumented programs
+				name: "content length and transfer encoding",
+				raw:  "POST / HTTP/1.1\r\nHost: my.site\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+			},
+			{
+				name:      "unexpected method",
+				raw:       "GPOST / HTTP/1.1\r\nHost: my.site\r\n\r\n",
+				expected:  &callback.ProtocolAnomalyAttackInfo{Reason: callback.ProtocolAnomalyUnexpectedMethod},
+				monitored: true,
+			},
+			{
+				name:     "too many headers",
+				raw:      rawTooManyHeaders.String(),
+				expected: &callback.ProtocolAnomalyAttackInfo{Reason: callback.ProtocolAnomalyTooManyHeaders},
+			},
+		} {
+			tc := tc
+			for _, blocking := range []bool{false, true} {
+				blocking := blocking
+				t.Run(tc.name, func(t *testing.T) {
+					req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(tc.raw)))
+					require.NoError(t, err)
+					run(t, req, tc.expected, tc.monitored, blocking)
+				})
+			}
+		}
+
+		t.Run("rejected anomalies", func(t *testing.T) {
+			for _, raw := range []string{
+				"GET / HTTP/1.1\r\nHost: my.site\r\nHost: evil.com\r\n\r\n",
+				"POST / HTTP/1.1\r\nHost: my.site\r\nContent-Length: 42\r\nContent-Length: 0\r\n\r\n",
+				"POST / HTTP/1.1\r\nHost: my.site\r\nContent-Length: -1\r\n\r\n",
+				"POST / HTTP/1.1\r\nHost: my.site\r\nTransfer-Encoding: chunked, identity\r\n\r\n0\r\n\r\n",
+			} {
+				_, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+				require.Error(t, err)
+			}
+		})
+	})
+
+	t.Run("Default configuration", func(t *testing.T) {
+		cfg := &mockups.NativeCallbackConfigMockup{}
+		cfg.ExpectData().Return(nil)
+		defer cfg.AssertExpectations(t)
+
+		_, err := callback.NewProtocolAnomalyCallback(&mockups.NativeRuleContextMockup{}, cfg)
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/sqreen/go-agent/internal/event"
+	"github.com/sqreen/go-agent/internal/protection/taint"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	"github.com/sqreen/go-agent/sdk/types"
+)
+
+// SQL injection reasons.
+const SQLInjectionTaintedQuery = "sql query from request parameters"
+
+// SQL metacharacters allowing to change the structure of SQL queries: the
+// string literal quotes, the statement separator, the escape character and
+// the characters of the boolean conditions injected in numeric values, along
+// with the comment sequences.
+const sqlMetacharacters = "'\"`;\\=("
+
+var sqlComments = []string{"--", "/*"}
+
+// NewSQLInjectionCallback returns the reflected callback to be attached to the
+// query, execution and statement preparation methods of package
+// `database/sql`, whose SQL query is their first string argument. The query
+// is checked against the taint of the request inputs: an attack is reported
+// when it includes a request parameter having SQL metacharacters. The function
+// call is aborted according to the rule blocking mode and strategy.
+func NewSQLInjectionCallback(r RuleContext, cfg ReflectedCallbackConfig) (sqhook.ReflectedPrologCallback, error) {
+	sqassert.NotNil(r)
+	strategy := cfg.Strategy()
+	if strategy == nil || strategy.Protection == nil {
+		return nil, sqerrors.New("unexpected nil protection strategy")
+	}
+
+	return func(params []reflect.Value) (epilog sqhook.ReflectedEpilogCallback, prologErr error) {
+		query, ok := sqlQueryParam(params)
+		if !ok {
+			return nil, nil
+		}
+
+		r := withCallParams(r, params)
+		r.Pre(func(c CallbackContext) error {
+			tracker := taintTracker(c.ProtectionContext())
+			if tracker.Len() == 0 {
+				return nil
+			}
+
+			info, injected := checkSQLQuery(tracker, query)
+			if !injected {
+				return nil
+			}
+
+			if blocked := c.HandleAttack(true, event.WithAttackInfo(info), event.WithStackTrace()); !blocked {
+				return nil
+			}
+			epilog = func(results []reflect.Value) {
+				abortErr := types.SqreenError{Err: SQLInjectionError{Reason: info.Reason}}
+				errorIndex := strategy.Protection.BlockStrategy.RetIndex
+				results[errorIndex].Elem().Set(reflect.ValueOf(abortErr))
+			}
+			prologErr = sqhook.AbortError
+			return nil
+		})
+		return
+	}, nil
+}
+
+type SQLInjectionAttackInfo struct {
+	Reason string `json:"reason"`
+	Source string `json:"source"`
+}
+
+type SQLInjectionError struct {
+	Reason string
+}
+
+func (e SQLInjectionError) Error() string {
+	return fmt.Sprintf("sql injection: %s", e.Reason)
+}
+
+// sqlQueryParam returns the first string argument of the hooked function call.
+func sqlQueryParam(params []reflect.Value) (query string, ok bool) {
+	for _, p := range params {
+		if p.Kind() == reflect.Ptr && !p.IsNil() && p.Elem().Kind() == reflect.String {
+			return p.Elem().String(), true
+		}
+	}
+	return "", false
+}
+
+func checkSQLQuery(tracker *taint.Tracker, query string) (info SQLInjectionAttackInfo, injected bool) {
+	hasMetacharacters := func(src taint.Source) bool {
+		if strings.ContainsAny(src.Value, sqlMetacharacters) {
+			return true
+		}
+		for _, comment := range sqlComments {
+			if strings.Contains(src.Value, comment) {
+				return true
+			}
+		}
+		return false
+	}
+	if src, tainted := tracker.IsTaintedBy(query, hasMetacharacters); tainted {
+		return SQLInjectionAttackInfo{Reason: SQLInjectionTaintedQuery, Source: src.Name}, true
+	}
+	return info, false
+}
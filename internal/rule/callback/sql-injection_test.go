@@ -0,0 +1,154 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	"github.com/sqreen/go-agent/sdk/types"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+type sqlInjectionCallbackConfig struct{}
+
+func (sqlInjectionCallbackConfig) BlockingMode() bool { return true }
+func (sqlInjectionCallbackConfig) Data() interface{}  { return nil }
+func (sqlInjectionCallbackConfig) Strategy() *api.ReflectedCallbackConfig {
+	// The error is the second result of `(*sql.DB).QueryContext()`
+	return &api.ReflectedCallbackConfig{
+		Protection: &api.ReflectedCallbackProtectionConfig{
+			ReflectedCallbackHTTPProtectionConfig: api.ReflectedCallbackHTTPProtectionConfig{
+				BlockStrategy: api.ReflectedCallbackBlockStrategyConfig{
+					ReflectedCallbackBlockStrategyReturnFunctionErrorConfig: api.ReflectedCallbackBlockStrategyReturnFunctionErrorConfig{RetIndex: 1},
+				},
+			},
+		},
+	}
+}
+func (sqlInjectionCallbackConfig) PrologFuncType() reflect.Type { return nil }
+
+func TestSQLInjectionCallback(t *testing.T) {
+	t.Run("unexpected configuration", func(t *testing.T) {
+		_, err := callback.NewSQLInjectionCallback(&mockups.NativeRuleContextMockup{}, jsCallbackConfig{})
+		require.Error(t, err)
+	})
+
+	for _, tc := range []struct {
+		name     string
+		target   string
+		query    string
+		expected *callback.SQLInjectionAttackInfo
+	}{
+		{
+			name:   "untainted query",
+			target: "/?name=alice",
+			query:  "SELECT * FROM users WHERE name = 'bob'",
+		},
+		{
+			name:   "tainted value without metacharacters",
+			target: "/?name=alice%20smith",
+			query:  "SELECT * FROM users WHERE name = 'alice smith'",
+		},
+		{
+			name:     "string literal injection",
+			target:   "/?name=%27%20OR%20%27a%27%3D%27a",
+			query:    "SELECT * FROM users WHERE name = '' OR 'a'='a'",
+			expected: &callback.SQLInjectionAttackInfo{Reason: callback.SQLInjectionTaintedQuery, Source: "query"},
+		},
+		{
+			name:     "numeric injection",
+			target:   "/?id=1%20OR%201%3D1",
+			query:    "SELECT * FROM users WHERE id = 1 OR 1=1",
+			expected: &callback.SQLInjectionAttackInfo{Reason: callback.SQLInjectionTaintedQuery, Source: "query"},
+		},
+		{
+			name:     "comment injection",
+			target:   "/?name=admin--",
+			query:    "SELECT * FROM users WHERE name = 'admin--' AND password = 'secret'",
+			expected: &callback.SQLInjectionAttackInfo{Reason: callback.SQLInjectionTaintedQuery, Source: "query"},
+		},
+	} {
+		tc := tc
+		for _, blocking := range []bool{false, true} {
+			blocking := blocking
+			t.Run(tc.name, func(t *testing.T) {
+				r := &mockups.NativeRuleContextMockup{}
+				defer r.AssertExpectations(t)
+
+				prolog, err := callback.NewSQLInjectionCallback(r, sqlInjectionCallbackConfig{})
+				require.NoError(t, err)
+
+				req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+				p := http_protection.NewTestProtectionContext(nil, net.IPv4(1, 2, 3, 4), nil, &requestReader{req: req})
+
+				// The callback is only called once since the matcher is called again
+				// by AssertExpectations()
+				var called bool
+				r.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+					if called {
+						return true
+					}
+					called = true
+					c := &mockups.CallbackContextMockup{}
+					defer c.AssertExpectations(t)
+					c.ExpectProtectionContext().Return(p)
+					if tc.expected != nil {
+						c.ExpectHandleAttack(true, mock.MatchedBy(func(opts []event.AttackEventOption) bool {
+							var attack event.AttackEvent
+							for _, opt := range opts {
+								opt(&attack)
+							}
+							require.Equal(t, *tc.expected, attack.Info)
+							return true
+						})).Return(blocking).Once()
+					}
+					require.NoError(t, cb(c))
+					return true
+				})).Once()
+
+				// Simulate the call to `(*sql.DB).QueryContext()`
+				var (
+					db   *sql.DB
+					ctx  = context.Background()
+					args []interface{}
+				)
+				query := tc.query
+				epilog, err := prolog([]reflect.Value{reflect.ValueOf(&db), reflect.ValueOf(&ctx), reflect.ValueOf(&query), reflect.ValueOf(&args)})
+				if tc.expected == nil || !blocking {
+					require.NoError(t, err)
+					require.Nil(t, epilog)
+					return
+				}
+
+				require.Equal(t, sqhook.AbortError, err)
+				require.NotNil(t, epilog)
+				var (
+					rows    *sql.Rows
+					callErr error
+				)
+				epilog([]reflect.Value{reflect.ValueOf(&rows), reflect.ValueOf(&callErr)})
+				var sqErr types.SqreenError
+				require.True(t, xerrors.As(callErr, &sqErr))
+				var injectionErr callback.SQLInjectionError
+				require.True(t, xerrors.As(sqErr.Err, &injectionErr))
+				require.Equal(t, tc.expected.Reason, injectionErr.Reason)
+			})
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"reflect"
+
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqgls"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+)
+
+// NewTaintPropagationCallback returns the reflected callback propagating the
+// taint of the request inputs to the results of the hooked function, such as
+// the string functions of packages `strings`, `fmt` and `strconv`. The strings
+// found in the results are tainted when a string found in the arguments is.
+// The goroutine-local storage is cleared while propagating so that the hooked
+// functions the propagation may call in the same goroutine don't find the
+// protection context and are not propagated again, while the other goroutines
+// of the request keep propagating the taint concurrently.
+func NewTaintPropagationCallback(r RuleContext, _ ReflectedCallbackConfig) (sqhook.ReflectedPrologCallback, error) {
+	sqassert.NotNil(r)
+	return func(params []reflect.Value) (sqhook.ReflectedEpilogCallback, error) {
+		r := withCallParams(r, params)
+		return func(results []reflect.Value) {
+			r.Post(func(c CallbackContext) error {
+				tracker := taintTracker(c.ProtectionContext())
+				if tracker.Len() == 0 {
+					return nil
+				}
+				gls := sqgls.Get()
+				sqgls.Set(nil)
+				defer sqgls.Set(gls)
+				tracker.Propagate(interfaceValues(params), interfaceValues(results))
+				return nil
+			})
+		}, nil
+	}, nil
+}
+
+func interfaceValues(values []reflect.Value) []interface{} {
+	faces := make([]interface{}, len(values))
+	for i, v := range values {
+		faces[i] = v.Interface()
+	}
+	return faces
+}
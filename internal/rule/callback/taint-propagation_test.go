@@ -0,0 +1,87 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	bindingaccessor "github.com/sqreen/go-agent/internal/binding-accessor"
+	"github.com/sqreen/go-agent/internal/protection/taint"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type taintTrackingProtectionContext struct {
+	*mockups.ProtectionContextMockup
+	tracker *taint.Tracker
+}
+
+func (p taintTrackingProtectionContext) TaintTracker() *taint.Tracker { return p.tracker }
+
+func TestTaintPropagationCallback(t *testing.T) {
+	tracker := taint.NewTracker()
+	src := taint.Source{Name: "query", Value: " ../../etc/passwd "}
+	tracker.Taint(src.Value, src)
+	p := taintTrackingProtectionContext{ProtectionContextMockup: &mockups.ProtectionContextMockup{}, tracker: tracker}
+
+	r := &mockups.NativeRuleContextMockup{}
+	defer r.AssertExpectations(t)
+	r.ExpectPost(mock.Anything).Run(func(args mock.Arguments) {
+		c := &mockups.CallbackContextMockup{}
+		defer c.AssertExpectations(t)
+		c.ExpectProtectionContext().Return(p)
+		cb := args.Get(0).(func(callback.CallbackContext) error)
+		require.NoError(t, cb(c))
+	}).Twice()
+
+	prolog, err := callback.NewTaintPropagationCallback(r, nil)
+	require.NoError(t, err)
+
+	// Simulate the call to the hooked function strings.TrimSpace
+	call := func(s string) string {
+		result := strings.TrimSpace(s)
+		epilog, err := prolog([]reflect.Value{reflect.ValueOf(&s)})
+		require.NoError(t, err)
+		require.NotNil(t, epilog)
+		epilog([]reflect.Value{reflect.ValueOf(&result)})
+		return result
+	}
+
+	trimmed := call(src.Value)
+	got, tainted := tracker.IsTainted(trimmed)
+	require.True(t, tainted)
+	require.Equal(t, src, got)
+
+	untainted := call(" untainted ")
+	_, tainted = tracker.IsTainted(untainted)
+	require.False(t, tainted)
+}
+
+func TestTaintBindingAccessorContext(t *testing.T) {
+	tracker := taint.NewTracker()
+	src := taint.Source{Name: "json", Value: "' OR 1=1 --"}
+	tracker.Taint(src.Value, src)
+	p := taintTrackingProtectionContext{ProtectionContextMockup: &mockups.ProtectionContextMockup{}, tracker: tracker}
+
+	query := "SELECT * FROM users WHERE name = '" + src.Value + "'"
+	ctx, err := callback.NewReflectedCallbackBindingAccessorContext([]string{"taint", "func"}, p, []reflect.Value{reflect.ValueOf(&query)}, nil, nil)
+	require.NoError(t, err)
+
+	isTainted, err := bindingaccessor.Compile("#.Taint.IsTainted(#.Func.Args[0])")
+	require.NoError(t, err)
+	v, err := isTainted(ctx)
+	require.NoError(t, err)
+	require.Equal(t, true, v)
+
+	source, err := bindingaccessor.Compile("#.Taint.Source(#.Func.Args[0]).Name")
+	require.NoError(t, err)
+	v, err = source(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "json", v)
+}
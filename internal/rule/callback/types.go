@@ -5,6 +5,9 @@
 package callback
 
 import (
+	"reflect"
+	"time"
+
 	"github.com/dop251/goja"
 	"github.com/sqreen/go-agent/internal/backend/api"
 	bindingaccessor "github.com/sqreen/go-agent/internal/binding-accessor"
@@ -20,12 +23,32 @@ type NativeCallbackConfig interface {
 type ReflectedCallbackConfig interface {
 	NativeCallbackConfig
 	Strategy() *api.ReflectedCallbackConfig
+	// PrologFuncType returns the prolog function type of the hooked function,
+	// or nil when unknown.
+	PrologFuncType() reflect.Type
 }
 
 type JSReflectedCallbackConfig interface {
 	ReflectedCallbackConfig
 	Pre() (funcDecl *goja.Program, funcCallParams []bindingaccessor.BindingAccessorFunc)
 	Post() (funcDecl *goja.Program, funcCallParams []bindingaccessor.BindingAccessorFunc)
+	Limits() JSLimits
+}
+
+// JSLimits are the execution limits of the javascript callbacks. Default
+// values are used when zero. A callback call is interrupted once it exceeds
+// its execution time or its number of steps, which are the function calls and
+// loop iterations of the callback source code compiled by CompileJSCallback().
+// The step limit also bounds the memory a call can allocate, except in native
+// built-in functions.
+type JSLimits struct {
+	// Timeout is the maximum execution time of a callback call.
+	Timeout time.Duration
+	// MaxSteps is the maximum number of steps of a callback call.
+	MaxSteps uint64
+	// MaxOverBudget is the number of over-budget calls after which the
+	// callbacks are disabled. They are never disabled when zero.
+	MaxOverBudget uint64
 }
 
 // NativeCallbackConstructorFunc is a function returning a native callback
@@ -0,0 +1,399 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	sdk_types "github.com/sqreen/go-agent/sdk/types"
+)
+
+// Limits of the documents decoded from request bodies.
+const (
+	maxDecodedDocumentSize  = 1024 * 1024
+	maxDecodedDocumentDepth = 64
+	// maxGobTypeDefinitions is the maximum number of type definitions of a gob
+	// stream. Every nested struct, slice or map type of a gob value requires
+	// its own type definition.
+	maxGobTypeDefinitions = 64
+)
+
+// Unsafe decoding reasons.
+const (
+	UnsafeDecodingOversizedDocument = "oversized document"
+	UnsafeDecodingNestedDocument    = "deeply nested document"
+	UnsafeDecodingDocumentType      = "document type declaration"
+	UnsafeDecodingEntityDeclaration = "entity declaration"
+)
+
+// NewXMLDecoderCallback returns the native prolog callback to be attached to
+// `encoding/xml.NewDecoder`. When the decoder reads the request body, it is
+// checked for entity declarations and document type declarations having an
+// internal subset, used by XML external entity (XXE) and entity expansion
+// attacks, and for deeply nested or oversized documents. Document type
+// declarations without internal subset, such as the XHTML and SVG ones, are
+// allowed since encoding/xml never loads external DTDs. The decoding is
+// aborted with a read error according to the rule blocking mode.
+func NewXMLDecoderCallback(r RuleContext, cfg NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	sqassert.NotNil(cfg)
+	return newXMLDecoderPrologCallback(r), nil
+}
+
+type XMLDecoderPrologCallbackType = func(*io.Reader) (XMLDecoderEpilogCallbackType, error)
+type XMLDecoderEpilogCallbackType = func(**xml.Decoder)
+
+func newXMLDecoderPrologCallback(r RuleContext) XMLDecoderPrologCallbackType {
+	return func(reader *io.Reader) (XMLDecoderEpilogCallbackType, error) {
+		protectDecoding(r, "xml", reader, func() func([]byte) (string, bool) {
+			return new(xmlDocumentCheck).check
+		})
+		return nil, nil
+	}
+}
+
+// NewGobDecoderCallback returns the native prolog callback to be attached to
+// `encoding/gob.NewDecoder`. Gob streams are not hardened against adversarial
+// inputs, so the request body is checked for deeply nested or oversized values
+// when the decoder reads it. The decoding is aborted with a read error
+// according to the rule blocking mode.
+func NewGobDecoderCallback(r RuleContext, cfg NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	sqassert.NotNil(cfg)
+	return newGobDecoderPrologCallback(r), nil
+}
+
+type GobDecoderPrologCallbackType = func(*io.Reader) (GobDecoderEpilogCallbackType, error)
+type GobDecoderEpilogCallbackType = func(**gob.Decoder)
+
+func newGobDecoderPrologCallback(r RuleContext) GobDecoderPrologCallbackType {
+	return func(reader *io.Reader) (GobDecoderEpilogCallbackType, error) {
+		protectDecoding(r, "gob", reader, func() func([]byte) (string, bool) {
+			return new(gobStreamCheck).check
+		})
+		return nil, nil
+	}
+}
+
+type UnsafeDecodingAttackInfo struct {
+	Decoder string `json:"decoder"`
+	Reason  string `json:"reason"`
+}
+
+type UnsafeDecodingError struct {
+	UnsafeDecodingAttackInfo
+}
+
+func (e UnsafeDecodingError) Error() string {
+	return fmt.Sprintf("unsafe %s decoding: %s", e.Decoder, e.Reason)
+}
+
+// protectDecoding replaces the reader of a decoder created in an HTTP request
+// by a reader checking the request body as the decoder reads it.
+func protectDecoding(r RuleContext, decoder string, reader *io.Reader, newCheck func() func(body []byte) (reason string, unsafe bool)) {
+	r.Pre(func(c CallbackContext) error {
+		p, _ := c.ProtectionContext().(*http_protection.ProtectionContext)
+		if p == nil || *reader == nil {
+			// Not an HTTP protection context
+			return nil
+		}
+		*reader = &decodingCheckReader{
+			Reader:   *reader,
+			rule:     r,
+			p:        p,
+			decoder:  decoder,
+			newCheck: newCheck,
+		}
+		return nil
+	})
+}
+
+// decodingCheckReader is the reader of a decoder checking the request body it
+// reads, which is detected by the growth of the request body read by the
+// underlying reader. The check state is kept per request so that every read
+// only checks the request body bytes read since the previous one, including by
+// other decoders, and the attack is only reported once. The read bytes are not
+// returned to the decoder when blocked, and the sdk/types.SqreenError is
+// returned instead so that the decoding is aborted before processing them.
+type decodingCheckReader struct {
+	io.Reader
+	rule     RuleContext
+	p        *http_protection.ProtectionContext
+	decoder  string
+	newCheck func() func(body []byte) (reason string, unsafe bool)
+	// err is the error aborting the decoding once blocked.
+	err error
+}
+
+func (d *decodingCheckReader) Read(b []byte) (n int, err error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	read := len(d.p.RequestReader.Body())
+	n, err = d.Reader.Read(b)
+	body := d.p.RequestReader.Body()
+	if len(body) <= read {
+		// The reader didn't read the request body
+		return n, err
+	}
+	d.rule.Post(func(c CallbackContext) error {
+		state := d.p.CallbackState(decodingCheckKey(d.decoder), func() interface{} {
+			return &decodingCheck{check: d.newCheck()}
+		}).(*decodingCheck)
+		d.err = state.handle(c, d.decoder, body)
+		return nil
+	})
+	if d.err != nil {
+		return 0, d.err
+	}
+	return n, err
+}
+
+// decodingCheckKey is the key of the request states of the decoding checks.
+type decodingCheckKey string
+
+// decodingCheck is the request state of a decoding check.
+type decodingCheck struct {
+	mu sync.Mutex
+	// check is the incremental check of the request body.
+	check func(body []byte) (reason string, unsafe bool)
+	// checked is the size of the request body checked so far.
+	checked int
+	reason  string
+	unsafe  bool
+	// handled is true once the attack was handled, and blocked is the blocking
+	// decision.
+	handled bool
+	blocked bool
+}
+
+// handle checks the request body read so far and returns the error aborting
+// the decoding when blocked, nil otherwise.
+func (d *decodingCheck) handle(c CallbackContext, decoder string, body []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.unsafe && len(body) > d.checked {
+		d.reason, d.unsafe = d.check(body)
+		d.checked = len(body)
+	}
+	if !d.unsafe {
+		return nil
+	}
+
+	info := UnsafeDecodingAttackInfo{Decoder: decoder, Reason: d.reason}
+	if !d.handled {
+		d.handled = true
+		d.blocked = c.HandleAttack(true, event.WithAttackInfo(info), event.WithStackTrace())
+	}
+	if !d.blocked {
+		return nil
+	}
+	err := sdk_types.SqreenError{Err: UnsafeDecodingError{UnsafeDecodingAttackInfo: info}}
+	c.Logger().Debug(err.Error())
+	return err
+}
+
+// xmlDocumentCheck incrementally checks an XML document by scanning its markup
+// without decoding it.
+type xmlDocumentCheck struct {
+	// offset is the offset of the next markup to scan, which can be incomplete
+	// until more of the document is read.
+	offset int
+	depth  int
+}
+
+func (x *xmlDocumentCheck) check(doc []byte) (reason string, unsafe bool) {
+	if len(doc) > maxDecodedDocumentSize {
+		return UnsafeDecodingOversizedDocument, true
+	}
+	for {
+		i := bytes.IndexByte(doc[x.offset:], '<')
+		if i == -1 {
+			x.offset = len(doc)
+			return "", false
+		}
+		x.offset += i
+		markup := doc[x.offset:]
+
+		var end int
+		switch {
+		case bytes.HasPrefix(markup, []byte("<!--")):
+			end = indexEnd(markup, "-->")
+		case bytes.HasPrefix(markup, []byte("<![CDATA[")):
+			end = indexEnd(markup, "]]>")
+		case bytes.HasPrefix(markup, []byte("<?")):
+			end = indexEnd(markup, "?>")
+		case hasPrefixFold(markup, "<!DOCTYPE"):
+			var internalSubset bool
+			end, internalSubset = xmlDoctypeEnd(markup)
+			if internalSubset {
+				// The internal subset holds the entity declarations
+				if containsFold(markup, "<!ENTITY") {
+					return UnsafeDecodingEntityDeclaration, true
+				}
+				return UnsafeDecodingDocumentType, true
+			}
+		case hasPrefixFold(markup, "<!ENTITY"):
+			return UnsafeDecodingEntityDeclaration, true
+		case bytes.HasPrefix(markup, []byte("<!")):
+			// Other declarations
+			end = xmlTagEnd(markup)
+		case bytes.HasPrefix(markup, []byte("</")):
+			if end = xmlTagEnd(markup); end != -1 {
+				x.depth--
+			}
+		default:
+			if end = xmlTagEnd(markup); end != -1 && markup[end-2] != '/' {
+				x.depth++
+				if x.depth > maxDecodedDocumentDepth {
+					return UnsafeDecodingNestedDocument, true
+				}
+			}
+		}
+
+		if end == -1 {
+			// Incomplete markup: wait for more of the document
+			return "", false
+		}
+		x.offset += end
+	}
+}
+
+// indexEnd returns the index following the first occurrence of `sep` in `b`,
+// or -1 if not present.
+func indexEnd(b []byte, sep string) int {
+	i := bytes.Index(b, []byte(sep))
+	if i == -1 {
+		return -1
+	}
+	return i + len(sep)
+}
+
+// xmlTagEnd returns the index following the end of the tag starting `b`, or -1
+// if incomplete. Quoted attribute values can include `>`.
+func xmlTagEnd(b []byte) int {
+	var quote byte
+	for i := 1; i < len(b); i++ {
+		switch c := b[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// xmlDoctypeEnd returns the index following the end of the document type
+// declaration starting `b`, or -1 if incomplete. It stops and returns true
+// when the declaration has an internal subset.
+func xmlDoctypeEnd(b []byte) (end int, internalSubset bool) {
+	var quote byte
+	for i := len("<!DOCTYPE"); i < len(b); i++ {
+		switch c := b[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			return -1, true
+		case c == '>':
+			return i + 1, false
+		}
+	}
+	return -1, false
+}
+
+func hasPrefixFold(b []byte, prefix string) bool {
+	return len(b) >= len(prefix) && bytes.EqualFold(b[:len(prefix)], []byte(prefix))
+}
+
+func containsFold(b []byte, s string) bool {
+	for i := bytes.IndexByte(b, s[0]); i != -1; {
+		if hasPrefixFold(b[i:], s) {
+			return true
+		}
+		next := bytes.IndexByte(b[i+1:], s[0])
+		if next == -1 {
+			break
+		}
+		i += 1 + next
+	}
+	return false
+}
+
+// gobStreamCheck incrementally checks a gob stream. A gob stream is a sequence
+// of messages prefixed by their length, and beginning with their type id which
+// is negative for type definitions.
+type gobStreamCheck struct {
+	// offset is the offset of the next message, which can be incomplete until
+	// more of the stream is read.
+	offset          int
+	typeDefinitions int
+}
+
+func (g *gobStreamCheck) check(stream []byte) (reason string, unsafe bool) {
+	if len(stream) > maxDecodedDocumentSize {
+		return UnsafeDecodingOversizedDocument, true
+	}
+	for g.offset < len(stream) {
+		length, rest, err := gobDecodeUint(stream[g.offset:])
+		if err != nil || length > uint64(len(rest)) {
+			// Incomplete message
+			break
+		}
+		msg := rest[:length]
+		g.offset = len(stream) - len(rest) + int(length)
+		u, _, err := gobDecodeUint(msg)
+		if err != nil {
+			break
+		}
+		// Signed integers have their sign in the lowest bit
+		if u&1 != 0 {
+			g.typeDefinitions++
+		}
+	}
+	if g.typeDefinitions > maxGobTypeDefinitions {
+		return UnsafeDecodingNestedDocument, true
+	}
+	return "", false
+}
+
+// gobDecodeUint decodes a gob unsigned integer: values lower than 128 are
+// encoded in a single byte, while larger values are encoded in big-endian
+// bytes preceded by their negated byte count.
+func gobDecodeUint(b []byte) (v uint64, rest []byte, err error) {
+	if len(b) == 0 {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	if b[0] < 0x80 {
+		return uint64(b[0]), b[1:], nil
+	}
+	n := 256 - int(b[0])
+	if n > 8 || len(b) < 1+n {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	for _, c := range b[1 : 1+n] {
+		v = v<<8 | uint64(c)
+	}
+	return v, b[1+n:], nil
+}
@@ -0,0 +1,276 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/event"
+	"github.com/sqreen/go-agent/internal/plog"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	middleware_mockups "github.com/sqreen/go-agent/sdk/middleware/_testlib/mockups"
+	"github.com/sqreen/go-agent/sdk/types"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestUnsafeDecodingCallbacks(t *testing.T) {
+	// manyGobTypes returns a gob stream with more type definitions than
+	// allowed: every new encoder sends the type definitions again.
+	manyGobTypes := func() []byte {
+		var buf bytes.Buffer
+		for i := 0; i < 70; i++ {
+			_ = gob.NewEncoder(&buf).Encode(struct{ A []int }{A: []int{i}})
+		}
+		return buf.Bytes()
+	}
+
+	xxe := []byte(`<?xml version="1.0"?><!DOCTYPE foo [<!ENTITY xxe SYSTEM "file:///etc/passwd">]><foo>&xxe;</foo>`)
+
+	// newProtectionContext returns an HTTP protection context of a request
+	// having the given body, along with the request body reader.
+	newProtectionContext := func(body []byte) (*http_protection.ProtectionContext, io.Reader) {
+		root := &middleware_mockups.RootHTTPProtectionContextMockup{}
+		root.ExpectContext().Return(context.Background()).Maybe()
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		p := http_protection.NewTestProtectionContext(root, net.IPv4(1, 2, 3, 4), nil, &requestReader{req: req})
+		req = p.WrapRequest(req)
+		return p, req.Body
+	}
+
+	// decode decodes the given reader with the decoder callback attached, and
+	// returns the decoding error. The callback context c is given to the
+	// callbacks.
+	decode := func(t *testing.T, decoder string, p *http_protection.ProtectionContext, c *mockups.CallbackContextMockup, reader io.Reader) error {
+		r := &mockups.NativeRuleContextMockup{}
+		defer r.AssertExpectations(t)
+		run := func(args mock.Arguments) {
+			cb := args.Get(0).(func(callback.CallbackContext) error)
+			require.NoError(t, cb(c))
+		}
+		r.ExpectPre(mock.Anything).Run(run).Once()
+		r.ExpectPost(mock.Anything).Run(run).Maybe()
+
+		switch decoder {
+		case "xml":
+			cb, err := callback.NewXMLDecoderCallback(r, &mockups.NativeCallbackConfigMockup{})
+			require.NoError(t, err)
+			prolog, ok := cb.(callback.XMLDecoderPrologCallbackType)
+			require.True(t, ok)
+			epilog, err := prolog(&reader)
+			require.NoError(t, err)
+			require.Nil(t, epilog)
+			return xml.NewDecoder(reader).Decode(&struct{}{})
+		case "gob":
+			cb, err := callback.NewGobDecoderCallback(r, &mockups.NativeCallbackConfigMockup{})
+			require.NoError(t, err)
+			prolog, ok := cb.(callback.GobDecoderPrologCallbackType)
+			require.True(t, ok)
+			epilog, err := prolog(&reader)
+			require.NoError(t, err)
+			require.Nil(t, epilog)
+			d := gob.NewDecoder(reader)
+			for {
+				var v struct {
+					Name string
+					A    []int
+				}
+				if err := d.Decode(&v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	// expectAttack sets up the callback context expectations of the given
+	// attack.
+	expectAttack := func(t *testing.T, c *mockups.CallbackContextMockup, p *http_protection.ProtectionContext, decoder, reason string, blocking bool) {
+		c.ExpectProtectionContext().Return(p)
+		if reason == "" {
+			return
+		}
+		c.ExpectHandleAttack(true, mock.MatchedBy(func(opts []event.AttackEventOption) bool {
+			var attack event.AttackEvent
+			for _, opt := range opts {
+				opt(&attack)
+			}
+			require.Equal(t, callback.UnsafeDecodingAttackInfo{Decoder: decoder, Reason: reason}, attack.Info)
+			return true
+		})).Return(blocking).Once()
+		c.On("Logger").Return(plog.NewLogger(plog.Disabled, nil, nil)).Maybe()
+	}
+
+	// requireBlocked checks the decoding error is the blocking one.
+	requireBlocked := func(t *testing.T, decodeErr error, reason string) {
+		var sqErr types.SqreenError
+		require.True(t, xerrors.As(decodeErr, &sqErr))
+		var decodingErr callback.UnsafeDecodingError
+		require.True(t, xerrors.As(sqErr.Err, &decodingErr))
+		require.Equal(t, reason, decodingErr.Reason)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		decoder  string
+		body     []byte
+		expected string
+	}{
+		{
+			name:     "safe xml",
+			decoder:  "xml",
+			body:     []byte(`<user><name>Alice</name></user>`),
+			expected: "",
+		},
+		{
+			name:     "xml external entity",
+			decoder:  "xml",
+			body:     xxe,
+			expected: callback.UnsafeDecodingEntityDeclaration,
+		},
+		{
+			name:     "xml document type",
+			decoder:  "xml",
+			body:     []byte(`<!doctype foo [<!ELEMENT foo ANY>]><foo/>`),
+			expected: callback.UnsafeDecodingDocumentType,
+		},
+		{
+			name:    "xhtml document type",
+			decoder: "xml",
+			body:    []byte(`<?xml version="1.0"?><!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd"><html><body><p>a &amp; b</p></body></html>`),
+		},
+		{
+			name:    "svg document type",
+			decoder: "xml",
+			body:    []byte(`<!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd"><svg xmlns="http://www.w3.org/2000/svg"><text x="0" y="1">a > b</text><!-- <!ENTITY --></svg>`),
+		},
+		{
+			name:     "deeply nested xml",
+			decoder:  "xml",
+			body:     []byte(strings.Repeat("<a>", 100) + strings.Repeat("</a>", 100)),
+			expected: callback.UnsafeDecodingNestedDocument,
+		},
+		{
+			name:    "long flat xml",
+			decoder: "xml",
+			body:    []byte("<a>" + strings.Repeat(`<b c="/>"/><d>text</d>`, 100) + "</a>"),
+		},
+		{
+			name:     "oversized xml",
+			decoder:  "xml",
+			body:     []byte("<a>" + strings.Repeat("a", 2*1024*1024) + "</a>"),
+			expected: callback.UnsafeDecodingOversizedDocument,
+		},
+		{
+			name:    "safe gob",
+			decoder: "gob",
+			body: func() []byte {
+				var buf bytes.Buffer
+				_ = gob.NewEncoder(&buf).Encode(struct{ Name string }{Name: "Alice"})
+				return buf.Bytes()
+			}(),
+		},
+		{
+			name:     "gob with too many type definitions",
+			decoder:  "gob",
+			body:     manyGobTypes(),
+			expected: callback.UnsafeDecodingNestedDocument,
+		},
+	} {
+		tc := tc
+		for _, blocking := range []bool{false, true} {
+			blocking := blocking
+			t.Run(tc.name, func(t *testing.T) {
+				p, body := newProtectionContext(tc.body)
+				c := &mockups.CallbackContextMockup{}
+				defer c.AssertExpectations(t)
+				expectAttack(t, c, p, tc.decoder, tc.expected, blocking)
+
+				decodeErr := decode(t, tc.decoder, p, c, body)
+				if tc.expected == "" || !blocking {
+					// Decoding errors of partial documents are ignored
+					require.False(t, xerrors.As(decodeErr, &types.SqreenError{}))
+					return
+				}
+				requireBlocked(t, decodeErr, tc.expected)
+			})
+		}
+	}
+
+	t.Run("decoder not reading the request body", func(t *testing.T) {
+		p, body := newProtectionContext(xxe)
+		// The body is read before decoding a copy of it
+		buf, err := ioutil.ReadAll(body)
+		require.NoError(t, err)
+
+		c := &mockups.CallbackContextMockup{}
+		defer c.AssertExpectations(t)
+		expectAttack(t, c, p, "xml", "", false)
+
+		decodeErr := decode(t, "xml", p, c, bytes.NewReader(buf))
+		require.False(t, xerrors.As(decodeErr, &types.SqreenError{}))
+	})
+
+	t.Run("decoding aborted while reading the request body", func(t *testing.T) {
+		doc := append(append([]byte{}, xxe...), strings.Repeat("<bar/>", 64*1024)...)
+		p, body := newProtectionContext(doc)
+
+		c := &mockups.CallbackContextMockup{}
+		defer c.AssertExpectations(t)
+		expectAttack(t, c, p, "xml", callback.UnsafeDecodingEntityDeclaration, true)
+
+		decodeErr := decode(t, "xml", p, c, body)
+		requireBlocked(t, decodeErr, callback.UnsafeDecodingEntityDeclaration)
+
+		// The decoder stopped reading the request body
+		require.Less(t, len(p.RequestReader.Body()), len(doc))
+		rest, err := ioutil.ReadAll(body)
+		require.NoError(t, err)
+		require.NotEmpty(t, rest)
+	})
+
+	t.Run("request body decoded several times", func(t *testing.T) {
+		for _, blocking := range []bool{false, true} {
+			blocking := blocking
+			t.Run("", func(t *testing.T) {
+				doc := append(append([]byte{}, xxe...), strings.Repeat("<bar/>", 1024)...)
+				p, body := newProtectionContext(doc)
+
+				// The attack is only reported once per request
+				c := &mockups.CallbackContextMockup{}
+				defer c.AssertExpectations(t)
+				expectAttack(t, c, p, "xml", callback.UnsafeDecodingEntityDeclaration, blocking)
+
+				// The first decoding reads the entity declaration
+				decodeErr := decode(t, "xml", p, c, io.LimitReader(body, int64(len(xxe))))
+				if blocking {
+					requireBlocked(t, decodeErr, callback.UnsafeDecodingEntityDeclaration)
+				} else {
+					require.False(t, xerrors.As(decodeErr, &types.SqreenError{}))
+				}
+
+				// The following decodings of the request body return the same result
+				decodeErr = decode(t, "xml", p, c, body)
+				if blocking {
+					requireBlocked(t, decodeErr, callback.UnsafeDecodingEntityDeclaration)
+				} else {
+					require.False(t, xerrors.As(decodeErr, &types.SqreenError{}))
+				}
+			})
+		}
+	})
+}
@@ -60,7 +60,7 @@ func prepareWAF(cfg NativeCallbackConfig) (waf_types.Rule, map[string]bindingacc
 	}
 	bindingAccessors := make(map[string]bindingaccessor.BindingAccessorFunc, len(data.BindingAccessors))
 	for _, expr := range data.BindingAccessors {
-		ba, err := bindingaccessor.Compile(expr)
+		ba, err := bindingaccessor.Compile(expr, bindingaccessor.WithTypeEnv(WAFBindingAccessorTypeEnv))
 		if err != nil {
 			return nil, nil, 0, sqerrors.Wrapf(err, "could not compile binding accessor expression `%s`", expr)
 		}
@@ -209,12 +209,15 @@ func NewFunctionWAFCallback(r RuleContext, cfg ReflectedCallbackConfig, function
 		return nil, sqerrors.Wrap(err, "unexpected configuration error")
 	}
 
-	pre, err := compileFunctionWAFBindingAccessors(functionWAFCfg.Pre)
+	// The function WAF binding accessor contexts have no rule data
+	env := NewReflectedCallbackBindingAccessorTypeEnv(cfg.PrologFuncType(), nil)
+
+	pre, err := compileFunctionWAFBindingAccessors(functionWAFCfg.Pre, env)
 	if err != nil {
 		return nil, sqerrors.Wrap(err, "could not compile the pre binding accessors")
 	}
 
-	post, err := compileFunctionWAFBindingAccessors(functionWAFCfg.Post)
+	post, err := compileFunctionWAFBindingAccessors(functionWAFCfg.Post, env)
 	if err != nil {
 		return nil, sqerrors.Wrap(err, "could not compile the post binding accessors")
 	}
@@ -224,19 +227,19 @@ func NewFunctionWAFCallback(r RuleContext, cfg ReflectedCallbackConfig, function
 
 type functionWAFBindingAccessorMap map[*bindingaccessor.BindingAccessorFunc]bindingaccessor.BindingAccessorFunc
 
-func compileFunctionWAFBindingAccessors(bas map[string]string) (functionWAFBindingAccessorMap, error) {
+func compileFunctionWAFBindingAccessors(bas map[string]string, env *bindingaccessor.TypeEnv) (functionWAFBindingAccessorMap, error) {
 	if len(bas) == 0 {
 		return nil, nil
 	}
 
 	r := make(functionWAFBindingAccessorMap, len(bas))
 	for k, v := range bas {
-		kf, err := bindingaccessor.Compile(k)
+		kf, err := bindingaccessor.Compile(k, bindingaccessor.WithTypeEnv(env))
 		if err != nil {
 			return nil, sqerrors.Wrapf(err, "could not compile the binding accessor expression `%s`", k)
 		}
 
-		vf, err := bindingaccessor.Compile(v)
+		vf, err := bindingaccessor.Compile(v, bindingaccessor.WithTypeEnv(env))
 		if err != nil {
 			return nil, sqerrors.Wrapf(err, "could not compile the binding accessor expression `%s`", v)
 		}
@@ -257,6 +260,7 @@ func makeFunctionWAFPrologCallback(r RuleContext, wafRule waf_types.Rule, bindin
 	sqassert.True(len(pre) > 0 || len(post) > 0)
 
 	return func(params []reflect.Value) (epilog sqhook.ReflectedEpilogCallback, prologErr error) {
+		r := withCallParams(r, params)
 		if l := len(pre); l > 0 {
 			var preErr error
 			r.Pre(func(c CallbackContext) (err error) {
@@ -5,14 +5,20 @@
 package rule
 
 import (
+	"context"
 	"errors"
+	"reflect"
 	"testing"
 	"time"
 
+	"github.com/sqreen/go-agent/internal/backend/api"
+	protection_context "github.com/sqreen/go-agent/internal/protection/context"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
 	"github.com/sqreen/go-agent/internal/rule/callback"
 	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
 	"github.com/sqreen/go-agent/internal/sqlib/sqsafe"
 	"github.com/sqreen/go-agent/internal/sqlib/sqtime"
+	middleware_mockups "github.com/sqreen/go-agent/sdk/middleware/_testlib/mockups"
 	"github.com/sqreen/go-agent/tools/testlib/testmock"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -207,3 +213,135 @@ func TestCallbackMiddlewares(t *testing.T) {
 		require.Equal(t, perf, float64(sqreenTime.Duration().Nanoseconds())/float64(time.Millisecond))
 	})
 }
+
+func TestFromCallParams(t *testing.T) {
+	p1 := &mockups.ProtectionContextMockup{}
+	p2 := &mockups.ProtectionContextMockup{}
+	ctx1 := context.WithValue(context.Background(), protection_context.ContextKey, p1)
+	// Frameworks such as Gin use string keys
+	ctx2 := context.WithValue(context.Background(), protection_context.ContextKey.String, p2)
+	var nilCtx context.Context
+
+	params := func(args ...interface{}) []reflect.Value {
+		params := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			params[i] = reflect.ValueOf(arg)
+		}
+		return params
+	}
+
+	for _, tc := range []struct {
+		Name     string
+		Config   *api.ReflectedCallbackHTTPProtectionContextConfig
+		Params   []reflect.Value
+		Expected ProtectionContext
+	}{
+		{
+			Name:     "no arguments",
+			Params:   params(),
+			Expected: nil,
+		},
+		{
+			Name:     "no context argument",
+			Params:   params(new(string), new(int)),
+			Expected: nil,
+		},
+		{
+			Name:     "first context argument",
+			Params:   params(new(string), &ctx1, &ctx2),
+			Expected: p1,
+		},
+		{
+			Name:     "string context key",
+			Params:   params(&ctx2, &ctx1),
+			Expected: p2,
+		},
+		{
+			Name:     "nil context argument",
+			Params:   params(&nilCtx),
+			Expected: nil,
+		},
+		{
+			Name:     "function argument",
+			Config:   &api.ReflectedCallbackHTTPProtectionContextConfig{Type: api.ProtectionContextFromFuncArgType, ReflectedCallbackHTTPProtectionContextFromFuncArgConfig: api.ReflectedCallbackHTTPProtectionContextFromFuncArgConfig{ArgIndex: 2}},
+			Params:   params(new(string), &ctx1, &ctx2),
+			Expected: p2,
+		},
+		{
+			Name:     "function argument out of range",
+			Config:   &api.ReflectedCallbackHTTPProtectionContextConfig{Type: api.ProtectionContextFromFuncArgType, ReflectedCallbackHTTPProtectionContextFromFuncArgConfig: api.ReflectedCallbackHTTPProtectionContextFromFuncArgConfig{ArgIndex: 3}},
+			Params:   params(new(string), &ctx1, &ctx2),
+			Expected: nil,
+		},
+		{
+			Name:     "function argument index overflowing int",
+			Config:   &api.ReflectedCallbackHTTPProtectionContextConfig{Type: api.ProtectionContextFromFuncArgType, ReflectedCallbackHTTPProtectionContextFromFuncArgConfig: api.ReflectedCallbackHTTPProtectionContextFromFuncArgConfig{ArgIndex: ^uint(0)}},
+			Params:   params(new(string), &ctx1, &ctx2),
+			Expected: nil,
+		},
+		{
+			Name:     "goroutine-local storage only",
+			Config:   &api.ReflectedCallbackHTTPProtectionContextConfig{Type: api.ProtectionContextFromGLSType},
+			Params:   params(&ctx1),
+			Expected: nil,
+		},
+	} {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			// The program is not instrumented and the goroutine-local storage
+			// fallback always returns nil
+			p := fromCallParams(tc.Config, tc.Params, FromGLS)
+			if tc.Expected == nil {
+				require.Nil(t, p)
+			} else {
+				require.True(t, tc.Expected == p)
+			}
+		})
+	}
+}
+
+func TestActiveProtectionContext(t *testing.T) {
+	t.Run("no protection context", func(t *testing.T) {
+		require.Nil(t, activeProtectionContext(nil))
+		require.Nil(t, activeProtectionContext("not a protection context"))
+	})
+
+	t.Run("goroutine outliving the request handler", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		root := &middleware_mockups.RootHTTPProtectionContextMockup{}
+		root.ExpectContext().Return(ctx)
+		p := http_protection.NewTestProtectionContext(root, nil, nil, nil)
+
+		// The goroutine created by the request handler inherits its
+		// goroutine-local storage value
+		var gls interface{} = p
+		require.True(t, activeProtectionContext(gls) == p)
+
+		handlerDone := make(chan struct{})
+		result := make(chan ProtectionContext)
+		go func() {
+			<-handlerDone
+			result <- activeProtectionContext(gls)
+		}()
+
+		// The request handler returns and its context gets canceled
+		cancel()
+		close(handlerDone)
+		require.Nil(t, <-result)
+	})
+
+	t.Run("request context outliving the request handler", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		root := &middleware_mockups.RootHTTPProtectionContextMockup{}
+		root.ExpectContext().Return(ctx)
+		p := http_protection.NewTestProtectionContext(root, nil, nil, nil)
+
+		// The request context is carried into a background job
+		reqCtx := context.WithValue(context.Background(), protection_context.ContextKey, p)
+		require.True(t, FromContext(reqCtx) == p)
+
+		// The request handler returns and its context gets canceled
+		cancel()
+		require.Nil(t, FromContext(reqCtx))
+	})
+}
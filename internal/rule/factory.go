@@ -5,6 +5,8 @@
 package rule
 
 import (
+	"reflect"
+
 	"github.com/sqreen/go-agent/internal/backend/api"
 	"github.com/sqreen/go-agent/internal/rule/callback"
 	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
@@ -27,6 +29,8 @@ func NewNativeCallback(name string, ctx *nativeRuleContext, cfg callback.NativeC
 		callbackCtor = callback.NewWriteHTTPRedirectionCallbacks
 	case "AddSecurityHeaders":
 		callbackCtor = callback.NewAddSecurityHeadersCallback
+	case "ContentSecurityPolicy":
+		callbackCtor = callback.NewContentSecurityPolicyCallback
 	case "MonitorHTTPStatusCode":
 		ctx.SetCritical(true)
 		callbackCtor = callback.NewMonitorHTTPStatusCodeCallback
@@ -41,28 +45,60 @@ func NewNativeCallback(name string, ctx *nativeRuleContext, cfg callback.NativeC
 	case "IPBlockList", "IPDenyList":
 		ctx.SetCritical(true)
 		callbackCtor = callback.NewIPDenyListCallback
+	case "IPListSource":
+		ctx.SetCritical(true)
+		callbackCtor = callback.NewIPListSourceCallback
+	case "CookieSigning":
+		ctx.SetCritical(true)
+		callbackCtor = callback.NewCookieSigningCallback
+	case "CookieVerification":
+		ctx.SetCritical(true)
+		callbackCtor = callback.NewCookieVerificationCallback
+	case "ResponseHeaderInjection":
+		callbackCtor = callback.NewResponseHeaderInjectionCallback
+	case "CSRFProtection":
+		ctx.SetCritical(true)
+		callbackCtor = callback.NewCSRFProtectionCallback
+	case "ProtocolAnomaly":
+		ctx.SetCritical(true)
+		callbackCtor = callback.NewProtocolAnomalyCallback
 	case "Shellshock":
 		callbackCtor = callback.NewShellshockCallback
+	case "CommandInjection":
+		callbackCtor = callback.NewCommandInjectionCallback
+	case "PathTraversal":
+		callbackCtor = callback.NewPathTraversalCallback
+	case "MonitorPanics":
+		callbackCtor = callback.NewMonitorPanicsCallback
+	case "DataLeak":
+		callbackCtor = callback.NewDataLeakCallback
+	case "XMLDecoder":
+		callbackCtor = callback.NewXMLDecoderCallback
+	case "GobDecoder":
+		callbackCtor = callback.NewGobDecoderCallback
+	case "OpenRedirectProtection":
+		callbackCtor = callback.NewOpenRedirectProtectionCallback
 	}
 	return callbackCtor(ctx, cfg)
 }
 
 // NewReflectedCallback returns the callback object or function of the given
 // callback name. An error is returned if the callback name is unknown or an
-// error occurred during the constructor call.
-func NewReflectedCallback(name string, r callback.RuleContext, rule *api.Rule) (prolog sqhook.PrologCallback, err error) {
+// error occurred during the constructor call. The prolog function type of the
+// hook is used to type-check the binding accessors when not nil.
+func NewReflectedCallback(name string, r callback.RuleContext, rule *api.Rule, prologFuncType reflect.Type) (prolog sqhook.PrologCallback, err error) {
 	switch name {
 	default:
 		return nil, sqerrors.Errorf("undefined reflected callback name `%s`", name)
 	case "", "JSExec":
-		cfg, err := newJSReflectedCallbackConfig(rule)
+		cfg, err := newJSReflectedCallbackConfig(rule, prologFuncType)
 		if err != nil {
 			return nil, sqerrors.Wrap(err, "configuration error")
 		}
 		return callback.NewJSExecCallback(r, cfg)
 
 	case "FunctionWAF":
-		cfg, err := newReflectedCallbackConfig(rule)
+		cfg, err := newReflectedCallbackConfig(rule, prologFuncType)
 		if err != nil {
 			return nil, sqerrors.Wrap(err, "configuration error")
 		}
@@ -71,5 +107,19 @@ func NewReflectedCallback(name string, r callback.RuleContext, rule *api.Rule) (
 			return nil, sqerrors.Errorf("unexpected callbacks type `%T` instead of `%T`", rule.Callbacks.RuleCallbacksNode, callbacks)
 		}
 		return callback.NewFunctionWAFCallback(r, cfg, callbacks)
+
+	case "TaintPropagation":
+		cfg, err := newReflectedCallbackConfig(rule, prologFuncType)
+		if err != nil {
+			return nil, sqerrors.Wrap(err, "configuration error")
+		}
+		return callback.NewTaintPropagationCallback(r, cfg)
+
+	case "SQLInjection":
+		cfg, err := newReflectedCallbackConfig(rule, prologFuncType)
+		if err != nil {
+			return nil, sqerrors.Wrap(err, "configuration error")
+		}
+		return callback.NewSQLInjectionCallback(r, cfg)
 	}
 }
@@ -7,6 +7,8 @@
 package rule
 
 import (
+	"reflect"
+
 	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
 )
 
@@ -39,3 +41,18 @@ func (defaultInstrumentationImpl) Find(symbol string) (HookFace, error) {
 }
 
 type defaultHook struct{ *sqhook.Hook }
+
+// prologFuncTyper is the optional interface of hooks knowing the prolog
+// function type they expect.
+type prologFuncTyper interface {
+	PrologFuncType() reflect.Type
+}
+
+// hookPrologFuncType returns the prolog function type of the hook, or nil when
+// unknown.
+func hookPrologFuncType(hook HookFace) reflect.Type {
+	if typer, ok := hook.(prologFuncTyper); ok {
+		return typer.PrologFuncType()
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package rule
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/sqreen/go-agent/internal/metrics"
+	"github.com/sqreen/go-agent/internal/plog"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+)
+
+// Replayer runs rules against recorded calls of their hookpoints without any
+// program instrumentation, so that rules can be tested offline. The hooks of
+// the rules are replay hooks whose attached callbacks are called by Call with
+// the given protection context. A Replayer is not safe for concurrent use.
+type Replayer struct {
+	engine *Engine
+	hooks  map[string]*replayHook
+	// Protection context of the ongoing call.
+	current ProtectionContext
+}
+
+// NewReplayer returns a new rule replayer. The rule signatures are not
+// verified when the public key is nil.
+func NewReplayer(logger plog.DebugLevelLogger, publicKey *ecdsa.PublicKey) *Replayer {
+	r := &Replayer{
+		hooks: make(map[string]*replayHook),
+	}
+	// The performance histograms are not reported and only need valid values
+	e := NewEngine(logger, replayInstrumentation{r}, metrics.NewEngine(), publicKey, 0.1, 2, time.Minute)
+	e.skipSignatureVerification = publicKey == nil
+	e.protectionContext = func() ProtectionContext { return r.current }
+	r.engine = e
+	return r
+}
+
+// SetRules sets and enables the given rules.
+func (r *Replayer) SetRules(packID string, rules []api.Rule) {
+	r.engine.SetRules(packID, rules)
+	r.engine.Enable()
+}
+
+// Hookpoints returns the sorted list of the hookpoints having callbacks.
+func (r *Replayer) Hookpoints() []string {
+	var hookpoints []string
+	for symbol, hook := range r.hooks {
+		if len(hook.prologs) > 0 {
+			hookpoints = append(hookpoints, symbol)
+		}
+	}
+	sort.Strings(hookpoints)
+	return hookpoints
+}
+
+// Call replays the call of function `symbol` with the given arguments and
+// results by calling the callbacks attached to its hook with `p` as protection
+// context. The arguments and results are converted to the parameter types of
+// native callbacks, through their JSON representation if needed, while
+// reflected callbacks get them as they are. The results modified by the
+// epilog callbacks are written back into `results`. It returns true when a
+// callback aborted the call.
+func (r *Replayer) Call(p ProtectionContext, symbol string, args, results []interface{}) (aborted bool, err error) {
+	hook := r.hooks[symbol]
+	if hook == nil {
+		return false, nil
+	}
+
+	r.current = p
+	defer func() { r.current = nil }()
+
+	var epilogs []replayEpilog
+	for _, prolog := range hook.prologs {
+		epilog, abort, err := callReplayProlog(prolog, args)
+		if err != nil {
+			return false, sqerrors.Wrapf(err, "hook `%s`", symbol)
+		}
+		if epilog != nil {
+			epilogs = append(epilogs, epilog)
+		}
+		if abort {
+			aborted = true
+			break
+		}
+	}
+
+	// The epilogs are called in the reverse order, like deferred calls
+	for i := len(epilogs) - 1; i >= 0; i-- {
+		if err := epilogs[i](results); err != nil {
+			return aborted, sqerrors.Wrapf(err, "hook `%s`", symbol)
+		}
+	}
+	return aborted, nil
+}
+
+type replayEpilog func(results []interface{}) error
+
+func callReplayProlog(prolog sqhook.PrologCallback, args []interface{}) (epilog replayEpilog, abort bool, err error) {
+	// Unwrap the prolog callback getters like sqhook.Hook.Attach
+	for {
+		getter, ok := prolog.(sqhook.PrologCallbackGetter)
+		if !ok {
+			break
+		}
+		prolog = getter.PrologCallback()
+	}
+
+	switch actual := prolog.(type) {
+	case sqhook.PanicObserver:
+		// Panics are not replayed
+		return nil, false, nil
+
+	case sqhook.ReflectedPrologCallback:
+		reflectedEpilog, err := actual(replayValuePointers(args))
+		if err != nil && err != sqhook.AbortError {
+			return nil, false, err
+		}
+		if reflectedEpilog != nil {
+			epilog = func(results []interface{}) error {
+				ptrs := replayValuePointers(results)
+				reflectedEpilog(ptrs)
+				setReplayValues(results, ptrs)
+				return nil
+			}
+		}
+		return epilog, err == sqhook.AbortError, nil
+	}
+
+	fn := reflect.ValueOf(prolog)
+	if fn.Kind() != reflect.Func {
+		return nil, false, sqerrors.Errorf("unexpected prolog type `%T`", prolog)
+	}
+	params, err := convertReplayValues(fn.Type(), args)
+	if err != nil {
+		return nil, false, sqerrors.Wrap(err, "prolog arguments")
+	}
+	ret := fn.Call(params)
+	prologErr, _ := ret[1].Interface().(error)
+	if prologErr != nil && prologErr != sqhook.AbortError {
+		return nil, false, prologErr
+	}
+	if nativeEpilog := ret[0]; !nativeEpilog.IsNil() {
+		epilog = func(results []interface{}) error {
+			ptrs, err := convertReplayValues(nativeEpilog.Type(), results)
+			if err != nil {
+				return sqerrors.Wrap(err, "epilog results")
+			}
+			nativeEpilog.Call(ptrs)
+			setReplayValues(results, ptrs)
+			return nil
+		}
+	}
+	return epilog, prologErr == sqhook.AbortError, nil
+}
+
+// replayValuePointers returns the pointers to copies of the given values, as
+// expected by reflected callbacks.
+func replayValuePointers(values []interface{}) []reflect.Value {
+	ptrs := make([]reflect.Value, len(values))
+	for i, v := range values {
+		var ptr reflect.Value
+		if v == nil {
+			ptr = reflect.New(reflect.TypeOf((*interface{})(nil)).Elem())
+		} else {
+			ptr = reflect.New(reflect.TypeOf(v))
+			ptr.Elem().Set(reflect.ValueOf(v))
+		}
+		ptrs[i] = ptr
+	}
+	return ptrs
+}
+
+func setReplayValues(values []interface{}, ptrs []reflect.Value) {
+	for i := range values {
+		values[i] = ptrs[i].Elem().Interface()
+	}
+}
+
+// convertReplayValues returns the pointers to the given values converted into
+// the parameter types of the given native callback function type. Values that
+// cannot be directly assigned nor converted are converted through their JSON
+// representation.
+func convertReplayValues(fnType reflect.Type, values []interface{}) ([]reflect.Value, error) {
+	if n := fnType.NumIn(); n != len(values) {
+		return nil, sqerrors.Errorf("got %d values instead of %d", len(values), n)
+	}
+	ptrs := make([]reflect.Value, len(values))
+	for i, v := range values {
+		ptrType := fnType.In(i)
+		if ptrType.Kind() != reflect.Ptr {
+			return nil, sqerrors.Errorf("unexpected non-pointer parameter type `%s`", ptrType)
+		}
+		typ := ptrType.Elem()
+		ptr := reflect.New(typ)
+		ptrs[i] = ptr
+		if v == nil {
+			continue
+		}
+
+		value := reflect.ValueOf(v)
+		switch {
+		case value.Type().AssignableTo(typ):
+			ptr.Elem().Set(value)
+		case value.Type().ConvertibleTo(typ):
+			ptr.Elem().Set(value.Convert(typ))
+		default:
+			buf, err := json.Marshal(v)
+			if err != nil {
+				return nil, sqerrors.Wrapf(err, "value %d", i)
+			}
+			if err := json.Unmarshal(buf, ptr.Interface()); err != nil {
+				return nil, sqerrors.Wrapf(err, "value %d: could not convert `%T` into `%s`", i, v, typ)
+			}
+		}
+	}
+	return ptrs, nil
+}
+
+type replayInstrumentation struct {
+	r *Replayer
+}
+
+func (i replayInstrumentation) Find(symbol string) (HookFace, error) {
+	hook := i.r.hooks[symbol]
+	if hook == nil {
+		hook = &replayHook{}
+		i.r.hooks[symbol] = hook
+	}
+	return hook, nil
+}
+
+func (replayInstrumentation) Health(string) error { return nil }
+
+type replayHook struct {
+	prologs []sqhook.PrologCallback
+}
+
+func (h *replayHook) Attach(prologs ...sqhook.PrologCallback) error {
+	if l := len(prologs); l == 0 || (l == 1 && prologs[0] == nil) {
+		prologs = nil
+	}
+	h.prologs = prologs
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package rule
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/plog"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayer(t *testing.T) {
+	type options struct {
+		Path string
+		Mode int
+	}
+
+	var calls []string
+	native := func(name *string, opts *options) (func(*error), error) {
+		calls = append(calls, "native prolog "+*name+" "+opts.Path)
+		return func(err *error) {
+			calls = append(calls, "native epilog")
+			*err = errors.New("native")
+		}, nil
+	}
+	var reflected sqhook.ReflectedPrologCallback = func(params []reflect.Value) (sqhook.ReflectedEpilogCallback, error) {
+		calls = append(calls, "reflected prolog "+params[0].Elem().Interface().(string))
+		return func(results []reflect.Value) {
+			calls = append(calls, "reflected epilog")
+		}, nil
+	}
+	var aborting sqhook.ReflectedPrologCallback = func([]reflect.Value) (sqhook.ReflectedEpilogCallback, error) {
+		calls = append(calls, "aborting prolog")
+		return nil, sqhook.AbortError
+	}
+
+	r := NewReplayer(plog.NewLogger(plog.Disabled, nil, nil), nil)
+	hook, err := r.engine.instrumentationEngine.Find("pkg.F")
+	require.NoError(t, err)
+	require.Empty(t, r.Hookpoints())
+
+	t.Run("call", func(t *testing.T) {
+		calls = nil
+		require.NoError(t, hook.Attach(native, reflected))
+		require.Equal(t, []string{"pkg.F"}, r.Hookpoints())
+
+		// The options are converted through JSON
+		results := []interface{}{nil}
+		aborted, err := r.Call(nil, "pkg.F", []interface{}{"name", map[string]interface{}{"Path": "path", "Mode": 1}}, results)
+		require.NoError(t, err)
+		require.False(t, aborted)
+		require.Equal(t, []string{"native prolog name path", "reflected prolog name", "reflected epilog", "native epilog"}, calls)
+		require.EqualError(t, results[0].(error), "native")
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		calls = nil
+		require.NoError(t, hook.Attach(reflected, aborting, native))
+		aborted, err := r.Call(nil, "pkg.F", []interface{}{"name", nil}, []interface{}{nil})
+		require.NoError(t, err)
+		require.True(t, aborted)
+		require.Equal(t, []string{"reflected prolog name", "aborting prolog", "reflected epilog"}, calls)
+	})
+
+	t.Run("unexpected arguments", func(t *testing.T) {
+		require.NoError(t, hook.Attach(native))
+		_, err := r.Call(nil, "pkg.F", []interface{}{"name"}, nil)
+		require.Error(t, err)
+		_, err = r.Call(nil, "pkg.F", []interface{}{"name", "not an object"}, nil)
+		require.Error(t, err)
+	})
+
+	t.Run("unknown hookpoint", func(t *testing.T) {
+		aborted, err := r.Call(nil, "pkg.G", nil, nil)
+		require.NoError(t, err)
+		require.False(t, aborted)
+	})
+
+	t.Run("disabled hook", func(t *testing.T) {
+		require.NoError(t, hook.Attach(nil))
+		require.Empty(t, r.Hookpoints())
+	})
+}
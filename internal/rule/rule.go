@@ -45,8 +45,37 @@ type Engine struct {
 	instrumentationEngine                InstrumentationFace
 	perfHistogramUnit, perfHistogramBase float64
 	perfHistogramPeriod                  time.Duration
+	coverage                             InstrumentationCoverage
+	// protectionContext returns the protection context of the current
+	// goroutine when not nil, instead of the goroutine-local storage.
+	protectionContext func() ProtectionContext
+	// skipSignatureVerification disables the verification of the rule
+	// signatures.
+	skipSignatureVerification bool
 }
 
+// InstrumentationCoverage is the result of the instrumentation self-check
+// comparing the hookpoints expected by the rules with the hooks of the program
+// instrumentation.
+type InstrumentationCoverage struct {
+	// Number of distinct hookpoints expected by the rules.
+	Expected int
+	// Sorted list of the hookpoints not found in the program instrumentation.
+	Missing []string
+}
+
+// Ratio returns the ratio of the hookpoints of the rules that are present in
+// the program instrumentation. It is 1 when the rules don't expect any.
+func (c InstrumentationCoverage) Ratio() float64 {
+	if c.Expected == 0 {
+		return 1
+	}
+	return float64(c.Expected-len(c.Missing)) / float64(c.Expected)
+}
+
+// Name of the metrics store of the instrumentation coverage.
+const instrumentationCoverageMetricsStoreName = "sqreen_instrumentation_coverage"
+
 // NewEngine returns a new rule engine.
 func NewEngine(logger plog.DebugLevelLogger, instrumentationEngine InstrumentationFace, metricsEngine *metrics.Engine, publicKey *ecdsa.PublicKey, perfHistogramUnit, perfHistogramBase float64, perfHistogramPeriod time.Duration) *Engine {
 	if instrumentationEngine == nil {
@@ -69,6 +98,12 @@ func (e *Engine) Health(expectedVersion string) error {
 	return e.instrumentationEngine.Health(expectedVersion)
 }
 
+// InstrumentationCoverage returns the instrumentation coverage of the current
+// rules.
+func (e *Engine) InstrumentationCoverage() InstrumentationCoverage {
+	return e.coverage
+}
+
 // PackID returns the ID of the current pack of rules.
 func (e *Engine) PackID() string {
 	return e.packID
@@ -78,12 +113,37 @@ func (e *Engine) PackID() string {
 // them by atomically modifying the hooks, and removing what is left.
 func (e *Engine) SetRules(packID string, rules []api.Rule) {
 	// Create the new rule descriptors and replace the existing ones
-	var ruleDescriptors hookDescriptorMap
+	var (
+		ruleDescriptors hookDescriptorMap
+		coverage        InstrumentationCoverage
+	)
 	if len(rules) > 0 {
 		e.logger.Debugf("security rules: loading rules from pack `%s`", packID)
-		ruleDescriptors = newHookDescriptors(e, packID, rules)
+		ruleDescriptors, coverage = newHookDescriptors(e, packID, rules)
 	}
 	e.setRules(packID, ruleDescriptors)
+	e.setInstrumentationCoverage(coverage)
+}
+
+func (e *Engine) setInstrumentationCoverage(coverage InstrumentationCoverage) {
+	e.coverage = coverage
+	if e.metricsEngine == nil || coverage.Expected == 0 {
+		return
+	}
+	store := e.metricsEngine.TimeHistogram(instrumentationCoverageMetricsStoreName, e.perfHistogramPeriod, 10)
+	// The coverage is a gauge: the values of the last rules replace the previous
+	// ones of the same period instead of being summed up. The ratio is stored in
+	// percent as metrics values are integers.
+	for key, value := range map[string]uint64{
+		"expected": uint64(coverage.Expected),
+		"missing":  uint64(len(coverage.Missing)),
+		"percent":  uint64(coverage.Ratio() * 100),
+	} {
+		if err := store.Set(key, value); err != nil {
+			e.logger.Error(sqerrors.Wrap(err, "security rules: could not set the instrumentation coverage metrics"))
+			return
+		}
+	}
 }
 
 func (e *Engine) setRules(packID string, descriptors hookDescriptorMap) {
@@ -133,18 +193,23 @@ func (e *Engine) setRules(packID string, descriptors hookDescriptorMap) {
 
 // newHookDescriptors walks the list of received rules and creates the map of
 // hook descriptors indexed by their hook pointer. A hook descriptor contains
-// all it takes to enable and disable rules at run time.
-func newHookDescriptors(e *Engine, rulepackID string, rules []api.Rule) hookDescriptorMap {
+// all it takes to enable and disable rules at run time. The hookpoints of the
+// rules are also checked against the program instrumentation and the returned
+// coverage lists those that could not be found.
+func newHookDescriptors(e *Engine, rulepackID string, rules []api.Rule) (hookDescriptorMap, InstrumentationCoverage) {
 	logger := e.logger
+	hookpoints := make(map[string]bool)
 
 	// Create and configure the list of callbacks according to the given rules
 	var hookDescriptors = make(hookDescriptorMap)
 	for i := len(rules) - 1; i >= 0; i-- {
 		r := rules[i]
 		// Verify the signature
-		if err := VerifyRuleSignature(&r, e.publicKey); err != nil {
-			logger.Error(sqerrors.Wrapf(err, "security rules: rule `%s`: signature verification", r.Name))
-			continue
+		if !e.skipSignatureVerification {
+			if err := VerifyRuleSignature(&r, e.publicKey); err != nil {
+				logger.Error(sqerrors.Wrapf(err, "security rules: rule `%s`: signature verification", r.Name))
+				continue
+			}
 		}
 		// Find the symbol
 		hookpoint := r.Hookpoint
@@ -154,6 +219,7 @@ func newHookDescriptors(e *Engine, rulepackID string, rules []api.Rule) hookDesc
 			logger.Error(sqerrors.Wrapf(err, "security rules: rule `%s`: unexpected error while looking for the hook of `%s`", r.Name, symbol))
 			continue
 		}
+		hookpoints[symbol] = hook != nil
 		if hook == nil {
 			logger.Debugf("security rules: rule `%s`: could not find the hook of function `%s`", r.Name, symbol)
 			continue
@@ -167,6 +233,9 @@ func newHookDescriptors(e *Engine, rulepackID string, rules []api.Rule) hookDesc
 			logger.Error(sqerrors.Wrapf(err, "security rules: rule `%s`: callback configuration", r.Name))
 			continue
 		}
+		if e.protectionContext != nil {
+			ruleCtx.protectionContext = e.protectionContext
+		}
 
 		// Create the prolog callback
 		var prolog sqhook.PrologCallback
@@ -185,7 +254,7 @@ func newHookDescriptors(e *Engine, rulepackID string, rules []api.Rule) hookDesc
 			}
 
 		case "reflected":
-			prolog, err = NewReflectedCallback(hookpoint.Callback, ruleCtx, &r)
+			prolog, err = NewReflectedCallback(hookpoint.Callback, ruleCtx, &r, hookPrologFuncType(hook))
 			if err != nil {
 				logger.Error(sqerrors.Wrapf(err, "security rules: rule `%s`: callback constructor", r.Name))
 				continue
@@ -196,11 +265,19 @@ func newHookDescriptors(e *Engine, rulepackID string, rules []api.Rule) hookDesc
 		// disable it afterwards.
 		hookDescriptors.Add(hook, prolog, r.Priority)
 	}
+	coverage := InstrumentationCoverage{Expected: len(hookpoints)}
+	for symbol, found := range hookpoints {
+		if !found {
+			coverage.Missing = append(coverage.Missing, symbol)
+		}
+	}
+	sort.Strings(coverage.Missing)
+
 	// Nothing in the end
 	if len(hookDescriptors) == 0 {
-		return nil
+		return nil, coverage
 	}
-	return hookDescriptors
+	return hookDescriptors, coverage
 }
 
 // Enable the hooks of the ongoing configured rules.
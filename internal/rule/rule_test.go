@@ -98,6 +98,8 @@ func TestEngineUsage(t *testing.T) {
 		engine.Enable()
 		engine.SetRules("my other pack id", []api.Rule{})
 		require.Equal(t, engine.PackID(), "my other pack id")
+		require.Empty(t, engine.InstrumentationCoverage().Missing)
+		require.Equal(t, 1.0, engine.InstrumentationCoverage().Ratio())
 	})
 
 	t.Run("setting multiple rules", func(t *testing.T) {
@@ -168,6 +170,12 @@ func TestEngineUsage(t *testing.T) {
 			engine := rule.NewEngine(logger, instrumentation, metrics, publicKey, 1, 1, time.Minute)
 			engine.Disable()
 			engine.SetRules("yet another pack id", rules)
+
+			// The missing hookpoint is reported by the instrumentation coverage
+			coverage := engine.InstrumentationCoverage()
+			require.Equal(t, 3, coverage.Expected)
+			require.Equal(t, []string{"main.main"}, coverage.Missing)
+			require.InDelta(t, 2.0/3.0, coverage.Ratio(), 0.001)
 		})
 
 		t.Run("enabling the rules attaches the callbacks", func(t *testing.T) {
@@ -508,6 +516,62 @@ func TestEngineUsage(t *testing.T) {
 	})
 }
 
+func TestInstrumentationCoverageMetrics(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	publicKey := &privateKey.PublicKey
+
+	logger := plog.NewLogger(plog.Debug, os.Stderr, nil)
+	metricsEngine := metrics.NewEngine()
+
+	rules := []api.Rule{
+		{
+			Name: "a valid rule",
+			Hookpoint: api.Hookpoint{
+				Method:   thisPkgPath + ".func1",
+				Callback: "WriteCustomErrorPage",
+			},
+			Data: api.RuleData{
+				Values: []api.RuleDataEntry{
+					{&api.CustomErrorPageRuleDataEntry{}},
+				},
+			},
+			Signature: MakeSignature(privateKey, `{"name":"a valid rule"}`),
+		},
+		{
+			Name: "valid rule but no hookpoint",
+			Hookpoint: api.Hookpoint{
+				Method:   "main.main",
+				Callback: "WriteCustomErrorPage",
+			},
+			Signature: MakeSignature(privateKey, `{"name":"my rule"}`),
+		},
+	}
+
+	instrumentation := &instrumentationMockup{}
+	defer instrumentation.AssertExpectations(t)
+	instrumentation.ExpectFind(fmt.Sprintf("%s.%s", thisPkgPath, "func1")).Return(&hookMockup{}, nil).Twice()
+	instrumentation.ExpectFind("main.main").Return(rule.HookFace(nil), nil).Twice()
+
+	// Reload the rules twice in the same metrics period
+	period := time.Second
+	engine := rule.NewEngine(logger, instrumentation, metricsEngine, publicKey, 1, 1, period)
+	engine.Disable()
+	engine.SetRules("pack id", rules)
+	engine.SetRules("pack id", rules)
+
+	// The coverage metrics of the last rules replace the previous ones instead
+	// of being summed up
+	time.Sleep(period)
+	ready := metricsEngine.ReadyMetrics()
+	require.Contains(t, ready, "sqreen_instrumentation_coverage")
+	require.Equal(t, metrics.ReadyStoreMap{
+		"expected": 2,
+		"missing":  1,
+		"percent":  50,
+	}, ready["sqreen_instrumentation_coverage"].Metrics())
+}
+
 func MakeSignature(privateKey *ecdsa.PrivateKey, message string) api.RuleSignature {
 	hash := sha512.Sum512([]byte(message))
 	r, s, err := ecdsa.Sign(rand.Reader, privateKey, hash[:])
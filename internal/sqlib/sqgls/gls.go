@@ -2,12 +2,22 @@
 // Please refer to our terms for more information:
 // https://www.sqreen.io/terms.html
 
+// Package sqgls provides a goroutine-local storage (GLS) to programs
+// instrumented with the instrumentation tool, which adds a GLS field to the
+// runtime goroutine structure. Goroutines created by `go` statements inherit
+// the GLS value of their parent goroutine when created, the same way as the
+// pprof labels, and their GLS value is cleared when they exit. Note that the
+// GLS value is therefore shared by the goroutines and can outlive the parent
+// goroutine.
 package sqgls
 
+// Get returns the GLS value of the current goroutine.
 func Get() interface{} {
 	return get()
 }
 
+// Set sets the GLS value of the current goroutine. It doesn't change the value
+// of the goroutines created before.
 func Set(v interface{}) {
 	set(v)
 }
@@ -0,0 +1,256 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package sqhook
+
+import (
+	"reflect"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqgo"
+	"github.com/sqreen/go-agent/internal/sqlib/sqsafe"
+)
+
+// Generic functions cannot be used as function values and their prolog type
+// cannot depend on their type parameters. The instrumentation tool therefore
+// identifies them by their symbol name, without type arguments, such as
+// `pkg.F`, `pkg.T.M` or `pkg.(*T).M`, and their prolog variable has type
+// `**GenericPrologCallback`. Every instantiation of a generic function calls
+// the same prolog with the list of pointers to its parameters.
+//
+// Besides generic and reflected prologs, regular prolog callbacks can be
+// attached to generic functions. Their type is then checked against the
+// parameters of every call, so that they are only called by the
+// instantiations having the expected signature. For example, the prolog
+// `func(*string) (func(*int), error)` of the generic function
+// `func F[T any](T) int` is only called by the instantiation `F[string]`.
+
+var genericPrologVarType = reflect.TypeOf((**GenericPrologCallback)(nil))
+
+// addGeneric creates the hook object for the generic function having the
+// given symbol name, adds it to the find map and returns it. It returns an
+// error if it is not possible.
+func (t symbolIndexType) addGeneric(symbol string, prologVar interface{}) (h *Hook, err error) {
+	if symbol == "" {
+		return nil, sqerrors.New("unexpected empty generic function symbol")
+	}
+
+	// Unvendor it so that it is not prefixed by `<app>/vendor/`
+	symbol = sqgo.Unvendor(symbol)
+
+	// The hook may have been already added by a previous lookup
+	if hook, exists := t[symbol]; exists {
+		return hook, nil
+	}
+
+	if prologVar == nil {
+		return nil, sqerrors.Errorf("symbol `%s`: unexpected prolog variable argument value `nil`", symbol)
+	}
+	prologVarValue := reflect.ValueOf(prologVar)
+	if prologVarType := prologVarValue.Type(); prologVarType != genericPrologVarType {
+		return nil, sqerrors.Errorf("symbol `%s`: unexpected generic prolog variable type `%s` instead of `%s`", symbol, prologVarType, genericPrologVarType)
+	}
+
+	hook := &Hook{
+		symbol:         symbol,
+		prologFuncType: genericPrologVarType.Elem().Elem(),
+		prologVarAddr:  (*unsafe.Pointer)(unsafe.Pointer(prologVarValue.Pointer())),
+		generic:        true,
+	}
+	t[symbol] = hook
+	return hook, nil
+}
+
+// attachGeneric atomically attaches the given prologs and panic observers to
+// the hook of a generic function.
+func (h *Hook) attachGeneric(prologs []PrologCallback, observers []PanicObserver) error {
+	reflected := make([]ReflectedPrologCallback, len(prologs))
+	var prolog GenericPrologCallback
+	for i, p := range prologs {
+		for {
+			getter, ok := p.(PrologCallbackGetter)
+			if !ok {
+				break
+			}
+			p = getter.PrologCallback()
+		}
+
+		switch actual := p.(type) {
+		case GenericPrologCallback:
+			prolog = actual
+			reflected[i] = makeReflectedGenericPrologCallback(actual)
+		case ReflectedPrologCallback:
+			reflected[i] = actual
+		default:
+			cb, err := makeInstantiationPrologCallback(p)
+			if err != nil {
+				return sqerrors.Wrapf(err, "unexpected prolog type for hook `%s`", h)
+			}
+			reflected[i] = cb
+		}
+	}
+
+	if len(reflected) > 1 {
+		prolog = makeGenericPrologCallback(makeMultiReflectedPrologCallback(reflected))
+	} else if prolog == nil && len(reflected) == 1 {
+		prolog = makeGenericPrologCallback(reflected[0])
+	}
+
+	if len(observers) > 0 {
+		prolog = makePanicObservingGenericPrologCallback(prolog, observers)
+	}
+
+	// Atomically store the pointer to the prolog
+	ptr := new(GenericPrologCallback)
+	*ptr = prolog
+	atomic.StorePointer(h.prologVarAddr, unsafe.Pointer(ptr))
+	return nil
+}
+
+// makeGenericPrologCallback returns the generic prolog calling the given
+// reflected prolog with the reflected values of the pointers to the
+// parameters of the function call.
+func makeGenericPrologCallback(prolog ReflectedPrologCallback) GenericPrologCallback {
+	return func(params []interface{}) (GenericEpilogCallback, error) {
+		epilog, err := prolog(reflectedValues(params))
+		if epilog == nil {
+			return nil, err
+		}
+		return func(results []interface{}) {
+			epilog(reflectedValues(results))
+		}, err
+	}
+}
+
+// makeReflectedGenericPrologCallback returns the reflected prolog calling the
+// given generic prolog.
+func makeReflectedGenericPrologCallback(prolog GenericPrologCallback) ReflectedPrologCallback {
+	return func(params []reflect.Value) (ReflectedEpilogCallback, error) {
+		epilog, err := prolog(interfaceValues(params))
+		if epilog == nil {
+			return nil, err
+		}
+		return func(results []reflect.Value) {
+			epilog(interfaceValues(results))
+		}, err
+	}
+}
+
+// makeMultiReflectedPrologCallback returns the reflected prolog calling the
+// given list of reflected prologs until one of them returns an error. The
+// returned epilog calls the epilogs they returned.
+func makeMultiReflectedPrologCallback(prologs []ReflectedPrologCallback) ReflectedPrologCallback {
+	return func(params []reflect.Value) (epilog ReflectedEpilogCallback, err error) {
+		var epilogs []ReflectedEpilogCallback
+		safeCallErr := sqsafe.Call(func() error {
+			for _, prolog := range prologs {
+				e, prologErr := prolog(params)
+				if e != nil {
+					epilogs = append(epilogs, e)
+				}
+				if prologErr != nil {
+					err = prologErr
+					return nil
+				}
+			}
+			return nil
+		})
+		if safeCallErr != nil {
+			// TODO: log this error once
+		}
+		if len(epilogs) > 0 {
+			epilog = func(results []reflect.Value) {
+				for _, epilog := range epilogs {
+					epilog(results)
+				}
+			}
+		}
+		return epilog, err
+	}
+}
+
+// makeInstantiationPrologCallback returns the reflected prolog calling the
+// given regular prolog when the function call parameters have the types it
+// expects, and ignoring the call otherwise. The returned epilog is called the
+// same way.
+func makeInstantiationPrologCallback(prolog PrologCallback) (ReflectedPrologCallback, error) {
+	prologValue := reflect.ValueOf(prolog)
+	if err := validateInstantiationProlog(prologValue); err != nil {
+		return nil, err
+	}
+	prologType := prologValue.Type()
+	epilogType := prologType.Out(0)
+	return func(params []reflect.Value) (ReflectedEpilogCallback, error) {
+		if !isCallbackOfInstantiation(prologType, params) {
+			return nil, nil
+		}
+		results := prologValue.Call(params)
+		var err error
+		if r1 := results[1]; !r1.IsNil() {
+			err = r1.Interface().(error)
+		}
+		epilog := results[0]
+		if epilog.IsNil() {
+			return nil, err
+		}
+		return func(results []reflect.Value) {
+			if isCallbackOfInstantiation(epilogType, results) {
+				epilog.Call(results)
+			}
+		}, err
+	}, nil
+}
+
+// validateInstantiationProlog validates that the prolog is a function
+// returning an epilog function and an error.
+func validateInstantiationProlog(prolog reflect.Value) error {
+	if !prolog.IsValid() || prolog.Kind() != reflect.Func {
+		return sqerrors.Errorf("the prolog `%v` is not a function", prolog)
+	}
+	prologType := prolog.Type()
+	if numOut := prologType.NumOut(); numOut != 2 {
+		return sqerrors.Errorf("unexpected number result values: expected `2` but got `%d`", numOut)
+	}
+	if retType := prologType.Out(1); retType != reflect.TypeOf((*error)(nil)).Elem() {
+		return sqerrors.Errorf("unexpected second result value type `%s` instead of `error`", retType)
+	}
+	if epilogType := prologType.Out(0); epilogType.Kind() != reflect.Func || epilogType.NumOut() != 0 {
+		return sqerrors.Errorf("unexpected epilog type `%s`", epilogType)
+	}
+	return nil
+}
+
+// isCallbackOfInstantiation returns true when the callback type expects the
+// given argument values.
+func isCallbackOfInstantiation(callbackType reflect.Type, args []reflect.Value) bool {
+	if callbackType.NumIn() != len(args) {
+		return false
+	}
+	for i, arg := range args {
+		if callbackType.In(i) != arg.Type() {
+			return false
+		}
+	}
+	return true
+}
+
+func reflectedValues(values []interface{}) []reflect.Value {
+	reflected := make([]reflect.Value, len(values))
+	for i, v := range values {
+		reflected[i] = reflect.ValueOf(v)
+	}
+	return reflected
+}
+
+func interfaceValues(values []reflect.Value) []interface{} {
+	faces := make([]interface{}, len(values))
+	for i, v := range values {
+		faces[i] = v.Interface()
+	}
+	return faces
+}
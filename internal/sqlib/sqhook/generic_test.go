@@ -0,0 +1,140 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package sqhook
+
+import (
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+)
+
+// genericFunction simulates the instrumentation of the generic function
+// `func F[T any](p T) (r T)` by calling the prolog loaded from the given
+// prolog variable.
+func genericFunction(prologVar **GenericPrologCallback, p interface{}) (r interface{}, aborted bool) {
+	prolog := (*GenericPrologCallback)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(prologVar))))
+	if prolog == nil {
+		return p, false
+	}
+	// Simulate the instantiation by passing pointers to values of the actual
+	// type.
+	params := []interface{}{reflect.New(reflect.TypeOf(p)).Interface()}
+	reflect.ValueOf(params[0]).Elem().Set(reflect.ValueOf(p))
+	epilog, err := (*prolog)(params)
+	result := reflect.New(reflect.TypeOf(p))
+	if epilog != nil {
+		defer epilog([]interface{}{result.Interface()})
+	}
+	if err != nil {
+		return nil, true
+	}
+	result.Elem().Set(reflect.ValueOf(params[0]).Elem())
+	return result.Elem().Interface(), false
+}
+
+func TestGenericHook(t *testing.T) {
+	t.Run("instrumentation error", func(t *testing.T) {
+		var prologVar *GenericPrologCallback
+		var regularPrologVar *func(*int) (func(), error)
+		for _, tc := range []struct {
+			Name      string
+			Symbol    string
+			PrologVar interface{}
+		}{
+			{Name: "empty symbol", Symbol: "", PrologVar: &prologVar},
+			{Name: "nil prolog var", Symbol: "pkg.F", PrologVar: nil},
+			{Name: "not a generic prolog var", Symbol: "pkg.F", PrologVar: &regularPrologVar},
+			{Name: "prolog var is not a pointer", Symbol: "pkg.F", PrologVar: prologVar},
+		} {
+			t.Run(tc.Name, func(t *testing.T) {
+				h, err := symbolIndexType{}.add(tc.Symbol, tc.PrologVar)
+				require.Error(t, err)
+				require.Nil(t, h)
+			})
+		}
+	})
+
+	t.Run("attach", func(t *testing.T) {
+		var prologVar *GenericPrologCallback
+		index := symbolIndexType{}
+		hook, err := index.add("my-app/vendor/pkg.(*T).F", &prologVar)
+		require.NoError(t, err)
+		require.Equal(t, "pkg.(*T).F", hook.symbol)
+
+		// Added once
+		same, err := index.add("pkg.(*T).F", &prologVar)
+		require.NoError(t, err)
+		require.Equal(t, hook, same)
+
+		t.Run("generic prolog", func(t *testing.T) {
+			var called bool
+			require.NoError(t, hook.Attach(func(params []interface{}) (GenericEpilogCallback, error) {
+				require.Len(t, params, 1)
+				*params[0].(*string) = "modified"
+				return func(results []interface{}) {
+					called = true
+				}, nil
+			}))
+			r, aborted := genericFunction(&prologVar, "string")
+			require.False(t, aborted)
+			require.Equal(t, "modified", r)
+			require.True(t, called)
+		})
+
+		t.Run("per-instantiation prologs", func(t *testing.T) {
+			var stringCalls, intCalls, reflectedCalls int
+			stringProlog := func(p *string) (func(*string), error) {
+				stringCalls++
+				*p = "modified"
+				return func(r *string) { stringCalls++ }, nil
+			}
+			intProlog := func(p *int) (func(*int), error) {
+				intCalls++
+				return nil, errors.New("abort")
+			}
+			reflectedProlog := func(params []reflect.Value) (ReflectedEpilogCallback, error) {
+				reflectedCalls++
+				return nil, nil
+			}
+			require.NoError(t, hook.Attach(stringProlog, intProlog, ReflectedPrologCallback(reflectedProlog)))
+
+			r, aborted := genericFunction(&prologVar, "string")
+			require.False(t, aborted)
+			require.Equal(t, "modified", r)
+			require.Equal(t, 2, stringCalls)
+			require.Equal(t, 0, intCalls)
+			require.Equal(t, 1, reflectedCalls)
+
+			_, aborted = genericFunction(&prologVar, 33)
+			require.True(t, aborted)
+			require.Equal(t, 2, stringCalls)
+			require.Equal(t, 1, intCalls)
+			// The reflected prolog is not called after the abort error
+			require.Equal(t, 1, reflectedCalls)
+
+			r, aborted = genericFunction(&prologVar, 1.5)
+			require.False(t, aborted)
+			require.Equal(t, 1.5, r)
+			require.Equal(t, 2, reflectedCalls)
+		})
+
+		t.Run("wrong prolog types", func(t *testing.T) {
+			require.Error(t, hook.Attach(33))
+			require.Error(t, hook.Attach(func(*int) error { return nil }))
+			require.Error(t, hook.Attach(func(*int) (func() int, error) { return nil, nil }))
+		})
+
+		t.Run("disable", func(t *testing.T) {
+			require.NoError(t, hook.Attach(nil))
+			r, aborted := genericFunction(&prologVar, "string")
+			require.False(t, aborted)
+			require.Equal(t, "string", r)
+		})
+	})
+}
@@ -27,6 +27,21 @@
 // when not required. Context from the prolog can be shared with the epilog
 // using the epilog function closure.
 //
+// Generic functions are given the generic prolog and epilog signatures
+// instead, which are called with the pointers to the arguments and results of
+// every instantiation:
+//		type prolog = func(params []interface{}) (epilog, error)
+//		type epilog = func(results []interface{})
+// Regular prologs can still be attached to generic functions and are only
+// called by the instantiations having their signature.
+//
+// Panic observers can be attached along with the prologs in order to observe
+// the panics of the hooked function:
+//		type PanicObserver func(recovered interface{}) error
+// They are given the recovered value and can either let the panic continue by
+// returning a nil error, or convert it into the error return value of the
+// function. Note that the prolog is then called using `reflect.Call()`.
+//
 // Main requirements
 //
 // - Concurrent access and modification of callbacks.
@@ -51,6 +66,7 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"unsafe"
 
@@ -71,6 +87,9 @@ type symbolIndexType map[string]*Hook
 // a map of hook pointer in order to avoid GC overhead.
 var index = make(symbolIndexType)
 
+// indexLock serializes the lookups lazily adding hooks to the index.
+var indexLock sync.Mutex
+
 type Hook struct {
 	// Symbol name of the function the hook is associated with.
 	symbol string
@@ -79,6 +98,9 @@ type Hook struct {
 	// Pointer to the prolog pointer. The value has type **prologFuncType, which
 	// is checked at hook creation.
 	prologVarAddr *unsafe.Pointer
+	// True when the hooked function is generic. Its prolog type is then
+	// GenericPrologCallback.
+	generic bool
 }
 
 // PrologCallback is an interface to a prolog function.
@@ -96,6 +118,11 @@ type (
 	}
 	ReflectedPrologCallback = func(params []reflect.Value) (epilog ReflectedEpilogCallback, err error)
 	ReflectedEpilogCallback = func(results []reflect.Value)
+	// GenericPrologCallback is the prolog type of generic functions. Their
+	// prolog type cannot depend on their type parameters and they are rather
+	// given the lists of pointers to their parameters and results.
+	GenericPrologCallback = func(params []interface{}) (epilog GenericEpilogCallback, err error)
+	GenericEpilogCallback = func(results []interface{})
 )
 
 // Errors that hooks can return in order to modify the control flow of the
@@ -135,6 +162,8 @@ func Health(expectedVersion string) error {
 // Find returns the hook associated to the given symbol string when it was
 // created using `New()`, nil otherwise.
 func Find(symbol string) (*Hook, error) {
+	indexLock.Lock()
+	defer indexLock.Unlock()
 	return index.find(symbol)
 }
 
@@ -146,6 +175,10 @@ func (t symbolIndexType) find(symbol string) (*Hook, error) {
 		return hook, nil
 	}
 	// Not found in the index: lookup the hook table
+	if _sqreen_instrumentation_descriptor == nil {
+		// The program is not instrumented
+		return nil, nil
+	}
 	return hookTableLookup(_sqreen_instrumentation_descriptor.HookTable, symbol, index)
 }
 
@@ -187,6 +220,11 @@ func normalizedHookID(symbol string) string {
 // add creates the hook object for function `fn`, adds it to the find map and
 // returns it. It returns an error if it is not possible.
 func (t symbolIndexType) add(fn, prologVar interface{}) (h *Hook, err error) {
+	// Generic functions are given by their symbol name
+	if symbol, ok := fn.(string); ok {
+		return t.addGeneric(symbol, prologVar)
+	}
+
 	// Check fn is a non-nil function value
 	if fn == nil {
 		return nil, sqerrors.New("unexpected function argument value `nil`")
@@ -246,8 +284,20 @@ func (h *Hook) String() string {
 	return fmt.Sprintf("%s (%s)", h.symbol, h.prologFuncType)
 }
 
+// PrologFuncType returns the prolog function type expected by the hook.
+func (h *Hook) PrologFuncType() reflect.Type {
+	return h.prologFuncType
+}
+
+// Attached returns true when a prolog function is currently attached to the
+// hook.
+func (h *Hook) Attached() bool {
+	return atomic.LoadPointer(h.prologVarAddr) != nil
+}
+
 // Attach atomically attaches a prolog function to the hook. The hook can be
-// disabled with a `nil` prolog value.
+// disabled with a `nil` prolog value. Panic observers can be attached along
+// with the prologs.
 func (h *Hook) Attach(prologs ...PrologCallback) error {
 	addr := h.prologVarAddr
 	if l := len(prologs); l == 0 || (l == 1 && prologs[0] == nil) {
@@ -256,6 +306,12 @@ func (h *Hook) Attach(prologs ...PrologCallback) error {
 		return nil
 	}
 
+	prologs, observers := splitPanicObservers(prologs)
+
+	if h.generic {
+		return h.attachGeneric(prologs, observers)
+	}
+
 	prologCallbacks := make([]PrologCallback, len(prologs))
 	for i, prolog := range prologs {
 		// Loop until the prolog type is not one of the above
@@ -281,13 +337,20 @@ func (h *Hook) Attach(prologs ...PrologCallback) error {
 
 	// Create the prolog out of the prologCallbacks
 	var prolog PrologCallback
-	if l := len(prologCallbacks); l == 1 {
+	switch l := len(prologCallbacks); l {
+	case 0:
+		// Only panic observers
+	case 1:
 		prolog = prologCallbacks[0]
-	} else {
+	default:
 		// Create a dynamic function calling the prolog
 		prolog = makeMultiPrologCallback(h, prologCallbacks)
 	}
 
+	if len(observers) > 0 {
+		prolog = makePanicObservingPrologCallback(h, prolog, observers)
+	}
+
 	// Create a value having type "pointer to the prolog function"
 	ptr := reflect.New(h.prologFuncType)
 	// *ptr = prolog
@@ -397,6 +397,7 @@ func TestEnableDisable(t *testing.T) {
 	})
 	err = hook.Attach(expectedProlog.Interface())
 	require.NoError(t, err)
+	require.True(t, hook.Attached())
 
 	// Read the prolog variable and check it points to the previous prolog
 	// function
@@ -416,6 +417,7 @@ func TestEnableDisable(t *testing.T) {
 	// Walk the prolog var value in order to get the function pointer
 	prolog = loadProlog()
 	require.Equal(t, unsafe.Pointer(nil), prolog)
+	require.False(t, hook.Attached())
 }
 
 func TestStringer(t *testing.T) {
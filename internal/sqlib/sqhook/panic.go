@@ -0,0 +1,143 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package sqhook
+
+import (
+	"reflect"
+)
+
+// PanicObserver is a callback called with the value recovered from a panic of
+// the hooked function. Panic observers are attached to hooks along with the
+// prologs and are called in order until one of them returns a non-nil error.
+// This error is then returned by the hooked function instead of panicking,
+// provided its last result has type `error`. Otherwise, the panic continues
+// with the same recovered value. Panic observers can also re-panic with
+// another value.
+type (
+	PanicObserver       func(recovered interface{}) error
+	PanicObserverGetter interface {
+		PanicObserver() PanicObserver
+	}
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// splitPanicObservers separates the panic observers from the given list of
+// prologs. Values that are both prolog and panic observer getters are kept in
+// the prologs.
+func splitPanicObservers(callbacks []PrologCallback) (prologs []PrologCallback, observers []PanicObserver) {
+	for _, cb := range callbacks {
+		switch actual := cb.(type) {
+		case PanicObserver:
+			observers = append(observers, actual)
+			continue
+		case PanicObserverGetter:
+			observers = append(observers, actual.PanicObserver())
+			if _, ok := cb.(PrologCallbackGetter); !ok {
+				continue
+			}
+		}
+		prologs = append(prologs, cb)
+	}
+	return prologs, observers
+}
+
+// makePanicObservingPrologCallback returns the prolog calling the given prolog,
+// when not nil, and returning an epilog recovering the panics of the function
+// call in order to pass them to the panic observers. The epilog returned by the
+// prolog is then called.
+func makePanicObservingPrologCallback(h *Hook, prolog PrologCallback, observers []PanicObserver) PrologCallback {
+	prologValue := reflect.ValueOf(prolog)
+	epilogFuncType := h.prologFuncType.Out(0)
+	return reflect.MakeFunc(h.prologFuncType, func(params []reflect.Value) []reflect.Value {
+		var epilog reflect.Value
+		err := reflect.Zero(errorType)
+		if prologValue.IsValid() {
+			results := prologValue.Call(params)
+			epilog, err = results[0], results[1]
+		}
+		callEpilog := func(results []reflect.Value) {
+			if epilog.IsValid() && !epilog.IsNil() {
+				epilog.Call(results)
+			}
+		}
+		observingEpilog := reflect.MakeFunc(epilogFuncType, func(results []reflect.Value) []reflect.Value {
+			// recover() only stops the panic when called directly by the deferred
+			// function, which is this one.
+			recovered := recover()
+			if recovered == nil {
+				callEpilog(results)
+				return []reflect.Value{}
+			}
+			converted := setErrorResult(results, observePanic(observers, recovered))
+			callEpilog(results)
+			if !converted {
+				panic(recovered)
+			}
+			return []reflect.Value{}
+		})
+		return []reflect.Value{observingEpilog, err}
+	}).Interface()
+}
+
+// makePanicObservingGenericPrologCallback is the generic function counterpart
+// of makePanicObservingPrologCallback.
+func makePanicObservingGenericPrologCallback(prolog GenericPrologCallback, observers []PanicObserver) GenericPrologCallback {
+	return func(params []interface{}) (GenericEpilogCallback, error) {
+		var (
+			epilog GenericEpilogCallback
+			err    error
+		)
+		if prolog != nil {
+			epilog, err = prolog(params)
+		}
+		return func(results []interface{}) {
+			// recover() only stops the panic when called directly by the deferred
+			// function, which is this one.
+			recovered := recover()
+			if recovered == nil {
+				if epilog != nil {
+					epilog(results)
+				}
+				return
+			}
+			converted := setErrorResult(reflectedValues(results), observePanic(observers, recovered))
+			if epilog != nil {
+				epilog(results)
+			}
+			if !converted {
+				panic(recovered)
+			}
+		}, err
+	}
+}
+
+// observePanic calls the panic observers until one of them returns a non-nil
+// error, which is returned.
+func observePanic(observers []PanicObserver, recovered interface{}) error {
+	for _, observer := range observers {
+		if err := observer(recovered); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setErrorResult sets the last result of the function call to the given error
+// when not nil and when the result has type `error`. It returns true when the
+// error was set.
+func setErrorResult(results []reflect.Value, err error) bool {
+	if err == nil || len(results) == 0 {
+		return false
+	}
+	last := results[len(results)-1]
+	if last.Kind() != reflect.Ptr || last.IsNil() || last.Type().Elem() != errorType {
+		return false
+	}
+	last.Elem().Set(reflect.ValueOf(&err).Elem())
+	return true
+}
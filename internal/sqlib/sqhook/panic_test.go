@@ -0,0 +1,156 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package sqhook
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+)
+
+type panickingFunctionPrologType = func(*interface{}) (func(*error), error)
+
+var panickingFunctionPrologVar *panickingFunctionPrologType
+
+// panickingFunction simulates the instrumentation of a function panicking with
+// its argument value when not nil.
+func panickingFunction(v interface{}) (err error) {
+	if prolog := (*panickingFunctionPrologType)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&panickingFunctionPrologVar)))); prolog != nil {
+		epilog, prologErr := (*prolog)(&v)
+		if epilog != nil {
+			defer epilog(&err)
+		}
+		if prologErr != nil {
+			return prologErr
+		}
+	}
+	if v != nil {
+		panic(v)
+	}
+	return nil
+}
+
+func TestPanicObserver(t *testing.T) {
+	hook, err := symbolIndexType{}.add(panickingFunction, &panickingFunctionPrologVar)
+	require.NoError(t, err)
+	defer hook.Attach(nil)
+
+	t.Run("panic observer only", func(t *testing.T) {
+		var observed interface{}
+		require.NoError(t, hook.Attach(PanicObserver(func(recovered interface{}) error {
+			observed = recovered
+			return nil
+		})))
+
+		// No panic
+		require.NoError(t, panickingFunction(nil))
+		require.Nil(t, observed)
+
+		// Not converted into an error
+		require.PanicsWithValue(t, "oops", func() { _ = panickingFunction("oops") })
+		require.Equal(t, "oops", observed)
+	})
+
+	t.Run("converted into an error", func(t *testing.T) {
+		var epilogErr error
+		prolog := func(*interface{}) (func(*error), error) {
+			return func(err *error) {
+				epilogErr = *err
+			}, nil
+		}
+		myErr := errors.New("converted")
+		var calls int
+		observers := []PrologCallback{
+			prolog,
+			PanicObserver(func(recovered interface{}) error {
+				calls++
+				return nil
+			}),
+			PanicObserver(func(recovered interface{}) error {
+				calls++
+				return myErr
+			}),
+			PanicObserver(func(recovered interface{}) error {
+				calls++
+				return nil
+			}),
+		}
+		require.NoError(t, hook.Attach(observers...))
+
+		require.NotPanics(t, func() {
+			require.Equal(t, myErr, panickingFunction("oops"))
+		})
+		// The observers are called until one returns an error
+		require.Equal(t, 2, calls)
+		// The epilog sees the error
+		require.Equal(t, myErr, epilogErr)
+	})
+
+	t.Run("re-panic", func(t *testing.T) {
+		require.NoError(t, hook.Attach(PanicObserver(func(recovered interface{}) error {
+			panic("re-panic")
+		})))
+		require.PanicsWithValue(t, "re-panic", func() { _ = panickingFunction("oops") })
+	})
+
+	t.Run("panic observer getter", func(t *testing.T) {
+		var observed interface{}
+		require.NoError(t, hook.Attach(panicObserverGetter(func(recovered interface{}) error {
+			observed = recovered
+			return errors.New("converted")
+		})))
+		require.Error(t, panickingFunction("oops"))
+		require.Equal(t, "oops", observed)
+	})
+
+	t.Run("aborted call", func(t *testing.T) {
+		myErr := errors.New("abort")
+		require.NoError(t, hook.Attach(
+			func(*interface{}) (func(*error), error) { return nil, myErr },
+			PanicObserver(func(interface{}) error { return nil })))
+		require.Equal(t, myErr, panickingFunction("oops"))
+	})
+
+	t.Run("function without error result", func(t *testing.T) {
+		var prologVar *func() (func(), error)
+		hook, err := symbolIndexType{}.add(func() {}, &prologVar)
+		require.NoError(t, err)
+		require.NoError(t, hook.Attach(PanicObserver(func(interface{}) error {
+			return errors.New("converted")
+		})))
+		epilog, err := (*prologVar)()
+		require.NoError(t, err)
+		require.PanicsWithValue(t, "oops", func() {
+			defer epilog()
+			panic("oops")
+		})
+	})
+
+	t.Run("generic function", func(t *testing.T) {
+		var prologVar *GenericPrologCallback
+		hook, err := symbolIndexType{}.add("pkg.F", &prologVar)
+		require.NoError(t, err)
+		myErr := errors.New("converted")
+		require.NoError(t, hook.Attach(PanicObserver(func(recovered interface{}) error {
+			return myErr
+		})))
+
+		var result error
+		require.NotPanics(t, func() {
+			epilog, err := (*prologVar)(nil)
+			require.NoError(t, err)
+			defer epilog([]interface{}{&result})
+			panic("oops")
+		})
+		require.Equal(t, myErr, result)
+	})
+}
+
+type panicObserverGetter PanicObserver
+
+func (g panicObserverGetter) PanicObserver() PanicObserver { return PanicObserver(g) }
@@ -11,6 +11,20 @@ import (
 	"github.com/sqreen/go-agent/internal/sqlib/sqsafe"
 )
 
+const (
+	// SensitiveKeyPattern is the pattern of sensitive key names: passwd,
+	// password, passphrase, secret, authorization, api_key, apikey,
+	// accesstoken, access_token and token. It must be compiled
+	// case-insensitively, as done by DefaultKeyRegexp.
+	SensitiveKeyPattern = `(passw(((or)?d))|(phrase))|(secret)|(authorization)|(api_?key)|((access_?)?token)`
+	// DefaultKeyRegexp is the default regular expression of sensitive keys.
+	DefaultKeyRegexp = `(?i)` + SensitiveKeyPattern
+	// DefaultValueRegexp is the default regular expression of sensitive
+	// values. It matches credit card numbers with space, dash or no number
+	// separators.
+	DefaultValueRegexp = `(?:\d[ -]*?){13,16}`
+)
+
 // Scrubber scrubs values according to the key and value regular expressions
 // given to `NewScrubber()`. Field names and map keys of type string will be
 // checked against the regular expression for keys, while string values will be
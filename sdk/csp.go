@@ -0,0 +1,127 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// CSPViolationEvent is the name of the custom security event tracked by
+// CSPReportHandler() for every Content-Security-Policy violation report.
+const CSPViolationEvent = "csp.violation"
+
+// Maximum size in bytes of the CSP violation report requests.
+const maxCSPReportSize = 64 * 1024
+
+// CSPReportHandler returns the HTTP handler of the Content-Security-Policy
+// violation reports sent by browsers to the policy `report-uri`. Every report
+// is tracked as a custom security event named `csp.violation` whose properties
+// are the report values. Both the `report-uri` format and the Reporting API
+// format are supported. The handler must be protected by a Sqreen middleware
+// function.
+//
+// Usage example:
+//
+//	http.Handle("/csp-report", sqhttp.Middleware(sdk.CSPReportHandler()))
+//
+func CSPReportHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		reports, err := readCSPReports(io.LimitReader(r.Body, maxCSPReportSize))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		sqreen := FromRequest(r)
+		for _, report := range reports {
+			sqreen.TrackEvent(CSPViolationEvent).WithProperties(report)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Report values kept as event properties, per report format.
+var (
+	cspReportURIProperties = map[string]string{
+		"document-uri":        "document_uri",
+		"referrer":            "referrer",
+		"blocked-uri":         "blocked_uri",
+		"violated-directive":  "violated_directive",
+		"effective-directive": "effective_directive",
+		"source-file":         "source_file",
+		"line-number":         "line_number",
+		"column-number":       "column_number",
+		"disposition":         "disposition",
+	}
+	cspReportingAPIProperties = map[string]string{
+		"documentURL":        "document_uri",
+		"referrer":           "referrer",
+		"blockedURL":         "blocked_uri",
+		"effectiveDirective": "effective_directive",
+		"sourceFile":         "source_file",
+		"lineNumber":         "line_number",
+		"columnNumber":       "column_number",
+		"disposition":        "disposition",
+	}
+)
+
+// readCSPReports reads either a single report of the `report-uri` format
+// `{"csp-report": {...}}` or a list of reports of the Reporting API format
+// `[{"type": "csp-violation", "body": {...}}]`.
+func readCSPReports(r io.Reader) (reports []EventPropertyMap, err error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var reportURI struct {
+		Report map[string]interface{} `json:"csp-report"`
+	}
+	if err := json.Unmarshal(buf, &reportURI); err == nil {
+		if reportURI.Report == nil {
+			return nil, fmt.Errorf("missing csp report")
+		}
+		return []EventPropertyMap{newCSPReportProperties(reportURI.Report, cspReportURIProperties)}, nil
+	}
+
+	var reportingAPI []struct {
+		Type string                 `json:"type"`
+		Body map[string]interface{} `json:"body"`
+	}
+	if err := json.Unmarshal(buf, &reportingAPI); err != nil {
+		return nil, err
+	}
+	for _, report := range reportingAPI {
+		if report.Type != "csp-violation" || report.Body == nil {
+			continue
+		}
+		reports = append(reports, newCSPReportProperties(report.Body, cspReportingAPIProperties))
+	}
+	return reports, nil
+}
+
+func newCSPReportProperties(report map[string]interface{}, names map[string]string) EventPropertyMap {
+	props := make(EventPropertyMap, len(names))
+	for key, name := range names {
+		v, exists := report[key]
+		if !exists || v == nil {
+			continue
+		}
+		props[name] = fmt.Sprint(v)
+	}
+	return props
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package sdk_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/protection/context/_testlib"
+	"github.com/sqreen/go-agent/sdk"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSPReportHandler(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		body     string
+		expected []sdk.EventPropertyMap
+	}{
+		{
+			name: "report-uri format",
+			body: `{"csp-report": {"document-uri": "https://my.site/page", "blocked-uri": "https://evil.com/x.js", "violated-directive": "script-src", "line-number": 10, "original-policy": "script-src 'self'"}}`,
+			expected: []sdk.EventPropertyMap{
+				{
+					"document_uri":       "https://my.site/page",
+					"blocked_uri":        "https://evil.com/x.js",
+					"violated_directive": "script-src",
+					"line_number":        "10",
+				},
+			},
+		},
+		{
+			name: "reporting api format",
+			body: `[{"type": "csp-violation", "url": "https://my.site/page", "body": {"documentURL": "https://my.site/page", "blockedURL": "inline", "effectiveDirective": "script-src-elem", "disposition": "enforce"}}, {"type": "deprecation", "body": {}}]`,
+			expected: []sdk.EventPropertyMap{
+				{
+					"document_uri":        "https://my.site/page",
+					"blocked_uri":         "inline",
+					"effective_directive": "script-src-elem",
+					"disposition":         "enforce",
+				},
+			},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, recorder := newMockups()
+			defer recorder.AssertExpectations(t)
+
+			event := &_testlib.EventRecorderMockup{}
+			defer event.AssertExpectations(t)
+
+			recorder.ExpectTrackEvent(sdk.CSPViolationEvent).Return(event).Times(len(tc.expected))
+			for _, props := range tc.expected {
+				event.ExpectWithProperties(props).Once()
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader(tc.body)).WithContext(ctx)
+			rec := httptest.NewRecorder()
+			sdk.CSPReportHandler().ServeHTTP(rec, req)
+			require.Equal(t, http.StatusNoContent, rec.Code)
+		})
+	}
+
+	t.Run("bad requests", func(t *testing.T) {
+		ctx, recorder := newMockups()
+		defer recorder.AssertExpectations(t)
+
+		for _, body := range []string{"", "{", `{"a": 1}`, "33"} {
+			req := httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader(body)).WithContext(ctx)
+			rec := httptest.NewRecorder()
+			sdk.CSPReportHandler().ServeHTTP(rec, req)
+			require.Equal(t, http.StatusBadRequest, rec.Code, body)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/csp-report", nil).WithContext(ctx)
+		rec := httptest.NewRecorder()
+		sdk.CSPReportHandler().ServeHTTP(rec, req)
+		require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+}
@@ -108,6 +108,11 @@ func (a *RootHTTPProtectionContextMockup) DeadlineExceeded(needed time.Duration)
 	return a.Called(needed).Bool(0)
 }
 
+func (a *RootHTTPProtectionContextMockup) RemainingTime() (remaining time.Duration, limited bool) {
+	ret := a.Called()
+	return ret.Get(0).(time.Duration), ret.Bool(1)
+}
+
 func (a *RootHTTPProtectionContextMockup) Context() context.Context {
 	c, _ := a.Called().Get(0).(context.Context)
 	return c
@@ -142,6 +147,7 @@ func NewHTTPProtectionConfigMockup() *HTTPProtectionConfigMockup {
 	m := &HTTPProtectionConfigMockup{}
 	m.ExpectHTTPClientIPHeader().Return("").Maybe()
 	m.ExpectHTTPClientIPHeaderFormat().Return("").Maybe()
+	m.ExpectCookieSigningKey().Return("").Maybe()
 	return m
 }
 
@@ -160,3 +166,11 @@ func (c *HTTPProtectionConfigMockup) HTTPClientIPHeaderFormat() string {
 func (c *HTTPProtectionConfigMockup) ExpectHTTPClientIPHeaderFormat() *mock.Call {
 	return c.On("HTTPClientIPHeaderFormat")
 }
+
+func (c *HTTPProtectionConfigMockup) CookieSigningKey() string {
+	return c.Called().String(0)
+}
+
+func (c *HTTPProtectionConfigMockup) ExpectCookieSigningKey() *mock.Call {
+	return c.On("CookieSigningKey")
+}
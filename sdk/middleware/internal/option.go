@@ -0,0 +1,30 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+// Package internal provides the helpers shared by the middleware packages.
+package internal
+
+import (
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/sdk/middleware/option"
+)
+
+// ProtectionOptions returns the protection context options of the given
+// middleware options.
+func ProtectionOptions(opts []option.Option) []http_protection.Option {
+	if len(opts) == 0 {
+		return nil
+	}
+	protectionOpts := make([]http_protection.Option, 0, len(opts))
+	for _, opt := range opts {
+		if opt, ok := opt.(interface {
+			ProtectionOption() http_protection.Option
+		}); ok {
+			protectionOpts = append(protectionOpts, opt.ProtectionOption())
+		}
+	}
+	return protectionOpts
+}
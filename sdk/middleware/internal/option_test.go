@@ -0,0 +1,31 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package internal
+
+import (
+	"testing"
+
+	"github.com/sqreen/go-agent/sdk/middleware/option"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtectionOptions(t *testing.T) {
+	require.Nil(t, ProtectionOptions(nil))
+
+	_, err := option.WithUserIdentifierFromJWTClaim(option.JWTUserIdentifierConfig{Claim: "sub"})
+	require.Error(t, err)
+	jwt, err := option.WithUserIdentifierFromJWTClaim(option.JWTUserIdentifierConfig{Claim: "sub", Secret: []byte("secret")})
+	require.NoError(t, err)
+
+	opts := ProtectionOptions([]option.Option{
+		option.WithUserIdentifierFromHeader("X-User-Id", "uid"),
+		option.WithUserIdentifierFromCookie("uid", "uid"),
+		jwt,
+	})
+	require.Len(t, opts, 3)
+	for _, opt := range opts {
+		require.NotNil(t, opt)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+// Package option provides the options of the Sqreen middleware functions, such
+// as sqhttp.Middleware(). They are also available in every middleware package
+// under the same names.
+package option
+
+import (
+	"crypto/rsa"
+
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+)
+
+// Option is a middleware option. Options can only be created by the functions
+// of this package.
+type Option interface {
+	isMiddlewareOption()
+}
+
+// option is the Option implementation wrapping the protection context option.
+type option http_protection.Option
+
+func (option) isMiddlewareOption() {}
+
+// ProtectionOption returns the wrapped protection context option. It is only
+// meant to be used by the middleware packages.
+func (o option) ProtectionOption() http_protection.Option {
+	return http_protection.Option(o)
+}
+
+// JWTUserIdentifierConfig is the configuration of the middleware option
+// WithUserIdentifierFromJWTClaim().
+type JWTUserIdentifierConfig struct {
+	// Claim is the name of the JWT claim to use as user identifier value.
+	Claim string
+	// Key is the user identifier key. The claim name is used when empty.
+	Key string
+	// Cookie is the name of the cookie containing the token. The token is taken
+	// from the `Authorization: Bearer` request header when empty.
+	Cookie string
+	// Secret is the HMAC secret key to verify HS256 tokens.
+	Secret []byte
+	// PublicKey is the RSA public key to verify RS256 tokens.
+	PublicKey *rsa.PublicKey
+}
+
+// WithUserIdentifierFromHeader returns a middleware option automatically
+// identifying the request user with the value of the given request header.
+// The value is used as the user identifier `key` of the map of user
+// identifiers, as with `sdk.ForUser()`. The middleware then calls
+// `Identify()` before the request handler so that blocked users are stopped
+// without any handler code change.
+//
+// Usage example:
+//
+//	// Identify users with `sdk.EventUserIdentifiersMap{"uid": <X-User-Id value>}`
+//	WithUserIdentifierFromHeader("X-User-Id", "uid")
+//
+func WithUserIdentifierFromHeader(header, key string) Option {
+	return option(http_protection.WithUserIdentifierFromHeader(header, key))
+}
+
+// WithUserIdentifierFromCookie returns a middleware option automatically
+// identifying the request user with the value of the given request cookie,
+// similarly to WithUserIdentifierFromHeader().
+func WithUserIdentifierFromCookie(cookie, key string) Option {
+	return option(http_protection.WithUserIdentifierFromCookie(cookie, key))
+}
+
+// WithUserIdentifierFromJWTClaim returns a middleware option automatically
+// identifying the request user with the value of a claim of the request's JSON
+// Web Token, similarly to WithUserIdentifierFromHeader(). The token is only
+// used when its HS256 or RS256 signature is successfully verified with the
+// configured key. An error is returned when the configuration is invalid.
+//
+// Usage example:
+//
+//	opt, err := WithUserIdentifierFromJWTClaim(JWTUserIdentifierConfig{
+//		Claim:  "sub",
+//		Key:    "uid",
+//		Secret: []byte("my-hs256-secret"),
+//	})
+//
+func WithUserIdentifierFromJWTClaim(cfg JWTUserIdentifierConfig) (Option, error) {
+	opt, err := http_protection.WithUserIdentifierFromJWTClaim(http_protection.JWTUserIdentifierConfig{
+		Claim:     cfg.Claim,
+		Key:       cfg.Key,
+		Cookie:    cfg.Cookie,
+		Secret:    cfg.Secret,
+		PublicKey: cfg.PublicKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return option(opt), nil
+}
@@ -5,6 +5,7 @@
 package sqecho
 
 import (
+	"bufio"
 	"bytes"
 	"net"
 	"net/http"
@@ -18,6 +19,7 @@ import (
 	http_protection "github.com/sqreen/go-agent/internal/protection/http"
 	"github.com/sqreen/go-agent/internal/protection/http/types"
 	"github.com/sqreen/go-agent/sdk"
+	middleware_internal "github.com/sqreen/go-agent/sdk/middleware/internal"
 )
 
 // FromContext allows to access the HTTPRequestRecord from Echo request handlers
@@ -71,7 +73,11 @@ func FromContext(c echo.Context) sdk.Context {
 //		return nil
 //	}
 //
-func Middleware() echo.MiddlewareFunc {
+// Middleware options can be provided to configure the middleware, such as
+// WithUserIdentifierFromHeader() to automatically identify users before
+// calling the handler.
+//
+func Middleware(opts ...Option) echo.MiddlewareFunc {
 	internal.Start()
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -80,14 +86,14 @@ func Middleware() echo.MiddlewareFunc {
 				return next(c)
 			}
 			defer cancel()
-			return middlewareHandlerFromRootProtectionContext(ctx, next, c)
+			return middlewareHandlerFromRootProtectionContext(ctx, next, c, opts...)
 		}
 	}
 }
 
-func middlewareHandlerFromRootProtectionContext(ctx types.RootProtectionContext, next echo.HandlerFunc, c echo.Context) (err error) {
+func middlewareHandlerFromRootProtectionContext(ctx types.RootProtectionContext, next echo.HandlerFunc, c echo.Context, opts ...Option) (err error) {
 	r := &requestReaderImpl{c: c}
-	p := http_protection.NewProtectionContext(ctx, c.Response(), r)
+	p := http_protection.NewProtectionContext(ctx, c.Response(), r, middleware_internal.ProtectionOptions(opts)...)
 	if p == nil {
 		return next(c)
 	}
@@ -96,6 +102,16 @@ func middlewareHandlerFromRootProtectionContext(ctx types.RootProtectionContext,
 		p.Close(newObservedResponse(c.Response(), err))
 	}()
 
+	res := c.Response()
+	res.Before(func() {
+		p.BeforeWriteHeader(res.Header())
+	})
+
+	if p.ResponseWAFEnabled() {
+		// Only buffer the response when the response WAF needs to check it
+		defer bufferResponse(res, p.ResponseWAF)(&err)
+	}
+
 	return middlewareHandlerFromProtectionContext(p, next, c)
 }
 
@@ -208,6 +224,128 @@ func (r *requestReaderImpl) RemoteAddr() string {
 	return r.c.Request().RemoteAddr
 }
 
+// bufferResponse buffers echo's response until the returned commit function
+// gets called, which is expected before returning to echo. It sets the given
+// error to the response WAF error when it blocked the response. Note that the
+// responses echo's HTTP error handler writes after the middleware returned are
+// therefore not checked.
+func bufferResponse(res *echo.Response, responseWAF func(status int, headers http.Header, body []byte) error) (commit func(*error)) {
+	w := &responseWAFWriter{
+		ResponseWriter: res.Writer,
+		res:            res,
+		responseWAF:    responseWAF,
+	}
+	res.Writer = w
+	return func(err *error) {
+		if commitErr := w.commit(); commitErr != nil && *err == nil {
+			*err = commitErr
+		}
+	}
+}
+
+// responseWAFWriter is the response writer of echo's response buffering the
+// response status code and the first http_protection.MaxResponseWAFBodySize
+// bytes of the response body until the response gets committed, so that the
+// response WAF can check them and replace the response when blocking it.
+type responseWAFWriter struct {
+	http.ResponseWriter
+	res *echo.Response
+	// responseWAF is called once when committing the response with the
+	// buffered response. The buffered response is dropped when it returns an
+	// error.
+	responseWAF func(status int, headers http.Header, body []byte) error
+	status      int
+	// buf is the buffered response body prefix.
+	buf       []byte
+	committed bool
+	// err is the error returned by responseWAF, returned by the writes
+	// following the blocked response.
+	err error
+}
+
+func (w *responseWAFWriter) WriteHeader(statusCode int) {
+	if w.err != nil {
+		return
+	}
+	if !w.committed {
+		// Only the first status code is taken into account, like net/http does
+		if w.status == 0 {
+			w.status = statusCode
+		}
+		return
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseWAFWriter) Write(b []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if !w.committed {
+		room := http_protection.MaxResponseWAFBodySize - len(w.buf)
+		if len(b) <= room {
+			w.buf = append(w.buf, b...)
+			return len(b), nil
+		}
+		w.buf = append(w.buf, b[:room]...)
+		if err := w.commit(); err != nil {
+			return 0, err
+		}
+		n, err := w.ResponseWriter.Write(b[room:])
+		return room + n, err
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// commit writes the buffered response once the response WAF checked it. The
+// response is dropped when the response WAF blocked it, in which case the
+// blocking response was written instead while calling it using echo's
+// response.
+func (w *responseWAFWriter) commit() error {
+	if w.committed {
+		return w.err
+	}
+	// The response writes performed by the response WAF, such as the blocking
+	// response, are no longer buffered.
+	w.committed = true
+	status, buf := w.status, w.buf
+	w.buf = nil
+
+	// Let the blocking response replace the response echo considers already
+	// written.
+	committed, resStatus, size := w.res.Committed, w.res.Status, w.res.Size
+	w.res.Committed, w.res.Size = false, 0
+	if err := w.responseWAF(status, w.Header(), buf); err != nil {
+		w.err = err
+		return err
+	}
+	w.res.Committed, w.res.Status, w.res.Size = committed, resStatus, size
+
+	if status != 0 {
+		w.ResponseWriter.WriteHeader(status)
+	}
+	if len(buf) > 0 {
+		if _, err := w.ResponseWriter.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *responseWAFWriter) Flush() {
+	if err := w.commit(); err != nil {
+		return
+	}
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *responseWAFWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	// The response is no longer written through the response writer
+	w.committed = true
+	w.buf = nil
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
 // response observed by the response writer
 type observedResponse struct {
 	contentType   string
@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/labstack/echo/v4"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
 	"github.com/sqreen/go-agent/internal/protection/http/types"
 	"github.com/sqreen/go-agent/sdk"
 	"github.com/sqreen/go-agent/sdk/middleware/_testlib/mockups"
@@ -633,3 +634,104 @@ func middleware(p types.RootProtectionContext) echo.MiddlewareFunc {
 		}
 	}
 }
+
+func TestResponseWAF(t *testing.T) {
+	// serve serves the request with the given handler and response WAF
+	// function, which is given echo's response to write the blocking response
+	// like the response WAF does.
+	serve := func(handler echo.HandlerFunc, responseWAF func(res *echo.Response, status int, headers http.Header, body []byte) error) (*httptest.ResponseRecorder, error) {
+		var handledErr error
+		e := echo.New()
+		e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c echo.Context) (err error) {
+				res := c.Response()
+				defer bufferResponse(res, func(status int, headers http.Header, body []byte) error {
+					return responseWAF(res, status, headers, body)
+				})(&err)
+				return next(c)
+			}
+		})
+		e.HTTPErrorHandler = func(err error, c echo.Context) {
+			handledErr = err
+		}
+		e.GET("/", handler)
+
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/", nil)
+		e.ServeHTTP(rec, req)
+		return rec, handledErr
+	}
+
+	t.Run("buffered response", func(t *testing.T) {
+		var (
+			checkedStatus int
+			checkedBody   string
+		)
+		rec, err := serve(func(c echo.Context) error {
+			return c.String(http.StatusCreated, "hello")
+		}, func(_ *echo.Response, status int, _ http.Header, body []byte) error {
+			checkedStatus = status
+			checkedBody = string(body)
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, checkedStatus)
+		require.Equal(t, "hello", checkedBody)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		require.Equal(t, "hello", rec.Body.String())
+	})
+
+	t.Run("response larger than the buffer", func(t *testing.T) {
+		var checkedBody []byte
+		body := strings.Repeat("a", 2*http_protection.MaxResponseWAFBodySize)
+		rec, err := serve(func(c echo.Context) error {
+			return c.String(http.StatusOK, body)
+		}, func(_ *echo.Response, _ int, _ http.Header, body []byte) error {
+			checkedBody = body
+			return nil
+		})
+		require.NoError(t, err)
+		require.Len(t, checkedBody, http_protection.MaxResponseWAFBodySize)
+		require.Equal(t, body, rec.Body.String())
+	})
+
+	t.Run("bodiless response", func(t *testing.T) {
+		var checkedStatus int
+		rec, err := serve(func(c echo.Context) error {
+			return c.NoContent(http.StatusNoContent)
+		}, func(_ *echo.Response, status int, _ http.Header, _ []byte) error {
+			checkedStatus = status
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, checkedStatus)
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Empty(t, rec.Body.String())
+	})
+
+	t.Run("blocked response", func(t *testing.T) {
+		blockErr := errors.New("blocked")
+		var writeErr error
+		rec, err := serve(func(c echo.Context) error {
+			c.Response().Header().Set("X-Leak", "leak")
+			_ = c.String(http.StatusInternalServerError, "leak")
+			c.Response().Flush()
+			// The following writes are dropped
+			_, writeErr = c.Response().Write([]byte("more leak"))
+			return nil
+		}, func(res *echo.Response, _ int, headers http.Header, _ []byte) error {
+			// Write the blocking response like the response WAF does
+			for k := range headers {
+				delete(headers, k)
+			}
+			res.WriteHeader(http.StatusForbidden)
+			_, _ = res.Write([]byte("blocked"))
+			return blockErr
+		})
+		require.Equal(t, blockErr, err)
+		require.Equal(t, blockErr, writeErr)
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		require.Equal(t, "blocked", rec.Body.String())
+		require.Empty(t, rec.Header().Get("X-Leak"))
+	})
+}
@@ -7,6 +7,7 @@
 package sqgin
 
 import (
+	"bufio"
 	"net"
 	"net/http"
 	"net/textproto"
@@ -18,6 +19,7 @@ import (
 	protection_context "github.com/sqreen/go-agent/internal/protection/context"
 	http_protection "github.com/sqreen/go-agent/internal/protection/http"
 	"github.com/sqreen/go-agent/internal/protection/http/types"
+	middleware_internal "github.com/sqreen/go-agent/sdk/middleware/internal"
 )
 
 // Middleware is Sqreen's middleware function for Gin to monitor and protect the
@@ -58,7 +60,11 @@ import (
 //		// ... not blocked ...
 //	}
 //
-func Middleware() gin.HandlerFunc {
+// Middleware options can be provided to configure the middleware, such as
+// WithUserIdentifierFromHeader() to automatically identify users before
+// calling the handler.
+//
+func Middleware(opts ...Option) gin.HandlerFunc {
 	internal.Start()
 	return func(c *gin.Context) {
 		ctx, cancel := internal.NewRootHTTPProtectionContext(c.Request.Context())
@@ -67,13 +73,13 @@ func Middleware() gin.HandlerFunc {
 			return
 		}
 		defer cancel()
-		middlewareHandlerFromRootProtectionContext(ctx, c)
+		middlewareHandlerFromRootProtectionContext(ctx, c, opts...)
 	}
 }
 
-func middlewareHandlerFromRootProtectionContext(ctx types.RootProtectionContext, c *gin.Context) {
+func middlewareHandlerFromRootProtectionContext(ctx types.RootProtectionContext, c *gin.Context, opts ...Option) {
 	r := &requestReaderImpl{c: c}
-	p := http_protection.NewProtectionContext(ctx, c.Writer, r)
+	p := http_protection.NewProtectionContext(ctx, c.Writer, r, middleware_internal.ProtectionOptions(opts)...)
 	if p == nil {
 		c.Next()
 		return
@@ -83,7 +89,38 @@ func middlewareHandlerFromRootProtectionContext(ctx types.RootProtectionContext,
 		p.Close(newObservedResponse(c.Writer))
 	}()
 
-	middlewareHandlerFromProtectionContext(p, c)
+	var responseWAF func(status int, headers http.Header, body []byte) error
+	if p.ResponseWAFEnabled() {
+		// Only buffer the response when the response WAF needs to check it
+		responseWAF = p.ResponseWAF
+	}
+
+	handleWithResponseWriter(c, p.BeforeWriteHeader, responseWAF, func() {
+		middlewareHandlerFromProtectionContext(p, c)
+	})
+}
+
+// handleWithResponseWriter calls the handler with gin's response writer wrapped
+// by a responseWriterImpl calling beforeWriteHeader, and buffering the response
+// for responseWAF when not nil.
+func handleWithResponseWriter(c *gin.Context, beforeWriteHeader func(http.Header), responseWAF func(status int, headers http.Header, body []byte) error, handler func()) {
+	w := &responseWriterImpl{
+		ResponseWriter:    c.Writer,
+		beforeWriteHeader: beforeWriteHeader,
+		responseWAF:       responseWAF,
+	}
+	c.Writer = w
+
+	defer func() {
+		// Write the response buffered for the response WAF, if not already done.
+		// Gin writes the headers of bodiless responses after the middleware
+		// returns, using its own response writer rather than c.Writer.
+		if err := w.commit(); err == nil {
+			w.before()
+		}
+	}()
+
+	handler()
 }
 
 type protectionContext interface {
@@ -200,14 +237,154 @@ func (r *requestReaderImpl) RemoteAddr() string {
 	return r.c.Request.RemoteAddr
 }
 
+// responseWriterImpl wraps gin's response writer in order to call the
+// protection context right before the response headers get written. When the
+// response WAF is enabled, the first http_protection.MaxResponseWAFBodySize
+// bytes of the response body are buffered until the response gets committed,
+// so that the response WAF can check them and replace the response when
+// blocking it. Gin only writes the response status code along with the first
+// body write, so that it doesn't need to be buffered.
 type responseWriterImpl struct {
 	gin.ResponseWriter
+	// beforeWriteHeader is called once right before the response headers get
+	// written.
+	beforeWriteHeader func(http.Header)
+	calledBefore      bool
+	// responseWAF is called once when committing the response with the
+	// buffered response. The buffered response is dropped when it returns an
+	// error. The response is not buffered when nil.
+	responseWAF func(status int, headers http.Header, body []byte) error
+	// buf is the buffered response body prefix.
+	buf []byte
+	// writeHeaderNow is true when the response headers were explicitly written
+	// while buffering.
+	writeHeaderNow bool
+	committed      bool
+	// err is the error returned by responseWAF, returned by the writes
+	// following the blocked response.
+	err error
 }
 
 func (w *responseWriterImpl) closeResponseWriter() types.ResponseFace {
 	return newObservedResponse(w)
 }
 
+func (w *responseWriterImpl) before() {
+	if w.calledBefore || w.ResponseWriter.Written() {
+		return
+	}
+	w.calledBefore = true
+	w.beforeWriteHeader(w.ResponseWriter.Header())
+}
+
+// buffering returns true when the response writes are buffered until the
+// response gets committed.
+func (w *responseWriterImpl) buffering() bool {
+	return !w.committed && w.responseWAF != nil
+}
+
+// commit writes the buffered response once the response WAF checked it. The
+// response is dropped when the response WAF blocked it, in which case the
+// blocking response was written instead while calling it using gin's response
+// writer.
+func (w *responseWriterImpl) commit() error {
+	if w.committed {
+		return w.err
+	}
+	w.committed = true
+	if w.responseWAF == nil {
+		return nil
+	}
+	buf := w.buf
+	w.buf = nil
+	if err := w.responseWAF(w.ResponseWriter.Status(), w.ResponseWriter.Header(), buf); err != nil {
+		w.err = err
+		return err
+	}
+	if len(buf) > 0 {
+		_, err := w.Write(buf)
+		return err
+	}
+	if w.writeHeaderNow {
+		w.WriteHeaderNow()
+	}
+	return nil
+}
+
+func (w *responseWriterImpl) WriteHeaderNow() {
+	if w.err != nil {
+		return
+	}
+	if w.buffering() {
+		w.writeHeaderNow = true
+		return
+	}
+	w.before()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *responseWriterImpl) Write(b []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.buffering() {
+		room := http_protection.MaxResponseWAFBodySize - len(w.buf)
+		if len(b) <= room {
+			w.buf = append(w.buf, b...)
+			return len(b), nil
+		}
+		w.buf = append(w.buf, b[:room]...)
+		if err := w.commit(); err != nil {
+			return 0, err
+		}
+		n, err := w.Write(b[room:])
+		return room + n, err
+	}
+	w.before()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseWriterImpl) WriteString(s string) (int, error) {
+	if w.buffering() || w.err != nil {
+		return w.Write([]byte(s))
+	}
+	w.before()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Size returns the size of the response body written so far, including the
+// buffered one.
+func (w *responseWriterImpl) Size() int {
+	if w.buffering() && (len(w.buf) > 0 || w.writeHeaderNow) {
+		return len(w.buf)
+	}
+	return w.ResponseWriter.Size()
+}
+
+// Written returns true when the response was written, including when it is
+// buffered.
+func (w *responseWriterImpl) Written() bool {
+	if w.buffering() && (len(w.buf) > 0 || w.writeHeaderNow) {
+		return true
+	}
+	return w.ResponseWriter.Written()
+}
+
+func (w *responseWriterImpl) Flush() {
+	if err := w.commit(); err != nil {
+		return
+	}
+	w.before()
+	w.ResponseWriter.Flush()
+}
+
+func (w *responseWriterImpl) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	// The response is no longer written through the response writer
+	w.committed = true
+	w.buf = nil
+	return w.ResponseWriter.Hijack()
+}
+
 // response observed by the response writer
 type observedResponse struct {
 	contentType   string
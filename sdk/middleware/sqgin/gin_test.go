@@ -6,6 +6,7 @@ package sqgin
 
 import (
 	"context"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -14,6 +15,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
 	"github.com/sqreen/go-agent/internal/protection/http/types"
 	"github.com/sqreen/go-agent/sdk"
 	"github.com/sqreen/go-agent/sdk/middleware/_testlib/mockups"
@@ -542,6 +544,183 @@ func TestMiddleware(t *testing.T) {
 		})
 	})
 
+	t.Run("before write header", func(t *testing.T) {
+		for _, tc := range []struct {
+			name    string
+			handler gin.HandlerFunc
+			status  int
+			body    string
+		}{
+			{
+				name: "response with a body",
+				handler: func(c *gin.Context) {
+					c.String(http.StatusOK, "hello")
+				},
+				status: http.StatusOK,
+				body:   "hello",
+			},
+			{
+				name: "bodiless response",
+				handler: func(c *gin.Context) {
+					c.Status(http.StatusNoContent)
+				},
+				status: http.StatusNoContent,
+			},
+			{
+				name: "aborted response",
+				handler: func(c *gin.Context) {
+					c.AbortWithStatus(http.StatusUnauthorized)
+				},
+				status: http.StatusUnauthorized,
+			},
+			{
+				name:    "default response",
+				handler: func(c *gin.Context) {},
+				status:  http.StatusOK,
+			},
+		} {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				var called int
+				router := gin.New()
+				router.Use(func(c *gin.Context) {
+					handleWithResponseWriter(c, func(headers http.Header) {
+						called++
+						headers.Set("X-Before-Write-Header", "true")
+					}, nil, c.Next)
+				})
+				router.GET("/", tc.handler)
+
+				rec := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "/", nil)
+				router.ServeHTTP(rec, req)
+
+				require.Equal(t, 1, called)
+				require.Equal(t, "true", rec.Header().Get("X-Before-Write-Header"))
+				require.Equal(t, tc.status, rec.Code)
+				require.Equal(t, tc.body, rec.Body.String())
+			})
+		}
+	})
+}
+
+func TestResponseWAF(t *testing.T) {
+	// serve serves the request with the given handler and response WAF
+	// function, which is given gin's response writer to write the blocking
+	// response like the response WAF does.
+	serve := func(handler gin.HandlerFunc, responseWAF func(w gin.ResponseWriter, status int, headers http.Header, body []byte) error) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			w := c.Writer
+			handleWithResponseWriter(c, func(http.Header) {}, func(status int, headers http.Header, body []byte) error {
+				return responseWAF(w, status, headers, body)
+			}, c.Next)
+		})
+		router.GET("/", handler)
+
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/", nil)
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("buffered response", func(t *testing.T) {
+		var (
+			checkedStatus int
+			checkedBody   string
+		)
+		rec := serve(func(c *gin.Context) {
+			c.String(http.StatusCreated, "hello")
+			// The buffered response is reported as written to the handlers
+			require.True(t, c.Writer.Written())
+			require.Equal(t, len("hello"), c.Writer.Size())
+		}, func(_ gin.ResponseWriter, status int, _ http.Header, body []byte) error {
+			checkedStatus = status
+			checkedBody = string(body)
+			return nil
+		})
+		require.Equal(t, http.StatusCreated, checkedStatus)
+		require.Equal(t, "hello", checkedBody)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		require.Equal(t, "hello", rec.Body.String())
+	})
+
+	t.Run("response larger than the buffer", func(t *testing.T) {
+		var checkedBody []byte
+		body := strings.Repeat("a", 2*http_protection.MaxResponseWAFBodySize)
+		rec := serve(func(c *gin.Context) {
+			c.String(http.StatusOK, body)
+		}, func(_ gin.ResponseWriter, _ int, _ http.Header, body []byte) error {
+			checkedBody = body
+			return nil
+		})
+		require.Len(t, checkedBody, http_protection.MaxResponseWAFBodySize)
+		require.Equal(t, body, rec.Body.String())
+	})
+
+	t.Run("bodiless responses", func(t *testing.T) {
+		for _, tc := range []struct {
+			name    string
+			handler gin.HandlerFunc
+			status  int
+		}{
+			{
+				name: "status",
+				handler: func(c *gin.Context) {
+					c.Status(http.StatusNoContent)
+				},
+				status: http.StatusNoContent,
+			},
+			{
+				name: "aborted",
+				handler: func(c *gin.Context) {
+					c.AbortWithStatus(http.StatusUnauthorized)
+				},
+				status: http.StatusUnauthorized,
+			},
+			{
+				name:    "default response",
+				handler: func(c *gin.Context) {},
+				status:  http.StatusOK,
+			},
+		} {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				var checkedStatus int
+				rec := serve(tc.handler, func(_ gin.ResponseWriter, status int, _ http.Header, _ []byte) error {
+					checkedStatus = status
+					return nil
+				})
+				require.Equal(t, tc.status, checkedStatus)
+				require.Equal(t, tc.status, rec.Code)
+				require.Empty(t, rec.Body.String())
+			})
+		}
+	})
+
+	t.Run("blocked response", func(t *testing.T) {
+		blockErr := errors.New("blocked")
+		var writeErr error
+		rec := serve(func(c *gin.Context) {
+			c.Header("X-Leak", "leak")
+			c.String(http.StatusInternalServerError, "leak")
+			c.Writer.Flush()
+			// The following writes are dropped
+			_, writeErr = c.Writer.WriteString("more leak")
+		}, func(w gin.ResponseWriter, _ int, headers http.Header, _ []byte) error {
+			// Write the blocking response like the response WAF does
+			for k := range headers {
+				delete(headers, k)
+			}
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.WriteString("blocked")
+			return blockErr
+		})
+		require.Equal(t, blockErr, writeErr)
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		require.Equal(t, "blocked", rec.Body.String())
+		require.Empty(t, rec.Header().Get("X-Leak"))
+	})
 }
 
 func middleware(p types.RootProtectionContext) gin.HandlerFunc {
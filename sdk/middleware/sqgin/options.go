@@ -0,0 +1,34 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package sqgin
+
+import (
+	"github.com/sqreen/go-agent/sdk/middleware/option"
+)
+
+// Option is a middleware option. See package
+// github.com/sqreen/go-agent/sdk/middleware/option.
+type Option = option.Option
+
+// JWTUserIdentifierConfig is the configuration of the middleware option
+// WithUserIdentifierFromJWTClaim().
+type JWTUserIdentifierConfig = option.JWTUserIdentifierConfig
+
+// WithUserIdentifierFromHeader is option.WithUserIdentifierFromHeader().
+func WithUserIdentifierFromHeader(header, key string) Option {
+	return option.WithUserIdentifierFromHeader(header, key)
+}
+
+// WithUserIdentifierFromCookie is option.WithUserIdentifierFromCookie().
+func WithUserIdentifierFromCookie(cookie, key string) Option {
+	return option.WithUserIdentifierFromCookie(cookie, key)
+}
+
+// WithUserIdentifierFromJWTClaim is option.WithUserIdentifierFromJWTClaim().
+func WithUserIdentifierFromJWTClaim(cfg JWTUserIdentifierConfig) (Option, error) {
+	return option.WithUserIdentifierFromJWTClaim(cfg)
+}
@@ -5,6 +5,8 @@
 package sqhttp
 
 import (
+	"bufio"
+	"io"
 	"net"
 	"net/http"
 	"net/textproto"
@@ -15,6 +17,7 @@ import (
 	"github.com/sqreen/go-agent/internal"
 	http_protection "github.com/sqreen/go-agent/internal/protection/http"
 	"github.com/sqreen/go-agent/internal/protection/http/types"
+	middleware_internal "github.com/sqreen/go-agent/sdk/middleware/internal"
 )
 
 // Middleware is Sqreen's middleware function for `net/http` to monitor and
@@ -47,7 +50,11 @@ import (
 //	}
 //	http.Handle("/foo", sqhttp.Middleware(http.HandlerFunc(fn)))
 //
-func Middleware(next http.Handler) http.Handler {
+// Middleware options can be provided to configure the middleware, such as
+// WithUserIdentifierFromHeader() to automatically identify users before
+// calling the handler.
+//
+func Middleware(next http.Handler, opts ...Option) http.Handler {
 	internal.Start()
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx, cancel := internal.NewRootHTTPProtectionContext(r.Context())
@@ -56,24 +63,33 @@ func Middleware(next http.Handler) http.Handler {
 			return
 		}
 		defer cancel()
-		middlewareHandlerFromRootProtectionContext(ctx, next, w, r)
+		middlewareHandlerFromRootProtectionContext(ctx, next, w, r, opts...)
 	})
 }
-func middlewareHandlerFromRootProtectionContext(ctx types.RootProtectionContext, next http.Handler, w http.ResponseWriter, r *http.Request) {
+func middlewareHandlerFromRootProtectionContext(ctx types.RootProtectionContext, next http.Handler, w http.ResponseWriter, r *http.Request, opts ...Option) {
 	// requestReader is a pointer value in order to change the inner request
 	// pointer with the new one created by http.(*Request).WithContext below
 	requestReader := &requestReaderImpl{Request: r}
 	responseWriter, responseWriterObserver := wrapResponseWriter(w)
-	p := http_protection.NewProtectionContext(ctx, responseWriter, requestReader)
+	p := http_protection.NewProtectionContext(ctx, responseWriter, requestReader, middleware_internal.ProtectionOptions(opts)...)
 	if p == nil {
 		next.ServeHTTP(w, r)
 		return
 	}
 
 	defer func() {
+		// Write the response buffered by the response writer observer, if not
+		// already done.
+		_ = responseWriterObserver.commit()
 		p.Close(newObservedResponse(responseWriterObserver))
 	}()
 
+	responseWriterObserver.beforeWriteHeader = p.BeforeWriteHeader
+	if p.ResponseWAFEnabled() {
+		// Only buffer the response when the response WAF needs to check it
+		responseWriterObserver.responseWAF = p.ResponseWAF
+	}
+
 	middlewareHandlerFromProtectionContext(p, next, responseWriter, requestReader)
 }
 
@@ -180,10 +196,30 @@ func (r *requestReaderImpl) RemoteAddr() string {
 	return r.Request.RemoteAddr
 }
 
+// responseWriterObserver observes the response written by the handler. When
+// the response WAF is enabled, the response status code and the first
+// http_protection.MaxResponseWAFBodySize bytes of the response body are
+// buffered until the response gets committed, so that the response WAF can
+// check them and replace the response when blocking it. The response is
+// otherwise written straight through.
 type responseWriterObserver struct {
 	http.ResponseWriter
 	status  int
 	written int
+	// beforeWriteHeader is called once right before the response headers get
+	// written.
+	beforeWriteHeader func(http.Header)
+	wroteHeader       bool
+	// responseWAF is called once when committing the response with the
+	// buffered response. The buffered response is dropped when it returns an
+	// error. The response is not buffered when nil.
+	responseWAF func(status int, headers http.Header, body []byte) error
+	// buf is the buffered response body prefix.
+	buf       []byte
+	committed bool
+	// err is the error returned by responseWAF, returned by the writes
+	// following the blocked response.
+	err error
 }
 
 // response observed by the response writer
@@ -232,7 +268,35 @@ func (w *responseWriterObserver) Header() http.Header {
 	return w.ResponseWriter.Header()
 }
 
+func (w *responseWriterObserver) before() {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.beforeWriteHeader != nil {
+		w.beforeWriteHeader(w.ResponseWriter.Header())
+	}
+}
+
 func (w *responseWriterObserver) Write(b []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.buffering() {
+		room := http_protection.MaxResponseWAFBodySize - len(w.buf)
+		if len(b) <= room {
+			w.buf = append(w.buf, b...)
+			return len(b), nil
+		}
+		w.buf = append(w.buf, b[:room]...)
+		if err := w.commit(); err != nil {
+			return 0, err
+		}
+		n, err := w.Write(b[room:])
+		return room + n, err
+	}
+
+	w.before()
 	written, err := w.ResponseWriter.Write(b)
 	if err == nil {
 		w.written += written
@@ -241,6 +305,115 @@ func (w *responseWriterObserver) Write(b []byte) (int, error) {
 }
 
 func (w *responseWriterObserver) WriteHeader(statusCode int) {
+	if w.err != nil {
+		return
+	}
+	if w.buffering() {
+		// Only the first status code is taken into account, like net/http does
+		if w.status == 0 {
+			w.status = statusCode
+		}
+		return
+	}
+	w.before()
 	w.status = statusCode
 	w.ResponseWriter.WriteHeader(statusCode)
 }
+
+// buffering returns true when the response writes are buffered until the
+// response gets committed.
+func (w *responseWriterObserver) buffering() bool {
+	return !w.committed && w.responseWAF != nil
+}
+
+// commit writes the buffered response once the response WAF checked it. The
+// response is dropped when the response WAF blocked it, in which case the
+// blocking response was written instead while calling it.
+func (w *responseWriterObserver) commit() error {
+	if w.committed {
+		return w.err
+	}
+	// The response writes performed by the response WAF, such as the blocking
+	// response, are no longer buffered.
+	w.committed = true
+	if w.responseWAF == nil {
+		// Nothing was buffered. Headers are also written by net/http when the
+		// handler didn't write anything, and therefore also need to go through
+		// beforeWriteHeader.
+		w.before()
+		return nil
+	}
+	status, buf := w.status, w.buf
+	w.buf = nil
+	if err := w.responseWAF(status, w.Header(), buf); err != nil {
+		w.err = err
+		return err
+	}
+
+	// Headers are also written by net/http when the handler didn't write
+	// anything, and therefore also need to go through beforeWriteHeader.
+	w.before()
+	if status == 0 && len(buf) == 0 {
+		// Nothing was written yet
+		return nil
+	}
+	if status != 0 {
+		w.ResponseWriter.WriteHeader(status)
+	}
+	if len(buf) > 0 {
+		written, err := w.ResponseWriter.Write(buf)
+		w.written += written
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *responseWriterObserver) flush(f http.Flusher) {
+	if err := w.commit(); err != nil {
+		return
+	}
+	w.before()
+	f.Flush()
+}
+
+func (w *responseWriterObserver) writeString(sw io.StringWriter, s string) (int, error) {
+	if !w.committed || w.err != nil {
+		return w.Write([]byte(s))
+	}
+	w.before()
+	written, err := sw.WriteString(s)
+	if err == nil {
+		w.written += written
+	}
+	return written, err
+}
+
+func (w *responseWriterObserver) readFrom(rf io.ReaderFrom, r io.Reader) (n int64, err error) {
+	if w.buffering() {
+		// Buffer the reader data until the response gets committed by writing
+		// more than the buffer size.
+		room := http_protection.MaxResponseWAFBodySize - len(w.buf)
+		n, err = io.CopyN(w, r, int64(room)+1)
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+	}
+	if w.err != nil {
+		return n, w.err
+	}
+	w.before()
+	written, err := rf.ReadFrom(r)
+	w.written += int(written)
+	return n + written, err
+}
+
+func (w *responseWriterObserver) hijack(h http.Hijacker) (net.Conn, *bufio.ReadWriter, error) {
+	// The response is no longer written through the response writer
+	w.committed = true
+	w.buf = nil
+	return h.Hijack()
+}
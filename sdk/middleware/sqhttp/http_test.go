@@ -6,6 +6,7 @@ package sqhttp
 
 import (
 	"context"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -14,6 +15,7 @@ import (
 	"testing"
 	"time"
 
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
 	"github.com/sqreen/go-agent/internal/protection/http/types"
 	"github.com/sqreen/go-agent/sdk"
 	"github.com/sqreen/go-agent/sdk/middleware/_testlib/mockups"
@@ -417,3 +419,129 @@ func TestRequestReaderImpl(t *testing.T) {
 		})
 	})
 }
+
+func TestResponseWriterObserver(t *testing.T) {
+	t.Run("buffered response", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w, observer := wrapResponseWriter(rec)
+
+		var (
+			checkedStatus int
+			checkedBody   string
+		)
+		observer.responseWAF = func(status int, headers http.Header, body []byte) error {
+			checkedStatus = status
+			checkedBody = string(body)
+			return nil
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		_, err := io.WriteString(w, "hello")
+		require.NoError(t, err)
+
+		// Nothing is written until the response gets committed
+		require.False(t, rec.Flushed)
+		require.Empty(t, rec.Body.String())
+
+		require.NoError(t, observer.commit())
+		require.Equal(t, http.StatusCreated, checkedStatus)
+		require.Equal(t, "hello", checkedBody)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		require.Equal(t, "hello", rec.Body.String())
+		require.Equal(t, len("hello"), observer.written)
+	})
+
+	t.Run("response without response waf", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w, observer := wrapResponseWriter(rec)
+
+		var checkedHeaders http.Header
+		observer.beforeWriteHeader = func(headers http.Header) {
+			checkedHeaders = headers
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		_, err := io.WriteString(w, "hello")
+		require.NoError(t, err)
+
+		// The response is written straight through
+		require.Equal(t, "text/plain", checkedHeaders.Get("Content-Type"))
+		require.Equal(t, http.StatusCreated, rec.Code)
+		require.Equal(t, "hello", rec.Body.String())
+
+		require.NoError(t, observer.commit())
+		require.Equal(t, http.StatusCreated, rec.Code)
+		require.Equal(t, "hello", rec.Body.String())
+		require.Equal(t, len("hello"), observer.written)
+	})
+
+	t.Run("response larger than the buffer", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w, observer := wrapResponseWriter(rec)
+
+		var checkedBody []byte
+		observer.responseWAF = func(_ int, _ http.Header, body []byte) error {
+			checkedBody = body
+			return nil
+		}
+
+		body := strings.Repeat("a", 2*http_protection.MaxResponseWAFBodySize)
+		n, err := io.Copy(w, strings.NewReader(body))
+		require.NoError(t, err)
+		require.Equal(t, int64(len(body)), n)
+		require.Len(t, checkedBody, http_protection.MaxResponseWAFBodySize)
+
+		require.NoError(t, observer.commit())
+		require.Equal(t, body, rec.Body.String())
+		require.Equal(t, len(body), observer.written)
+	})
+
+	t.Run("empty response", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w, observer := wrapResponseWriter(rec)
+
+		// The response headers are written by net/http when the handler returns
+		var checkedHeaders http.Header
+		observer.beforeWriteHeader = func(headers http.Header) {
+			checkedHeaders = headers
+		}
+
+		w.Header().Set("X-Header", "value")
+		require.NoError(t, observer.commit())
+		require.Equal(t, "value", checkedHeaders.Get("X-Header"))
+		require.Empty(t, rec.Body.String())
+	})
+
+	t.Run("blocked response", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w, observer := wrapResponseWriter(rec)
+
+		blockErr := errors.New("blocked")
+		observer.responseWAF = func(_ int, headers http.Header, _ []byte) error {
+			// Write the blocking response like the response WAF does
+			for k := range headers {
+				delete(headers, k)
+			}
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = io.WriteString(w, "blocked")
+			return blockErr
+		}
+
+		w.Header().Set("X-Leak", "leak")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := io.WriteString(w, "leak")
+		require.NoError(t, err)
+
+		require.Equal(t, blockErr, observer.commit())
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		require.Equal(t, "blocked", rec.Body.String())
+		require.Empty(t, rec.Header().Get("X-Leak"))
+
+		// The following writes are dropped
+		_, err = io.WriteString(w, "more leak")
+		require.Equal(t, blockErr, err)
+		require.Equal(t, "blocked", rec.Body.String())
+	})
+}
@@ -708,384 +708,385 @@ type (
 )
 
 func adaptResponseWriter(wrapper, wrapped http.ResponseWriter) http.ResponseWriter {
-	switch actual := wrapped.(type) {
+	methods := optionalResponseWriterMethods{wrapper: wrapper, wrapped: wrapped}
+	switch wrapped.(type) {
 
 	case FlusherPusherCloseNotifierHijackerReaderFromStringWriter:
 		return flusherPusherCloseNotifierHijackerReaderFromStringWriter{
 			ResponseWriter: wrapper,
-			FlusherPusherCloseNotifierHijackerReaderFromStringWriter: actual,
+			FlusherPusherCloseNotifierHijackerReaderFromStringWriter: methods,
 		}
 
 	case PusherCloseNotifierHijackerReaderFromStringWriter:
 		return pusherCloseNotifierHijackerReaderFromStringWriter{
 			ResponseWriter: wrapper,
-			PusherCloseNotifierHijackerReaderFromStringWriter: actual,
+			PusherCloseNotifierHijackerReaderFromStringWriter: methods,
 		}
 
 	case FlusherCloseNotifierHijackerReaderFromStringWriter:
 		return flusherCloseNotifierHijackerReaderFromStringWriter{
 			ResponseWriter: wrapper,
-			FlusherCloseNotifierHijackerReaderFromStringWriter: actual,
+			FlusherCloseNotifierHijackerReaderFromStringWriter: methods,
 		}
 
 	case FlusherPusherCloseNotifierHijackerStringWriter:
 		return flusherPusherCloseNotifierHijackerStringWriter{
 			ResponseWriter: wrapper,
-			FlusherPusherCloseNotifierHijackerStringWriter: actual,
+			FlusherPusherCloseNotifierHijackerStringWriter: methods,
 		}
 
 	case FlusherPusherHijackerReaderFromStringWriter:
 		return flusherPusherHijackerReaderFromStringWriter{
 			ResponseWriter: wrapper,
-			FlusherPusherHijackerReaderFromStringWriter: actual,
+			FlusherPusherHijackerReaderFromStringWriter: methods,
 		}
 
 	case FlusherPusherCloseNotifierReaderFromStringWriter:
 		return flusherPusherCloseNotifierReaderFromStringWriter{
 			ResponseWriter: wrapper,
-			FlusherPusherCloseNotifierReaderFromStringWriter: actual,
+			FlusherPusherCloseNotifierReaderFromStringWriter: methods,
 		}
 
 	case FlusherPusherCloseNotifierHijackerReaderFrom:
 		return flusherPusherCloseNotifierHijackerReaderFrom{
 			ResponseWriter: wrapper,
-			FlusherPusherCloseNotifierHijackerReaderFrom: actual,
+			FlusherPusherCloseNotifierHijackerReaderFrom: methods,
 		}
 
 	case FlusherPusherCloseNotifierReaderFrom:
 		return flusherPusherCloseNotifierReaderFrom{
 			ResponseWriter:                       wrapper,
-			FlusherPusherCloseNotifierReaderFrom: actual,
+			FlusherPusherCloseNotifierReaderFrom: methods,
 		}
 
 	case FlusherHijackerReaderFromStringWriter:
 		return flusherHijackerReaderFromStringWriter{
 			ResponseWriter:                        wrapper,
-			FlusherHijackerReaderFromStringWriter: actual,
+			FlusherHijackerReaderFromStringWriter: methods,
 		}
 
 	case FlusherPusherCloseNotifierHijacker:
 		return flusherPusherCloseNotifierHijacker{
 			ResponseWriter:                     wrapper,
-			FlusherPusherCloseNotifierHijacker: actual,
+			FlusherPusherCloseNotifierHijacker: methods,
 		}
 
 	case FlusherPusherHijackerStringWriter:
 		return flusherPusherHijackerStringWriter{
 			ResponseWriter:                    wrapper,
-			FlusherPusherHijackerStringWriter: actual,
+			FlusherPusherHijackerStringWriter: methods,
 		}
 
 	case PusherHijackerReaderFromStringWriter:
 		return pusherHijackerReaderFromStringWriter{
 			ResponseWriter:                       wrapper,
-			PusherHijackerReaderFromStringWriter: actual,
+			PusherHijackerReaderFromStringWriter: methods,
 		}
 
 	case PusherCloseNotifierHijackerStringWriter:
 		return pusherCloseNotifierHijackerStringWriter{
 			ResponseWriter:                          wrapper,
-			PusherCloseNotifierHijackerStringWriter: actual,
+			PusherCloseNotifierHijackerStringWriter: methods,
 		}
 
 	case CloseNotifierHijackerReaderFromStringWriter:
 		return closeNotifierHijackerReaderFromStringWriter{
 			ResponseWriter: wrapper,
-			CloseNotifierHijackerReaderFromStringWriter: actual,
+			CloseNotifierHijackerReaderFromStringWriter: methods,
 		}
 
 	case PusherCloseNotifierReaderFromStringWriter:
 		return pusherCloseNotifierReaderFromStringWriter{
 			ResponseWriter: wrapper,
-			PusherCloseNotifierReaderFromStringWriter: actual,
+			PusherCloseNotifierReaderFromStringWriter: methods,
 		}
 
 	case FlusherCloseNotifierReaderFromStringWriter:
 		return flusherCloseNotifierReaderFromStringWriter{
 			ResponseWriter: wrapper,
-			FlusherCloseNotifierReaderFromStringWriter: actual,
+			FlusherCloseNotifierReaderFromStringWriter: methods,
 		}
 
 	case PusherCloseNotifierHijackerReaderFrom:
 		return pusherCloseNotifierHijackerReaderFrom{
 			ResponseWriter:                        wrapper,
-			PusherCloseNotifierHijackerReaderFrom: actual,
+			PusherCloseNotifierHijackerReaderFrom: methods,
 		}
 
 	case FlusherPusherReaderFromStringWriter:
 		return flusherPusherReaderFromStringWriter{
 			ResponseWriter:                      wrapper,
-			FlusherPusherReaderFromStringWriter: actual,
+			FlusherPusherReaderFromStringWriter: methods,
 		}
 
 	case FlusherCloseNotifierHijackerReaderFrom:
 		return flusherCloseNotifierHijackerReaderFrom{
 			ResponseWriter:                         wrapper,
-			FlusherCloseNotifierHijackerReaderFrom: actual,
+			FlusherCloseNotifierHijackerReaderFrom: methods,
 		}
 
 	case FlusherPusherHijackerReaderFrom:
 		return flusherPusherHijackerReaderFrom{
 			ResponseWriter:                  wrapper,
-			FlusherPusherHijackerReaderFrom: actual,
+			FlusherPusherHijackerReaderFrom: methods,
 		}
 
 	case FlusherCloseNotifierHijackerStringWriter:
 		return flusherCloseNotifierHijackerStringWriter{
 			ResponseWriter:                           wrapper,
-			FlusherCloseNotifierHijackerStringWriter: actual,
+			FlusherCloseNotifierHijackerStringWriter: methods,
 		}
 
 	case FlusherPusherCloseNotifierStringWriter:
 		return flusherPusherCloseNotifierStringWriter{
 			ResponseWriter:                         wrapper,
-			FlusherPusherCloseNotifierStringWriter: actual,
+			FlusherPusherCloseNotifierStringWriter: methods,
 		}
 
 	case FlusherCloseNotifierReaderFrom:
 		return flusherCloseNotifierReaderFrom{
 			ResponseWriter:                 wrapper,
-			FlusherCloseNotifierReaderFrom: actual,
+			FlusherCloseNotifierReaderFrom: methods,
 		}
 
 	case FlusherReaderFromStringWriter:
 		return flusherReaderFromStringWriter{
 			ResponseWriter:                wrapper,
-			FlusherReaderFromStringWriter: actual,
+			FlusherReaderFromStringWriter: methods,
 		}
 
 	case PusherCloseNotifierReaderFrom:
 		return pusherCloseNotifierReaderFrom{
 			ResponseWriter:                wrapper,
-			PusherCloseNotifierReaderFrom: actual,
+			PusherCloseNotifierReaderFrom: methods,
 		}
 
 	case PusherHijackerReaderFrom:
 		return pusherHijackerReaderFrom{
 			ResponseWriter:           wrapper,
-			PusherHijackerReaderFrom: actual,
+			PusherHijackerReaderFrom: methods,
 		}
 
 	case PusherReaderFromStringWriter:
 		return pusherReaderFromStringWriter{
 			ResponseWriter:               wrapper,
-			PusherReaderFromStringWriter: actual,
+			PusherReaderFromStringWriter: methods,
 		}
 
 	case CloseNotifierHijackerReaderFrom:
 		return closeNotifierHijackerReaderFrom{
 			ResponseWriter:                  wrapper,
-			CloseNotifierHijackerReaderFrom: actual,
+			CloseNotifierHijackerReaderFrom: methods,
 		}
 
 	case FlusherPusherReaderFrom:
 		return flusherPusherReaderFrom{
 			ResponseWriter:          wrapper,
-			FlusherPusherReaderFrom: actual,
+			FlusherPusherReaderFrom: methods,
 		}
 
 	case CloseNotifierReaderFromStringWriter:
 		return closeNotifierReaderFromStringWriter{
 			ResponseWriter:                      wrapper,
-			CloseNotifierReaderFromStringWriter: actual,
+			CloseNotifierReaderFromStringWriter: methods,
 		}
 
 	case FlusherHijackerStringWriter:
 		return flusherHijackerStringWriter{
 			ResponseWriter:              wrapper,
-			FlusherHijackerStringWriter: actual,
+			FlusherHijackerStringWriter: methods,
 		}
 
 	case FlusherHijackerReaderFrom:
 		return flusherHijackerReaderFrom{
 			ResponseWriter:            wrapper,
-			FlusherHijackerReaderFrom: actual,
+			FlusherHijackerReaderFrom: methods,
 		}
 
 	case PusherCloseNotifierHijacker:
 		return pusherCloseNotifierHijacker{
 			ResponseWriter:              wrapper,
-			PusherCloseNotifierHijacker: actual,
+			PusherCloseNotifierHijacker: methods,
 		}
 
 	case FlusherCloseNotifierHijacker:
 		return flusherCloseNotifierHijacker{
 			ResponseWriter:               wrapper,
-			FlusherCloseNotifierHijacker: actual,
+			FlusherCloseNotifierHijacker: methods,
 		}
 
 	case FlusherPusherStringWriter:
 		return flusherPusherStringWriter{
 			ResponseWriter:            wrapper,
-			FlusherPusherStringWriter: actual,
+			FlusherPusherStringWriter: methods,
 		}
 
 	case FlusherPusherHijacker:
 		return flusherPusherHijacker{
 			ResponseWriter:        wrapper,
-			FlusherPusherHijacker: actual,
+			FlusherPusherHijacker: methods,
 		}
 
 	case FlusherCloseNotifierStringWriter:
 		return flusherCloseNotifierStringWriter{
 			ResponseWriter:                   wrapper,
-			FlusherCloseNotifierStringWriter: actual,
+			FlusherCloseNotifierStringWriter: methods,
 		}
 
 	case PusherCloseNotifierStringWriter:
 		return pusherCloseNotifierStringWriter{
 			ResponseWriter:                  wrapper,
-			PusherCloseNotifierStringWriter: actual,
+			PusherCloseNotifierStringWriter: methods,
 		}
 
 	case CloseNotifierHijackerStringWriter:
 		return closeNotifierHijackerStringWriter{
 			ResponseWriter:                    wrapper,
-			CloseNotifierHijackerStringWriter: actual,
+			CloseNotifierHijackerStringWriter: methods,
 		}
 
 	case FlusherPusherCloseNotifier:
 		return flusherPusherCloseNotifier{
 			ResponseWriter:             wrapper,
-			FlusherPusherCloseNotifier: actual,
+			FlusherPusherCloseNotifier: methods,
 		}
 
 	case PusherHijackerStringWriter:
 		return pusherHijackerStringWriter{
 			ResponseWriter:             wrapper,
-			PusherHijackerStringWriter: actual,
+			PusherHijackerStringWriter: methods,
 		}
 
 	case HijackerReaderFromStringWriter:
 		return hijackerReaderFromStringWriter{
 			ResponseWriter:                 wrapper,
-			HijackerReaderFromStringWriter: actual,
+			HijackerReaderFromStringWriter: methods,
 		}
 
 	case PusherCloseNotifier:
 		return pusherCloseNotifier{
 			ResponseWriter:      wrapper,
-			PusherCloseNotifier: actual,
+			PusherCloseNotifier: methods,
 		}
 
 	case FlusherPusher:
 		return flusherPusher{
 			ResponseWriter: wrapper,
-			FlusherPusher:  actual,
+			FlusherPusher:  methods,
 		}
 
 	case CloseNotifierStringWriter:
 		return closeNotifierStringWriter{
 			ResponseWriter:            wrapper,
-			CloseNotifierStringWriter: actual,
+			CloseNotifierStringWriter: methods,
 		}
 
 	case PusherStringWriter:
 		return pusherStringWriter{
 			ResponseWriter:     wrapper,
-			PusherStringWriter: actual,
+			PusherStringWriter: methods,
 		}
 
 	case FlusherStringWriter:
 		return flusherStringWriter{
 			ResponseWriter:      wrapper,
-			FlusherStringWriter: actual,
+			FlusherStringWriter: methods,
 		}
 
 	case ReaderFromStringWriter:
 		return readerFromStringWriter{
 			ResponseWriter:         wrapper,
-			ReaderFromStringWriter: actual,
+			ReaderFromStringWriter: methods,
 		}
 
 	case HijackerReaderFrom:
 		return hijackerReaderFrom{
 			ResponseWriter:     wrapper,
-			HijackerReaderFrom: actual,
+			HijackerReaderFrom: methods,
 		}
 
 	case CloseNotifierReaderFrom:
 		return closeNotifierReaderFrom{
 			ResponseWriter:          wrapper,
-			CloseNotifierReaderFrom: actual,
+			CloseNotifierReaderFrom: methods,
 		}
 
 	case PusherReaderFrom:
 		return pusherReaderFrom{
 			ResponseWriter:   wrapper,
-			PusherReaderFrom: actual,
+			PusherReaderFrom: methods,
 		}
 
 	case FlusherReaderFrom:
 		return flusherReaderFrom{
 			ResponseWriter:    wrapper,
-			FlusherReaderFrom: actual,
+			FlusherReaderFrom: methods,
 		}
 
 	case HijackerStringWriter:
 		return hijackerStringWriter{
 			ResponseWriter:       wrapper,
-			HijackerStringWriter: actual,
+			HijackerStringWriter: methods,
 		}
 
 	case FlusherCloseNotifier:
 		return flusherCloseNotifier{
 			ResponseWriter:       wrapper,
-			FlusherCloseNotifier: actual,
+			FlusherCloseNotifier: methods,
 		}
 
 	case CloseNotifierHijacker:
 		return closeNotifierHijacker{
 			ResponseWriter:        wrapper,
-			CloseNotifierHijacker: actual,
+			CloseNotifierHijacker: methods,
 		}
 
 	case PusherHijacker:
 		return pusherHijacker{
 			ResponseWriter: wrapper,
-			PusherHijacker: actual,
+			PusherHijacker: methods,
 		}
 
 	case FlusherHijacker:
 		return flusherHijacker{
 			ResponseWriter:  wrapper,
-			FlusherHijacker: actual,
+			FlusherHijacker: methods,
 		}
 
 	case ReaderFrom:
 		return readerFrom{
 			ResponseWriter: wrapper,
-			ReaderFrom:     actual,
+			ReaderFrom:     methods,
 		}
 
 	case Flusher:
 		return flusher{
 			ResponseWriter: wrapper,
-			Flusher:        actual,
+			Flusher:        methods,
 		}
 
 	case CloseNotifier:
 		return closeNotifier{
 			ResponseWriter: wrapper,
-			CloseNotifier:  actual,
+			CloseNotifier:  methods,
 		}
 
 	case StringWriter:
 		return stringWriter{
 			ResponseWriter: wrapper,
-			StringWriter:   actual,
+			StringWriter:   methods,
 		}
 
 	case Pusher:
 		return pusher{
 			ResponseWriter: wrapper,
-			Pusher:         actual,
+			Pusher:         methods,
 		}
 
 	case Hijacker:
 		return hijacker{
 			ResponseWriter: wrapper,
-			Hijacker:       actual,
+			Hijacker:       methods,
 		}
 
 	default:
@@ -5,6 +5,9 @@
 package sqhttp
 
 import (
+	"bufio"
+	"io"
+	"net"
 	"net/http"
 )
 
@@ -13,3 +16,65 @@ func wrapResponseWriter(w http.ResponseWriter) (http.ResponseWriter, *responseWr
 	w = adaptResponseWriter(wrapper, w)
 	return w, wrapper
 }
+
+// responseWriterInterceptor is the optional interface of response writer
+// wrappers intercepting the calls to the optional response writer methods
+// adapted by adaptResponseWriter(). The wrapper cannot directly implement them
+// since it would then implement them regardless of the wrapped response
+// writer.
+type responseWriterInterceptor interface {
+	flush(http.Flusher)
+	writeString(io.StringWriter, string) (int, error)
+	readFrom(io.ReaderFrom, io.Reader) (int64, error)
+	hijack(http.Hijacker) (net.Conn, *bufio.ReadWriter, error)
+}
+
+// optionalResponseWriterMethods implements the optional response writer
+// methods of the wrapped response writer, either through the wrapper when it
+// intercepts them, or by directly calling the wrapped response writer. Its
+// methods must only be called when the wrapped response writer implements
+// them, as ensured by adaptResponseWriter().
+type optionalResponseWriterMethods struct {
+	wrapper, wrapped http.ResponseWriter
+}
+
+func (m optionalResponseWriterMethods) Flush() {
+	f := m.wrapped.(http.Flusher)
+	if i, ok := m.wrapper.(responseWriterInterceptor); ok {
+		i.flush(f)
+		return
+	}
+	f.Flush()
+}
+
+func (m optionalResponseWriterMethods) WriteString(s string) (int, error) {
+	sw := m.wrapped.(io.StringWriter)
+	if i, ok := m.wrapper.(responseWriterInterceptor); ok {
+		return i.writeString(sw, s)
+	}
+	return sw.WriteString(s)
+}
+
+func (m optionalResponseWriterMethods) ReadFrom(r io.Reader) (int64, error) {
+	rf := m.wrapped.(io.ReaderFrom)
+	if i, ok := m.wrapper.(responseWriterInterceptor); ok {
+		return i.readFrom(rf, r)
+	}
+	return rf.ReadFrom(r)
+}
+
+func (m optionalResponseWriterMethods) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h := m.wrapped.(http.Hijacker)
+	if i, ok := m.wrapper.(responseWriterInterceptor); ok {
+		return i.hijack(h)
+	}
+	return h.Hijack()
+}
+
+func (m optionalResponseWriterMethods) Push(target string, opts *http.PushOptions) error {
+	return m.wrapped.(http.Pusher).Push(target, opts)
+}
+
+func (m optionalResponseWriterMethods) CloseNotify() <-chan bool {
+	return m.wrapped.(http.CloseNotifier).CloseNotify()
+}
@@ -48,6 +48,16 @@ type (
 		//	sqreen.TrackEvent("my.event").WithUserIdentifiers(uid).WithProperties(props)
 		//
 		TrackEvent(name string) TrackEvent
+
+		// CSPNonce returns the Content-Security-Policy nonce of the request to add
+		// to the HTML script and style tags allowed by the policy. A fresh nonce
+		// is generated for every request. It returns an empty string when Sqreen
+		// is disabled.
+		//
+		//	nonce := sdk.FromContext(ctx).CSPNonce()
+		//	tmpl.Execute(w, map[string]string{"Nonce": nonce})
+		//
+		CSPNonce() string
 	}
 
 	context struct {
@@ -130,6 +140,21 @@ func (ctx context) TrackEvent(event string) TrackEvent {
 	return trackEvent{event: ctx.events.TrackEvent(event)}
 }
 
+// CSPNonce returns the Content-Security-Policy nonce of the request to add to
+// the HTML script and style tags allowed by the policy. A fresh nonce is
+// generated for every request. It returns an empty string when Sqreen is
+// disabled.
+//
+//	nonce := sdk.FromContext(ctx).CSPNonce()
+//	tmpl.Execute(w, map[string]string{"Nonce": nonce})
+//
+func (ctx context) CSPNonce() string {
+	if getter, ok := ctx.events.(protection_context.CSPNonceGetter); ok {
+		return getter.CSPNonce()
+	}
+	return ""
+}
+
 // EventPropertyMap is the type used to represent extra event properties.
 //
 //	props := sdk.EventPropertyMap{
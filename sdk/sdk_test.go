@@ -330,6 +330,22 @@ func TestEventPropertyMap(t *testing.T) {
 	require.Equal(t, string(expected), string(buf))
 }
 
+func TestCSPNonce(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		require.Empty(t, sdk.FromContext(context.Background()).CSPNonce())
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		ctx, recorder := newMockups()
+		defer recorder.AssertExpectations(t)
+
+		nonce := testlib.RandPrintableUSASCIIString(1, 50)
+		recorder.ExpectCSPNonce().Return(nonce).Once()
+
+		require.Equal(t, nonce, sdk.FromContext(ctx).CSPNonce())
+	})
+}
+
 func dummySDKTest(t *testing.T, sqreen sdk.Context) {
 	event := sqreen.TrackEvent(testlib.RandPrintableUSASCIIString(0, 50))
 	event = event.WithTimestamp(time.Now())
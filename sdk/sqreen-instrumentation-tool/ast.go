@@ -9,6 +9,7 @@ import (
 	"go/token"
 	"log"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/dave/dst"
@@ -41,8 +42,25 @@ type hookpoint struct {
 func newHookpoint(pkgPath string, funcDecl *dst.FuncDecl, descriptorTypeIdent string, descriptorValueInitializer hookDescriptorValueInitializer) *hookpoint {
 	id := normalizedHookpointID(pkgPath, funcDecl)
 
-	epilogFuncType, epilogCallArgs := newSqreenEpilogFuncType(funcDecl.Type)
-	prologFuncType, prologCallArgs := newSqreenPrologFuncType(funcDecl, epilogFuncType)
+	var (
+		prologFuncType                 *dst.FuncType
+		prologCallArgs, epilogCallArgs []dst.Expr
+		funcValue                      dst.Expr
+	)
+	if isGenericFuncDecl(funcDecl) {
+		// Generic functions cannot be used as function values nor have a prolog
+		// type depending on their type parameters at package level. Their prolog
+		// type is therefore the generic prolog type, getting the pointers to the
+		// parameters and results of every instantiation, while the function is
+		// identified by its symbol name.
+		prologFuncType, prologCallArgs, epilogCallArgs = newSqreenGenericPrologFuncType(funcDecl)
+		funcValue = newGenericFunctionSymbolExpr(pkgPath, funcDecl)
+	} else {
+		var epilogFuncType *dst.FuncType
+		epilogFuncType, epilogCallArgs = newSqreenEpilogFuncType(funcDecl.Type)
+		prologFuncType, prologCallArgs = newSqreenPrologFuncType(funcDecl, epilogFuncType)
+		funcValue = newFunctionValueExpr(funcDecl)
+	}
 
 	prologVarIdent := fmt.Sprintf(sqreenPrologVarIdentFormat, id)
 	prologVarDecl, prologValueSpec := newPrologVarDecl(prologVarIdent, prologFuncType)
@@ -51,7 +69,7 @@ func newHookpoint(pkgPath string, funcDecl *dst.FuncDecl, descriptorTypeIdent st
 	prologLoadFuncDecl := newPrologLoadFuncDecl(prologLoadFuncIdent, prologValueSpec)
 
 	descriptorFuncIdent := fmt.Sprintf(sqreenHookDescriptorFuncIdentFormat, id)
-	descriptorFuncDecl := newHookDescriptorFuncDecl(descriptorFuncIdent, funcDecl, prologVarIdent, descriptorValueInitializer)
+	descriptorFuncDecl := newHookDescriptorFuncDecl(descriptorFuncIdent, funcValue, prologVarIdent, descriptorValueInitializer)
 
 	instrumentationStmt := newInstrumentationStmt(prologLoadFuncIdent, prologCallArgs, epilogCallArgs)
 
@@ -67,27 +85,63 @@ func newHookpoint(pkgPath string, funcDecl *dst.FuncDecl, descriptorTypeIdent st
 func normalizedHookpointID(pkgPath string, node *dst.FuncDecl) string {
 	var receiver string
 	if node.Recv != nil {
-		t := node.Recv.List[0].Type
-	loop:
-		for {
-			switch actual := t.(type) {
-			default:
-				log.Fatalf("unexpected type %T\n", actual)
-
-			case *dst.StarExpr:
-				t = actual.X
-
-			case *dst.Ident:
-				receiver = actual.Name
-				break loop
-			}
-		}
+		receiver, _ = receiverTypeName(node.Recv.List[0].Type)
 		receiver += "_"
 	}
 	pkgPath = normalizedPkgPath(pkgPath)
 	return fmt.Sprintf("%s_%s%s", pkgPath, receiver, node.Name)
 }
 
+// receiverTypeName returns the type name of the given method receiver type
+// expression, without its type arguments when generic, along with whether it
+// is a pointer receiver or not.
+func receiverTypeName(t dst.Expr) (name string, pointer bool) {
+	for {
+		switch actual := t.(type) {
+		default:
+			log.Fatalf("unexpected type %T\n", actual)
+
+		case *dst.StarExpr:
+			pointer = true
+			t = actual.X
+
+		case *dst.ParenExpr:
+			t = actual.X
+
+		case *dst.IndexExpr:
+			// Generic type with a single type parameter
+			t = actual.X
+
+		case *dst.IndexListExpr:
+			// Generic type with several type parameters
+			t = actual.X
+
+		case *dst.Ident:
+			return actual.Name, pointer
+		}
+	}
+}
+
+// isGenericFuncDecl returns true when the given function declaration is a
+// generic function or a method of a generic type.
+func isGenericFuncDecl(funcDecl *dst.FuncDecl) bool {
+	if funcDecl.Type.TypeParams != nil && len(funcDecl.Type.TypeParams.List) > 0 {
+		return true
+	}
+	if funcDecl.Recv == nil || len(funcDecl.Recv.List) == 0 {
+		return false
+	}
+	t := funcDecl.Recv.List[0].Type
+	if star, ok := t.(*dst.StarExpr); ok {
+		t = star.X
+	}
+	switch t.(type) {
+	case *dst.IndexExpr, *dst.IndexListExpr:
+		return true
+	}
+	return false
+}
+
 func normalizedPkgPath(pkgPath string) string {
 	return regexp.MustCompile(`[/.\-@]`).ReplaceAllString(pkgPath, "_")
 }
@@ -239,6 +293,51 @@ func newSqreenPrologFuncType(funcDecl *dst.FuncDecl, epilogType *dst.FuncType) (
 	}, callbackCallParams
 }
 
+// Return the generic prolog type along with the prolog and epilog call
+// arguments of the given generic function declaration. The parameters and
+// results are passed as lists of pointers so that the prolog type doesn't
+// depend on the type parameters.
+// `f[<type params>](<params>) <results>` returns
+// `func([]interface{}) (func([]interface{}), error)`, called with
+// `[]interface{}{<&params>}`, and the epilog with `[]interface{}{<&results>}`.
+func newSqreenGenericPrologFuncType(funcDecl *dst.FuncDecl) (prologType *dst.FuncType, prologCallArgs, epilogCallArgs []dst.Expr) {
+	_, prologCallParams := newSqreenCallbackParams(funcDecl.Recv, funcDecl.Type.Params, "_sqreen_param")
+	_, epilogCallParams := newSqreenCallbackParams(nil, funcDecl.Type.Results, "_sqreen_result")
+	return newSqreenGenericPrologType(), []dst.Expr{newEmptyInterfaceSliceLit(prologCallParams)}, []dst.Expr{newEmptyInterfaceSliceLit(epilogCallParams)}
+}
+
+// Return the generic prolog type
+// `func([]interface{}) (func([]interface{}), error)`.
+func newSqreenGenericPrologType() *dst.FuncType {
+	newArgsField := func() *dst.Field {
+		return &dst.Field{Type: &dst.ArrayType{Elt: newEmptyInterfaceType()}}
+	}
+	return &dst.FuncType{
+		Params: &dst.FieldList{List: []*dst.Field{newArgsField()}},
+		Results: &dst.FieldList{
+			List: []*dst.Field{
+				{
+					Type: &dst.FuncType{
+						Func:    true,
+						Params:  &dst.FieldList{List: []*dst.Field{newArgsField()}},
+						Results: &dst.FieldList{},
+					},
+				},
+				{
+					Type: dst.NewIdent("error"),
+				},
+			},
+		},
+	}
+}
+
+// Return the expression of the symbol name of the given generic function
+// declaration, in the same format as the runtime symbol names but without the
+// type arguments, such as `<pkg>.F`, `<pkg>.T.M` or `<pkg>.(*T).M`.
+func newGenericFunctionSymbolExpr(pkgPath string, funcDecl *dst.FuncDecl) dst.Expr {
+	return &dst.BasicLit{Kind: token.STRING, Value: strconv.Quote(pkgPath + "." + funcDeclName(funcDecl))}
+}
+
 // Return the epilog type of the given function type.
 // `f(<params>) <results>` returns `func(<*results>)`
 func newSqreenEpilogFuncType(funcType *dst.FuncType) (epilogType *dst.FuncType, callParams []dst.Expr) {
@@ -313,7 +412,7 @@ func newSqreenParamIdent(prefix string, p int) *dst.Ident {
 
 // Return the hook descriptor function declaration which returns the hook
 // descriptor structure.
-func newHookDescriptorFuncDecl(ident string, funcDecl *dst.FuncDecl, prologVarIdent string, newDescriptorValueInitializer hookDescriptorValueInitializer) *dst.FuncDecl {
+func newHookDescriptorFuncDecl(ident string, funcValue dst.Expr, prologVarIdent string, newDescriptorValueInitializer hookDescriptorValueInitializer) *dst.FuncDecl {
 	const descriptorParamName = `_sqreen_hd`
 	return &dst.FuncDecl{
 		Decs: dst.FuncDeclDecorations{
@@ -345,7 +444,7 @@ func newHookDescriptorFuncDecl(ident string, funcDecl *dst.FuncDecl, prologVarId
 					},
 					Tok: token.ASSIGN,
 					Rhs: []dst.Expr{
-						newDescriptorValueInitializer(newFunctionValueExpr(funcDecl), newIdentAddressExpr(dst.NewIdent(prologVarIdent))),
+						newDescriptorValueInitializer(funcValue, newIdentAddressExpr(dst.NewIdent(prologVarIdent))),
 					},
 				},
 			},
@@ -419,7 +518,9 @@ func newHookDescriptorType() (*dst.GenDecl, *dst.TypeSpec, hookDescriptorValueIn
 	return typ, spec, valInitializer
 }
 
-func shouldIgnoreFuncDecl(funcDecl *dst.FuncDecl) bool {
+// funcDeclIgnoreReason returns the reason why the function must not be
+// instrumented, or the empty string when it can be.
+func funcDeclIgnoreReason(funcDecl *dst.FuncDecl) string {
 	fname := funcDecl.Name.Name
 	// don't instrument:
 	// - `_`: explicitly ignored function names.
@@ -429,12 +530,21 @@ func shouldIgnoreFuncDecl(funcDecl *dst.FuncDecl) bool {
 	// - functions having //go:nosplit directives because they are usually low-level
 	//   functions.
 	// - functions having //sqreen:ignore directives.
-	return funcDecl.Body == nil ||
-		fname == "_" ||
-		fname == "init" ||
-		strings.Contains(fname, "noescape") ||
-		hasSqreenIgnoreDirective(funcDecl) ||
-		hasGoNoSplitDirective(funcDecl)
+	switch {
+	case funcDecl.Body == nil:
+		return "no function body"
+	case fname == "_":
+		return "blank function name"
+	case fname == "init":
+		return "package init function"
+	case strings.Contains(fname, "noescape"):
+		return "noescape function"
+	case hasSqreenIgnoreDirective(funcDecl):
+		return "sqreen:ignore directive"
+	case hasGoNoSplitDirective(funcDecl):
+		return "go:nosplit directive"
+	}
+	return ""
 }
 
 func isHookDescriptorFuncInMainPackage(ident string) bool {
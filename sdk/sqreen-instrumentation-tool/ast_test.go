@@ -0,0 +1,82 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenericFuncInstrumentation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqreen-instrumentation-generics")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "generics.go")
+	require.NoError(t, ioutil.WriteFile(src, []byte(`package generics
+
+func Map[T, U any](s []T, f func(T) U) []U { return nil }
+
+type List[T any] struct{}
+
+func (l *List[T]) Push(T) {}
+
+type Pair[K comparable, V any] struct{}
+
+func (p Pair[K, V]) Key() (_ K) { return }
+
+func Regular(s string) {}
+`), 0644))
+
+	pkg := newDefaultPackageInstrumentation("my-org/generics", true, nil, dir)
+	require.NoError(t, pkg.AddFile(src))
+	instrumented, err := pkg.Instrument()
+	require.NoError(t, err)
+	require.Len(t, instrumented, 1)
+
+	buildDir := filepath.Join(dir, "build")
+	require.NoError(t, os.Mkdir(buildDir, 0777))
+	written, err := pkg.WriteInstrumentedFiles(buildDir, instrumented)
+	require.NoError(t, err)
+	buf, err := ioutil.ReadFile(written[src])
+	require.NoError(t, err)
+
+	// The instrumented file must still be valid Go code
+	_, err = parser.ParseFile(token.NewFileSet(), written[src], buf, 0)
+	require.NoError(t, err)
+
+	// The type parameters are kept
+	require.Contains(t, string(buf), "func Map[T, U any](")
+	require.Contains(t, string(buf), "func (l *List[T]) Push(")
+	// Generic functions are identified by their symbol and use the generic
+	// prolog type
+	for _, symbol := range []string{`"my-org/generics.Map"`, `"my-org/generics.(*List).Push"`, `"my-org/generics.Pair.Key"`} {
+		require.Contains(t, string(buf), symbol)
+	}
+	require.Contains(t, string(buf), "var _sqreen_hook_prolog_var_my_org_generics_Map *func([]interface{}) (func([]interface{}), error)")
+	require.Contains(t, string(buf), "([]interface{}{&s, &f})")
+	require.Contains(t, string(buf), "([]interface{}{&_sqreen_result0})")
+	// Regular functions are unchanged
+	require.Contains(t, string(buf), "var _sqreen_hook_prolog_var_my_org_generics_Regular *func(*string) (func(), error)")
+
+	require.Equal(t, []string{
+		"_sqreen_hook_descriptor_my_org_generics_List_Push",
+		"_sqreen_hook_descriptor_my_org_generics_Map",
+		"_sqreen_hook_descriptor_my_org_generics_Pair_Key",
+		"_sqreen_hook_descriptor_my_org_generics_Regular",
+	}, pkg.hooks())
+
+	var names []string
+	for _, f := range pkg.packageReport().Files[0].Functions {
+		names = append(names, f.Name)
+	}
+	require.Equal(t, []string{"Map", "(*List).Push", "Pair.Key", "Regular"}, names)
+}
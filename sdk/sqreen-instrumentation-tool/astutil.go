@@ -84,6 +84,14 @@ func newEmptyInterfaceType() dst.Expr {
 	return &dst.InterfaceType{Methods: &dst.FieldList{Opening: true, Closing: true}}
 }
 
+// Return expression for `[]interface{}{<elts>}`
+func newEmptyInterfaceSliceLit(elts []dst.Expr) dst.Expr {
+	return &dst.CompositeLit{
+		Type: &dst.ArrayType{Elt: newEmptyInterfaceType()},
+		Elts: elts,
+	}
+}
+
 // Return expression for `expr.sel`
 func newSelectorExpr(expr dst.Expr, sel string) *dst.SelectorExpr {
 	return &dst.SelectorExpr{
@@ -0,0 +1,265 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The instrumentation tool takes part in the Go build cache so that packages
+// are only instrumented and compiled again when they change. Because the main
+// package needs the hooks of every package it depends on to create the hook
+// table, including those whose compilation was cached, each compiled package
+// stores its instrumentation record in the instrumentation cache. Records are
+// indexed by the action ID of the package compilation, which is also part of
+// the build ID of the compiled archive. The main package can therefore find
+// back the records of its dependencies by reading the build IDs of the
+// archives listed in its import configuration file, and then the records of
+// their own dependencies.
+
+// instrumentationRecord is the instrumentation result of a compiled package.
+type instrumentationRecord struct {
+	// Sorted list of the hook descriptor function names of the package.
+	Hooks []string `json:"hooks,omitempty"`
+	// Instrumentation report of the package.
+	Report *packageReport `json:"report,omitempty"`
+	// Action IDs of the packages directly imported by the package.
+	Deps []string `json:"deps,omitempty"`
+}
+
+type instrumentationCache struct {
+	dir string
+}
+
+// newInstrumentationCache returns the instrumentation cache stored in the
+// given directory, or in the default one when empty. The default one is
+// located in the Go build cache directory when set by the environment
+// variable GOCACHE, or in the user cache directory otherwise.
+func newInstrumentationCache(dir string) (*instrumentationCache, error) {
+	if dir == "" {
+		cacheDir := os.Getenv("GOCACHE")
+		if cacheDir == "" || cacheDir == "off" {
+			var err error
+			cacheDir, err = os.UserCacheDir()
+			if err != nil {
+				return nil, err
+			}
+		}
+		dir = filepath.Join(cacheDir, "sqreen-instrumentation")
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	return &instrumentationCache{dir: dir}, nil
+}
+
+func (c *instrumentationCache) recordFilepath(actionID string) string {
+	// The action ID is base64 encoded and can contain `/`
+	return filepath.Join(c.dir, hex.EncodeToString([]byte(actionID))+".json")
+}
+
+// Store stores the instrumentation record of the package compilation having
+// the given action ID.
+func (c *instrumentationCache) Store(actionID string, record *instrumentationRecord) error {
+	buf, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	// Write a temporary file first and rename it so that concurrent builds never
+	// read partially written records.
+	tmp, err := ioutil.TempFile(c.dir, "record-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.recordFilepath(actionID))
+}
+
+// Load returns the instrumentation record of the package compilation having the
+// given action ID.
+func (c *instrumentationCache) Load(actionID string) (*instrumentationRecord, error) {
+	buf, err := ioutil.ReadFile(c.recordFilepath(actionID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("could not find the instrumentation record of action ID `%s`: the package may have been compiled without the instrumentation tool - please clean the build cache or force rebuilding every package with option -a", actionID)
+		}
+		return nil, err
+	}
+	var record instrumentationRecord
+	if err := json.Unmarshal(buf, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// LoadAll returns the instrumentation records of the given action IDs along
+// with the records of all their dependencies.
+func (c *instrumentationCache) LoadAll(actionIDs []string) ([]*instrumentationRecord, error) {
+	var records []*instrumentationRecord
+	visited := make(map[string]struct{})
+	for len(actionIDs) > 0 {
+		actionID := actionIDs[len(actionIDs)-1]
+		actionIDs = actionIDs[:len(actionIDs)-1]
+		if _, done := visited[actionID]; done {
+			continue
+		}
+		visited[actionID] = struct{}{}
+		record, err := c.Load(actionID)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+		actionIDs = append(actionIDs, record.Deps...)
+	}
+	return records, nil
+}
+
+// actionIDFromBuildID returns the action ID part of a Go build ID of the form
+// `actionID/contentID`.
+func actionIDFromBuildID(buildID string) string {
+	if i := strings.IndexByte(buildID, '/'); i != -1 {
+		return buildID[:i]
+	}
+	return buildID
+}
+
+// readImportCfgActionIDs returns the action IDs of the compiled archives
+// listed by the given import configuration file, in the form
+// `packagefile <import path>=<archive file>`.
+func readImportCfgActionIDs(importcfg string) (actionIDs []string, err error) {
+	f, err := os.Open(importcfg)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		directive := strings.TrimPrefix(line, "packagefile ")
+		if directive == line {
+			continue
+		}
+		kv := strings.SplitN(directive, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		buildID, err := readArchiveBuildID(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("could not read the build ID of package `%s`: %v", kv[0], err)
+		}
+		actionIDs = append(actionIDs, actionIDFromBuildID(buildID))
+	}
+	return actionIDs, scanner.Err()
+}
+
+// Maximum size of the archive header the build ID is looked for in.
+const archiveBuildIDHeaderSize = 32 * 1024
+
+// readArchiveBuildID reads the build ID of a compiled Go package archive. It is
+// written by the compiler in the archive header in the form
+// `build id "<build id>"`.
+func readArchiveBuildID(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf := make([]byte, archiveBuildIDHeaderSize)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	buf = buf[:n]
+
+	const prefix = "\nbuild id \""
+	i := bytes.Index(buf, []byte(prefix))
+	if i == -1 {
+		return "", fmt.Errorf("build id not found in `%s`", filename)
+	}
+	buf = buf[i+len(prefix):]
+	j := bytes.IndexByte(buf, '"')
+	if j == -1 {
+		return "", fmt.Errorf("unexpected build id format in `%s`", filename)
+	}
+	return string(buf[:j]), nil
+}
+
+// toolID returns the ID of the instrumentation tool and of its options changing
+// the compilation results. It is added to the compiler version returned to the
+// go toolchain so that the build cache entries of instrumented packages are
+// distinct from regular ones and invalidated when the tool or its options
+// change.
+func toolID(flags *instrumentationToolFlagSet) (string, error) {
+	h := sha256.New()
+
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if err := hashFile(h, executable); err != nil {
+		return "", err
+	}
+
+	_, _ = fmt.Fprintf(h, "full=%t\n", flags.Full)
+	if flags.Config != "" {
+		_, _ = fmt.Fprintf(h, "config=%s\n", flags.Config)
+		if err := hashFile(h, flags.Config); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:32], nil
+}
+
+// linkerToolID returns the ID of the instrumentation tool options changing the
+// link results. It is added to the linker version returned to the go toolchain.
+// Unlike the compilation results, the link only depends on the report path so
+// that changing it doesn't invalidate the cached compilations.
+func linkerToolID(flags *instrumentationToolFlagSet) string {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "report=%s\n", flags.Report)
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+func hashFile(w io.Writer, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// addToolIDToVersion adds the tool ID to the given output line of the compiler
+// option `-V=full`. The go toolchain uses the whole line as the compiler ID of
+// release versions, such as `compile version go1.15.2`, while it uses the
+// content ID part of the build ID of development versions, such as
+// `compile version devel +a1b2c3 buildID=<action ID>/<content ID>`.
+func addToolIDToVersion(line, id string) string {
+	line = strings.TrimSpace(line)
+	fields := strings.Fields(line)
+	if len(fields) > 0 && strings.HasPrefix(fields[len(fields)-1], "buildID=") {
+		return line + ".sqreen-" + id
+	}
+	return line + " sqreen-" + id
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrumentationCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqreen-instrumentation-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cache, err := newInstrumentationCache(filepath.Join(dir, "cache"))
+	require.NoError(t, err)
+
+	// Dependency graph: main -> a, b ; a -> c ; b -> c
+	records := map[string]*instrumentationRecord{
+		"main/id": {Hooks: []string{"main_hook"}, Deps: []string{"a/id", "b/id"}},
+		"a/id":    {Hooks: []string{"a_hook_1", "a_hook_2"}, Deps: []string{"c/id"}},
+		"b/id":    {Report: &packageReport{Path: "b", Ignored: "limited instrumentation"}, Deps: []string{"c/id"}},
+		"c/id":    {Hooks: []string{"c_hook"}},
+	}
+	for actionID, record := range records {
+		require.NoError(t, cache.Store(actionID, record))
+	}
+
+	record, err := cache.Load("b/id")
+	require.NoError(t, err)
+	require.Equal(t, records["b/id"], record)
+
+	all, err := cache.LoadAll([]string{"a/id", "b/id"})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []*instrumentationRecord{records["a/id"], records["b/id"], records["c/id"]}, all)
+
+	// Missing record
+	require.NoError(t, cache.Store("d/id", &instrumentationRecord{Deps: []string{"unknown"}}))
+	_, err = cache.LoadAll([]string{"a/id", "d/id"})
+	require.Error(t, err)
+}
+
+func TestImportCfgActionIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqreen-instrumentation-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeArchive := func(t *testing.T, name, buildID string) string {
+		filename := filepath.Join(dir, name)
+		content := fmt.Sprintf("!<arch>\n__.PKGDEF       0           0     0     644     42975     `\ngo object linux amd64 go1.15.2 X:none\nbuild id %q\n\n$$B\n", buildID)
+		require.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644))
+		return filename
+	}
+
+	fmtArchive := writeArchive(t, "fmt.a", "H_O9OEhtHgJHzr1RbdlY/KjbQUGSTING-HLBSMPio")
+	helperArchive := writeArchive(t, "helper.a", "q2bzhaFvW1-8cFDtXbTI/q2bzhaFvW1-8cFDtXbTI")
+
+	buildID, err := readArchiveBuildID(fmtArchive)
+	require.NoError(t, err)
+	require.Equal(t, "H_O9OEhtHgJHzr1RbdlY/KjbQUGSTING-HLBSMPio", buildID)
+	require.Equal(t, "H_O9OEhtHgJHzr1RbdlY", actionIDFromBuildID(buildID))
+
+	importcfg := filepath.Join(dir, "importcfg")
+	require.NoError(t, ioutil.WriteFile(importcfg, []byte(fmt.Sprintf(`# import config
+packagefile fmt=%s
+importmap old/path=new/path
+packagefile my-org/helper=%s
+`, fmtArchive, helperArchive)), 0644))
+
+	actionIDs, err := readImportCfgActionIDs(importcfg)
+	require.NoError(t, err)
+	require.Equal(t, []string{"H_O9OEhtHgJHzr1RbdlY", "q2bzhaFvW1-8cFDtXbTI"}, actionIDs)
+
+	t.Run("Errors", func(t *testing.T) {
+		_, err := readArchiveBuildID(filepath.Join(dir, "does-not-exist"))
+		require.Error(t, err)
+
+		notAnArchive := filepath.Join(dir, "not-an-archive")
+		require.NoError(t, ioutil.WriteFile(notAnArchive, []byte("oops"), 0644))
+		_, err = readArchiveBuildID(notAnArchive)
+		require.Error(t, err)
+
+		require.NoError(t, ioutil.WriteFile(importcfg, []byte("packagefile fmt="+notAnArchive), 0644))
+		_, err = readImportCfgActionIDs(importcfg)
+		require.Error(t, err)
+	})
+}
+
+func TestToolID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqreen-instrumentation-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := filepath.Join(dir, "config.yml")
+	require.NoError(t, ioutil.WriteFile(config, []byte("include: []"), 0644))
+
+	id, err := toolID(&instrumentationToolFlagSet{})
+	require.NoError(t, err)
+	sameID, err := toolID(&instrumentationToolFlagSet{Verbose: true})
+	require.NoError(t, err)
+	require.Equal(t, id, sameID, "the verbose mode doesn't change the compilation results")
+	reportID, err := toolID(&instrumentationToolFlagSet{Report: filepath.Join(dir, "report.json")})
+	require.NoError(t, err)
+	require.Equal(t, id, reportID, "the report path doesn't change the compilation results")
+	require.NotEqual(t, linkerToolID(&instrumentationToolFlagSet{Report: filepath.Join(dir, "report.json")}), linkerToolID(&instrumentationToolFlagSet{Report: filepath.Join(dir, "other.json")}))
+
+	fullID, err := toolID(&instrumentationToolFlagSet{Full: true})
+	require.NoError(t, err)
+	require.NotEqual(t, id, fullID)
+
+	configID, err := toolID(&instrumentationToolFlagSet{Config: config})
+	require.NoError(t, err)
+	require.NotEqual(t, id, configID)
+
+	require.NoError(t, ioutil.WriteFile(config, []byte("exclude: []"), 0644))
+	newConfigID, err := toolID(&instrumentationToolFlagSet{Config: config})
+	require.NoError(t, err)
+	require.NotEqual(t, configID, newConfigID)
+
+	_, err = toolID(&instrumentationToolFlagSet{Config: filepath.Join(dir, "does-not-exist")})
+	require.Error(t, err)
+
+	for _, tc := range []struct {
+		line, expected string
+	}{
+		{
+			line:     "compile version go1.15.2\n",
+			expected: "compile version go1.15.2 sqreen-" + id,
+		},
+		{
+			line:     "compile version go1.9.1 X:framepointer\n",
+			expected: "compile version go1.9.1 X:framepointer sqreen-" + id,
+		},
+		{
+			line:     "compile version devel +a1b2c3 Tue Sep 1 10:00:00 2020 +0000 buildID=abc/def\n",
+			expected: "compile version devel +a1b2c3 Tue Sep 1 10:00:00 2020 +0000 buildID=abc/def.sqreen-" + id,
+		},
+	} {
+		require.Equal(t, tc.expected, addToolIDToVersion(tc.line, id))
+	}
+}
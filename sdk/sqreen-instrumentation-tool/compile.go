@@ -8,13 +8,17 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 )
 
 type compileFlagSet struct {
-	Package string `sqflag:"-p"`
-	Output  string `sqflag:"-o"`
+	Package   string `sqflag:"-p"`
+	Output    string `sqflag:"-o"`
+	BuildID   string `sqflag:"-buildid"`
+	ImportCfg string `sqflag:"-importcfg"`
 }
 
 func (f *compileFlagSet) IsValid() bool {
@@ -29,11 +33,35 @@ func parseCompileCommand(args []string) (commandExecutionFunc, error) {
 	if len(args) == 0 {
 		return nil, errors.New("unexpected number of command arguments")
 	}
+	if len(args) == 2 && args[1] == "-V=full" {
+		return makeCompilerVersionCommandExecutionFunc(args), nil
+	}
 	flags := &compileFlagSet{}
 	parseFlags(flags, args[1:])
 	return makeCompileCommandExecutionFunc(flags, args), nil
 }
 
+// makeCompilerVersionCommandExecutionFunc returns the execution function of
+// the compiler version command `compile -V=full` used by the go toolchain to
+// compute the build cache keys. The instrumentation tool ID is added to it so
+// that instrumented packages have their own build cache entries.
+func makeCompilerVersionCommandExecutionFunc(args []string) commandExecutionFunc {
+	return func() ([]string, error) {
+		id, err := toolID(&globalFlags)
+		if err != nil {
+			return nil, err
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println(addToolIDToVersion(string(out), id))
+		return nil, errCommandExecuted
+	}
+}
+
 func makeCompileCommandExecutionFunc(flags *compileFlagSet, args []string) commandExecutionFunc {
 	return func() ([]string, error) {
 		if !flags.IsValid() {
@@ -45,21 +73,62 @@ func makeCompileCommandExecutionFunc(flags *compileFlagSet, args []string) comma
 		pkgPath := flags.Package
 		packageBuildDir := filepath.Dir(flags.Output)
 
+		var cfg *instrumentationConfig
+		if globalFlags.Config != "" {
+			var err error
+			cfg, err = loadInstrumentationConfig(globalFlags.Config)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if flags.BuildID == "" {
+			return nil, errors.New("unexpected compile command without option -buildid")
+		}
+		cache, err := newInstrumentationCache("")
+		if err != nil {
+			return nil, err
+		}
+		var deps []string
+		if flags.ImportCfg != "" {
+			deps, err = readImportCfgActionIDs(flags.ImportCfg)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		var i Instrumenter
 		switch pkgPath {
 		case "runtime":
 			i = newRuntimePackageInstrumentation(packageBuildDir)
 		case "main":
-			i = newMainPackageInstrumentation(pkgPath, globalFlags.Full, packageBuildDir)
+			// The main package needs the instrumentation records of every package
+			// it depends on, including those whose compilation was cached.
+			records, err := cache.LoadAll(deps)
+			if err != nil {
+				return nil, err
+			}
+			i = newMainPackageInstrumentation(pkgPath, globalFlags.Full, cfg, records, packageBuildDir)
 		default:
-			i = newDefaultPackageInstrumentation(pkgPath, globalFlags.Full, packageBuildDir)
+			i = newDefaultPackageInstrumentation(pkgPath, globalFlags.Full, cfg, packageBuildDir)
 		}
 
+		var newArgs []string
 		if i.IsIgnored() {
 			log.Printf("skipping instrumentation of package `%s`\n", pkgPath)
-			return nil, nil
+		} else {
+			newArgs, err = instrument(i, args, pkgPath, packageBuildDir)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		record := i.Record()
+		record.Deps = deps
+		if err := cache.Store(actionIDFromBuildID(flags.BuildID), record); err != nil {
+			return nil, err
 		}
-		return instrument(i, args, pkgPath, packageBuildDir)
+		return newArgs, nil
 	}
 }
 
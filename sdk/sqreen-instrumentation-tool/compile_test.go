@@ -101,6 +101,15 @@ func TestParseFlags(t *testing.T) {
 				Output:  randomOutputStr,
 			},
 		},
+		{
+			name: "build id starting with a dash",
+			args: []string{"-o", randomOutputStr, "-p", randomPackageStr, "-std", "-buildid", "-rhH22UM3MVzKQ_2ht4y/-rhH22UM3MVzKQ_2ht4y", "-goversion", "go1.15"},
+			expectedFlags: compileFlagSet{
+				Package: randomPackageStr,
+				Output:  randomOutputStr,
+				BuildID: "-rhH22UM3MVzKQ_2ht4y/-rhH22UM3MVzKQ_2ht4y",
+			},
+		},
 		{
 			name: "empty output option value",
 			args: []string{"-p", randomPackageStr, "-o", ""},
@@ -0,0 +1,204 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/dave/dst"
+	"gopkg.in/yaml.v2"
+)
+
+// instrumentationConfig is the instrumentation configuration file provided
+// with option `-config`. It allows to include or exclude packages, files and
+// functions, and is merged with the default instrumentation. For example:
+//
+//	include:
+//	  - package: github.com/my-org/orm/...
+//	  - package: github.com/my-org/rpc/client
+//	    files: [client.go]
+//	    functions: ["(*Client).Call*"]
+//	exclude:
+//	  - package: github.com/my-org/orm/internal/...
+//
+// Package paths can end with `/...` to match every package under the path.
+// File names and function names are glob patterns (cf. `path.Match()`) of the
+// file base names and of function names with their receiver type, such as
+// `Func`, `T.Method` or `(*T).Method`. Entries without files nor functions
+// apply to the whole package. YAML being a superset of JSON, the file can be
+// written in both formats.
+type instrumentationConfig struct {
+	Include []instrumentationConfigEntry `yaml:"include"`
+	Exclude []instrumentationConfigEntry `yaml:"exclude"`
+}
+
+type instrumentationConfigEntry struct {
+	Package   string   `yaml:"package"`
+	Files     []string `yaml:"files"`
+	Functions []string `yaml:"functions"`
+}
+
+// loadInstrumentationConfig reads and validates the instrumentation
+// configuration file `filename`.
+func loadInstrumentationConfig(filename string) (*instrumentationConfig, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var cfg instrumentationConfig
+	if err := yaml.UnmarshalStrict(buf, &cfg); err != nil {
+		return nil, fmt.Errorf("could not parse the configuration file `%s`: %v", filename, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration file `%s`: %v", filename, err)
+	}
+	return &cfg, nil
+}
+
+func (c *instrumentationConfig) validate() error {
+	for _, entries := range [][]instrumentationConfigEntry{c.Include, c.Exclude} {
+		for _, e := range entries {
+			if e.Package == "" {
+				return fmt.Errorf("unexpected empty package path")
+			}
+			for _, patterns := range [][]string{e.Files, e.Functions} {
+				for _, pattern := range patterns {
+					if _, err := path.Match(pattern, ""); err != nil {
+						return fmt.Errorf("package `%s`: bad pattern `%s`: %v", e.Package, pattern, err)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// packageRules returns the instrumentation rules of the configuration
+// applying to the given package path. It returns nil when none applies.
+func (c *instrumentationConfig) packageRules(pkgPath string) *packageInstrumentationRules {
+	if c == nil {
+		return nil
+	}
+	var rules *packageInstrumentationRules
+	get := func() *packageInstrumentationRules {
+		if rules == nil {
+			rules = &packageInstrumentationRules{}
+		}
+		return rules
+	}
+
+	for _, e := range c.Include {
+		if !matchPackagePath(e.Package, pkgPath) {
+			continue
+		}
+		r := get()
+		r.included = true
+		if len(e.Files) == 0 && len(e.Functions) == 0 {
+			r.allFiles = true
+			r.allFuncs = true
+			continue
+		}
+		if len(e.Files) == 0 {
+			r.allFiles = true
+		}
+		r.includedFiles = append(r.includedFiles, e.Files...)
+		if len(e.Functions) == 0 {
+			r.allFuncs = true
+		}
+		r.includedFuncs = append(r.includedFuncs, e.Functions...)
+	}
+
+	for _, e := range c.Exclude {
+		if !matchPackagePath(e.Package, pkgPath) {
+			continue
+		}
+		r := get()
+		if len(e.Files) == 0 && len(e.Functions) == 0 {
+			r.excluded = true
+			continue
+		}
+		r.excludedFiles = append(r.excludedFiles, e.Files...)
+		r.excludedFuncs = append(r.excludedFuncs, e.Functions...)
+	}
+
+	return rules
+}
+
+// matchPackagePath returns true when the package path matches the pattern,
+// either equal or prefixed by the path of a pattern ending with `/...`.
+func matchPackagePath(pattern, pkgPath string) bool {
+	if prefix := strings.TrimSuffix(pattern, "/..."); prefix != pattern {
+		return pkgPath == prefix || strings.HasPrefix(pkgPath, prefix+"/")
+	}
+	return pkgPath == pattern
+}
+
+// packageInstrumentationRules is the result of the merged configuration
+// entries applying to a package.
+type packageInstrumentationRules struct {
+	// The package is explicitly included.
+	included bool
+	// The whole package is explicitly excluded.
+	excluded bool
+	// Every file, resp. function, of the package is included.
+	allFiles, allFuncs bool
+	// Included and excluded file and function name patterns.
+	includedFiles, excludedFiles []string
+	includedFuncs, excludedFuncs []string
+}
+
+// isFileExcluded returns true when the file base name matches an excluded file
+// pattern.
+func (r *packageInstrumentationRules) isFileExcluded(basename string) bool {
+	return r != nil && matchAny(r.excludedFiles, basename)
+}
+
+// isFileIncluded returns true when the file base name is explicitly included.
+func (r *packageInstrumentationRules) isFileIncluded(basename string) bool {
+	return r != nil && r.included && (r.allFiles || matchAny(r.includedFiles, basename))
+}
+
+// isFuncIgnored returns true when the function must not be instrumented
+// according to the configuration. Functions of packages explicitly included
+// with a list of functions are only instrumented when they match one of them.
+func (r *packageInstrumentationRules) isFuncIgnored(funcDecl *dst.FuncDecl) bool {
+	if r == nil {
+		return false
+	}
+	name := funcDeclName(funcDecl)
+	if matchAny(r.excludedFuncs, name) {
+		return true
+	}
+	if r.included && !r.allFuncs {
+		return !matchAny(r.includedFuncs, name)
+	}
+	return false
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// funcDeclName returns the function name along with its receiver type, such
+// as `Func`, `T.Method` or `(*T).Method`. The type parameters of generic
+// receiver types are omitted.
+func funcDeclName(funcDecl *dst.FuncDecl) string {
+	if funcDecl.Recv == nil || len(funcDecl.Recv.List) == 0 {
+		return funcDecl.Name.Name
+	}
+	receiver, pointer := receiverTypeName(funcDecl.Recv.List[0].Type)
+	if pointer {
+		return fmt.Sprintf("(*%s).%s", receiver, funcDecl.Name.Name)
+	}
+	return fmt.Sprintf("%s.%s", receiver, funcDecl.Name.Name)
+}
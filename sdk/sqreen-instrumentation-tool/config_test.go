@@ -0,0 +1,219 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package main
+
+import (
+	"go/parser"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadInstrumentationConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqreen-instrumentation-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeConfig := func(t *testing.T, content string) string {
+		filename := filepath.Join(dir, "config")
+		require.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644))
+		return filename
+	}
+
+	expected := &instrumentationConfig{
+		Include: []instrumentationConfigEntry{
+			{Package: "my-org/orm/..."},
+			{Package: "my-org/rpc", Files: []string{"client.go"}, Functions: []string{"(*Client).Call*"}},
+		},
+		Exclude: []instrumentationConfigEntry{
+			{Package: "my-org/orm/internal"},
+		},
+	}
+
+	t.Run("YAML", func(t *testing.T) {
+		cfg, err := loadInstrumentationConfig(writeConfig(t, `
+include:
+  - package: my-org/orm/...
+  - package: my-org/rpc
+    files: [client.go]
+    functions: ["(*Client).Call*"]
+exclude:
+  - package: my-org/orm/internal
+`))
+		require.NoError(t, err)
+		require.Equal(t, expected, cfg)
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		cfg, err := loadInstrumentationConfig(writeConfig(t, `{
+  "include": [
+    { "package": "my-org/orm/..." },
+    { "package": "my-org/rpc", "files": ["client.go"], "functions": ["(*Client).Call*"] }
+  ],
+  "exclude": [ { "package": "my-org/orm/internal" } ]
+}`))
+		require.NoError(t, err)
+		require.Equal(t, expected, cfg)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for _, content := range []string{
+			`include: oops`,
+			`unknown: [ ]`,
+			`include: [ { files: [a.go] } ]`,
+			`exclude: [ { package: a, functions: ["[a-"] } ]`,
+		} {
+			_, err := loadInstrumentationConfig(writeConfig(t, content))
+			require.Error(t, err, content)
+		}
+
+		_, err := loadInstrumentationConfig(filepath.Join(dir, "does-not-exist"))
+		require.Error(t, err)
+	})
+}
+
+func TestInstrumentationConfigRules(t *testing.T) {
+	cfg := &instrumentationConfig{
+		Include: []instrumentationConfigEntry{
+			{Package: "my-org/orm/..."},
+			{Package: "my-org/rpc", Files: []string{"client*.go"}, Functions: []string{"(*Client).Call*", "Dial"}},
+			{Package: "net/http", Files: []string{"server.go"}},
+			{Package: "github.com/gin-gonic/gin"},
+		},
+		Exclude: []instrumentationConfigEntry{
+			{Package: "my-org/orm/internal"},
+			{Package: "my-org/orm", Files: []string{"*_gen.go"}, Functions: []string{"debug*"}},
+			{Package: "github.com/labstack/echo"},
+		},
+	}
+
+	newInstrumentation := func(pkgPath string, full bool) *defaultPackageInstrumentation {
+		return newDefaultPackageInstrumentation(pkgPath, full, cfg, "")
+	}
+
+	t.Run("Packages", func(t *testing.T) {
+		for _, tc := range []struct {
+			pkgPath string
+			full    bool
+			ignored bool
+		}{
+			{pkgPath: "my-org/orm"},
+			{pkgPath: "my-org/orm/dialect"},
+			{pkgPath: "my-org/orm/internal", ignored: true},
+			{pkgPath: "my-org/orm/internal", full: true, ignored: true},
+			{pkgPath: "my-org/orm-fork", ignored: true},
+			{pkgPath: "my-org/rpc"},
+			{pkgPath: "my-org/rpc/internal", ignored: true},
+			{pkgPath: "my-org/rpc/internal", full: true},
+			{pkgPath: "net/http"},
+			{pkgPath: "os"},
+			{pkgPath: "github.com/labstack/echo", ignored: true},
+			{pkgPath: "github.com/labstack/echo", full: true, ignored: true},
+			{pkgPath: "runtime/pprof", ignored: true},
+		} {
+			require.Equal(t, tc.ignored, newInstrumentation(tc.pkgPath, tc.full).IsIgnored(), tc)
+		}
+	})
+
+	t.Run("Files", func(t *testing.T) {
+		for _, tc := range []struct {
+			pkgPath  string
+			filename string
+			ignored  bool
+		}{
+			{pkgPath: "my-org/orm", filename: "orm.go"},
+			{pkgPath: "my-org/orm", filename: "orm_gen.go", ignored: true},
+			{pkgPath: "my-org/orm/dialect", filename: "dialect_gen.go"},
+			{pkgPath: "my-org/rpc", filename: "client.go"},
+			{pkgPath: "my-org/rpc", filename: "client_stream.go"},
+			{pkgPath: "my-org/rpc", filename: "server.go", ignored: true},
+			{pkgPath: "net/http", filename: "client.go"},
+			{pkgPath: "net/http", filename: "server.go"},
+			{pkgPath: "net/http", filename: "transport.go", ignored: true},
+			{pkgPath: "github.com/gin-gonic/gin", filename: "gin.go"},
+			{pkgPath: "go.mongodb.org/mongo-driver/mongo", filename: "mongo.go"},
+			{pkgPath: "go.mongodb.org/mongo-driver/mongo", filename: "client.go", ignored: true},
+		} {
+			h := newInstrumentation(tc.pkgPath, false)
+			ignored := h.rules.isFileExcluded(tc.filename) || h.isFileLimited(tc.filename)
+			require.Equal(t, tc.ignored, ignored, tc)
+		}
+	})
+
+	t.Run("Limited functions", func(t *testing.T) {
+		file, err := decorator.ParseFile(nil, "", `package http
+func Redirect() {}
+func (c *conn) serve() {}
+func (w *response) Write() {}
+`, parser.ParseComments)
+		require.NoError(t, err)
+
+		for _, tc := range []struct {
+			pkgPath  string
+			filename string
+			cfg      *instrumentationConfig
+			ignored  []string
+		}{
+			{pkgPath: "net/http", filename: "server.go", ignored: []string{"(*conn).serve", "(*response).Write"}},
+			{pkgPath: "net/http", filename: "client.go"},
+			{pkgPath: "my-org/rpc", filename: "server.go"},
+			// The configuration file includes the whole file
+			{pkgPath: "net/http", filename: "server.go", cfg: cfg},
+		} {
+			h := newDefaultPackageInstrumentation(tc.pkgPath, false, tc.cfg, "")
+			h.parsedFileSources = map[*dst.File]string{file: filepath.Join("src", tc.pkgPath, tc.filename)}
+			var ignored []string
+			for _, decl := range file.Decls {
+				if funcDecl, ok := decl.(*dst.FuncDecl); ok && h.isFuncLimited(file, funcDecl) {
+					ignored = append(ignored, funcDeclName(funcDecl))
+				}
+			}
+			require.Equal(t, tc.ignored, ignored, tc)
+		}
+	})
+
+	t.Run("Functions", func(t *testing.T) {
+		file, err := decorator.ParseFile(nil, "", `package rpc
+type Client struct{}
+func (*Client) Call() {}
+func (*Client) CallAsync() {}
+func (*Client) Close() {}
+func (Client) CallValue() {}
+func Dial() {}
+func debugf() {}
+`, parser.ParseComments)
+		require.NoError(t, err)
+		var funcs []*dst.FuncDecl
+		for _, decl := range file.Decls {
+			if funcDecl, ok := decl.(*dst.FuncDecl); ok {
+				funcs = append(funcs, funcDecl)
+			}
+		}
+
+		for _, tc := range []struct {
+			pkgPath string
+			ignored []string
+		}{
+			{pkgPath: "my-org/rpc", ignored: []string{"(*Client).Close", "Client.CallValue", "debugf"}},
+			{pkgPath: "my-org/orm", ignored: []string{"debugf"}},
+			{pkgPath: "my-org/orm/dialect"},
+			{pkgPath: "os"},
+		} {
+			rules := cfg.packageRules(tc.pkgPath)
+			var ignored []string
+			for _, funcDecl := range funcs {
+				if rules.isFuncIgnored(funcDecl) {
+					ignored = append(ignored, funcDeclName(funcDecl))
+				}
+			}
+			require.Equal(t, tc.ignored, ignored, tc.pkgPath)
+		}
+	})
+}
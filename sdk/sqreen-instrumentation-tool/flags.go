@@ -10,9 +10,12 @@ import (
 )
 
 type instrumentationToolFlagSet struct {
-	Help    bool `sqflag:"-h"`
-	Verbose bool `sqflag:"-v"`
-	Full    bool `sqflag:"-full"`
+	Help    bool   `sqflag:"-h"`
+	Verbose bool   `sqflag:"-v"`
+	Full    bool   `sqflag:"-full"`
+	Config  string `sqflag:"-config"`
+	Report  string `sqflag:"-report"`
+	Overlay string `sqflag:"-overlay"`
 }
 
 const structTagKey = "sqflag"
@@ -69,6 +72,11 @@ func parseOption(flagSetValueMap map[string]reflect.Value, arg, nextArg string)
 		if exists {
 			flag.SetString(value)
 		}
+	} else if exists && flag.Kind() == reflect.String {
+		// `-opt val` syntax of a known string option, whose value can start with
+		// a `-` such as build IDs.
+		shift = 2
+		flag.SetString(nextArg)
 	} else if nextArg == "" || len(nextArg) > 1 && nextArg[0] != '-' {
 		// `-opt val` syntax
 		value := nextArg
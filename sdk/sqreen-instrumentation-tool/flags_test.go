@@ -67,7 +67,38 @@ func TestFindFirstNonOptionArg(t *testing.T) {
 				Full:    true,
 			},
 		},
-
+		{
+			args:        []string{"-v", "-config", "/my/config.yml", "-full", "cmd", "-a", "b", "c"},
+			expectedPos: 4,
+			expectedFlagSet: instrumentationToolFlagSet{
+				Verbose: true,
+				Full:    true,
+				Config:  "/my/config.yml",
+			},
+		},
+		{
+			args:        []string{"-config=/my/config.yml", "cmd"},
+			expectedPos: 1,
+			expectedFlagSet: instrumentationToolFlagSet{
+				Config: "/my/config.yml",
+			},
+		},
+		{
+			args:        []string{"-report", "/my/report.json", "-v", "cmd"},
+			expectedPos: 3,
+			expectedFlagSet: instrumentationToolFlagSet{
+				Verbose: true,
+				Report:  "/my/report.json",
+			},
+		},
+		{
+			args:        []string{"-overlay", "/my/overlay.json", "-full", "./..."},
+			expectedPos: 3,
+			expectedFlagSet: instrumentationToolFlagSet{
+				Full:    true,
+				Overlay: "/my/overlay.json",
+			},
+		},
 		{
 			args:        []string{"-v", "/usr/lib/go-1.13/pkg/tool/linux_amd64/compile", "-V=full"},
 			expectedPos: 1,
@@ -5,15 +5,14 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"go/parser"
 	"go/token"
 	"io/ioutil"
 	"log"
 	"os"
-	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/dave/dst"
@@ -28,6 +27,9 @@ type Instrumenter interface {
 	Instrument() ([]*dst.File, error)
 	WriteInstrumentedFiles(packageBuildDir string, instrumented []*dst.File) (srcdst map[string]string, err error)
 	WriteExtraFiles() ([]string, error)
+	// Record returns the instrumentation record of the package to be stored
+	// in the instrumentation cache.
+	Record() *instrumentationRecord
 }
 
 type packageInstrumentationHelper struct {
@@ -35,9 +37,14 @@ type packageInstrumentationHelper struct {
 	parsedFileSources map[*dst.File]string
 	fset              *token.FileSet
 	pkgPath           string
+	// Source files skipped along with the reason why.
+	skippedFiles map[string]string
+	// Instrumentation rules of the configuration file applying to the package.
+	// Nil when none applies.
+	rules *packageInstrumentationRules
 }
 
-func makePackageInstrumentationHelper(pkgPath string) packageInstrumentationHelper {
+func makePackageInstrumentationHelper(pkgPath string, cfg *instrumentationConfig) packageInstrumentationHelper {
 	// Remove the package path vendor prefix so that everything, from this tool to
 	// the agent instrumentation package works properly with the package path names
 	// as if it wasn't vendored. By doing so, things like checking if the package
@@ -47,6 +54,7 @@ func makePackageInstrumentationHelper(pkgPath string) packageInstrumentationHelp
 
 	return packageInstrumentationHelper{
 		pkgPath: pkgPath,
+		rules:   cfg.packageRules(pkgPath),
 	}
 }
 
@@ -56,21 +64,19 @@ func (h *packageInstrumentationHelper) AddFile(src string) error {
 	// Check if the instrumentation should be skipped for this filename
 	if isFileNameIgnored(src) {
 		log.Println("skipping instrumentation of file", src)
+		h.skipFile(src, "ignored file name")
 		return nil
 	}
 
 	basename := filepath.Base(src)
-	if limited := limitedInstrumentationPkgFiles[h.pkgPath]; len(limited) > 0 {
-		found := false
-		for _, f := range limited {
-			if f == basename {
-				found = true
-				break
-			}
-		}
-		if !found {
-			return nil
-		}
+	if h.rules.isFileExcluded(basename) {
+		log.Printf("file `%s` skipped due to the configuration file", src)
+		h.skipFile(src, "configuration file")
+		return nil
+	}
+	if h.isFileLimited(basename) {
+		h.skipFile(src, "limited instrumentation")
+		return nil
 	}
 
 	log.Printf("parsing file `%s`", src)
@@ -86,6 +92,7 @@ func (h *packageInstrumentationHelper) AddFile(src string) error {
 	// Check if there is a file-level ignore directive
 	if hasSqreenIgnoreDirective(file) {
 		log.Printf("file `%s` skipped due to ignore directive", src)
+		h.skipFile(src, "sqreen:ignore directive")
 		return nil
 	}
 	if h.parsedFiles == nil {
@@ -97,6 +104,54 @@ func (h *packageInstrumentationHelper) AddFile(src string) error {
 	return nil
 }
 
+func (h *packageInstrumentationHelper) skipFile(src, reason string) {
+	if h.skippedFiles == nil {
+		h.skippedFiles = make(map[string]string)
+	}
+	h.skippedFiles[src] = reason
+}
+
+// isFileLimited returns true when the package instrumentation is limited to a
+// set of files, either by default or by the configuration file, which doesn't
+// include the given file base name.
+func (h *packageInstrumentationHelper) isFileLimited(basename string) bool {
+	if h.rules.isFileIncluded(basename) {
+		return false
+	}
+	limited := limitedInstrumentationPkgFiles[h.pkgPath]
+	if len(limited) == 0 {
+		// Packages included with a list of files are limited to them
+		return h.rules != nil && h.rules.included && !h.rules.allFiles
+	}
+	if h.rules != nil && h.rules.included && h.rules.allFiles {
+		// The configuration file removes the default limitation
+		return false
+	}
+	for _, f := range limited {
+		if f == basename {
+			return false
+		}
+	}
+	return true
+}
+
+// isFuncLimited returns true when the package instrumentation is limited to a
+// set of functions of the given file, either by default or by the
+// configuration file, which doesn't include the given function.
+func (h *packageInstrumentationHelper) isFuncLimited(file *dst.File, funcDecl *dst.FuncDecl) bool {
+	basename := filepath.Base(h.parsedFileSources[file])
+	limited := limitedInstrumentationPkgFuncs[h.pkgPath][basename]
+	if len(limited) == 0 {
+		return false
+	}
+	name := funcDeclName(funcDecl)
+	if h.rules != nil && h.rules.included && (matchAny(h.rules.includedFuncs, name) || h.rules.allFuncs && h.rules.isFileIncluded(basename)) {
+		// The configuration file removes the default limitation
+		return false
+	}
+	return !matchAny(limited, name)
+}
+
 func isFileNameIgnored(file string) bool {
 	filename := filepath.Base(file)
 	// Don't instrument cgo files
@@ -151,19 +206,15 @@ func (h *packageInstrumentationHelper) WriteInstrumentedFiles(buildDirPath strin
 type defaultPackageInstrumentation struct {
 	packageInstrumentationHelper
 	instrumentedFiles   map[*dst.File][]*hookpoint
+	stats               *instrumentationStats
 	fullInstrumentation bool
-	hookListFilepath    string
 	packageBuildDir     string
 }
 
-func newDefaultPackageInstrumentation(pkgPath string, fullInstrumentation bool, packageBuildDir string) *defaultPackageInstrumentation {
-	projectBuildDir := path.Join(packageBuildDir, "..")
-	hookListFilepath := getHookListFilepath(projectBuildDir)
-
+func newDefaultPackageInstrumentation(pkgPath string, fullInstrumentation bool, cfg *instrumentationConfig, packageBuildDir string) *defaultPackageInstrumentation {
 	return &defaultPackageInstrumentation{
-		packageInstrumentationHelper: makePackageInstrumentationHelper(pkgPath),
+		packageInstrumentationHelper: makePackageInstrumentationHelper(pkgPath, cfg),
 		fullInstrumentation:          fullInstrumentation,
-		hookListFilepath:             hookListFilepath,
 		packageBuildDir:              packageBuildDir,
 	}
 }
@@ -176,6 +227,10 @@ func (h *defaultPackageInstrumentation) IsIgnored() bool {
 	return false
 }
 
+func (h *defaultPackageInstrumentation) isPackageIgnored() bool {
+	return h.packageIgnoreReason() != ""
+}
+
 var ignoredPkgPrefixes = []string{
 	"runtime",
 	"sync",
@@ -201,6 +256,8 @@ var (
 	limitedInstrumentationPkgPaths = []string{
 		"os",
 		"net/http",
+		"encoding/xml",
+		"encoding/gob",
 		"github.com/gin-gonic/gin",
 		"github.com/labstack/echo",
 		"github.com/labstack/echo/v4",
@@ -220,6 +277,16 @@ var (
 			//   not found
 			"client.go",
 			"request.go",
+			"server.go",   // server.go contains `Redirect()`, cf. limitedInstrumentationPkgFuncs
+			"transfer.go", // transfer.go contains `readTransfer()`, cf. limitedInstrumentationPkgFuncs
+		},
+		"encoding/xml": {
+			// Limited to the decoder for performance reasons:
+			"xml.go", // xml.go contains `NewDecoder()`, cf. limitedInstrumentationPkgFuncs
+		},
+		"encoding/gob": {
+			// Limited to the decoder for performance reasons:
+			"decoder.go", // decoder.go contains `NewDecoder()`, cf. limitedInstrumentationPkgFuncs
 		},
 		"github.com/gin-gonic/gin": {
 			// Same comment as net/http
@@ -240,31 +307,62 @@ var (
 	}
 )
 
-func (h *defaultPackageInstrumentation) isPackageIgnored() bool {
+// Optional list of function name patterns we want to only instrument in a
+// given file of a given package.
+var limitedInstrumentationPkgFuncs = map[string]map[string][]string{
+	"net/http": {
+		// server.go mostly contains the HTTP server hot paths
+		"server.go": {"Redirect"},
+		// transfer.go mostly contains the request and response body hot paths
+		"transfer.go": {"readTransfer"},
+	},
+	"encoding/xml": {
+		// xml.go mostly contains the XML tokenizer hot paths
+		"xml.go": {"NewDecoder"},
+	},
+	"encoding/gob": {
+		"decoder.go": {"NewDecoder"},
+	},
+}
+
+// packageIgnoreReason returns the reason why the package must not be
+// instrumented, or the empty string when it can be.
+func (h *defaultPackageInstrumentation) packageIgnoreReason() string {
 	for _, prefix := range ignoredPkgPrefixes {
 		if strings.HasPrefix(h.pkgPath, prefix) {
-			return true
+			return "low-level package"
+		}
+	}
+
+	// Packages explicitly excluded by the configuration file are never
+	// instrumented, while those explicitly included are always instrumented.
+	if h.rules != nil {
+		if h.rules.excluded {
+			return "configuration file"
+		}
+		if h.rules.included {
+			return ""
 		}
 	}
 
 	if h.fullInstrumentation {
-		return false
+		return ""
 	}
 
 	// Non-full instrumentation mode is limited to a set of packages
 	for _, pkgPath := range limitedInstrumentationPkgPaths {
 		if h.pkgPath == pkgPath {
-			return false
+			return ""
 		}
 	}
 
 	for _, prefix := range limitedInstrumentationPkgPathPrefixes {
 		if strings.HasPrefix(h.pkgPath, prefix) {
-			return false
+			return ""
 		}
 	}
 
-	return true
+	return "limited instrumentation"
 }
 
 // Given the Go vendoring conventions, return the package prefix of the vendored
@@ -276,44 +374,44 @@ func unvendorPackagePath(pkg string) (unvendored string) {
 
 func (h *defaultPackageInstrumentation) Instrument() (instrumented []*dst.File, err error) {
 	h.instrumentedFiles = make(map[*dst.File][]*hookpoint)
-	v := newDefaultPackageInstrumentationVisitor(h.pkgPath, h.instrumentedFiles)
+	v := newDefaultPackageInstrumentationVisitor(h.pkgPath, h.rules, h.isFuncLimited, h.instrumentedFiles)
+	h.stats = &v.stats
 	return h.packageInstrumentationHelper.instrument(v)
 }
 
-func (h *defaultPackageInstrumentation) writeHookList(hookList *os.File) (count int, err error) {
-	for _, hooks := range h.instrumentedFiles {
-		for _, hook := range hooks {
-			if _, err = hookList.WriteString(fmt.Sprintf("%s\n", hook.descriptorFuncDecl.Name.Name)); err != nil {
-				return count, err
-			}
-			count += 1
+// hooks returns the sorted list of hook descriptor function names of the
+// package.
+func (h *defaultPackageInstrumentation) hooks() (hooks []string) {
+	for _, fileHooks := range h.instrumentedFiles {
+		for _, hook := range fileHooks {
+			hooks = append(hooks, hook.descriptorFuncDecl.Name.Name)
 		}
 	}
-	return count, nil
+	sort.Strings(hooks)
+	return hooks
 }
 
 func (h *defaultPackageInstrumentation) WriteExtraFiles() (extra []string, err error) {
-	// Add the hook IDs to the hook list file.
-	hookListFile, err := openHookListFile(h.hookListFilepath)
-	if err != nil {
-		return nil, err
-	}
-	defer hookListFile.Close()
-	count, err := h.writeHookList(hookListFile)
-	if err != nil {
-		return nil, err
-	}
-	log.Printf("added %d hooks to the hook list\n", count)
 	return nil, nil
 }
 
+func (h *defaultPackageInstrumentation) Record() *instrumentationRecord {
+	return &instrumentationRecord{
+		Hooks:  h.hooks(),
+		Report: h.packageReport(),
+	}
+}
+
 type mainPackageInstrumentation struct {
 	*defaultPackageInstrumentation
+	// Instrumentation records of every package the main package depends on.
+	deps []*instrumentationRecord
 }
 
-func newMainPackageInstrumentation(pkgPath string, fullInstrumentation bool, packageBuildDir string) *mainPackageInstrumentation {
+func newMainPackageInstrumentation(pkgPath string, fullInstrumentation bool, cfg *instrumentationConfig, deps []*instrumentationRecord, packageBuildDir string) *mainPackageInstrumentation {
 	return &mainPackageInstrumentation{
-		defaultPackageInstrumentation: newDefaultPackageInstrumentation(pkgPath, fullInstrumentation, packageBuildDir),
+		defaultPackageInstrumentation: newDefaultPackageInstrumentation(pkgPath, fullInstrumentation, cfg, packageBuildDir),
+		deps:                          deps,
 	}
 }
 
@@ -348,9 +446,9 @@ func (m *mainPackageInstrumentation) WriteExtraFiles() (extra []string, err erro
 func (m *mainPackageInstrumentation) writeHookTable() (string, error) {
 	// Create the hook table and compile it.
 	// Get the full list of hooks
-	hooks, err := readHookListFile(m.hookListFilepath)
-	if err != nil {
-		return "", err
+	hooks := m.hooks()
+	for _, dep := range m.deps {
+		hooks = append(hooks, dep.Hooks...)
 	}
 
 	if len(hooks) == 0 {
@@ -365,7 +463,7 @@ func (m *mainPackageInstrumentation) writeHookTable() (string, error) {
 	}
 	defer hookTableFile.Close()
 	log.Printf("creating the hook table for %d hooks into `%s`", len(hooks), hookTableFile.Name())
-	if err := writeHookTable(hookTableFile, hooks); err != nil {
+	if err := writeHookTable(hookTableFile, "main", hooks, isHookDescriptorFuncInMainPackage); err != nil {
 		return "", err
 	}
 
@@ -378,35 +476,6 @@ func createHookTableFile(dir string) (*os.File, error) {
 	return os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
 }
 
-// Create or append the hook list file in write-only.
-func openHookListFile(hookListFilepath string) (*os.File, error) {
-	return os.OpenFile(hookListFilepath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
-}
-
-func getHookListFilepath(dir string) string {
-	return filepath.Join(dir, "sqreen-hooks.txt")
-}
-
-// Read the given hook list file by reopening it and reading its full content,
-// returned as a slice of hook IDs.
-func readHookListFile(hookListFilepath string) (hooks []string, err error) {
-	f, err := os.OpenFile(hookListFilepath, os.O_RDONLY, 0666)
-	if os.IsNotExist(err) {
-		return nil, nil
-	}
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	// Read each hook id line by line
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		id := scanner.Text()
-		hooks = append(hooks, id)
-	}
-	return
-}
-
 type runtimePackageInstrumentation struct {
 	packageInstrumentationHelper
 	packageBuildDir string
@@ -428,6 +497,10 @@ func (h *runtimePackageInstrumentation) Instrument() (instrumented []*dst.File,
 	return h.packageInstrumentationHelper.instrument(v)
 }
 
+func (h *runtimePackageInstrumentation) Record() *instrumentationRecord {
+	return &instrumentationRecord{}
+}
+
 func (h *runtimePackageInstrumentation) WriteExtraFiles() ([]string, error) {
 	rtExtensions := filepath.Join(h.packageBuildDir, "sqreen.go")
 	if err := ioutil.WriteFile(rtExtensions, []byte(`package runtime
@@ -493,14 +566,70 @@ func (v *runtimeInstrumentationVisitor) instrument(root *dst.Package) (instrumen
 			})
 			instrumented = true
 			return true
+
+		case *dst.FuncDecl:
+			switch n.Name.Name {
+			case "newproc1", "goexit0", "gdestroy":
+				if n.Body != nil && instrumentGLSPropagation(n.Body) {
+					instrumented = true
+				}
+			}
+			return false
 		}
 	},
 		func(cursor *dstutil.Cursor) bool {
 			if n, ok := cursor.Node().(*dst.File); ok && instrumented {
-				instrumentedFiles = []*dst.File{n}
-				return false
+				instrumentedFiles = append(instrumentedFiles, n)
+				instrumented = false
 			}
 			return true
 		})
 	return
 }
+
+// instrumentGLSPropagation propagates the GLS value to the new goroutines the
+// same way as the pprof labels: the GLS value is copied from the parent
+// goroutine when the labels are, and cleared when the labels are cleared when
+// the goroutine exits.
+func instrumentGLSPropagation(body *dst.BlockStmt) (instrumented bool) {
+	dstutil.Apply(body, func(cursor *dstutil.Cursor) bool {
+		assign, ok := cursor.Node().(*dst.AssignStmt)
+		if !ok {
+			return true
+		}
+		if cursor.Index() < 0 || len(assign.Lhs) != 1 || len(assign.Rhs) != 1 {
+			return false
+		}
+		lhs, ok := assign.Lhs[0].(*dst.SelectorExpr)
+		if !ok || lhs.Sel.Name != "labels" {
+			return false
+		}
+
+		var value dst.Expr
+		switch rhs := assign.Rhs[0].(type) {
+		case *dst.SelectorExpr:
+			// newg.labels = parent.labels
+			if rhs.Sel.Name != "labels" {
+				return false
+			}
+			value = &dst.SelectorExpr{X: dst.Clone(rhs.X).(dst.Expr), Sel: dst.NewIdent("sqgls")}
+		case *dst.Ident:
+			// gp.labels = nil
+			if rhs.Name != "nil" {
+				return false
+			}
+			value = dst.NewIdent("nil")
+		default:
+			return false
+		}
+
+		cursor.InsertAfter(&dst.AssignStmt{
+			Lhs: []dst.Expr{&dst.SelectorExpr{X: dst.Clone(lhs.X).(dst.Expr), Sel: dst.NewIdent("sqgls")}},
+			Tok: token.ASSIGN,
+			Rhs: []dst.Expr{value},
+		})
+		instrumented = true
+		return false
+	}, nil)
+	return instrumented
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntimeInstrumentation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqreen-instrumentation-runtime")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	runtime2 := filepath.Join(dir, "runtime2.go")
+	require.NoError(t, ioutil.WriteFile(runtime2, []byte(`package runtime
+
+type g struct {
+	labels unsafe.Pointer
+}
+`), 0644))
+	proc := filepath.Join(dir, "proc.go")
+	require.NoError(t, ioutil.WriteFile(proc, []byte(`package runtime
+
+func newproc1(fn *funcval, callergp *g, callerpc uintptr) *g {
+	newg := gfget()
+	if isSystemGoroutine(newg, false) {
+		sched.ngsys.Add(1)
+	} else {
+		if mp.curg != nil {
+			newg.labels = mp.curg.labels
+		}
+	}
+	return newg
+}
+
+func gdestroy(gp *g) {
+	gp.labels = nil
+}
+
+func other(gp *g) {
+	gp.labels = nil
+}
+`), 0644))
+	mprof := filepath.Join(dir, "mprof.go")
+	require.NoError(t, ioutil.WriteFile(mprof, []byte(`package runtime
+
+func stopProfile() {
+	goroutineProfile.labels = nil
+}
+`), 0644))
+
+	pkg := newRuntimePackageInstrumentation(dir)
+	for _, src := range []string{runtime2, proc, mprof} {
+		require.NoError(t, pkg.AddFile(src))
+	}
+	instrumented, err := pkg.Instrument()
+	require.NoError(t, err)
+	// mprof.go is left unchanged
+	require.Len(t, instrumented, 2)
+
+	buildDir := filepath.Join(dir, "build")
+	require.NoError(t, os.Mkdir(buildDir, 0777))
+	written, err := pkg.WriteInstrumentedFiles(buildDir, instrumented)
+	require.NoError(t, err)
+	require.Len(t, written, 2)
+
+	buf, err := ioutil.ReadFile(written[runtime2])
+	require.NoError(t, err)
+	require.Contains(t, string(buf), "\tsqgls\tinterface{}\n")
+
+	buf, err = ioutil.ReadFile(written[proc])
+	require.NoError(t, err)
+	_, err = parser.ParseFile(token.NewFileSet(), written[proc], buf, 0)
+	require.NoError(t, err)
+	src := string(buf)
+	// The child goroutine inherits the GLS value of its parent
+	require.Contains(t, src, "newg.labels = mp.curg.labels\n\t\t\tnewg.sqgls = mp.curg.sqgls\n")
+	// The GLS value is cleared when the goroutine exits
+	require.Contains(t, src, "func gdestroy(gp *g) {\n\tgp.labels = nil\n\tgp.sqgls = nil\n}")
+	// Other functions are left unchanged
+	require.Contains(t, src, "func other(gp *g) {\n\tgp.labels = nil\n}")
+}
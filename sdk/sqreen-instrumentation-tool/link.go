@@ -0,0 +1,93 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// The instrumentation report is written when linking the program out of the
+// instrumentation records of every linked package, whether their compilation
+// was cached or not. The report path is therefore only part of the linker ID so
+// that changing it only links the program again.
+
+type linkFlagSet struct {
+	Output    string `sqflag:"-o"`
+	ImportCfg string `sqflag:"-importcfg"`
+}
+
+func (f *linkFlagSet) IsValid() bool {
+	return f.Output != "" && f.ImportCfg != ""
+}
+
+func (f *linkFlagSet) String() string {
+	return fmt.Sprintf("-o=%q -importcfg=%q", f.Output, f.ImportCfg)
+}
+
+func parseLinkCommand(args []string) (commandExecutionFunc, error) {
+	if len(args) == 0 {
+		return nil, errors.New("unexpected number of command arguments")
+	}
+	if globalFlags.Report == "" {
+		// Nothing to do
+		return nil, nil
+	}
+	if len(args) == 2 && args[1] == "-V=full" {
+		return makeLinkerVersionCommandExecutionFunc(args), nil
+	}
+	flags := &linkFlagSet{}
+	parseFlags(flags, args[1:])
+	return makeLinkCommandExecutionFunc(flags), nil
+}
+
+// makeLinkerVersionCommandExecutionFunc returns the execution function of the
+// linker version command `link -V=full` used by the go toolchain to compute the
+// build cache keys of the linked programs. The report path is added to it so
+// that the program is linked again, and its report written, when it changes.
+func makeLinkerVersionCommandExecutionFunc(args []string) commandExecutionFunc {
+	return func() ([]string, error) {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println(addToolIDToVersion(string(out), linkerToolID(&globalFlags)))
+		return nil, errCommandExecuted
+	}
+}
+
+func makeLinkCommandExecutionFunc(flags *linkFlagSet) commandExecutionFunc {
+	return func() ([]string, error) {
+		if !flags.IsValid() {
+			// Skip when the required set of flags is not valid.
+			log.Printf("nothing to do (%s)\n", flags)
+			return nil, nil
+		}
+
+		// The link import configuration file lists the archives of every linked
+		// package, including the main one.
+		actionIDs, err := readImportCfgActionIDs(flags.ImportCfg)
+		if err != nil {
+			return nil, err
+		}
+		cache, err := newInstrumentationCache("")
+		if err != nil {
+			return nil, err
+		}
+		records, err := cache.LoadAll(actionIDs)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeInstrumentationReport(globalFlags.Report, records); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+}
@@ -25,7 +25,18 @@ func main() {
 	log.SetOutput(os.Stderr)
 
 	args := os.Args[1:]
+
+	// The diff subcommand is run by the user and is not part of the toolexec
+	// command forwarding.
+	if len(args) > 0 && args[0] == diffCommandName {
+		os.Exit(runDiffCommand(os.Stdout, args[1:]))
+	}
+
 	cmd, cmdArgPos, err := parseCommand(&globalFlags, args)
+	if globalFlags.Overlay != "" && cmdArgPos == -1 {
+		// The list of packages is optional in overlay mode
+		err = nil
+	}
 	if err != nil || globalFlags.Help {
 		log.Println(err)
 		printUsage()
@@ -43,9 +54,29 @@ func main() {
 		log.SetOutput(&logs)
 	}
 
+	if globalFlags.Overlay != "" {
+		// The overlay mode instruments the given packages instead of executing a
+		// toolexec command.
+		var patterns []string
+		if cmdArgPos != -1 {
+			patterns = args
+		}
+		if err := runOverlayCommand(&globalFlags, patterns); err != nil {
+			log.Println(err)
+			if !globalFlags.Verbose {
+				fmt.Fprintln(os.Stderr, &logs)
+			}
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if cmd != nil {
 		// The command is implemented
 		newArgs, err := cmd()
+		if err == errCommandExecuted {
+			os.Exit(0)
+		}
 		if err != nil {
 			log.Println(err)
 			if !globalFlags.Verbose {
@@ -86,7 +117,9 @@ func forwardCommand(args []string) error {
 }
 
 func printUsage() {
-	const usageFormat = `Usage: go {build,install,get,test} -a -toolexec '%s [-v] [-full]' PACKAGES...
+	const usageFormat = `Usage: go {build,install,get,test} -toolexec '%s [-v] [-full] [-config FILE] [-report FILE]' PACKAGES...
+       %s -overlay FILE [-v] [-full] [-config FILE] [-report FILE] [PACKAGES...]
+       %s diff OLD_REPORT NEW_REPORT [PACKAGE...]
 
 Sqreen's instrumentation tool for Go v%s. It instruments Go source code at
 compilation time by adding hooks on every instrumented functions. The set of
@@ -94,9 +127,12 @@ instrumented functions is restricted to a few required packages by default but
 can be fully performed on every package by using option -full. Low-level package
 such as cgo or the runtime are never instrumented. The verbose output can be
 enabled using option -v and gives some details about the instrumentation.
-It is integrated into the go toolchain thanks to the option -toolexec and must
-be used along with option -a so that every package is compiled no matter the
-previously cached compilations.
+It is integrated into the go toolchain thanks to the option -toolexec and takes
+part in the go build cache so that only the packages that changed since the
+previous build, or whose instrumentation options changed, are instrumented and
+compiled again. The instrumentation results of the packages are kept in the
+directory sqreen-instrumentation of the go build cache directory $GOCACHE, or of
+the user cache directory when not set.
 
 Note that Sqreen's Go agent provides the instrumentation engine and therefore
 needs to be imported by the compiled package to be able to link.
@@ -108,19 +144,74 @@ Options:
                 Verbose mode. Detailed logs will be printed by the tool.
         -full
                 Perform a full instrumentation of the program.
+        -config FILE
+                Absolute path to the YAML or JSON instrumentation configuration
+                file listing the packages, files and functions to include or to
+                exclude, merged with the default instrumentation. For example:
+                  include:
+                    - package: github.com/my-org/orm/...
+                    - package: github.com/my-org/rpc/client
+                      files: [client.go]
+                      functions: ["(*Client).Call*"]
+                  exclude:
+                    - package: github.com/my-org/orm/internal/...
+                Package paths ending with /... match every package under the
+                path. File and function names are glob patterns of the file base
+                names and of the function names with their receiver type, such
+                as Func, T.Method or (*T).Method.
+        -report FILE
+                Absolute path to the JSON instrumentation report to write. It
+                lists every compiled package, file and function along with
+                their hook symbol when instrumented, or the reason why they
+                were ignored. It is written when linking the program, out of
+                the instrumentation results of every linked package, including
+                those whose compilation was cached.
+        -overlay FILE
+                Overlay mode. Instrument the given packages, along with their
+                dependencies and test dependencies, and write the go -overlay
+                JSON file FILE mapping their source files to the instrumented
+                ones. The instrumented sources are written into the directory
+                having the same path as FILE but with the .d extension. The
+                program can then be built, tested or debugged using the go
+                option -overlay instead of -toolexec, for example:
+                  go build -overlay FILE PACKAGES...
+                  go test -cover -race -overlay FILE PACKAGES...
+                  dlv debug --build-flags=-overlay=FILE PACKAGE
+                Build flags changing the set of compiled files, such as -tags,
+                must be provided through the GOFLAGS environment variable. The
+                overlay file must be written again when the sources change.
+                The go toolchain doesn't allow to replace the files of the
+                module cache so that modules must be vendored using
+                go mod vendor, and the program built with -mod=vendor, in
+                order to be instrumented in overlay mode. The command fails
+                when packages instrumented without -full, such as the agent
+                protections, are in the module cache, while the other ones
+                are skipped.
+
+The diff command compares the hookpoints of two instrumentation reports and
+prints the missing ones prefixed by - and the new ones prefixed by +. It can be
+restricted to the packages matching the given package paths, with the same
+syntax as the configuration file. Its exit code is 1 when hookpoints are
+missing so that it can be used to check the instrumentation of a program does
+not lose security-relevant hookpoints.
 
 To see the instrumented code, use the go option -work in order to keep the
 build directory. It will contain every instrumented Go source file.
 `
-	_, _ = fmt.Fprintf(os.Stderr, usageFormat, os.Args[0], version.Version())
+	_, _ = fmt.Fprintf(os.Stderr, usageFormat, os.Args[0], os.Args[0], os.Args[0], version.Version())
 	os.Exit(2)
 }
 
 type parseCommandFunc func([]string) (commandExecutionFunc, error)
 type commandExecutionFunc func() (newArgs []string, err error)
 
+// errCommandExecuted is returned by command execution functions which already
+// executed the command themselves so that it must not be forwarded.
+var errCommandExecuted = errors.New("command executed")
+
 var commandParserMap = map[string]parseCommandFunc{
 	"compile": parseCompileCommand,
+	"link":    parseLinkCommand,
 }
 
 // getCommand returns the command and arguments. The command is expectedFlags to be
@@ -0,0 +1,324 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// The overlay mode is an alternative to the toolexec mode where the program
+// packages are instrumented ahead of the build. The instrumented sources are
+// written into a directory next to the overlay file, and the overlay file maps
+// the original source files to them according to the format of the go option
+// -overlay. The go toolchain then compiles the instrumented sources as if they
+// were the original ones, so that the tools relying on the compiled source
+// files, such as debuggers, the coverage or the race detector, work unchanged.
+
+// goOverlay is the JSON file format of the go option -overlay.
+type goOverlay struct {
+	// Replace maps the original source file paths to the paths of their
+	// replacement. Replacement files of source files that do not exist are
+	// added to their package.
+	Replace map[string]string
+}
+
+// listedPackage is the subset of the package information returned by `go list`
+// used by the overlay mode.
+type listedPackage struct {
+	ImportPath string
+	Name       string
+	Dir        string
+	GoFiles    []string
+	ForTest    string
+	Deps       []string
+}
+
+// Name of the hook table file added to the internal test files of tested
+// packages.
+const testHookTableFilename = "sqreen_hooktable_test.go"
+
+// runOverlayCommand instruments the packages matching the given package
+// patterns, along with their dependencies and test dependencies, and writes
+// the overlay file `flags.Overlay`.
+func runOverlayCommand(flags *instrumentationToolFlagSet, patterns []string) error {
+	var cfg *instrumentationConfig
+	if flags.Config != "" {
+		var err error
+		cfg, err = loadInstrumentationConfig(flags.Config)
+		if err != nil {
+			return err
+		}
+	}
+
+	pkgs, err := goListPackages(patterns)
+	if err != nil {
+		return err
+	}
+	modCacheDir, err := goModCacheDir()
+	if err != nil {
+		return err
+	}
+
+	sourcesDir := overlaySourcesDir(flags.Overlay)
+	if err := os.RemoveAll(sourcesDir); err != nil {
+		return err
+	}
+	log.Printf("writing the instrumented sources into `%s`", sourcesDir)
+
+	overlay := goOverlay{Replace: make(map[string]string)}
+	records := make(map[string]*instrumentationRecord, len(pkgs))
+	listed := make(map[string]*listedPackage, len(pkgs))
+	// Packages of the module cache that must be instrumented.
+	var cached []string
+	for _, pkg := range pkgs {
+		if pkg.ForTest != "" {
+			// Test variants of packages are compiled from the same source files and
+			// therefore use the same instrumented files.
+			continue
+		}
+
+		packageBuildDir := filepath.Join(sourcesDir, filepath.FromSlash(pkg.ImportPath))
+		if err := os.MkdirAll(packageBuildDir, 0777); err != nil {
+			return err
+		}
+
+		if tested, exists := listed[strings.TrimSuffix(pkg.ImportPath, ".test")]; exists && pkg.Name == "main" {
+			// Test main package generated by the go toolchain.
+			if err := writeTestHookTable(&overlay, tested, pkg.Deps, records, packageBuildDir); err != nil {
+				return err
+			}
+			continue
+		}
+		listed[pkg.ImportPath] = pkg
+
+		// The go toolchain compiles the main packages with package path `main`.
+		pkgPath := pkg.ImportPath
+		if pkg.Name == "main" {
+			pkgPath = "main"
+		}
+
+		if isPathUnder(pkg.Dir, modCacheDir) {
+			// The go toolchain doesn't allow to replace the files of the module
+			// cache. Skipping the packages instrumented regardless of the full
+			// instrumentation mode, such as the agent protections, would silently
+			// disable them and is therefore an error.
+			if !newDefaultPackageInstrumentation(pkgPath, false, cfg, packageBuildDir).IsIgnored() {
+				cached = append(cached, pkg.ImportPath)
+			} else {
+				log.Printf("skipping instrumentation of package `%s`: the source files of the module cache cannot be replaced by an overlay - the module must be vendored in order to be instrumented", pkg.ImportPath)
+			}
+			records[pkg.ImportPath] = &instrumentationRecord{
+				Report: &packageReport{Path: unvendorPackagePath(pkgPath), Ignored: "module cache"},
+			}
+			continue
+		}
+
+		var i Instrumenter
+		switch pkgPath {
+		case "runtime":
+			i = newRuntimePackageInstrumentation(packageBuildDir)
+		case "main":
+			var deps []*instrumentationRecord
+			for _, dep := range pkg.Deps {
+				if record, exists := records[dep]; exists {
+					deps = append(deps, record)
+				}
+			}
+			// The report of every package is written at once by this command.
+			i = newMainPackageInstrumentation(pkgPath, flags.Full, cfg, deps, packageBuildDir)
+		default:
+			i = newDefaultPackageInstrumentation(pkgPath, flags.Full, cfg, packageBuildDir)
+		}
+
+		if i.IsIgnored() {
+			log.Printf("skipping instrumentation of package `%s`\n", pkg.ImportPath)
+		} else {
+			srcs := make([]string, 0, len(pkg.GoFiles))
+			for _, src := range pkg.GoFiles {
+				srcs = append(srcs, filepath.Join(pkg.Dir, src))
+			}
+			if err := instrumentOverlay(&overlay, i, pkg, srcs, packageBuildDir); err != nil {
+				return err
+			}
+		}
+		records[pkg.ImportPath] = i.Record()
+	}
+
+	if len(cached) > 0 {
+		return fmt.Errorf("the source files of the module cache cannot be replaced by an overlay while the following packages must be instrumented: %s - their modules must be vendored using `go mod vendor` and the program built with -mod=vendor", strings.Join(cached, ", "))
+	}
+
+	if flags.Report != "" {
+		all := make([]*instrumentationRecord, 0, len(records))
+		for _, record := range records {
+			all = append(all, record)
+		}
+		if err := writeInstrumentationReport(flags.Report, all); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("writing the overlay of %d files into `%s`", len(overlay.Replace), flags.Overlay)
+	return writeOverlayFile(flags.Overlay, &overlay)
+}
+
+// overlaySourcesDir returns the directory of the instrumented sources of the
+// given overlay file, which is the overlay file path without its extension
+// and with the `.d` suffix.
+func overlaySourcesDir(overlayFilepath string) string {
+	return strings.TrimSuffix(overlayFilepath, filepath.Ext(overlayFilepath)) + ".d"
+}
+
+// instrumentOverlay instruments the source files `srcs` of the given package
+// and adds the instrumented and extra files to the overlay.
+func instrumentOverlay(overlay *goOverlay, i Instrumenter, pkg *listedPackage, srcs []string, packageBuildDir string) error {
+	log.Println("instrumenting package:", pkg.ImportPath)
+
+	for _, src := range srcs {
+		if err := i.AddFile(src); err != nil {
+			return err
+		}
+	}
+
+	if instrumented, err := i.Instrument(); err != nil {
+		return err
+	} else if len(instrumented) > 0 {
+		written, err := i.WriteInstrumentedFiles(packageBuildDir, instrumented)
+		if err != nil {
+			return err
+		}
+		for src, dest := range written {
+			overlay.Replace[src] = dest
+		}
+	}
+
+	extraFiles, err := i.WriteExtraFiles()
+	if err != nil {
+		return err
+	}
+	// Extra files do not exist in the package directory and are therefore added
+	// to the package by the overlay.
+	for _, extra := range extraFiles {
+		overlay.Replace[filepath.Join(pkg.Dir, filepath.Base(extra))] = extra
+	}
+	return nil
+}
+
+// writeTestHookTable adds to the overlay the hook table of the test program
+// of package `tested`. The test main package being generated by the go
+// toolchain, the hook table is added to the internal test files of the tested
+// package instead. Main packages already have their hook table.
+func writeTestHookTable(overlay *goOverlay, tested *listedPackage, testDeps []string, records map[string]*instrumentationRecord, packageBuildDir string) error {
+	if tested.Name == "main" {
+		return nil
+	}
+
+	var hooks []string
+	seen := make(map[string]struct{}, len(testDeps))
+	for _, dep := range testDeps {
+		// Remove the test variant suffix ` [<package path>.test]`
+		if i := strings.Index(dep, " ["); i != -1 {
+			dep = dep[:i]
+		}
+		if _, done := seen[dep]; done {
+			continue
+		}
+		seen[dep] = struct{}{}
+		if record, exists := records[dep]; exists {
+			hooks = append(hooks, record.Hooks...)
+		}
+	}
+	if len(hooks) == 0 {
+		log.Printf("skipping the test hook table generation of package `%s`: the list of hooks is empty", tested.ImportPath)
+		return nil
+	}
+
+	localHooks := make(map[string]struct{})
+	if record, exists := records[tested.ImportPath]; exists {
+		for _, hook := range record.Hooks {
+			localHooks[hook] = struct{}{}
+		}
+	}
+
+	filename := filepath.Join(packageBuildDir, testHookTableFilename)
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	log.Printf("creating the test hook table of package `%s` for %d hooks into `%s`", tested.ImportPath, len(hooks), filename)
+	if err := writeHookTable(f, tested.Name, hooks, func(hook string) bool {
+		_, local := localHooks[hook]
+		return local
+	}); err != nil {
+		return err
+	}
+	overlay.Replace[filepath.Join(tested.Dir, testHookTableFilename)] = filename
+	return nil
+}
+
+// goListPackages returns the packages matching the given patterns along with
+// their dependencies and test dependencies, sorted in dependency order. The go
+// build flags changing the set of listed packages and files, such as build
+// tags, are taken into account when provided by the GOFLAGS environment
+// variable.
+func goListPackages(patterns []string) ([]*listedPackage, error) {
+	args := append([]string{"list", "-deps", "-test", "-json=ImportPath,Name,Dir,GoFiles,ForTest,Deps"}, patterns...)
+	cmd := exec.Command("go", args...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not list the packages: %v", err)
+	}
+
+	var pkgs []*listedPackage
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var pkg listedPackage
+		if err := dec.Decode(&pkg); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("could not parse the package list: %v", err)
+		}
+		pkgs = append(pkgs, &pkg)
+	}
+	return pkgs, nil
+}
+
+// goModCacheDir returns the module cache directory of the go toolchain.
+func goModCacheDir() (string, error) {
+	out, err := exec.Command("go", "env", "GOMODCACHE").Output()
+	if err != nil {
+		return "", fmt.Errorf("could not get the module cache directory: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// isPathUnder returns true when the given path is located under directory
+// `dir`.
+func isPathUnder(path, dir string) bool {
+	if dir == "" {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func writeOverlayFile(filename string, overlay *goOverlay) error {
+	buf, err := json.MarshalIndent(overlay, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf, 0666)
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverlayCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqreen-instrumentation-overlay")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := filepath.Join(dir, "config.yml")
+	require.NoError(t, ioutil.WriteFile(config, []byte("include:\n  - package: main\n"), 0644))
+
+	flags := &instrumentationToolFlagSet{
+		Config:  config,
+		Report:  filepath.Join(dir, "report.json"),
+		Overlay: filepath.Join(dir, "overlay.json"),
+	}
+	require.NoError(t, runOverlayCommand(flags, []string{"./testdata/hello-world"}))
+
+	buf, err := ioutil.ReadFile(flags.Overlay)
+	require.NoError(t, err)
+	var overlay goOverlay
+	require.NoError(t, json.Unmarshal(buf, &overlay))
+
+	appDir, err := filepath.Abs(filepath.Join("testdata", "hello-world"))
+	require.NoError(t, err)
+	sourcesDir := filepath.Join(dir, "overlay.d")
+
+	// The main package file is instrumented while the ignored one is not
+	instrumented, exists := overlay.Replace[filepath.Join(appDir, "main.go")]
+	require.True(t, exists)
+	require.Equal(t, sourcesDir, instrumented[:len(sourcesDir)])
+	_, exists = overlay.Replace[filepath.Join(appDir, "test.go")]
+	require.False(t, exists)
+
+	// The hook table and the runtime extensions are added
+	hookTable, exists := overlay.Replace[filepath.Join(appDir, "sqreen-hooktable.go")]
+	require.True(t, exists)
+	require.FileExists(t, hookTable)
+	_, exists = overlay.Replace[filepath.Join(runtime.GOROOT(), "src", "runtime", "sqreen.go")]
+	require.True(t, exists)
+
+	for _, dest := range overlay.Replace {
+		require.FileExists(t, dest)
+	}
+
+	report, err := readReportFile(flags.Report)
+	require.NoError(t, err)
+	require.Contains(t, report.hookpoints(nil), "main.main")
+}
+
+func TestTestHookTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqreen-instrumentation-overlay")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tested := &listedPackage{ImportPath: "my-org/rpc", Name: "rpc", Dir: "/src/my-org/rpc"}
+	records := map[string]*instrumentationRecord{
+		"my-org/rpc":      {Hooks: []string{"_sqreen_hook_descriptor_my_org_rpc_Call"}},
+		"my-org/rpc/util": {Hooks: []string{"_sqreen_hook_descriptor_my_org_rpc_util_Do"}},
+		"fmt":             {},
+	}
+	testDeps := []string{"fmt", "my-org/rpc", "my-org/rpc [my-org/rpc.test]", "my-org/rpc/util", "testing"}
+
+	overlay := goOverlay{Replace: make(map[string]string)}
+	require.NoError(t, writeTestHookTable(&overlay, tested, testDeps, records, dir))
+
+	filename := filepath.Join(dir, testHookTableFilename)
+	require.Equal(t, map[string]string{filepath.Join(tested.Dir, testHookTableFilename): filename}, overlay.Replace)
+
+	buf, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	src := string(buf)
+	require.Contains(t, src, "package rpc\n")
+	// Every hook is in the table while only the hooks of other packages are
+	// forward declared
+	require.Contains(t, src, "\t_sqreen_hook_descriptor_my_org_rpc_Call,\n")
+	require.Contains(t, src, "\t_sqreen_hook_descriptor_my_org_rpc_util_Do,\n")
+	require.NotContains(t, src, "func _sqreen_hook_descriptor_my_org_rpc_Call(")
+	require.Contains(t, src, "func _sqreen_hook_descriptor_my_org_rpc_util_Do(")
+
+	t.Run("Main package", func(t *testing.T) {
+		overlay := goOverlay{Replace: make(map[string]string)}
+		tested := &listedPackage{ImportPath: "my-org/cmd", Name: "main", Dir: "/src/my-org/cmd"}
+		require.NoError(t, writeTestHookTable(&overlay, tested, testDeps, records, dir))
+		require.Empty(t, overlay.Replace)
+	})
+}
+
+func TestIsPathUnder(t *testing.T) {
+	dir := filepath.Join("go", "pkg", "mod")
+	require.True(t, isPathUnder(filepath.Join(dir, "github.com", "pkg"), dir))
+	require.True(t, isPathUnder(dir, dir))
+	require.False(t, isPathUnder(filepath.Join("go", "pkg", "modules"), dir))
+	require.False(t, isPathUnder(filepath.Join("go", "src"), dir))
+	require.False(t, isPathUnder(dir, ""))
+}
+
+func TestOverlayCommandModuleCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqreen-instrumentation-overlay")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	const pkgPath = "github.com/stretchr/testify/require"
+	flags := &instrumentationToolFlagSet{
+		Overlay: filepath.Join(dir, "overlay.json"),
+	}
+
+	t.Run("package not instrumented", func(t *testing.T) {
+		// The module cache package is skipped
+		require.NoError(t, runOverlayCommand(flags, []string{pkgPath}))
+	})
+
+	t.Run("package to instrument", func(t *testing.T) {
+		// The module cache package included by the configuration cannot be
+		// instrumented
+		config := filepath.Join(dir, "config.yml")
+		require.NoError(t, ioutil.WriteFile(config, []byte("include:\n  - package: "+pkgPath+"\n"), 0644))
+		flags := *flags
+		flags.Config = config
+		err := runOverlayCommand(&flags, []string{pkgPath})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), pkgPath)
+		require.Contains(t, err.Error(), "-mod=vendor")
+	})
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/sqreen/go-agent/internal/version"
+)
+
+// instrumentationReport is the machine-readable manifest of the instrumentation
+// written by option `-report`. It lists every compiled package along with
+// their files and functions, and the reason why they were not instrumented
+// when ignored.
+type instrumentationReport struct {
+	// Version of the instrumentation tool.
+	Version  string          `json:"version"`
+	Packages []packageReport `json:"packages"`
+}
+
+type packageReport struct {
+	Path    string       `json:"path"`
+	Ignored string       `json:"ignored,omitempty"`
+	Files   []fileReport `json:"files,omitempty"`
+}
+
+type fileReport struct {
+	Name      string           `json:"name"`
+	Ignored   string           `json:"ignored,omitempty"`
+	Functions []functionReport `json:"functions,omitempty"`
+}
+
+type functionReport struct {
+	// Function name along with its receiver type, such as `Func`, `T.Method` or
+	// `(*T).Method`.
+	Name string `json:"name"`
+	// Hook descriptor symbol of the instrumented function.
+	Hook    string `json:"hook,omitempty"`
+	Ignored string `json:"ignored,omitempty"`
+}
+
+// hookpoints returns the set of instrumented functions of the packages
+// matching the given package path patterns (cf. the configuration file
+// syntax), or of every package when empty. They are in the form
+// `<package path>.<function name>`, eg. `net/http.(*Client).do`.
+func (r *instrumentationReport) hookpoints(pkgPatterns []string) map[string]struct{} {
+	hookpoints := make(map[string]struct{})
+	for _, pkg := range r.Packages {
+		if len(pkgPatterns) > 0 && !matchAnyPackagePath(pkgPatterns, pkg.Path) {
+			continue
+		}
+		for _, file := range pkg.Files {
+			for _, fn := range file.Functions {
+				if fn.Hook != "" {
+					hookpoints[pkg.Path+"."+fn.Name] = struct{}{}
+				}
+			}
+		}
+	}
+	return hookpoints
+}
+
+func matchAnyPackagePath(patterns []string, pkgPath string) bool {
+	for _, pattern := range patterns {
+		if matchPackagePath(pattern, pkgPath) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *defaultPackageInstrumentation) packageReport() *packageReport {
+	r := &packageReport{
+		Path:    h.pkgPath,
+		Ignored: h.packageIgnoreReason(),
+	}
+	if r.Ignored != "" {
+		return r
+	}
+
+	for src, reason := range h.skippedFiles {
+		r.Files = append(r.Files, fileReport{
+			Name:    filepath.Base(src),
+			Ignored: reason,
+		})
+	}
+
+	for src, file := range h.parsedFiles {
+		f := fileReport{Name: filepath.Base(src)}
+		if h.stats != nil {
+			for _, s := range h.stats.funcs[file] {
+				fn := functionReport{
+					Name:    funcDeclName(s.funcDecl),
+					Ignored: s.ignored,
+				}
+				if s.hook != nil {
+					fn.Hook = s.hook.descriptorFuncDecl.Name.Name
+				}
+				f.Functions = append(f.Functions, fn)
+			}
+		}
+		r.Files = append(r.Files, f)
+	}
+
+	sort.Slice(r.Files, func(i, j int) bool {
+		return r.Files[i].Name < r.Files[j].Name
+	})
+	return r
+}
+
+// writeInstrumentationReport writes the instrumentation report made of the
+// package reports of the given instrumentation records.
+func writeInstrumentationReport(filename string, records []*instrumentationRecord) error {
+	reports := make([]*packageReport, 0, len(records))
+	for _, record := range records {
+		reports = append(reports, record.Report)
+	}
+	report := newInstrumentationReport(reports)
+	log.Printf("writing the instrumentation report of %d packages into `%s`", len(report.Packages), filename)
+	return writeReportFile(filename, report)
+}
+
+// newInstrumentationReport returns the instrumentation report of the given
+// package reports sorted by package path. The last report of a package is kept
+// when present several times, such as when compiled for tests.
+func newInstrumentationReport(reports []*packageReport) *instrumentationReport {
+	pkgs := make(map[string]*packageReport, len(reports))
+	for _, pkg := range reports {
+		if pkg != nil {
+			pkgs[pkg.Path] = pkg
+		}
+	}
+	report := &instrumentationReport{
+		Version:  version.Version(),
+		Packages: make([]packageReport, 0, len(pkgs)),
+	}
+	for _, pkg := range pkgs {
+		report.Packages = append(report.Packages, *pkg)
+	}
+	sort.Slice(report.Packages, func(i, j int) bool {
+		return report.Packages[i].Path < report.Packages[j].Path
+	})
+	return report
+}
+
+func writeReportFile(filename string, report *instrumentationReport) error {
+	buf, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf, 0666)
+}
+
+func readReportFile(filename string) (*instrumentationReport, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var report instrumentationReport
+	if err := json.Unmarshal(buf, &report); err != nil {
+		return nil, fmt.Errorf("could not parse the instrumentation report `%s`: %v", filename, err)
+	}
+	return &report, nil
+}
+
+// diffCommandName is the name of the tool subcommand comparing two
+// instrumentation reports.
+const diffCommandName = "diff"
+
+// runDiffCommand compares the hookpoints of the two instrumentation reports
+// given as arguments, optionally restricted to the packages matching the
+// package path patterns given as extra arguments. The missing and new
+// hookpoints are printed to `w`, and the returned exit code is 1 when
+// hookpoints are missing, or 2 in case of error.
+func runDiffCommand(w io.Writer, args []string) (exitCode int) {
+	if len(args) < 2 {
+		_, _ = fmt.Fprintf(w, "usage: %s %s OLD_REPORT NEW_REPORT [PACKAGE...]\n", filepath.Base(os.Args[0]), diffCommandName)
+		return 2
+	}
+	oldReport, err := readReportFile(args[0])
+	if err != nil {
+		_, _ = fmt.Fprintln(w, err)
+		return 2
+	}
+	newReport, err := readReportFile(args[1])
+	if err != nil {
+		_, _ = fmt.Fprintln(w, err)
+		return 2
+	}
+
+	missing, added := diffInstrumentationReports(oldReport, newReport, args[2:])
+	for _, hookpoint := range missing {
+		_, _ = fmt.Fprintf(w, "- %s\n", hookpoint)
+	}
+	for _, hookpoint := range added {
+		_, _ = fmt.Fprintf(w, "+ %s\n", hookpoint)
+	}
+	if len(missing) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// diffInstrumentationReports returns the sorted lists of hookpoints missing in
+// the new report and added in the new report. When the list of package path
+// patterns is not empty, only the hookpoints of the packages matching one of
+// them are compared.
+func diffInstrumentationReports(oldReport, newReport *instrumentationReport, pkgPatterns []string) (missing, added []string) {
+	oldHookpoints := oldReport.hookpoints(pkgPatterns)
+	newHookpoints := newReport.hookpoints(pkgPatterns)
+	for hookpoint := range oldHookpoints {
+		if _, exists := newHookpoints[hookpoint]; !exists {
+			missing = append(missing, hookpoint)
+		}
+	}
+	for hookpoint := range newHookpoints {
+		if _, exists := oldHookpoints[hookpoint]; !exists {
+			added = append(added, hookpoint)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(added)
+	return missing, added
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrumentationReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqreen-instrumentation-report")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeFile := func(t *testing.T, name, content string) string {
+		filename := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644))
+		return filename
+	}
+
+	srcs := []string{
+		writeFile(t, "client.go", `package rpc
+type Client struct{}
+func (c *Client) Call() {}
+func init() {}
+`),
+		writeFile(t, "ignored.go", `//sqreen:ignore
+
+package rpc
+func Ignored() {}
+`),
+		writeFile(t, "server.go", `package rpc
+func Serve() {}
+`),
+	}
+
+	cfg := &instrumentationConfig{
+		Include: []instrumentationConfigEntry{{Package: "my-org/rpc"}},
+		Exclude: []instrumentationConfigEntry{{Package: "my-org/rpc", Files: []string{"server.go"}}},
+	}
+	reportFilepath := filepath.Join(dir, "report.json")
+
+	// Compile a package, an ignored one, and the main package
+	pkg := newDefaultPackageInstrumentation("my-org/rpc", false, cfg, dir)
+	require.False(t, pkg.IsIgnored())
+	for _, src := range srcs {
+		require.NoError(t, pkg.AddFile(src))
+	}
+	_, err = pkg.Instrument()
+	require.NoError(t, err)
+
+	ignored := newDefaultPackageInstrumentation("my-org/other", false, cfg, dir)
+	require.True(t, ignored.IsIgnored())
+
+	mainPkg := newMainPackageInstrumentation("main", false, cfg, nil, dir)
+	records := []*instrumentationRecord{mainPkg.Record(), pkg.Record(), ignored.Record()}
+	require.NoError(t, writeInstrumentationReport(reportFilepath, records))
+
+	report, err := readReportFile(reportFilepath)
+	require.NoError(t, err)
+	require.Equal(t, []packageReport{
+		{Path: "main", Ignored: "limited instrumentation"},
+		{Path: "my-org/other", Ignored: "limited instrumentation"},
+		{
+			Path: "my-org/rpc",
+			Files: []fileReport{
+				{
+					Name: "client.go",
+					Functions: []functionReport{
+						{Name: "(*Client).Call", Hook: "_sqreen_hook_descriptor_my_org_rpc_Client_Call"},
+						{Name: "init", Ignored: "package init function"},
+					},
+				},
+				{Name: "ignored.go", Ignored: "sqreen:ignore directive"},
+				{Name: "server.go", Ignored: "configuration file"},
+			},
+		},
+	}, report.Packages)
+}
+
+func TestLinkInstrumentationReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqreen-instrumentation-report")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	gocache := os.Getenv("GOCACHE")
+	defer os.Setenv("GOCACHE", gocache)
+	require.NoError(t, os.Setenv("GOCACHE", filepath.Join(dir, "go-cache")))
+	cache, err := newInstrumentationCache("")
+	require.NoError(t, err)
+
+	// The main package record is stored by its compilation, while its
+	// dependency record may come from a previous build.
+	require.NoError(t, cache.Store("main-id", &instrumentationRecord{
+		Report: &packageReport{Path: "main", Ignored: "limited instrumentation"},
+		Deps:   []string{"dep-id"},
+	}))
+	require.NoError(t, cache.Store("dep-id", &instrumentationRecord{
+		Report: &packageReport{Path: "my-org/dep", Ignored: "limited instrumentation"},
+	}))
+
+	archive := filepath.Join(dir, "main.a")
+	require.NoError(t, ioutil.WriteFile(archive, []byte("!<arch>\n__.PKGDEF       0           0     0     644     42975     `\ngo object linux amd64 go1.15.2 X:none\nbuild id \"main-id/content-id\"\n\n$$B\n"), 0644))
+	importcfg := filepath.Join(dir, "importcfg.link")
+	require.NoError(t, ioutil.WriteFile(importcfg, []byte("packagefile main="+archive+"\n"), 0644))
+
+	reportFilepath := filepath.Join(dir, "report.json")
+	report := globalFlags.Report
+	defer func() { globalFlags.Report = report }()
+	globalFlags.Report = reportFilepath
+
+	cmd, err := parseLinkCommand([]string{"link", "-o", filepath.Join(dir, "a.out"), "-importcfg", importcfg, archive})
+	require.NoError(t, err)
+	newArgs, err := cmd()
+	require.NoError(t, err)
+	require.Nil(t, newArgs)
+
+	written, err := readReportFile(reportFilepath)
+	require.NoError(t, err)
+	require.Equal(t, []packageReport{
+		{Path: "main", Ignored: "limited instrumentation"},
+		{Path: "my-org/dep", Ignored: "limited instrumentation"},
+	}, written.Packages)
+
+	t.Run("Disabled", func(t *testing.T) {
+		globalFlags.Report = ""
+		cmd, err := parseLinkCommand([]string{"link", "-o", filepath.Join(dir, "a.out"), "-importcfg", importcfg, archive})
+		require.NoError(t, err)
+		require.Nil(t, cmd)
+	})
+}
+
+func TestDiffInstrumentationReports(t *testing.T) {
+	makeReport := func(pkgs map[string][]string) *instrumentationReport {
+		var r instrumentationReport
+		for pkgPath, funcs := range pkgs {
+			file := fileReport{Name: "file.go"}
+			for _, fn := range funcs {
+				file.Functions = append(file.Functions, functionReport{Name: fn, Hook: "hook"})
+			}
+			file.Functions = append(file.Functions, functionReport{Name: "init", Ignored: "package init function"})
+			r.Packages = append(r.Packages, packageReport{Path: pkgPath, Files: []fileReport{file}})
+		}
+		return &r
+	}
+
+	oldReport := makeReport(map[string][]string{
+		"net/http":            {"(*Client).do", "(*Request).ParseForm"},
+		"database/sql":        {"(*DB).Exec", "(*DB).Query"},
+		"my-org/orm":          {"Open"},
+		"my-org/orm/internal": {"debug"},
+	})
+	newReport := makeReport(map[string][]string{
+		"net/http":            {"(*Client).do", "(*Request).FormValue"},
+		"database/sql":        {"(*DB).Exec", "(*DB).Query"},
+		"my-org/orm/internal": {"trace"},
+	})
+
+	missing, added := diffInstrumentationReports(oldReport, newReport, nil)
+	require.Equal(t, []string{"my-org/orm.Open", "my-org/orm/internal.debug", "net/http.(*Request).ParseForm"}, missing)
+	require.Equal(t, []string{"my-org/orm/internal.trace", "net/http.(*Request).FormValue"}, added)
+
+	missing, added = diffInstrumentationReports(oldReport, newReport, []string{"database/sql", "my-org/orm"})
+	require.Equal(t, []string{"my-org/orm.Open"}, missing)
+	require.Empty(t, added)
+
+	missing, added = diffInstrumentationReports(oldReport, newReport, []string{"database/sql"})
+	require.Empty(t, missing)
+	require.Empty(t, added)
+
+	t.Run("Command", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "sqreen-instrumentation-report")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		oldFile := filepath.Join(dir, "old.json")
+		newFile := filepath.Join(dir, "new.json")
+		require.NoError(t, writeReportFile(oldFile, oldReport))
+		require.NoError(t, writeReportFile(newFile, newReport))
+
+		var out bytes.Buffer
+		require.Equal(t, 1, runDiffCommand(&out, []string{oldFile, newFile, "my-org/..."}))
+		require.Equal(t, "- my-org/orm.Open\n- my-org/orm/internal.debug\n+ my-org/orm/internal.trace\n", out.String())
+
+		out.Reset()
+		require.Equal(t, 0, runDiffCommand(&out, []string{oldFile, newFile, "database/sql"}))
+		require.Empty(t, out.String())
+
+		require.Equal(t, 2, runDiffCommand(&out, []string{oldFile}))
+		require.Equal(t, 2, runDiffCommand(&out, []string{oldFile, filepath.Join(dir, "does-not-exist")}))
+	})
+}
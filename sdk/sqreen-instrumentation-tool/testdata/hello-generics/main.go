@@ -0,0 +1,75 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//go:build go1.18
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/sqreen/go-agent/sdk/sqreen-instrumentation-tool/testdata/helpers"
+)
+
+// single type parameter
+func g1[T any](v T) T {
+	defer helpers.TraceCall()()
+	return v
+}
+
+// several type parameters and unnamed results
+func g2[K comparable, V any](m map[K]V, k K) (V, bool) {
+	defer helpers.TraceCall()()
+	v, ok := m[k]
+	return v, ok
+}
+
+// ignored and variadic parameters
+func g3[T fmt.Stringer](_ int, s ...T) (r string) {
+	defer helpers.TraceCall()()
+	for _, s := range s {
+		r += s.String()
+	}
+	return r
+}
+
+type stringer string
+
+func (s stringer) String() string { return string(s) }
+
+type list[T any] struct {
+	elts []T
+}
+
+func (l *list[T]) push(v T) { defer helpers.TraceCall()(); l.elts = append(l.elts, v) }
+func (l list[T]) len() int  { defer helpers.TraceCall()(); return len(l.elts) }
+
+type pair[K comparable, V any] struct {
+	k K
+	v V
+}
+
+func (p *pair[K, V]) key() K { defer helpers.TraceCall()(); return p.k }
+
+func main() {
+	defer helpers.TraceCall()()
+	fmt.Println("Hello, Go!")
+
+	fmt.Println("g1 =", g1("g1 string"))
+	fmt.Println("g1 =", g1(1))
+
+	g2r0, g2r1 := g2(map[string]int{"two": 2}, "two")
+	fmt.Println("g2 =", g2r0, g2r1)
+
+	fmt.Println("g3 =", g3(3, stringer("g3"), stringer("str")))
+
+	var l list[int]
+	l.push(1)
+	fmt.Println("len =", l.len())
+
+	p := &pair[string, int]{k: "key", v: 1}
+	fmt.Println("key =", p.key())
+
+	fmt.Println("Bye, Go!")
+}
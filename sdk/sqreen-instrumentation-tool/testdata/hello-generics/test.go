@@ -0,0 +1,25 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//go:build go1.18
+
+//sqreen:ignore
+
+package main
+
+import (
+	"github.com/sqreen/go-agent/sdk/sqreen-instrumentation-tool/testdata/helpers"
+)
+
+func init() {
+	helpers.MustAttachTracer("main.main", func() (func(), error)(nil))
+	// Generic prolog called by every instantiation
+	helpers.MustAttachGenericTracer("main.g1")
+	helpers.MustAttachGenericTracer("main.g2")
+	// Regular prolog only called by the instantiation having its signature
+	helpers.MustAttachTracer("main.g3", func(*int, *[]stringer) (func(*string), error)(nil))
+	helpers.MustAttachGenericTracer("main.(*list).push")
+	helpers.MustAttachGenericTracer("main.list.len")
+	helpers.MustAttachTracer("main.(*pair).key", func(**pair[string, int]) (func(*string), error)(nil))
+}
@@ -48,6 +48,25 @@ func MustAttachTracer(symbol string, prologType interface{}) {
 	MustAttach(symbol, makePrologEpilogTracer(symbol, prologType))
 }
 
+// MustAttachGenericTracer attaches a tracer to the generic function symbol
+// using the generic prolog type.
+func MustAttachGenericTracer(symbol string) {
+	MustAttach(symbol, sqhook.GenericPrologCallback(func(params []interface{}) (sqhook.GenericEpilogCallback, error) {
+		traceProlog(symbol, reflectedValues(params))
+		return func(results []interface{}) {
+			traceEpilog(symbol, reflectedValues(results))
+		}, nil
+	}))
+}
+
+func reflectedValues(values []interface{}) []reflect.Value {
+	reflected := make([]reflect.Value, len(values))
+	for i, v := range values {
+		reflected[i] = reflect.ValueOf(v)
+	}
+	return reflected
+}
+
 //sqreen:ignore
 //go:noinline
 func getFunctionName(skip int) string {
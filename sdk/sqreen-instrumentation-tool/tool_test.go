@@ -31,6 +31,18 @@ func TestInstrumentation(t *testing.T) {
 	t.Run("hello-gls", func(t *testing.T) {
 		testInstrumentation(t, toolPath, "./testdata/hello-gls")
 	})
+
+	t.Run("hello-generics", func(t *testing.T) {
+		testInstrumentation(t, toolPath, "./testdata/hello-generics")
+	})
+
+	t.Run("overlay", func(t *testing.T) {
+		testOverlayInstrumentation(t, toolPath, "./testdata/hello-world")
+	})
+
+	t.Run("build cache", func(t *testing.T) {
+		testInstrumentationBuildCache(t, toolPath, "./testdata/hello-world")
+	})
 }
 
 func buildInstrumentationTool(t *testing.T) (path string) {
@@ -58,6 +70,109 @@ func testInstrumentation(t *testing.T, toolPath string, testApp string) {
 	outputBuf, err := cmd.Output()
 	require.NoError(t, err)
 
+	checkInstrumentationOutput(t, testApp, outputBuf)
+}
+
+func testOverlayInstrumentation(t *testing.T, toolPath string, testApp string) {
+	overlayDir, err := ioutil.TempDir("", "sqreen-overlay")
+	require.NoError(t, err)
+	defer os.RemoveAll(overlayDir)
+	overlay := filepath.Join(overlayDir, "overlay.json")
+
+	// Write the overlay with full instrumentation and verbose mode
+	cmd := exec.Command(toolPath, "-v", "-full", "-overlay", overlay, testApp)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	require.NoError(t, cmd.Run())
+
+	cmd = exec.Command(godriver, "run", "-overlay", overlay, testApp)
+	cmd.Stderr = os.Stderr
+	outputBuf, err := cmd.Output()
+	require.NoError(t, err)
+
+	checkInstrumentationOutput(t, testApp, outputBuf)
+}
+
+// testInstrumentationBuildCache builds the test app several times without
+// option -a in order to check the go build cache is used until the
+// instrumentation configuration changes.
+func testInstrumentationBuildCache(t *testing.T, toolPath string, testApp string) {
+	dir, err := ioutil.TempDir("", "sqreen-build-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	gocache := filepath.Join(dir, "go-cache")
+	config := filepath.Join(dir, "config.yml")
+	bin := filepath.Join(dir, "app")
+	report := filepath.Join(dir, "report.json")
+
+	// build builds and runs the test app, and returns the packages actually
+	// compiled. The compilation outputs are replayed by the go toolchain when
+	// using its cache, so the executed commands printed by option -x are used
+	// instead of the instrumentation tool logs.
+	build := func(t *testing.T) (compiled []string) {
+		toolexec := fmt.Sprintf("%s -config %s -report %s", toolPath, config, report)
+		cmd := exec.Command(godriver, "build", "-x", "-o", bin, "-toolexec", toolexec, testApp)
+		cmd.Env = append(os.Environ(), "GOCACHE="+gocache)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+		for _, line := range strings.Split(string(output), "\n") {
+			if !strings.HasPrefix(line, toolexec+" ") {
+				continue
+			}
+			args := strings.Fields(strings.TrimPrefix(line, toolexec))
+			if filepath.Base(args[0]) != "compile" {
+				continue
+			}
+			for i := 1; i < len(args)-1; i++ {
+				if args[i] == "-p" {
+					compiled = append(compiled, args[i+1])
+					break
+				}
+			}
+		}
+
+		outputBuf, err := exec.Command(bin).Output()
+		require.NoError(t, err)
+		checkInstrumentationOutput(t, testApp, outputBuf)
+		return compiled
+	}
+
+	const helpers = "github.com/sqreen/go-agent/sdk/sqreen-instrumentation-tool/testdata/helpers"
+	require.NoError(t, ioutil.WriteFile(config, []byte("include:\n  - package: main\n"), 0644))
+	require.Contains(t, build(t), "main")
+
+	// Nothing is compiled again when nothing changed
+	require.Empty(t, build(t))
+
+	// Changing the configuration invalidates the cached compilations
+	require.NoError(t, ioutil.WriteFile(config, []byte("include:\n  - package: main\n  - package: "+helpers+"\n"), 0644))
+	compiled := build(t)
+	require.Contains(t, compiled, "main")
+	require.Contains(t, compiled, helpers)
+
+	// Which are then cached again
+	require.Empty(t, build(t))
+	requireReport := func(t *testing.T) {
+		written, err := readReport(report)
+		require.NoError(t, err)
+		require.Contains(t, written, "\"main\"")
+		require.Contains(t, written, helpers)
+	}
+	requireReport(t)
+
+	// Moving the report links the program again, out of the cached
+	// compilations, in order to write it
+	report = filepath.Join(dir, "moved-report.json")
+	require.Empty(t, build(t))
+	requireReport(t)
+}
+
+func readReport(filename string) (string, error) {
+	buf, err := ioutil.ReadFile(filename)
+	return string(buf), err
+}
+
+func checkInstrumentationOutput(t *testing.T, testApp string, outputBuf []byte) {
 	output := string(outputBuf)
 	fmt.Print(output)
 
@@ -26,6 +26,14 @@ type defaultPackageInstrumentationVisitor struct {
 	// Package path being instrumented. Used to generate unique hook names
 	// prefixed by the package path.
 	pkgPath string
+	// Instrumentation rules of the configuration file applying to the package.
+	// Nil when none applies.
+	rules *packageInstrumentationRules
+	// isFuncLimited returns true when the default instrumentation of the file
+	// is limited to other functions.
+	isFuncLimited func(*dst.File, *dst.FuncDecl) bool
+	// File currently being instrumented.
+	currentFile *dst.File
 	// False when the first file is being instrumented in order to add
 	// metadata that must appear once.
 	fileMetadataOnce bool
@@ -47,25 +55,41 @@ type defaultPackageInstrumentationVisitor struct {
 }
 
 type instrumentationStats struct {
-	ignored      []string
-	instrumented []string
+	// Function declarations per file along with their hookpoint, or the reason
+	// why they were ignored.
+	funcs map[*dst.File][]funcDeclStats
 }
 
-func (s *instrumentationStats) addIgnored(funcDecl *dst.FuncDecl) {
-	s.ignored = append(s.ignored, funcDecl.Name.Name)
+type funcDeclStats struct {
+	funcDecl *dst.FuncDecl
+	hook     *hookpoint
+	ignored  string
 }
 
-func (s *instrumentationStats) addInstrumented(funcDecl *dst.FuncDecl) {
-	s.instrumented = append(s.instrumented, funcDecl.Name.Name)
+func (s *instrumentationStats) addIgnored(file *dst.File, funcDecl *dst.FuncDecl, reason string) {
+	s.add(file, funcDeclStats{funcDecl: funcDecl, ignored: reason})
 }
 
-func newDefaultPackageInstrumentationVisitor(pkgPath string, instrumentedFiles map[*dst.File][]*hookpoint) *defaultPackageInstrumentationVisitor {
+func (s *instrumentationStats) addInstrumented(file *dst.File, funcDecl *dst.FuncDecl, hook *hookpoint) {
+	s.add(file, funcDeclStats{funcDecl: funcDecl, hook: hook})
+}
+
+func (s *instrumentationStats) add(file *dst.File, stats funcDeclStats) {
+	if s.funcs == nil {
+		s.funcs = make(map[*dst.File][]funcDeclStats)
+	}
+	s.funcs[file] = append(s.funcs[file], stats)
+}
+
+func newDefaultPackageInstrumentationVisitor(pkgPath string, rules *packageInstrumentationRules, isFuncLimited func(*dst.File, *dst.FuncDecl) bool, instrumentedFiles map[*dst.File][]*hookpoint) *defaultPackageInstrumentationVisitor {
 	sqassert.NotNil(instrumentedFiles)
 
 	hookDescriptorTypeDecl, hookDescriptorTypeSpec, newDescriptorValueInitializer := newHookDescriptorType()
 	hookDescriptorTypeIdent := hookDescriptorTypeSpec.Name.Name
 	return &defaultPackageInstrumentationVisitor{
 		pkgPath:                           pkgPath,
+		rules:                             rules,
+		isFuncLimited:                     isFuncLimited,
 		instrumentedHooks:                 instrumentedFiles,
 		hookDescriptorTypeIdent:           hookDescriptorTypeIdent,
 		hookDescriptorTypeDecl:            hookDescriptorTypeDecl,
@@ -74,13 +98,22 @@ func newDefaultPackageInstrumentationVisitor(pkgPath string, instrumentedFiles m
 }
 
 func (v *defaultPackageInstrumentationVisitor) instrumentFuncDeclPre(funcDecl *dst.FuncDecl) {
-	if shouldIgnoreFuncDecl(funcDecl) {
-		v.stats.addIgnored(funcDecl)
+	if reason := funcDeclIgnoreReason(funcDecl); reason != "" {
+		v.stats.addIgnored(v.currentFile, funcDecl, reason)
+		return
+	}
+	if v.rules.isFuncIgnored(funcDecl) {
+		v.stats.addIgnored(v.currentFile, funcDecl, "configuration file")
+		return
+	}
+	if v.isFuncLimited != nil && v.isFuncLimited(v.currentFile, funcDecl) {
+		v.stats.addIgnored(v.currentFile, funcDecl, "limited instrumentation")
 		return
 	}
 
 	hook := newHookpoint(v.pkgPath, funcDecl, v.hookDescriptorTypeIdent, v.newHookDescriptorValueInitializer)
 	v.instrumented = append(v.instrumented, hook)
+	v.stats.addInstrumented(v.currentFile, funcDecl, hook)
 
 	funcDecl.Body.List = append([]dst.Stmt{hook.instrumentationStmt}, funcDecl.Body.List...)
 }
@@ -92,6 +125,8 @@ func (v *defaultPackageInstrumentationVisitor) instrument(root *dst.Package) (in
 
 func (v *defaultPackageInstrumentationVisitor) instrumentPre(cursor *dstutil.Cursor) bool {
 	switch node := cursor.Node().(type) {
+	case *dst.File:
+		v.currentFile = node
 	case *dst.FuncDecl:
 		v.instrumentFuncDeclPre(node)
 		// Note that we don't add the file metadata here in order to avoid to
@@ -165,9 +200,11 @@ func (v *defaultPackageInstrumentationVisitor) addHookDescriptorType(file *dst.F
 	file.Decls = append(file.Decls, v.hookDescriptorTypeDecl)
 }
 
-// Write into `w` the Go sources of the hook table for the list of hook
-// descriptor function `hooks`.
-func writeHookTable(w io.Writer, hooks []string) error {
+// Write into `w` the Go sources of the hook table of package `pkgName` for the
+// list of hook descriptor function `hooks`. The hook descriptor functions for
+// which `isLocalHook` returns true are defined by the package itself and are
+// therefore not forward declared.
+func writeHookTable(w io.Writer, pkgName string, hooks []string, isLocalHook func(string) bool) error {
 	sort.Strings(hooks)
 
 	// In case the hook descriptor type hasn't been created, we recreate the
@@ -194,7 +231,7 @@ var _sqreen_instrumentation_descriptor = &_sqreen_instrumentation_descriptor_typ
 func %[1]s(*_sqreen_hook_table_hook_descriptor_type)
 
 `
-		fileFormat = `package main
+		fileFormat = `package %s
 
 import _ "unsafe"
 
@@ -215,10 +252,9 @@ type _sqreen_hook_table_hook_descriptor_type = struct {	Func, Prolog interface{}
 			return err
 		}
 
-		// We are writing a file for the main package so we don't need to forward
-		// declare the hook descriptor functions that are defined in the main
-		// package.
-		if isHookDescriptorFuncInMainPackage(hookDescriptorFuncName) {
+		// We don't need to forward declare the hook descriptor functions that are
+		// defined in the package itself.
+		if isLocalHook(hookDescriptorFuncName) {
 			continue
 		}
 
@@ -230,6 +266,6 @@ type _sqreen_hook_table_hook_descriptor_type = struct {	Func, Prolog interface{}
 	}
 
 	hookTableVar := fmt.Sprintf(tableFormat, &tableInitList, version.Version())
-	_, err := io.WriteString(w, fmt.Sprintf(fileFormat, &hookDescriptorForwardFuncDecls, hookTableVar))
+	_, err := io.WriteString(w, fmt.Sprintf(fileFormat, pkgName, &hookDescriptorForwardFuncDecls, hookTableVar))
 	return err
 }
@@ -0,0 +1,213 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+// Command sqreen-rule runs security rules offline against recorded requests
+// and function calls, without deploying them nor instrumenting any program.
+// It loads a rules JSON file, replays every fixture of a fixtures JSON file
+// through the rule callbacks, such as the WAF, JSExec and FunctionWAF
+// callbacks, and prints which rules fired along with their attack info.
+//
+// Usage:
+//
+//	sqreen-rule [-skip-signature] [-v] -rules RULES_FILE FIXTURES_FILE
+//
+// The rules file is either a rules pack object `{"pack_id": ..., "rules": [...]}`
+// or a JSON array of rules. The fixtures file is a JSON array of fixtures:
+//
+//	[
+//	  {
+//	    "name": "sqli in the query string",
+//	    "request": {
+//	      "method": "GET",
+//	      "url": "/users?id=1%27%20OR%201=1",
+//	      "headers": { "User-Agent": [ "curl" ] },
+//	      "body": "",
+//	      "remote_addr": "1.2.3.4:5678"
+//	    },
+//	    "calls": [
+//	      {
+//	        "hookpoint": "database/sql.(*DB).QueryContext",
+//	        "args": [ null, null, "SELECT * FROM users WHERE id = '1' OR 1=1'" ],
+//	        "results": [ null, null ]
+//	      }
+//	    ],
+//	    "expect": [ "sqreen-sqli" ]
+//	  }
+//	]
+//
+// The request is replayed through the HTTP protections before the function
+// calls. The optional list of rules expected to fire makes the command exit
+// with status 1 when the rules fired differ, so that rule regression suites
+// can be run in CI.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/sqreen/go-agent/internal/config"
+	"github.com/sqreen/go-agent/internal/plog"
+	"github.com/sqreen/go-agent/internal/rule"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+)
+
+func main() {
+	os.Exit(run(os.Stdout, os.Stderr, os.Args[1:]))
+}
+
+func run(stdout, stderr io.Writer, args []string) (exitCode int) {
+	flags := flag.NewFlagSet("sqreen-rule", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	rulesFile := flags.String("rules", "", "rules JSON file")
+	skipSignature := flags.Bool("skip-signature", false, "skip the verification of the rule signatures")
+	verbose := flags.Bool("v", false, "enable the debug logs")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(stderr, "usage: sqreen-rule [-skip-signature] [-v] -rules RULES_FILE FIXTURES_FILE")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *rulesFile == "" || flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	packID, rules, err := readRulesFile(*rulesFile)
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 2
+	}
+	fixtures, err := readFixturesFile(flags.Arg(0))
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	logLevel := plog.Error
+	if *verbose {
+		logLevel = plog.Debug
+	}
+	logger := plog.NewLogger(logLevel, stderr, nil)
+
+	publicKey, err := rule.NewECDSAPublicKey(config.PublicKey)
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if *skipSignature {
+		publicKey = nil
+	}
+
+	replayer := rule.NewReplayer(logger, publicKey)
+	replayer.SetRules(packID, rules)
+
+	for i := range fixtures {
+		f := &fixtures[i]
+		if f.Name == "" {
+			f.Name = fmt.Sprintf("fixture %d", i)
+		}
+
+		result, err := replayFixture(replayer, f)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "%s: %v\n", f.Name, err)
+			exitCode = 1
+			continue
+		}
+
+		if !printResult(stdout, f, result) {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+// printResult prints the rules that fired and returns false when they differ
+// from the expected ones.
+func printResult(w io.Writer, f *fixture, result *replayResult) (ok bool) {
+	var fired []string
+	for _, attack := range result.Attacks {
+		fired = append(fired, attack.Rule)
+		info, err := json.Marshal(attack.Info)
+		if err != nil {
+			info = []byte(fmt.Sprintf("%q", fmt.Sprint(attack.Info)))
+		}
+		_, _ = fmt.Fprintf(w, "%s: rule `%s` fired (blocked: %t)\n\tinfo: %s\n", f.Name, attack.Rule, attack.Blocked, info)
+	}
+	if len(result.Attacks) == 0 {
+		_, _ = fmt.Fprintf(w, "%s: no rule fired\n", f.Name)
+	}
+
+	if f.Expect == nil {
+		return true
+	}
+	if !sameRules(fired, f.Expect) {
+		_, _ = fmt.Fprintf(w, "%s: FAIL: expected rules %q but got %q\n", f.Name, f.Expect, fired)
+		return false
+	}
+	return true
+}
+
+// sameRules returns true when both lists contain the same set of rules.
+func sameRules(a, b []string) bool {
+	set := func(l []string) []string {
+		m := make(map[string]struct{}, len(l))
+		for _, v := range l {
+			m[v] = struct{}{}
+		}
+		s := make([]string, 0, len(m))
+		for v := range m {
+			s = append(s, v)
+		}
+		sort.Strings(s)
+		return s
+	}
+	sa, sb := set(a), set(b)
+	if len(sa) != len(sb) {
+		return false
+	}
+	for i := range sa {
+		if sa[i] != sb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func readRulesFile(filename string) (packID string, rules []api.Rule, err error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", nil, sqerrors.Wrap(err, "rules file")
+	}
+	if buf = bytes.TrimSpace(buf); len(buf) > 0 && buf[0] == '[' {
+		if err := json.Unmarshal(buf, &rules); err != nil {
+			return "", nil, sqerrors.Wrapf(err, "rules file `%s`", filename)
+		}
+		return filename, rules, nil
+	}
+	var pack api.RulesPackResponse
+	if err := json.Unmarshal(buf, &pack); err != nil {
+		return "", nil, sqerrors.Wrapf(err, "rules file `%s`", filename)
+	}
+	return pack.PackID, pack.Rules, nil
+}
+
+func readFixturesFile(filename string) ([]fixture, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, sqerrors.Wrap(err, "fixtures file")
+	}
+	var fixtures []fixture
+	if err := json.Unmarshal(buf, &fixtures); err != nil {
+		return nil, sqerrors.Wrapf(err, "fixtures file `%s`", filename)
+	}
+	return fixtures, nil
+}
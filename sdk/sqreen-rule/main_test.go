@@ -0,0 +1,45 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	t.Run("usage", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		require.Equal(t, 2, run(&stdout, &stderr, []string{"testdata/fixtures.json"}))
+		require.Contains(t, stderr.String(), "usage:")
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		exitCode := run(&stdout, &stderr, []string{"-skip-signature", "-rules", "testdata/rules.json", "testdata/fixtures.json"})
+		require.Equal(t, 0, exitCode, stderr.String())
+		require.Equal(t, "path traversal: rule `js-path-traversal` fired (blocked: false)\n"+
+			"\tinfo: {\"name\":\"../../etc/passwd\"}\n"+
+			"security scanner: rule `js-scanner` fired (blocked: false)\n"+
+			"\tinfo: {\"user_agent\":\"sqlmap/1.4\"}\n"+
+			"no attack: no rule fired\n", stdout.String())
+	})
+
+	t.Run("signature verification", func(t *testing.T) {
+		// The test rules are not signed
+		var stdout, stderr bytes.Buffer
+		require.Equal(t, 1, run(&stdout, &stderr, []string{"-rules", "testdata/rules.json", "testdata/fixtures.json"}))
+		require.Contains(t, stdout.String(), "path traversal: FAIL")
+	})
+}
+
+func TestSameRules(t *testing.T) {
+	require.True(t, sameRules(nil, []string{}))
+	require.True(t, sameRules([]string{"a", "b", "a"}, []string{"b", "a"}))
+	require.False(t, sameRules([]string{"a"}, []string{"a", "b"}))
+	require.False(t, sameRules([]string{"a"}, []string{"b"}))
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sqreen/go-agent/internal/actor"
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/sqreen/go-agent/internal/rule"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqtime"
+)
+
+// fixture is a recorded HTTP request along with the recorded function calls
+// performed while handling it.
+type fixture struct {
+	Name    string          `json:"name"`
+	Request *fixtureRequest `json:"request"`
+	Calls   []fixtureCall   `json:"calls"`
+	// Expect is the optional list of rules expected to fire.
+	Expect []string `json:"expect"`
+}
+
+type fixtureRequest struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Headers    http.Header `json:"headers"`
+	Body       string      `json:"body"`
+	RemoteAddr string      `json:"remote_addr"`
+}
+
+type fixtureCall struct {
+	Hookpoint string        `json:"hookpoint"`
+	Args      []interface{} `json:"args"`
+	Results   []interface{} `json:"results"`
+}
+
+// replayResult is the result of a fixture replay.
+type replayResult struct {
+	Blocked bool
+	Attacks []*event.AttackEvent
+}
+
+// Hookpoints of the HTTP protection called before the request handler, in the
+// same order as the HTTP protection context does, followed by the request body
+// WAF.
+var httpProtectionHookpoints = append(append([]http_protection.Hookpoint{}, http_protection.BeforeHookpoints...), http_protection.BodyWAFHookpoint)
+
+// replayFixture replays the given fixture with a new HTTP protection context
+// created out of the fixture request. The HTTP protections are replayed
+// first, and the function calls are then replayed unless the request was
+// blocked.
+func replayFixture(replayer *rule.Replayer, f *fixture) (*replayResult, error) {
+	req, err := newFixtureHTTPRequest(f.Request)
+	if err != nil {
+		return nil, sqerrors.Wrap(err, "request")
+	}
+
+	root := newReplayRootProtectionContext()
+	reader := &requestReader{Request: req, body: []byte(fixtureBody(f.Request))}
+	clientIP := http_protection.ClientIP(req.RemoteAddr, req.Header, "", "")
+	p := http_protection.NewTestProtectionContext(root, clientIP, httptest.NewRecorder(), reader)
+
+	result := &replayResult{}
+	for _, hookpoint := range httpProtectionHookpoints {
+		results := []interface{}{}
+		if hookpoint.Blocking {
+			results = append(results, nil)
+		}
+		aborted, err := replayer.Call(p, hookpoint.Symbol(), []interface{}{p}, results)
+		if err != nil {
+			return nil, err
+		}
+		if aborted {
+			result.Blocked = true
+			break
+		}
+	}
+
+	if !result.Blocked {
+		for i, call := range f.Calls {
+			results := call.Results
+			if results == nil {
+				results = []interface{}{}
+			}
+			aborted, err := replayer.Call(p, call.Hookpoint, call.Args, results)
+			if err != nil {
+				return nil, sqerrors.Wrapf(err, "call %d", i)
+			}
+			if aborted {
+				result.Blocked = true
+				break
+			}
+		}
+	}
+
+	p.Close(replayResponse{})
+	if root.closed != nil {
+		result.Attacks = root.closed.Events().AttackEvents
+	}
+	return result, nil
+}
+
+func fixtureBody(r *fixtureRequest) string {
+	if r == nil {
+		return ""
+	}
+	return r.Body
+}
+
+func newFixtureHTTPRequest(r *fixtureRequest) (*http.Request, error) {
+	if r == nil {
+		r = &fixtureRequest{}
+	}
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	target := r.URL
+	if target == "" {
+		target = "/"
+	}
+	if _, err := url.Parse(target); err != nil {
+		return nil, err
+	}
+
+	req := httptest.NewRequest(method, target, strings.NewReader(r.Body))
+	for k, values := range r.Headers {
+		for _, v := range values {
+			req.Header.Add(k, v)
+		}
+	}
+	if r.RemoteAddr != "" {
+		req.RemoteAddr = r.RemoteAddr
+	}
+	if req.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+		if err := req.ParseForm(); err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(strings.NewReader(r.Body))
+	}
+	return req, nil
+}
+
+// replayRootProtectionContext is the root protection context of the replayed
+// requests. It has no deadline, no actions, no allowlists, and it keeps the
+// closed protection context.
+type replayRootProtectionContext struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	sqreenTime *sqtime.SharedStopWatch
+	closed     types.ClosedProtectionContextFace
+}
+
+func newReplayRootProtectionContext() *replayRootProtectionContext {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &replayRootProtectionContext{ctx: ctx, cancel: cancel, sqreenTime: sqtime.NewSharedStopWatch()}
+}
+
+func (p *replayRootProtectionContext) Context() context.Context { return p.ctx }
+func (p *replayRootProtectionContext) CancelContext()           { p.cancel() }
+func (p *replayRootProtectionContext) SqreenTime() *sqtime.SharedStopWatch {
+	return p.sqreenTime
+}
+func (p *replayRootProtectionContext) DeadlineExceeded(time.Duration) bool  { return false }
+func (p *replayRootProtectionContext) RemainingTime() (time.Duration, bool) { return 0, false }
+func (p *replayRootProtectionContext) FindActionByIP(net.IP) (actor.Action, bool, error) {
+	return nil, false, nil
+}
+func (p *replayRootProtectionContext) FindActionByUserID(map[string]string) (actor.Action, bool) {
+	return nil, false
+}
+func (p *replayRootProtectionContext) IsIPAllowed(net.IP) bool    { return false }
+func (p *replayRootProtectionContext) IsPathAllowed(string) bool  { return false }
+func (p *replayRootProtectionContext) Config() types.ConfigReader { return replayConfig{} }
+func (p *replayRootProtectionContext) Close(closed types.ClosedProtectionContextFace) {
+	p.closed = closed
+	p.cancel()
+}
+
+type replayConfig struct{}
+
+func (replayConfig) HTTPClientIPHeader() string       { return "" }
+func (replayConfig) HTTPClientIPHeaderFormat() string { return "" }
+func (replayConfig) CookieSigningKey() string         { return "" }
+
+type replayResponse struct{}
+
+func (replayResponse) Status() int          { return http.StatusOK }
+func (replayResponse) ContentType() string  { return "" }
+func (replayResponse) ContentLength() int64 { return 0 }
+
+// requestReader is the request reader of the replayed requests.
+type requestReader struct {
+	*http.Request
+	body []byte
+}
+
+func (r *requestReader) Body() []byte { return r.body }
+
+func (r *requestReader) Header(h string) (value *string) {
+	v := r.Request.Header[textproto.CanonicalMIMEHeaderKey(h)]
+	if len(v) == 0 {
+		return nil
+	}
+	return &v[0]
+}
+
+func (r *requestReader) Params() types.RequestParamMap { return nil }
+func (r *requestReader) ClientIP() net.IP              { return nil }
+func (r *requestReader) Method() string                { return r.Request.Method }
+func (r *requestReader) URL() *url.URL                 { return r.Request.URL }
+func (r *requestReader) RequestURI() string            { return r.Request.RequestURI }
+func (r *requestReader) Host() string                  { return r.Request.Host }
+func (r *requestReader) IsTLS() bool                   { return r.Request.TLS != nil }
+func (r *requestReader) QueryForm() url.Values         { return r.Request.URL.Query() }
+func (r *requestReader) PostForm() url.Values          { return r.Request.PostForm }
+func (r *requestReader) Headers() http.Header          { return r.Request.Header }
+func (r *requestReader) RemoteAddr() string            { return r.Request.RemoteAddr }